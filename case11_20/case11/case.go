@@ -0,0 +1,13 @@
+package case11
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:       11,
+		Title:    "gRPC 限流之后只查 Redis",
+		Requires: []cases.Infra{cases.MySQL, cases.Redis},
+		Package:  "./case11_20/case11",
+		Tests:    []string{"TestCase11_"},
+	})
+}
@@ -99,7 +99,7 @@ func TestCase11_(t *testing.T) {
 			},
 			after: func() {
 				// 清空mysql
-				err := db.Exec("TRUNCATE TABLE `articles`").Error
+				err := test.Truncate(db, "articles")
 				assert.NoError(t, err)
 			},
 			wantRes: &pb2.ListArticlesResponse{
@@ -0,0 +1,12 @@
+package case12
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:      12,
+		Title:   "哈希环根据请求量重新划分槽位",
+		Package: "./case11_20/case12",
+		Tests:   []string{"TestHashRing_Balance"},
+	})
+}
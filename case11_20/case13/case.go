@@ -0,0 +1,12 @@
+package case13
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:      13,
+		Title:   "根据节点状态动态调整权重的负载均衡",
+		Package: "./case11_20/case13/...",
+		Tests:   []string{"TestIntegration", "TestIntegrationSuite"},
+	})
+}
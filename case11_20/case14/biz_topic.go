@@ -1,26 +1,18 @@
 package case14
 
 import (
-	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"context"
+	"interview-cases/pkg/mq"
 )
 
 const bizTopic = "biz_topic"
 
 type BizConsumer struct {
-	consumer *kafka.Consumer
+	consumer mq.Consumer
 }
 
-func NewBizConsumer(addr string) (*BizConsumer, error) {
-	config := &kafka.ConfigMap{
-		"bootstrap.servers": addr,
-		"auto.offset.reset": "earliest",
-		"group.id":          "biz_group",
-	}
-	consumer, err := kafka.NewConsumer(config)
-	if err != nil {
-		return nil, err
-	}
-	err = consumer.SubscribeTopics([]string{bizTopic}, nil)
+func NewBizConsumer(broker mq.Broker) (*BizConsumer, error) {
+	consumer, err := broker.Consumer("biz_group", bizTopic)
 	if err != nil {
 		return nil, err
 	}
@@ -29,8 +21,12 @@ func NewBizConsumer(addr string) (*BizConsumer, error) {
 	}, nil
 }
 
-func (b *BizConsumer) Consume() (string, error) {
-	msg, err := b.consumer.ReadMessage(-1)
+func (b *BizConsumer) Consume(ctx context.Context) (string, error) {
+	msg, err := b.consumer.Fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	err = b.consumer.Commit(ctx, msg)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,13 @@
+package case14
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:       14,
+		Title:    "用 Kafka 分区实现延迟消息",
+		Requires: []cases.Infra{cases.Kafka},
+		Package:  "./case11_20/case14",
+		Tests:    []string{"TestDelayMsg"},
+	})
+}
@@ -1,10 +1,12 @@
 package case14
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/ecodeclub/ekit/syncx"
+	"interview-cases/pkg/mq"
 	"log"
 	"log/slog"
 	"time"
@@ -16,11 +18,13 @@ const (
 )
 
 type DelayConsumer struct {
-	consumer *kafka.Consumer
+	consumer mq.Consumer
 	// 记录分区和超时时间的关系 可以从配置文件中获取
 	partitionMap *syncx.Map[int, time.Duration]
 	// 记录topic和其kafka连接
-	topicConn *syncx.Map[string, *kafka.Producer]
+	topicConn *syncx.Map[string, mq.Producer]
+	// 睡眠期间从别的分区上读到的消息，睡醒之后接着处理
+	pending []mq.Message
 }
 
 type DelayMsg struct {
@@ -30,18 +34,8 @@ type DelayMsg struct {
 	Topic string `json:"topic"`
 }
 
-func NewDelayConsumer(addr string, topicMap *syncx.Map[string, *kafka.Producer], partitionMap *syncx.Map[int, time.Duration]) (*DelayConsumer, error) {
-	config := &kafka.ConfigMap{
-		"bootstrap.servers":  addr,
-		"group.id":           delayConsumerGroupName,
-		"auto.offset.reset":  "earliest",
-		"enable.auto.commit": "false",
-	}
-	consumer, err := kafka.NewConsumer(config)
-	if err != nil {
-		return nil, err
-	}
-	err = consumer.SubscribeTopics([]string{delayTopic}, nil)
+func NewDelayConsumer(broker mq.Broker, topicMap *syncx.Map[string, mq.Producer], partitionMap *syncx.Map[int, time.Duration]) (*DelayConsumer, error) {
+	consumer, err := broker.Consumer(delayConsumerGroupName, delayTopic)
 	if err != nil {
 		return nil, err
 	}
@@ -52,9 +46,13 @@ func NewDelayConsumer(addr string, topicMap *syncx.Map[string, *kafka.Producer],
 	}, nil
 }
 
-func (d *DelayConsumer) Consume() {
-	for {
-		msg, err := d.consumer.ReadMessage(-1)
+// Consume 一直转发延迟消息，直到 ctx 被取消
+func (d *DelayConsumer) Consume(ctx context.Context) {
+	for ctx.Err() == nil {
+		msg, err := d.next(ctx)
+		if ctx.Err() != nil {
+			return
+		}
 		if err != nil {
 			// 失败记录一下报错然后重试
 			slog.Error("获取延迟消息失败", slog.Any("err", err))
@@ -63,19 +61,33 @@ func (d *DelayConsumer) Consume() {
 		vv, _ := json.Marshal(msg)
 		slog.Info("成功获取延迟消息", slog.Any("msg", string(vv)))
 		// 转发
-		err = d.consume(msg)
+		err = d.consume(ctx, msg)
 		if err != nil {
 			slog.Error("转发延迟消息失败", slog.Any("err", err))
 		}
 	}
 }
 
-func (d *DelayConsumer) consume(msg *kafka.Message) error {
+// Close 关闭底层的消费者，要在 Consume 返回之后调用
+func (d *DelayConsumer) Close() error {
+	return d.consumer.Close()
+}
+
+func (d *DelayConsumer) next(ctx context.Context) (mq.Message, error) {
+	if len(d.pending) > 0 {
+		msg := d.pending[0]
+		d.pending = d.pending[1:]
+		return msg, nil
+	}
+	return d.consumer.Fetch(ctx)
+}
+
+func (d *DelayConsumer) consume(ctx context.Context, msg mq.Message) error {
 	// 获取发送时间
 	sendTime := msg.Timestamp
 	now := time.Now()
 	// 获取当前分区需要睡多久
-	interval, ok := d.partitionMap.Load(int(msg.TopicPartition.Partition))
+	interval, ok := d.partitionMap.Load(int(msg.Partition))
 	if !ok {
 		return fmt.Errorf("未知延迟分区")
 	}
@@ -85,46 +97,67 @@ func (d *DelayConsumer) consume(msg *kafka.Message) error {
 	log.Printf("msg %v 要睡 %f分钟", string(msg.Value), subTime.Minutes())
 	if subTime > 0 {
 		// 暂停分区消费
-		err := d.consumer.Pause([]kafka.TopicPartition{msg.TopicPartition})
+		err := d.consumer.Pause(msg.TopicPartition())
 		if err != nil {
 			return fmt.Errorf("暂停分区失败 %v", err)
 		}
 		// 睡眠
-		d.sleep(subTime)
+		err = d.sleep(ctx, subTime)
+		if err != nil {
+			return err
+		}
 		// 恢复分区消费
-		err = d.consumer.Resume([]kafka.TopicPartition{msg.TopicPartition})
+		err = d.consumer.Resume(msg.TopicPartition())
 		if err != nil {
 			return fmt.Errorf("恢复分区失败 %v", err)
 		}
 	}
 	// 转发
-	err := d.sendMsg(msg)
+	err := d.sendMsg(ctx, msg)
 	if err != nil {
 		return err
 	}
-	_, err = d.consumer.CommitMessage(msg)
+	err = d.consumer.Commit(ctx, msg)
 	if err != nil {
-		return fmt.Errorf("提交消息失败 offset %d Topic %s, 原因 %w", msg.TopicPartition.Offset, delayTopic, err)
+		return fmt.Errorf("提交消息失败 offset %d Topic %s, 原因 %w", msg.Offset, delayTopic, err)
 	}
 	return nil
 }
 
-func (d *DelayConsumer) sleep(subTime time.Duration) {
+func (d *DelayConsumer) sleep(ctx context.Context, subTime time.Duration) error {
 	ticker := time.NewTicker(defaultPollInterval)
 	defer ticker.Stop()
 	timer := time.NewTimer(subTime)
 	defer timer.Stop()
 	for {
 		select {
+		case <-ctx.Done():
+			return ctx.Err()
 		case <-timer.C:
-			return
+			return nil
 		case <-ticker.C:
-			d.consumer.Poll(100)
+			// 睡眠期间也要继续拉取，不然会被踢出消费者组
+			// 暂停的分区上不会有消息，别的分区上的消息先存起来
+			d.poll(ctx)
 		}
 	}
 }
 
-func (d *DelayConsumer) sendMsg(msg *kafka.Message) error {
+func (d *DelayConsumer) poll(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	msg, err := d.consumer.Fetch(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	if err != nil {
+		slog.Error("获取延迟消息失败", slog.Any("err", err))
+		return
+	}
+	d.pending = append(d.pending, msg)
+}
+
+func (d *DelayConsumer) sendMsg(ctx context.Context, msg mq.Message) error {
 	var delayMsg DelayMsg
 	err := json.Unmarshal(msg.Value, &delayMsg)
 	if err != nil {
@@ -136,10 +169,11 @@ func (d *DelayConsumer) sendMsg(msg *kafka.Message) error {
 	if !ok {
 		return fmt.Errorf("未知topic %s", topic)
 	}
-	err = producer.Produce(&kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &topic},
-		Value:          []byte(delayMsg.Data),
-	}, nil)
+	err = producer.Produce(ctx, mq.Message{
+		Topic:     topic,
+		Partition: mq.PartitionAny,
+		Value:     []byte(delayMsg.Data),
+	})
 	if err != nil {
 		return fmt.Errorf("转发失败 %v", err)
 	}
@@ -2,22 +2,29 @@ package case14
 
 import (
 	"context"
-	"fmt"
-	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/ecodeclub/ekit/syncx"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"interview-cases/pkg/mq"
+	"interview-cases/test"
 	"log"
+	"sync"
 	"testing"
 	"time"
 )
 
 type TestSuite struct {
 	suite.Suite
-	addr        string
+	broker      mq.Broker
 	producer    *Producer
 	bizConsumer *BizConsumer
+	// unit 延迟的单位，案例里面是分钟，测试里面用秒，不然要跑十几分钟
+	unit time.Duration
+
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	consumers []*DelayConsumer
 }
 
 func (s *TestSuite) SetupSuite() {
@@ -25,80 +32,92 @@ func (s *TestSuite) SetupSuite() {
 	s.initProducerAndConsumer()
 }
 
+// TearDownSuite 停掉延迟消费者，同一个进程里面再跑一次的时候不会跟新的消费者抢分区
+func (s *TestSuite) TearDownSuite() {
+	s.cancel()
+	s.wg.Wait()
+	for _, c := range s.consumers {
+		assert.NoError(s.T(), c.Close())
+	}
+	assert.NoError(s.T(), s.bizConsumer.consumer.Close())
+}
+
 func (s *TestSuite) initProducerAndConsumer() {
-	kafkaProducer, err := kafka.NewProducer(&kafka.ConfigMap{
-		"bootstrap.servers": s.addr,
-	})
+	kafkaProducer, err := s.broker.Producer()
 	require.NoError(s.T(), err)
 	proMap := syncx.Map[time.Duration, int]{}
-	proMap.Store(3*time.Minute, 0)
-	proMap.Store(5*time.Minute, 1)
-	proMap.Store(10*time.Minute, 2)
+	proMap.Store(3*s.unit, 0)
+	proMap.Store(5*s.unit, 1)
+	proMap.Store(10*s.unit, 2)
 	producer := &Producer{
 		producer:     kafkaProducer,
 		partitionMap: &proMap,
 	}
 	consumeMap := syncx.Map[int, time.Duration]{}
-	consumeMap.Store(0, 3*time.Minute)
-	consumeMap.Store(1, 5*time.Minute)
-	consumeMap.Store(2, 10*time.Minute)
+	consumeMap.Store(0, 3*s.unit)
+	consumeMap.Store(1, 5*s.unit)
+	consumeMap.Store(2, 10*s.unit)
 
-	topicMap := syncx.Map[string, *kafka.Producer]{}
+	topicMap := syncx.Map[string, mq.Producer]{}
 	topicMap.Store(bizTopic, kafkaProducer)
-	consumer0, err := NewDelayConsumer(s.addr, &topicMap, &consumeMap)
-	require.NoError(s.T(), err)
-	consumer1, err := NewDelayConsumer(s.addr, &topicMap, &consumeMap)
-	require.NoError(s.T(), err)
-	consumer2, err := NewDelayConsumer(s.addr, &topicMap, &consumeMap)
-	require.NoError(s.T(), err)
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
 	// 启动三个消费者
-	go consumer0.Consume()
-	go consumer1.Consume()
-	go consumer2.Consume()
+	for i := 0; i < 3; i++ {
+		consumer, err := NewDelayConsumer(s.broker, &topicMap, &consumeMap)
+		require.NoError(s.T(), err)
+		s.consumers = append(s.consumers, consumer)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			consumer.Consume(ctx)
+		}()
+	}
 	s.producer = producer
-	s.bizConsumer, err = NewBizConsumer(s.addr)
+	s.bizConsumer, err = NewBizConsumer(s.broker)
 	require.NoError(s.T(), err)
 }
 
 func (s *TestSuite) TestDelayConsume() {
 	// 发送消息
-	startTime1 := s.sendMsg("delayMsg1", 10*time.Minute)
-	startTime2 := s.sendMsg("delayMsg2", 3*time.Minute)
-	time.Sleep(1 * time.Minute)
-	startTime3 := s.sendMsg("delayMsg3", 3*time.Minute)
-	startTime4 := s.sendMsg("delayMsg4", 5*time.Minute)
+	startTime1 := s.sendMsg("delayMsg1", 10*s.unit)
+	startTime2 := s.sendMsg("delayMsg2", 3*s.unit)
+	time.Sleep(s.unit)
+	startTime3 := s.sendMsg("delayMsg3", 3*s.unit)
+	startTime4 := s.sendMsg("delayMsg4", 5*s.unit)
 	wantMsgs := []WantDelayMsg{
 		{
 			StartTime:    startTime2,
-			IntervalTime: 3 * time.Minute,
+			IntervalTime: 3 * s.unit,
 			Data:         "delayMsg2",
 		},
 		{
 			StartTime:    startTime3,
-			IntervalTime: 3 * time.Minute,
+			IntervalTime: 3 * s.unit,
 			Data:         "delayMsg3",
 		},
 		{
 			StartTime:    startTime4,
-			IntervalTime: 5 * time.Minute,
+			IntervalTime: 5 * s.unit,
 			Data:         "delayMsg4",
 		},
 		{
 			StartTime:    startTime1,
-			IntervalTime: 10 * time.Minute,
+			IntervalTime: 10 * s.unit,
 			Data:         "delayMsg1",
 		},
 	}
 	for _, want := range wantMsgs {
 		startTime := want.StartTime
-		msg, err := s.bizConsumer.Consume()
+		msg, err := s.bizConsumer.Consume(context.Background())
 		subTime := time.Now().Sub(startTime)
-		log.Printf("开始校验 %v 睡了 %f分钟", want, subTime.Minutes())
+		log.Printf("开始校验 %v 睡了 %v", want, subTime)
 		require.NoError(s.T(), err)
 		assert.Equal(s.T(), want.Data, msg)
 
-		// 允许误差10s
-		require.True(s.T(), subTime >= want.IntervalTime-10*time.Second && subTime <= want.IntervalTime+10*time.Second)
+		// 允许误差 1/6 个单位，按分钟算就是 10s
+		delta := s.unit / 6
+		require.True(s.T(), subTime >= want.IntervalTime-delta && subTime <= want.IntervalTime+delta)
 	}
 }
 
@@ -114,8 +133,9 @@ func (s *TestSuite) sendMsg(data string, intervalTime time.Duration) time.Time {
 func TestDelayMsg(t *testing.T) {
 
 	suite.Run(t, &TestSuite{
-		// 记得换你的 Kafka 地址
-		addr: "127.0.0.1:9092",
+		// 连不上 Kafka 的时候会使用进程内的消息队列
+		broker: test.InitMQ(),
+		unit:   time.Second,
 	})
 }
 
@@ -126,40 +146,11 @@ type WantDelayMsg struct {
 }
 
 func (s *TestSuite) initTopic() {
-	// 创建 AdminClient
-	adminClient, err := kafka.NewAdminClient(&kafka.ConfigMap{
-		"bootstrap.servers": s.addr,
-	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// 三个分区分别对应三种延迟时间
+	err := s.broker.CreateTopic(ctx, delayTopic, 3)
 	require.NoError(s.T(), err)
-	defer adminClient.Close()
-	// 设置要创建的主题的配置信息
-	topic := delayTopic
-	numPartitions := 3
-	replicationFactor := 1
-	// 创建主题
-	results, err := adminClient.CreateTopics(
-		context.Background(),
-		[]kafka.TopicSpecification{
-			{
-				Topic:             topic,
-				NumPartitions:     numPartitions,
-				ReplicationFactor: replicationFactor,
-			},
-			{
-				Topic:             bizTopic,
-				NumPartitions:     1,
-				ReplicationFactor: replicationFactor,
-			},
-		},
-	)
+	err = s.broker.CreateTopic(ctx, bizTopic, 1)
 	require.NoError(s.T(), err)
-	// 处理创建主题的结果
-	for _, result := range results {
-		if result.Error.Code() != kafka.ErrNoError && result.Error.Code() != kafka.ErrTopicAlreadyExists {
-			fmt.Printf("创建topic失败 %s: %v\n", result.Topic, result.Error)
-
-		} else {
-			fmt.Printf("Topic %s 创建成功\n", result.Topic)
-		}
-	}
 }
@@ -4,8 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/pkg/errors"
+	"interview-cases/pkg/mq"
 
-	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/ecodeclub/ekit/syncx"
 	"time"
 )
@@ -15,11 +15,10 @@ const delayTopic = "delay_topic"
 type Producer struct {
 	// 记录分区和超时时间的关系
 	partitionMap *syncx.Map[time.Duration, int]
-	producer     *kafka.Producer
+	producer     mq.Producer
 }
 
 func (p *Producer) Produce(ctx context.Context, msg DelayMsg, delayTime time.Duration) error {
-	topic := delayTopic
 	partition, ok := p.partitionMap.Load(delayTime)
 	if !ok {
 		return errors.New("不支持的超时时间")
@@ -28,11 +27,9 @@ func (p *Producer) Produce(ctx context.Context, msg DelayMsg, delayTime time.Dur
 	if err != nil {
 		return err
 	}
-	return p.producer.Produce(&kafka.Message{
-		TopicPartition: kafka.TopicPartition{
-			Topic:     &topic,
-			Partition: int32(partition),
-		},
-		Value: msgByte,
-	}, nil)
+	return p.producer.Produce(ctx, mq.Message{
+		Topic:     delayTopic,
+		Partition: int32(partition),
+		Value:     msgByte,
+	})
 }
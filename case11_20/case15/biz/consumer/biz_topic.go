@@ -1,28 +1,19 @@
 package consumer
 
 import (
-	"github.com/confluentinc/confluent-kafka-go/kafka"
-	"time"
+	"context"
+	"interview-cases/pkg/mq"
 )
 
 const bizTopic = "biz_topic"
 
 type BizConsumer struct {
-	consumer *kafka.Consumer
+	consumer mq.Consumer
 }
 
 // NewBizConsumer 模拟业务消费者
-func NewBizConsumer(addr string) (*BizConsumer, error) {
-	config := &kafka.ConfigMap{
-		"bootstrap.servers": addr,
-		"auto.offset.reset": "earliest",
-		"group.id":          "biz_group",
-	}
-	consumer, err := kafka.NewConsumer(config)
-	if err != nil {
-		return nil, err
-	}
-	err = consumer.SubscribeTopics([]string{bizTopic}, nil)
+func NewBizConsumer(broker mq.Broker) (*BizConsumer, error) {
+	consumer, err := broker.Consumer("biz_group", bizTopic)
 	if err != nil {
 		return nil, err
 	}
@@ -31,8 +22,12 @@ func NewBizConsumer(addr string) (*BizConsumer, error) {
 	}, nil
 }
 
-func (b *BizConsumer) Consume(timeout time.Duration) (string, error) {
-	msg, err := b.consumer.ReadMessage(timeout)
+func (b *BizConsumer) Consume(ctx context.Context) (string, error) {
+	msg, err := b.consumer.Fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	err = b.consumer.Commit(ctx, msg)
 	if err != nil {
 		return "", err
 	}
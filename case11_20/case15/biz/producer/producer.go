@@ -3,15 +3,15 @@ package producer
 import (
 	"context"
 	"encoding/json"
-	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"interview-cases/pkg/mq"
 )
 
 // Producer 业务方使用的 producer
 type Producer struct {
-	producer *kafka.Producer
+	producer mq.Producer
 }
 
-func NewProducer(producer *kafka.Producer) *Producer {
+func NewProducer(producer mq.Producer) *Producer {
 	return &Producer{producer}
 }
 
@@ -22,7 +22,6 @@ func (p *Producer) Produce(ctx context.Context,
 	// 时间戳，毫秒数
 	deadline int64,
 	bizTopic string) error {
-	topic := delayTopic
 	delayMsg := DelayMsg{
 		Value:    msg,
 		Topic:    bizTopic,
@@ -32,10 +31,9 @@ func (p *Producer) Produce(ctx context.Context,
 	if err != nil {
 		return err
 	}
-	return p.producer.Produce(&kafka.Message{
-		TopicPartition: kafka.TopicPartition{
-			Topic: &topic,
-		},
-		Value: msgByte,
-	}, nil)
+	return p.producer.Produce(ctx, mq.Message{
+		Topic:     delayTopic,
+		Partition: mq.PartitionAny,
+		Value:     msgByte,
+	})
 }
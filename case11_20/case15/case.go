@@ -0,0 +1,13 @@
+package case15
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:       15,
+		Title:    "分库分表的延迟消息平台",
+		Requires: []cases.Infra{cases.MySQLOnly, cases.Kafka},
+		Package:  "./case11_20/case15",
+		Tests:    []string{"TestDelayMsg"},
+	})
+}
@@ -2,7 +2,6 @@ package case15
 
 import (
 	"context"
-	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/ecodeclub/ekit/syncx"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -12,6 +11,7 @@ import (
 	"interview-cases/case11_20/case15/biz/producer"
 	"interview-cases/case11_20/case15/delay_platform"
 	"interview-cases/case11_20/case15/delay_platform/dao"
+	"interview-cases/pkg/mq"
 	"interview-cases/test"
 	"log"
 	"testing"
@@ -23,7 +23,7 @@ const bizTopic = "biz_topic"
 
 type TestSuite struct {
 	suite.Suite
-	addr        string
+	broker      mq.Broker
 	producer    *producer.Producer
 	bizConsumer *consumer.BizConsumer
 	db          *gorm.DB
@@ -35,42 +35,29 @@ type WantDelayMsg struct {
 }
 
 func (s *TestSuite) SetupSuite() {
-	s.db = test.InitDB()
+	// 延迟消息分布在多个库里面，嵌入式数据库没有办法跨库访问
+	s.db = test.RequireDB(s.T(), test.CapCrossDatabase)
 	s.initTopic()
 	s.initConsumerAndProducer()
 }
 
 func (s *TestSuite) initTopic() {
-	test.InitTopic(
-		kafka.TopicSpecification{
-			Topic:         "delay_topic",
-			NumPartitions: 1,
-		},
-		kafka.TopicSpecification{
-			Topic:         bizTopic,
-			NumPartitions: 1,
-		},
-	)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := s.broker.CreateTopic(ctx, "delay_topic", 1)
+	require.NoError(s.T(), err)
+	err = s.broker.CreateTopic(ctx, bizTopic, 1)
+	require.NoError(s.T(), err)
 }
 
 func (s *TestSuite) initConsumerAndProducer() {
-	kafkaProducer, err := kafka.NewProducer(&kafka.ConfigMap{
-		"bootstrap.servers": s.addr,
-	})
+	kafkaProducer, err := s.broker.Producer()
 	require.NoError(s.T(), err)
 	s.producer = producer.NewProducer(kafkaProducer)
 	require.NoError(s.T(), err)
 	msgDAO := dao.NewDelayMsgDAO(s.db)
 
-	config := &kafka.ConfigMap{
-		"bootstrap.servers":  s.addr,
-		"group.id":           "delay_msg_group",
-		"auto.offset.reset":  "earliest",
-		"enable.auto.commit": "false",
-	}
-	kaCon, err := kafka.NewConsumer(config)
-	require.NoError(s.T(), err)
-	err = kaCon.SubscribeTopics([]string{"delay_topic"}, nil)
+	kaCon, err := s.broker.Consumer("delay_msg_group", "delay_topic")
 	require.NoError(s.T(), err)
 	receiver := delay_platform.NewDelayMsgReceiver(kaCon, msgDAO)
 	// 启动延迟消息接收者，测试环境下，一个就够了
@@ -81,13 +68,13 @@ func (s *TestSuite) initConsumerAndProducer() {
 	s.initSenders(kafkaProducer, msgDAO)
 
 	// 初始化业务消费者
-	bizConsumer, err := consumer.NewBizConsumer(s.addr)
+	bizConsumer, err := consumer.NewBizConsumer(s.broker)
 	require.NoError(s.T(), err)
 	s.bizConsumer = bizConsumer
 }
 
-func (s *TestSuite) initSenders(kafkaProducer *kafka.Producer, msgDAO *dao.DelayMsgDAO) {
-	topicMap := &syncx.Map[string, *kafka.Producer]{}
+func (s *TestSuite) initSenders(kafkaProducer mq.Producer, msgDAO *dao.DelayMsgDAO) {
+	topicMap := &syncx.Map[string, mq.Producer]{}
 	topicMap.Store(bizTopic, kafkaProducer)
 	tables := []string{
 		"delay_msg_db_0.delay_msg_tab_0",
@@ -155,7 +142,7 @@ func (s *TestSuite) TestDelayMsg() {
 	}
 	for _, want := range wantMsgs {
 		startTime := want.StartTime
-		msg, err := s.bizConsumer.Consume(-1)
+		msg, err := s.bizConsumer.Consume(context.Background())
 		subTime := time.Now().Sub(startTime)
 		log.Printf("开始校验 %v 睡了 %f秒", want, subTime.Seconds())
 		assert.NoError(s.T(), err)
@@ -169,7 +156,7 @@ func (s *TestSuite) TestDelayMsg() {
 // 不然你的数据库，Kafka 上有各种残余的数据，你运行会各种失败。
 func TestDelayMsg(t *testing.T) {
 	suite.Run(t, &TestSuite{
-		// 记得换你的 Kafka 地址
-		addr: "127.0.0.1:9092",
+		// 连不上 Kafka 的时候会使用进程内的消息队列
+		broker: test.InitMQ(),
 	})
 }
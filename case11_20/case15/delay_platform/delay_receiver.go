@@ -4,27 +4,27 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/ecodeclub/ekit/sqlx"
 	"interview-cases/case11_20/case15/delay_platform/dao"
+	"interview-cases/pkg/mq"
 	"log/slog"
 	"time"
 )
 
 // DelayMsgReceiver 延迟消息接收者
 type DelayMsgReceiver struct {
-	consumer *kafka.Consumer
+	consumer mq.Consumer
 	dao      *dao.DelayMsgDAO
 }
 
-func NewDelayMsgReceiver(consumer *kafka.Consumer, dao *dao.DelayMsgDAO) *DelayMsgReceiver {
+func NewDelayMsgReceiver(consumer mq.Consumer, dao *dao.DelayMsgDAO) *DelayMsgReceiver {
 	return &DelayMsgReceiver{consumer: consumer, dao: dao}
 }
 
 // ReceiveMsg 接收消息进行转发
 func (receiver *DelayMsgReceiver) ReceiveMsg() {
 	for {
-		msg, err := receiver.consumer.ReadMessage(-1)
+		msg, err := receiver.consumer.Fetch(context.Background())
 		if err != nil {
 			// 失败记录一下报错然后重试
 			// 这里如果一直失败其实也没什么很好的办法，可以告警，然后人手工介入处理
@@ -42,7 +42,7 @@ func (receiver *DelayMsgReceiver) ReceiveMsg() {
 	}
 }
 
-func (receiver *DelayMsgReceiver) sendToDb(msg *kafka.Message) error {
+func (receiver *DelayMsgReceiver) sendToDb(msg mq.Message) error {
 	type DelayMsg struct {
 		// 转发内容
 		Value []byte
@@ -72,7 +72,7 @@ func (receiver *DelayMsgReceiver) sendToDb(msg *kafka.Message) error {
 	if err != nil {
 		return fmt.Errorf("转储消息失败 %w", err)
 	}
-	_, err = receiver.consumer.CommitMessage(msg)
+	err = receiver.consumer.Commit(ctx, msg)
 	if err != nil {
 		return fmt.Errorf("转储消息失败 %w", err)
 	}
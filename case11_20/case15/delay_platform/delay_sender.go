@@ -3,9 +3,9 @@ package delay_platform
 import (
 	"context"
 	"fmt"
-	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/ecodeclub/ekit/syncx"
 	"interview-cases/case11_20/case15/delay_platform/dao"
+	"interview-cases/pkg/mq"
 	"log/slog"
 	"sync"
 	"time"
@@ -13,13 +13,13 @@ import (
 
 // DelayMsgSender 延迟消息发送者
 type DelayMsgSender struct {
-	topicConn *syncx.Map[string, *kafka.Producer]
+	topicConn *syncx.Map[string, mq.Producer]
 	dao       *dao.DelayMsgDAO
 	// 轮询的目标表
 	dst string
 }
 
-func NewDelayMsgSender(topicConn *syncx.Map[string, *kafka.Producer],
+func NewDelayMsgSender(topicConn *syncx.Map[string, mq.Producer],
 	dao *dao.DelayMsgDAO,
 	dst string,
 ) *DelayMsgSender {
@@ -80,13 +80,12 @@ func (sender *DelayMsgSender) sendMsg(ctx context.Context, msg dao.DelayMsg) err
 	if !ok {
 		return fmt.Errorf("未知topic %s", msg.Topic)
 	}
-	topic := msg.Topic
-	conn.BeginTransaction()
-	err := conn.Produce(&kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &topic},
-		Value:          msg.Value,
-		Key:            []byte(msg.Key.String),
-	}, nil)
+	err := conn.Produce(ctx, mq.Message{
+		Topic:     msg.Topic,
+		Partition: mq.PartitionAny,
+		Value:     msg.Value,
+		Key:       []byte(msg.Key.String),
+	})
 	if err != nil {
 		return fmt.Errorf("消息 %v 转发失败 %w", msg, err)
 	}
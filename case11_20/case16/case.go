@@ -0,0 +1,12 @@
+package case16
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:      16,
+		Title:   "Redis 主从节点故障切换",
+		Package: "./case11_20/case16",
+		Tests:   []string{"TestRedisManager"},
+	})
+}
@@ -0,0 +1,12 @@
+package case17
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:      17,
+		Title:   "根据内存使用率限流的 gRPC 拦截器",
+		Package: "./case11_20/case17",
+		Tests:   []string{"TestLimit"},
+	})
+}
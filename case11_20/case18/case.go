@@ -0,0 +1,13 @@
+package case18
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:       18,
+		Title:    "基于 Redis 的滑动窗口限流",
+		Requires: []cases.Infra{cases.Redis},
+		Package:  "./case11_20/case18",
+		Tests:    []string{"TestLimiter_Allow"},
+	})
+}
@@ -0,0 +1,13 @@
+package case20
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:       20,
+		Title:    "Redis 里面大 JSON 的存储优化",
+		Requires: []cases.Infra{cases.Redis},
+		Package:  "./case11_20/case20",
+		Tests:    []string{"TestJson"},
+	})
+}
@@ -1,12 +1,12 @@
 package case20
 
 import (
+	"context"
 	"fmt"
-	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"interview-cases/pkg/bench"
 	"interview-cases/test"
 	"testing"
-	"time"
 )
 
 func TestJson(t *testing.T) {
@@ -17,22 +17,25 @@ func TestJson(t *testing.T) {
 	err := jsonClient.SetBusiness()
 	require.NoError(t, err)
 
-	startTime := time.Now().UnixMilli()
-	for i := 0; i < 1000; i++ {
-		res, err := jsonClient.MyBusiness()
-		require.NoError(t, err)
-		assert.Equal(t, "NVIDIA GTX 1650", res)
-	}
-	endTime := time.Now().UnixMilli()
-	fmt.Printf("通过直接存储json花了 %dms\n", endTime-startTime)
+	// 每次只读一个字段，重复 1000 次来看延迟的分布
+	test.RunBench(t, "case20 json vs hash", bench.Options{Warmup: 100, Repetitions: 1000}, nil,
+		// 直接存储 json
+		bench.Variant{Name: "MyBusiness", Run: func(ctx context.Context) error {
+			return checkGraphics(jsonClient.MyBusiness())
+		}},
+		// 优化成 map
+		bench.Variant{Name: "MyBusinessV1", Run: func(ctx context.Context) error {
+			return checkGraphics(jsonClient.MyBusinessV1())
+		}},
+	)
+}
 
-	startTime = time.Now().UnixMilli()
-	for i := 0; i < 1000; i++ {
-		res, err := jsonClient.MyBusinessV1()
-		require.NoError(t, err)
-		assert.Equal(t, "NVIDIA GTX 1650", res)
+func checkGraphics(res string, err error) error {
+	if err != nil {
+		return err
 	}
-	endTime = time.Now().UnixMilli()
-	fmt.Printf("优化成map花了 %dms\n", endTime-startTime)
-
+	if res != "NVIDIA GTX 1650" {
+		return fmt.Errorf("读到的数据不对 %s", res)
+	}
+	return nil
 }
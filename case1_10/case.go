@@ -0,0 +1,58 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package case1_10
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:       1,
+		Title:    "覆盖索引：把查询的列放进联合索引",
+		Requires: []cases.Infra{cases.MySQL},
+		Package:  "./case1_10",
+		Tests:    []string{"TestCase1"},
+	})
+
+	cases.Register(cases.Case{
+		ID:       4,
+		Title:    "利用索引来排序，避免 filesort",
+		Requires: []cases.Infra{cases.MySQL},
+		Package:  "./case1_10",
+		Tests:    []string{"TestCase4"},
+	})
+
+	cases.Register(cases.Case{
+		ID:      5,
+		Title:   "逃逸分析：返回指针还是结构体",
+		Package: "./case1_10",
+		Tests:   []string{"TestCase5"},
+	})
+
+	cases.Register(cases.Case{
+		ID:       6,
+		Title:    "深度分页：用子查询优化 LIMIT OFFSET",
+		Requires: []cases.Infra{cases.MySQL},
+		Package:  "./case1_10",
+		Tests:    []string{"TestCase6"},
+	})
+
+	cases.Register(cases.Case{
+		ID:       7,
+		Title:    "深度分页：用上一页的最大 id 作为查询条件",
+		Requires: []cases.Infra{cases.MySQL},
+		Package:  "./case1_10",
+		Tests:    []string{"TestCase7"},
+	})
+}
@@ -0,0 +1,108 @@
+// Package case1 把 case1 的覆盖索引用在真正的登录接口上：
+// users_after 上的 name_pwd 是 (username, password_hash) 联合索引，
+// 登录只需要 id、username 和 password_hash，InnoDB 的二级索引后面隐含了主键 id，所以不需要回表
+package case1
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"interview-cases/case1_10"
+	"interview-cases/pkg/credential"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// ErrInvalidCredential 用户不存在或者密码不对，两种情况对外不做区分，不然可以用来探测用户名
+var ErrInvalidCredential = errors.New("case1: 用户名或者密码不对")
+
+type LoginHandler struct {
+	db     *gorm.DB
+	hasher *credential.Hasher
+}
+
+func NewLoginHandler(db *gorm.DB, hasher *credential.Hasher) *LoginHandler {
+	return &LoginHandler{db: db, hasher: hasher}
+}
+
+func (h *LoginHandler) RegisterRouter(server *gin.Engine) {
+	server.POST("/users/login", h.Login)
+}
+
+type LoginReq struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type LoginResp struct {
+	ID       uint   `json:"id"`
+	Username string `json:"username"`
+}
+
+func (h *LoginHandler) Login(c *gin.Context) {
+	var req LoginReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.String(http.StatusBadRequest, "用户名和密码都不能为空")
+		return
+	}
+	u, err := h.Verify(c.Request.Context(), req.Username, req.Password)
+	switch {
+	case errors.Is(err, ErrInvalidCredential):
+		c.String(http.StatusUnauthorized, "用户名或者密码不对")
+	case err != nil:
+		slog.Error("登录失败", slog.String("username", req.Username), slog.Any("err", err))
+		c.String(http.StatusInternalServerError, "系统错误")
+	default:
+		c.JSON(http.StatusOK, LoginResp{ID: u.ID, Username: u.Username})
+	}
+}
+
+// Verify 校验用户名和密码，成功的时候返回用户，只有 id、username 和 password_hash 三个字段
+// 哈希值用的是旧的算法或者参数的话，顺便用新的参数重新计算
+func (h *LoginHandler) Verify(ctx context.Context, username, password string) (case1_10.UserAfter, error) {
+	var u case1_10.UserAfter
+	// 用 Take 而不是 First，First 会加上 ORDER BY id
+	err := h.db.WithContext(ctx).Select("id, username, password_hash").
+		Where("username = ?", username).Take(&u).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// 用户不存在也算一次哈希，响应时间和密码不对的时候差不多
+		h.hasher.VerifyDummy(password)
+		return u, ErrInvalidCredential
+	}
+	if err != nil {
+		return u, err
+	}
+	rehash, err := h.hasher.Verify(u.PasswordHash, password)
+	if errors.Is(err, credential.ErrMismatch) {
+		return u, ErrInvalidCredential
+	}
+	if err != nil {
+		return u, err
+	}
+	if rehash {
+		// 升级失败不影响这一次登录，下一次登录还会再试
+		err = h.upgrade(ctx, &u, password)
+		if err != nil {
+			slog.Warn("升级密码哈希失败", slog.Uint64("id", uint64(u.ID)), slog.Any("err", err))
+		}
+	}
+	return u, nil
+}
+
+// upgrade 只有哈希值没有被改过才更新，避免覆盖掉同时发生的修改密码
+func (h *LoginHandler) upgrade(ctx context.Context, u *case1_10.UserAfter, password string) error {
+	hash, err := h.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	err = h.db.WithContext(ctx).Model(&case1_10.UserAfter{}).
+		Where("id = ? AND password_hash = ?", u.ID, u.PasswordHash).
+		Updates(map[string]any{"password_hash": hash, "utime": time.Now().UnixMilli()}).Error
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = hash
+	return nil
+}
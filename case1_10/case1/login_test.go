@@ -0,0 +1,143 @@
+package case1
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+	"interview-cases/case1_10"
+	"interview-cases/pkg/credential"
+	"interview-cases/pkg/explain"
+	"interview-cases/test"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// LoginTestSuite alice 的密码是用当前的参数计算的，bob 的还是以前的 bcrypt
+type LoginTestSuite struct {
+	suite.Suite
+	db     *gorm.DB
+	hasher *credential.Hasher
+	server *gin.Engine
+}
+
+func (s *LoginTestSuite) SetupSuite() {
+	s.db = test.InitDB()
+	err := s.db.AutoMigrate(&case1_10.UserAfter{})
+	require.NoError(s.T(), err)
+	err = test.Truncate(s.db, "users_after")
+	require.NoError(s.T(), err)
+
+	s.hasher = newHasher(s.T(), credential.Argon2id)
+	old := newHasher(s.T(), credential.Bcrypt)
+	for _, u := range []struct {
+		name   string
+		hasher *credential.Hasher
+	}{{name: "alice", hasher: s.hasher}, {name: "bob", hasher: old}} {
+		hash, err := u.hasher.Hash(u.name + "_password")
+		require.NoError(s.T(), err)
+		err = s.db.Create(&case1_10.UserAfter{Username: u.name, PasswordHash: hash}).Error
+		require.NoError(s.T(), err)
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	s.server = gin.New()
+	NewLoginHandler(s.db, s.hasher).RegisterRouter(s.server)
+}
+
+func (s *LoginTestSuite) TearDownSuite() {
+	// SQLite 的索引名字是全局的，留着 users_after 的话，在线表结构变更就建不了 name_pwd 索引了
+	err := s.db.Migrator().DropTable(&case1_10.UserAfter{})
+	require.NoError(s.T(), err)
+}
+
+func (s *LoginTestSuite) TestLogin() {
+	testCases := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantUser string
+	}{
+		{name: "登录成功", body: `{"username":"alice","password":"alice_password"}`, wantCode: http.StatusOK, wantUser: "alice"},
+		{name: "密码不对", body: `{"username":"alice","password":"bob_password"}`, wantCode: http.StatusUnauthorized},
+		{name: "用户不存在", body: `{"username":"carol","password":"alice_password"}`, wantCode: http.StatusUnauthorized},
+		{name: "没有密码", body: `{"username":"alice"}`, wantCode: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			code, resp := s.login(tc.body)
+			assert.Equal(s.T(), tc.wantCode, code)
+			assert.Equal(s.T(), tc.wantUser, resp.Username)
+		})
+	}
+}
+
+// TestUpgrade bob 登录成功之后，哈希值换成了 argon2id，之后还能正常登录
+func (s *LoginTestSuite) TestUpgrade() {
+	code, _ := s.login(`{"username":"bob","password":"alice_password"}`)
+	assert.Equal(s.T(), http.StatusUnauthorized, code)
+	assert.True(s.T(), strings.HasPrefix(s.hashOf("bob"), "$2a$"))
+
+	for i := 0; i < 2; i++ {
+		code, resp := s.login(`{"username":"bob","password":"bob_password"}`)
+		assert.Equal(s.T(), http.StatusOK, code)
+		assert.Equal(s.T(), "bob", resp.Username)
+		assert.True(s.T(), strings.HasPrefix(s.hashOf("bob"), "$argon2id$"))
+	}
+	s.checkPlan()
+}
+
+// checkPlan 登录的查询只需要扫描 name_pwd 索引，不需要回表
+func (s *LoginTestSuite) checkPlan() {
+	if !test.Supports(s.T(), s.db, test.CapExplain) {
+		return
+	}
+	plan, err := explain.Explain(s.db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Select("id, username, password_hash").Where("username = ?", "bob").
+			Take(&case1_10.UserAfter{})
+	})
+	require.NoError(s.T(), err)
+	s.T().Log(plan)
+	row, ok := plan.Table("users_after")
+	require.True(s.T(), ok)
+	assert.Equal(s.T(), "name_pwd", row.Key)
+	assert.True(s.T(), row.HasExtra(explain.ExtraUsingIndex))
+}
+
+func (s *LoginTestSuite) login(body string) (int, LoginResp) {
+	req := httptest.NewRequest(http.MethodPost, "/users/login", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	s.server.ServeHTTP(recorder, req)
+	var resp LoginResp
+	if recorder.Code == http.StatusOK {
+		require.NoError(s.T(), json.Unmarshal(recorder.Body.Bytes(), &resp))
+	}
+	return recorder.Code, resp
+}
+
+func (s *LoginTestSuite) hashOf(username string) string {
+	var u case1_10.UserAfter
+	err := s.db.Where("username = ?", username).Take(&u).Error
+	require.NoError(s.T(), err)
+	return u.PasswordHash
+}
+
+// newHasher 测试用的参数，比默认值快很多
+func newHasher(t *testing.T, alg credential.Algorithm) *credential.Hasher {
+	p := credential.DefaultParams()
+	p.Algorithm, p.BcryptCost = alg, 4
+	p.Argon2.Memory, p.Argon2.Time = 1024, 1
+	h, err := credential.NewHasher(p)
+	require.NoError(t, err)
+	return h
+}
+
+func TestLogin(t *testing.T) {
+	suite.Run(t, new(LoginTestSuite))
+}
@@ -0,0 +1,141 @@
+package case1
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+	"interview-cases/case1_10"
+	"interview-cases/pkg/explain"
+	"interview-cases/pkg/fixture"
+	"interview-cases/pkg/osc"
+	"interview-cases/test"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+)
+
+// MigrateTestSuite 在有写入的情况下，把 users_before 在线改成 UserAfter 的结构，
+// 也就是去掉 username 上的唯一索引，加上 (username, password_hash) 的联合索引
+type MigrateTestSuite struct {
+	suite.Suite
+	db *gorm.DB
+}
+
+func (s *MigrateTestSuite) SetupSuite() {
+	s.db = test.InitDB()
+	// 上一次失败留下来的表都删掉，重新建一张改造前的 users_before
+	err := s.db.Migrator().DropTable("_users_before_old", "_users_before_new", &case1_10.UserBefore{})
+	require.NoError(s.T(), err)
+	ctx := context.Background()
+	opts := test.FixtureOptions()
+	err = fixture.Reset(ctx, s.db, &case1_10.UserBefore{})
+	require.NoError(s.T(), err)
+	now := fixture.BaseTime.UnixMilli()
+	err = fixture.Load(ctx, s.db, opts, "case1_users", opts.Size.Rows(10000), func(r *rand.Rand, i int) case1_10.UserBefore {
+		return case1_10.UserBefore{
+			Username:     fmt.Sprintf("username_%d", i),
+			PasswordHash: fmt.Sprintf("hash_%d", r.IntN(1000000)),
+			Age:          18 + r.IntN(40),
+			Avatar:       fmt.Sprintf("头像_%d", i),
+			Ctime:        now,
+			Utime:        now,
+		}
+	})
+	require.NoError(s.T(), err)
+}
+
+func (s *MigrateTestSuite) TearDownSuite() {
+	// 恢复成改造前的结构，不影响 case1
+	err := s.db.Migrator().DropTable("_users_before_old", "_users_before_new", &case1_10.UserBefore{})
+	require.NoError(s.T(), err)
+	err = s.db.AutoMigrate(&case1_10.UserBefore{})
+	require.NoError(s.T(), err)
+}
+
+func (s *MigrateTestSuite) TestMigrate() {
+	opts := osc.DefaultOptions()
+	opts.BatchSize = 500
+
+	// 先 DryRun 看一下要执行哪些语句
+	opts.DryRun = true
+	m, err := osc.New(s.db, "users_before", &case1_10.UserAfter{}, opts)
+	require.NoError(s.T(), err)
+	plan, err := m.Plan(context.Background())
+	require.NoError(s.T(), err)
+	for _, sql := range plan.Statements {
+		s.T().Log(sql)
+	}
+	require.NoError(s.T(), m.Run(context.Background()))
+	assert.False(s.T(), s.db.Migrator().HasIndex(&case1_10.UserBefore{}, "name_pwd"))
+
+	opts.DryRun = false
+	m, err = osc.New(s.db, "users_before", &case1_10.UserAfter{}, opts)
+	require.NoError(s.T(), err)
+	// 迁移的同时模拟用户注册、修改密码和注销
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			u := case1_10.UserBefore{Username: fmt.Sprintf("new_user_%d", i), PasswordHash: "hash"}
+			assert.NoError(s.T(), s.db.Create(&u).Error)
+			assert.NoError(s.T(), s.db.Model(&u).Update("password_hash", fmt.Sprintf("hash_%d", i)).Error)
+			if i%10 == 0 {
+				assert.NoError(s.T(), s.db.Delete(&u).Error)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	// 中途暂停一下，比如说业务高峰期
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		m.Pause()
+		s.T().Logf("暂停 %+v", m.Progress())
+		time.Sleep(50 * time.Millisecond)
+		m.Resume()
+	}()
+	err = m.Run(context.Background())
+	close(stop)
+	wg.Wait()
+	require.NoError(s.T(), err)
+	s.T().Logf("完成 %+v", m.Progress())
+
+	assert.True(s.T(), s.db.Migrator().HasIndex(&case1_10.UserBefore{}, "name_pwd"))
+	// 切换之后新表继续接收写入，旧表保留下来用来回滚
+	var n int64
+	err = s.db.Model(&case1_10.UserBefore{}).Where("username LIKE ?", "new_user_%").Count(&n).Error
+	require.NoError(s.T(), err)
+	assert.Greater(s.T(), n, int64(0))
+	s.checkPlan()
+}
+
+// checkPlan 迁移之后 users_before 上的查询和 case1 里面的 users_after 一样，只需要扫描 name_pwd 索引
+func (s *MigrateTestSuite) checkPlan() {
+	if !test.Supports(s.T(), s.db, test.CapExplain) {
+		return
+	}
+	plan, err := explain.Explain(s.db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Model(&case1_10.UserBefore{}).Select("id, username, password_hash").
+			Where("username = ?", "username_999").Find(&[]case1_10.UserBefore{})
+	})
+	require.NoError(s.T(), err)
+	s.T().Log(plan)
+	row, ok := plan.Table("users_before")
+	require.True(s.T(), ok)
+	assert.Equal(s.T(), "name_pwd", row.Key)
+	assert.True(s.T(), row.HasExtra(explain.ExtraUsingIndex))
+}
+
+func TestMigrate(t *testing.T) {
+	suite.Run(t, new(MigrateTestSuite))
+}
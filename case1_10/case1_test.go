@@ -21,7 +21,9 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"gorm.io/gorm"
+	"interview-cases/pkg/explain"
 	"interview-cases/test"
+	"testing"
 	"time"
 )
 
@@ -39,9 +41,7 @@ func (s *Case1TestSuite) SetupSuite() {
 	//	LogLevel: logger.Error,
 	//})
 
-	err = s.db.Exec("TRUNCATE table `users_before`").Error
-	assert.NoError(s.T(), err)
-	err = s.db.Exec("TRUNCATE table `users_after`").Error
+	err = test.Truncate(s.db, "users_before", "users_after")
 	assert.NoError(s.T(), err)
 }
 
@@ -79,20 +79,21 @@ func (s *Case1TestSuite) TestCoverIndex() {
 			befores = append(befores, UserBefore{
 				ID:       id,
 				Username: fmt.Sprintf("username_%d", id),
-				Password: fmt.Sprintf("password_%d", id),
-				Age:      18,
-				Avatar:   fmt.Sprintf("头像_%d", id),
-				Ctime:    now,
-				Utime:    now,
+				// 这里只演示覆盖索引，哈希值随便填，真正的注册和登录参考 case1/login.go
+				PasswordHash: fmt.Sprintf("hash_%d", id),
+				Age:          18,
+				Avatar:       fmt.Sprintf("头像_%d", id),
+				Ctime:        now,
+				Utime:        now,
 			})
 			afters = append(afters, UserAfter{
-				ID:       id,
-				Username: fmt.Sprintf("username_%d", id),
-				Password: fmt.Sprintf("password_%d", id),
-				Age:      35,
-				Avatar:   fmt.Sprintf("头像_%d", id),
-				Ctime:    now,
-				Utime:    now,
+				ID:           id,
+				Username:     fmt.Sprintf("username_%d", id),
+				PasswordHash: fmt.Sprintf("hash_%d", id),
+				Age:          35,
+				Avatar:       fmt.Sprintf("头像_%d", id),
+				Ctime:        now,
+				Utime:        now,
 			})
 		}
 
@@ -105,41 +106,35 @@ func (s *Case1TestSuite) TestCoverIndex() {
 	// 现在就是来对比查询时间了
 	// 而后在控制台分别执行下面两个语句:
 	// SELECT * FROM users_before WHERE username='username_999';
-	// SELECT username, password FROM users_after WHERE username='username_999';
-}
-
-// UserBefore 改造前
-type UserBefore struct {
-	ID       uint   `gorm:"primaryKey"`
-	Username string `gorm:"unique;type:varchar(255)"`
-	Password string `gorm:"type:varchar(255)"`
-	Age      int
-	// 头像
-	Avatar string `gorm:"type:varchar(255)"`
-
-	// 其它字段，一般来说，额外字段越多，改进的效果就越好
-
-	// 时间戳
-	Utime int64
-	Ctime int64
-}
-
-func (UserBefore) TableName() string {
-	return "users_before"
+	// SELECT id, username, password_hash FROM users_after WHERE username='username_999';
+	s.checkPlan()
 }
 
-// UserAfter 改造后
-type UserAfter struct {
-	ID uint `gorm:"primaryKey"`
-	// 正常这需要唯一索引确保不冲突，也需要联合索引来加速查询
-	Username string `gorm:"index:name_pwd;type:varchar(255)"`
-	Password string `gorm:"index:name_pwd;type:varchar(255)"`
-	Age      int
-	Avatar   string `gorm:"type:varchar(255)"`
-	Utime    int64
-	Ctime    int64
+// checkPlan 确认 users_after 的查询只需要扫描 name_pwd 索引，不需要回表
+func (s *Case1TestSuite) checkPlan() {
+	if !test.Supports(s.T(), s.db, test.CapExplain) {
+		return
+	}
+	after, err := explain.Explain(s.db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Model(&UserAfter{}).Select("id, username, password_hash").
+			Where("username = ?", "username_999").Find(&[]UserAfter{})
+	})
+	require.NoError(s.T(), err)
+	s.T().Log(after)
+	row, ok := after.Table("users_after")
+	require.True(s.T(), ok)
+	assert.Equal(s.T(), "name_pwd", row.Key)
+	assert.True(s.T(), row.HasExtra(explain.ExtraUsingIndex))
+
+	before, err := explain.Explain(s.db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("username = ?", "username_999").Find(&[]UserBefore{})
+	})
+	require.NoError(s.T(), err)
+	s.T().Log(before)
+	// SELECT * 要回表，所以不会是覆盖索引
+	assert.False(s.T(), before.HasExtra(explain.ExtraUsingIndex))
 }
 
-func (UserAfter) TableName() string {
-	return "users_after"
+func TestCase1(t *testing.T) {
+	suite.Run(t, new(Case1TestSuite))
 }
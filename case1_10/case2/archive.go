@@ -0,0 +1,250 @@
+package case2
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// ErrArchiveVerify 归档表里面的订单和搬走的订单对不上，这一批已经回滚了
+var ErrArchiveVerify = errors.New("case2: 归档校验失败")
+
+// archiveMonth 归档表按照 UTC 的月份划分，不受服务器时区影响
+const archiveMonth = "200601"
+
+// OrderArchive 已经建好的归档表，每个月一张，FindByUid 根据它决定要查哪些归档表
+type OrderArchive struct {
+	// Month 例如 202410
+	Month    string `gorm:"type:varchar(6);primaryKey"`
+	Location string `gorm:"type:varchar(64)"`
+	// Total 归档进去的订单数
+	Total int64
+	Utime int64
+}
+
+func (OrderArchive) TableName() string {
+	return "order_archives"
+}
+
+// start 这个月的第一天
+func (a OrderArchive) start() time.Time {
+	t, _ := time.ParseInLocation(archiveMonth, a.Month, time.UTC)
+	return t
+}
+
+// end 下个月的第一天
+func (a OrderArchive) end() time.Time {
+	return a.start().AddDate(0, 1, 0)
+}
+
+// ArchiveTable 归档表的表名，结构和 MerchantOrder 一样，按照 (uid, create_time) 查询能走索引
+func ArchiveTable(month string) string {
+	return "orders_archive_" + month
+}
+
+// archivesSince 可能有 since 之后订单的归档表，调用方要持有读锁
+func (d *OrderDAO) archivesSince(since time.Time) []OrderArchive {
+	var res []OrderArchive
+	for _, a := range d.archives {
+		if a.end().After(since) {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+func (d *OrderDAO) addArchive(a OrderArchive) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if slices.ContainsFunc(d.archives, func(o OrderArchive) bool { return o.Month == a.Month }) {
+		return
+	}
+	d.archives = append(d.archives, a)
+	slices.SortFunc(d.archives, func(x, y OrderArchive) int {
+		// 从新到旧
+		return cmp.Compare(y.Month, x.Month)
+	})
+}
+
+func (d *OrderDAO) hasArchive(month string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return slices.ContainsFunc(d.archives, func(a OrderArchive) bool {
+		return a.Month == month
+	})
+}
+
+// Archiver 把创建时间早于 Retention 的订单按照月份搬到归档表
+//
+// 每一批订单在一个事务里面复制到归档表、校验、再从原来的表里面删掉，
+// 所以中途失败了重新运行就可以，不会丢也不会重复
+type Archiver struct {
+	dao *OrderDAO
+	// Retention 在线的表里面只保留这么久的订单
+	Retention time.Duration
+	// BatchSize 每个事务搬多少条订单
+	BatchSize int
+	// now 测试的时候替换掉，用 fixture.BaseTime 作为当前时间
+	now func() time.Time
+}
+
+func NewArchiver(dao *OrderDAO, retention time.Duration) *Archiver {
+	return &Archiver{dao: dao, Retention: retention, BatchSize: 500, now: time.Now}
+}
+
+// ArchiveResult 一次归档的结果，Months 是每个月搬了多少条
+type ArchiveResult struct {
+	Rows   int64
+	Months map[string]int64
+}
+
+// RunOnce 归档在线的表里面所有过期的订单，包括大商家独占的表
+func (a *Archiver) RunOnce(ctx context.Context) (ArchiveResult, error) {
+	a.dao.job.Lock()
+	defer a.dao.job.Unlock()
+	cutoff := a.now().Add(-a.Retention)
+	res := ArchiveResult{Months: make(map[string]int64)}
+
+	a.dao.mu.RLock()
+	tables := a.dao.liveTables()
+	a.dao.mu.RUnlock()
+
+	start := time.Now()
+	for _, table := range tables {
+		for {
+			months, err := a.archiveBatch(ctx, table, cutoff)
+			if err != nil {
+				return res, fmt.Errorf("归档 %s 失败 %w", table, err)
+			}
+			if len(months) == 0 {
+				break
+			}
+			for m, n := range months {
+				res.Months[m] += n
+				res.Rows += n
+			}
+		}
+	}
+	slog.Info("订单归档完成", slog.Time("cutoff", cutoff), slog.Int64("rows", res.Rows),
+		slog.Any("months", res.Months), slog.Duration("cost", time.Since(start)))
+	return res, nil
+}
+
+// archiveBatch 搬一批订单，返回每个月搬了多少条，没有需要归档的订单的时候返回空
+// orders 和独占的表会归档到同一张归档表，依赖订单 ID 由 OrderDAO 统一生成，不同的表之间不会重复
+func (a *Archiver) archiveBatch(ctx context.Context, table string, cutoff time.Time) (map[string]int64, error) {
+	db := a.dao.db.WithContext(ctx)
+	var batch []Order
+	err := db.Table(table).Select("id, uid, create_time").Where("create_time < ?", cutoff).
+		Order("id").Limit(a.BatchSize).Find(&batch).Error
+	if err != nil || len(batch) == 0 {
+		return nil, err
+	}
+	ids := make(map[string][]int)
+	uids := make(map[int64]int64)
+	for _, o := range batch {
+		m := o.CreateTime.UTC().Format(archiveMonth)
+		ids[m] = append(ids[m], o.ID)
+		uids[o.Uid]++
+	}
+	// MySQL 上建表会隐式提交事务，所以要在事务外面建好
+	for m := range ids {
+		err = a.ensureTable(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	months := make(map[string]int64, len(ids))
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for m, mids := range ids {
+			archive := ArchiveTable(m)
+			err := tx.Exec("INSERT INTO ? (id, uid, credit, create_time) SELECT id, uid, credit, create_time FROM ? WHERE id IN ?",
+				clause.Table{Name: archive}, clause.Table{Name: table}, mids).Error
+			if err != nil {
+				return err
+			}
+			err = verifyArchive(tx, table, archive, mids)
+			if err != nil {
+				return err
+			}
+			del := tx.Exec("DELETE FROM ? WHERE id IN ?", clause.Table{Name: table}, mids)
+			if del.Error != nil {
+				return del.Error
+			}
+			if del.RowsAffected != int64(len(mids)) {
+				return fmt.Errorf("%w: %s 删除了 %d 条，应该是 %d 条", ErrArchiveVerify, table, del.RowsAffected, len(mids))
+			}
+			err = tx.Model(&OrderArchive{}).Where("month = ?", m).Updates(map[string]any{
+				"total": gorm.Expr("total + ?", len(mids)),
+				"utime": time.Now().UnixMilli(),
+			}).Error
+			if err != nil {
+				return err
+			}
+			months[m] = int64(len(mids))
+		}
+		// 归档的订单不再算在在线的表里面，Promoter 只看在线的表有多大
+		for uid, n := range uids {
+			err := tx.Model(&MerchantStat{}).Where("uid = ?", uid).
+				Update("order_count", gorm.Expr("order_count - ?", n)).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return months, err
+}
+
+// verifyArchive 比较两张表里面这批订单的行数和各列的和，删除之前确认复制得没有问题
+func verifyArchive(tx *gorm.DB, table, archive string, ids []int) error {
+	type summary struct {
+		Cnt    int64
+		Uid    int64
+		Credit int64
+	}
+	sum := func(t string) (summary, error) {
+		var s summary
+		err := tx.Table(t).Select("COUNT(*) AS cnt, COALESCE(SUM(uid), 0) AS uid, COALESCE(SUM(credit), 0) AS credit").
+			Where("id IN ?", ids).Scan(&s).Error
+		return s, err
+	}
+	src, err := sum(table)
+	if err != nil {
+		return err
+	}
+	dst, err := sum(archive)
+	if err != nil {
+		return err
+	}
+	if src != dst || src.Cnt != int64(len(ids)) {
+		return fmt.Errorf("%w: %s %+v, %s %+v", ErrArchiveVerify, table, src, archive, dst)
+	}
+	return nil
+}
+
+// ensureTable 建好归档表并且登记到 order_archives，之后 FindByUid 才会查它
+func (a *Archiver) ensureTable(ctx context.Context, month string) error {
+	if a.dao.hasArchive(month) {
+		return nil
+	}
+	db := a.dao.db.WithContext(ctx)
+	archive := OrderArchive{Month: month, Location: ArchiveTable(month), Utime: time.Now().UnixMilli()}
+	err := db.Table(archive.Location).AutoMigrate(&MerchantOrder{})
+	if err != nil {
+		return err
+	}
+	err = db.Clauses(clause.OnConflict{DoNothing: true}).Create(&archive).Error
+	if err != nil {
+		return err
+	}
+	a.dao.addArchive(archive)
+	return nil
+}
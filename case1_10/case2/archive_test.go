@@ -0,0 +1,141 @@
+package case2
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+	"interview-cases/pkg/fixture"
+	"interview-cases/test"
+	"testing"
+	"time"
+)
+
+// ArchiveTestSuite 只保留最近 500 个小时的订单，更早的按照月份归档
+// 大商家 123 先搬到独占的表，归档的时候 orders 和独占的表都要处理
+type ArchiveTestSuite struct {
+	suite.Suite
+	db  *gorm.DB
+	dao *OrderDAO
+}
+
+func (s *ArchiveTestSuite) SetupTest() {
+	s.db = test.InitDB()
+	s.dao = initDAO(s.T(), s.db)
+	_, err := NewPromoter(s.dao, 5000).RunOnce(context.Background())
+	require.NoError(s.T(), err)
+}
+
+func (s *ArchiveTestSuite) TearDownSuite() {
+	require.NoError(s.T(), dropTables(s.db))
+}
+
+func (s *ArchiveTestSuite) TestArchive() {
+	ctx := context.Background()
+	type query struct {
+		uid   int64
+		since time.Time
+		limit int
+	}
+	queries := []query{
+		// 只需要查在线的表
+		{uid: 123, since: fixture.BaseTime.Add(-2000 * time.Hour), limit: 100},
+		// 在线的表不够，还要查归档表
+		{uid: 123, since: fixture.BaseTime.Add(-700 * time.Hour), limit: 10000},
+		{uid: 234, since: fixture.BaseTime.Add(-2000 * time.Hour), limit: 10000},
+		{uid: 456, since: fixture.BaseTime.Add(-600 * time.Hour), limit: 300},
+	}
+	before := make([][]int, 0, len(queries))
+	for _, q := range queries {
+		res, err := s.dao.FindByUid(ctx, q.uid, q.since, q.limit)
+		require.NoError(s.T(), err)
+		before = append(before, ids(res))
+	}
+
+	a := NewArchiver(s.dao, 500*time.Hour)
+	a.BatchSize = 1000
+	a.now = func() time.Time {
+		return fixture.BaseTime
+	}
+	res, err := a.RunOnce(ctx)
+	require.NoError(s.T(), err)
+	assert.Greater(s.T(), res.Rows, int64(0))
+	s.T().Logf("归档 %+v", res)
+
+	// 在线的表里面没有过期的订单了，加上归档表一共还是 12000 条
+	cutoff := fixture.BaseTime.Add(-500 * time.Hour)
+	var live, expired int64
+	for _, table := range []string{SharedTable, DedicatedTable(123)} {
+		var n int64
+		require.NoError(s.T(), s.db.Table(table).Where("create_time < ?", cutoff).Count(&n).Error)
+		expired += n
+		require.NoError(s.T(), s.db.Table(table).Count(&n).Error)
+		live += n
+	}
+	assert.Zero(s.T(), expired)
+	var archives []OrderArchive
+	require.NoError(s.T(), s.db.Find(&archives).Error)
+	var archived int64
+	for _, ar := range archives {
+		var n int64
+		require.NoError(s.T(), s.db.Table(ar.Location).Count(&n).Error)
+		assert.Equal(s.T(), ar.Total, n)
+		assert.Equal(s.T(), res.Months[ar.Month], n)
+		archived += n
+	}
+	assert.Equal(s.T(), res.Rows, archived)
+	assert.Equal(s.T(), int64(12000), live+archived)
+	var counted int64
+	require.NoError(s.T(), s.db.Model(&MerchantStat{}).Select("SUM(order_count)").Scan(&counted).Error)
+	assert.Equal(s.T(), live, counted)
+
+	// 归档前后查询的结果一样，新启动的实例从 order_archives 加载归档表
+	// 新启动的实例用另外一个节点编号
+	dao, err := NewOrderDAO(s.db, 2)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), dao.Init(ctx))
+	for _, d := range []*OrderDAO{s.dao, dao} {
+		for i, q := range queries {
+			res, err := d.FindByUid(ctx, q.uid, q.since, q.limit)
+			require.NoError(s.T(), err)
+			assert.Equal(s.T(), before[i], ids(res))
+		}
+	}
+
+	// 再运行一次没有需要归档的订单
+	res, err = a.RunOnce(ctx)
+	require.NoError(s.T(), err)
+	assert.Zero(s.T(), res.Rows)
+}
+
+// TestArchive_NewOrders 搬迁之后新写的订单，orders 和独占的表上各有一条，归档到同一张表不会主键冲突
+func (s *ArchiveTestSuite) TestArchive_NewOrders() {
+	ctx := context.Background()
+	require.Equal(s.T(), StorageDedicated, s.dao.Route(123).Storage)
+	ctime := fixture.BaseTime.Add(-600 * time.Hour)
+	shared := Order{Uid: 234, Credit: 1, CreateTime: ctime}
+	require.NoError(s.T(), s.dao.Insert(ctx, &shared))
+	dedicated := Order{Uid: 123, Credit: 2, CreateTime: ctime}
+	require.NoError(s.T(), s.dao.Insert(ctx, &dedicated))
+	assert.NotEqual(s.T(), shared.ID, dedicated.ID)
+
+	a := NewArchiver(s.dao, 500*time.Hour)
+	a.now = func() time.Time {
+		return fixture.BaseTime
+	}
+	_, err := a.RunOnce(ctx)
+	require.NoError(s.T(), err)
+
+	var archived []Order
+	err = s.db.Table(ArchiveTable(ctime.UTC().Format(archiveMonth))).
+		Where("id IN ?", []int{shared.ID, dedicated.ID}).Order("uid").Find(&archived).Error
+	require.NoError(s.T(), err)
+	require.Len(s.T(), archived, 2)
+	assert.Equal(s.T(), []int64{123, 234}, []int64{archived[0].Uid, archived[1].Uid})
+	assert.Equal(s.T(), []int{2, 1}, []int{archived[0].Credit, archived[1].Credit})
+}
+
+func TestArchive(t *testing.T) {
+	suite.Run(t, new(ArchiveTestSuite))
+}
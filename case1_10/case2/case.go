@@ -0,0 +1,14 @@
+package case2
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:       2,
+		Title:    "索引失效：命中的数据量占比太大",
+		Requires: []cases.Infra{cases.MySQL},
+		Package:  "./case1_10/case2",
+		Tests:    []string{"TestCase2"},
+		Doc:      "case1_10/case2/case2.md",
+	})
+}
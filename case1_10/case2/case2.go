@@ -3,6 +3,7 @@ package case2
 import (
 	"context"
 	"gorm.io/gorm"
+	"interview-cases/pkg/fixture"
 	"math/rand/v2"
 	"time"
 )
@@ -18,44 +19,25 @@ func InitTable(db *gorm.DB) error {
 	return db.AutoMigrate(&Order{})
 }
 
-func InitData(db *gorm.DB) error {
-	baseCreated, err := time.Parse(time.DateOnly, "2024-11-01")
+// merchants 大商家 123 的订单占了绝大部分，小商家 234 和 456 各占一点
+var merchants = fixture.NewWeighted(
+	fixture.WeightedItem[int64]{Item: 123, Weight: 10},
+	fixture.WeightedItem[int64]{Item: 234, Weight: 1},
+	fixture.WeightedItem[int64]{Item: 456, Weight: 1},
+)
+
+// InitData 清空订单，按照 opts 重新生成数据，medium 档位是 12000 条
+func InitData(db *gorm.DB, opts fixture.Options) error {
+	ctx := context.Background()
+	err := fixture.Reset(ctx, db, &Order{})
 	if err != nil {
 		return err
 	}
-	// 大商家123
-	for i := 0; i < 10000; i++ {
-		err := db.WithContext(context.Background()).Create(&Order{
-			Uid:        123,
-			Credit:     i,
-			CreateTime: baseCreated.Add(-1 * time.Duration(rand.IntN(1000)) * time.Hour),
-		}).Error
-		if err != nil {
-			continue
-		}
-	}
-	// 小商家234
-	for i := 0; i < 1000; i++ {
-		err := db.WithContext(context.Background()).Create(&Order{
-			Uid:        234,
+	return fixture.Load(ctx, db, opts, "case2_orders", opts.Size.Rows(12000), func(r *rand.Rand, i int) Order {
+		return Order{
+			Uid:        merchants.Pick(r),
 			Credit:     i,
-			CreateTime: baseCreated.Add(-1 * time.Duration(rand.IntN(1000)) * time.Hour),
-		}).Error
-		if err != nil {
-			continue
+			CreateTime: fixture.BaseTime.Add(-1 * time.Duration(r.IntN(1000)) * time.Hour),
 		}
-	}
-	// 小商家456
-	for i := 0; i < 1000; i++ {
-		err := db.WithContext(context.Background()).Create(&Order{
-			Uid:        456,
-			Credit:     i,
-			CreateTime: baseCreated.Add(-1 * time.Duration(rand.IntN(1000)) * time.Hour),
-		}).Error
-		if err != nil {
-			continue
-		}
-	}
-
-	return nil
+	})
 }
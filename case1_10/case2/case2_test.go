@@ -10,6 +10,6 @@ func TestCase2(t *testing.T) {
 	db := test.InitDB()
 	err := InitTable(db)
 	require.NoError(t, err)
-	err = InitData(db)
+	err = InitData(db, test.FixtureOptions())
 	require.NoError(t, err)
 }
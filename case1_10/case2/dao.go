@@ -0,0 +1,313 @@
+package case2
+
+import (
+	"context"
+	"fmt"
+	"github.com/bwmarrin/snowflake"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OrderDAO 根据 uid 把订单路由到不同的表，调用方不需要知道订单存在哪里
+//
+// 小商家共用 orders 表，大商家的订单数占比太大，orders 上 (create_time, uid) 的索引对它们没有用，
+// 所以 Promoter 会把它们搬到独占的表里面，独占的表上是 (uid, create_time) 索引
+type OrderDAO struct {
+	db *gorm.DB
+	// mu 保护 routes，Insert 全程持有读锁，Promoter 切换路由的时候加写锁，
+	// 这样切换之后不会再有这个商家的订单写到 orders 里面
+	mu sync.RWMutex
+	// routes 不是 StorageShared 的商家，一般只有几个
+	routes map[int64]MerchantStat
+	// archives 已经有的归档表，按照月份从新到旧排列
+	archives []OrderArchive
+	// job Promoter 和 Archiver 都会搬订单，同一时间只运行一个
+	job   sync.Mutex
+	hooks []InsertHook
+	// node 生成订单 ID，orders、独占的表和归档表共用一个 ID 空间，
+	// 不能用各自的自增 ID，不然搬迁、归档的时候同一个 ID 会出现在两张表里面
+	node *snowflake.Node
+}
+
+// InsertHook 和订单在同一个事务里面执行，返回 error 的时候订单也会回滚
+type InsertHook func(tx *gorm.DB, o *Order) error
+
+// NewOrderDAO nodeID 是生成订单 ID 的节点编号，范围是 [0, 1023]，
+// 多个实例部署的时候每个实例的 nodeID 必须不一样，不然同一毫秒内可能生成同样的 ID
+func NewOrderDAO(db *gorm.DB, nodeID int64) (*OrderDAO, error) {
+	node, err := snowflake.NewNode(nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("创建 ID 生成节点失败 %w", err)
+	}
+	return &OrderDAO{db: db, routes: make(map[int64]MerchantStat), node: node}, nil
+}
+
+// Init 建表并且加载路由
+func (d *OrderDAO) Init(ctx context.Context) error {
+	err := d.db.WithContext(ctx).AutoMigrate(&Order{}, &MerchantStat{}, &OrderArchive{})
+	if err != nil {
+		return err
+	}
+	return d.Reload(ctx)
+}
+
+// Reload 从 merchant_stats 和 order_archives 重新加载路由，多个实例部署的时候要定时调用
+func (d *OrderDAO) Reload(ctx context.Context) error {
+	var stats []MerchantStat
+	err := d.db.WithContext(ctx).Where("storage <> ?", StorageShared).Find(&stats).Error
+	if err != nil {
+		return err
+	}
+	routes := make(map[int64]MerchantStat, len(stats))
+	for _, s := range stats {
+		routes[s.Uid] = s
+	}
+	var archives []OrderArchive
+	err = d.db.WithContext(ctx).Order("month DESC").Find(&archives).Error
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.routes = routes
+	d.archives = archives
+	return nil
+}
+
+// Route 商家的订单存在哪里
+func (d *OrderDAO) Route(uid int64) MerchantStat {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.route(uid)
+}
+
+func (d *OrderDAO) route(uid int64) MerchantStat {
+	if s, ok := d.routes[uid]; ok {
+		return s
+	}
+	return MerchantStat{Uid: uid, Storage: StorageShared, Location: SharedTable}
+}
+
+func (d *OrderDAO) setRoute(s MerchantStat) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.routes[s.Uid] = s
+}
+
+// OnInsert 注册写订单的钩子，要在开始写订单之前注册
+func (d *OrderDAO) OnInsert(h InsertHook) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hooks = append(d.hooks, h)
+}
+
+// Insert 写订单，同时更新商家的订单数
+// 订单 ID 由 DAO 生成，所以新订单都要通过 Insert 写入
+func (d *OrderDAO) Insert(ctx context.Context, o *Order) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	table := d.route(o.Uid).Location
+	if o.ID == 0 {
+		o.ID = int(d.node.Generate().Int64())
+	}
+	// 不依赖数据库的默认值，钩子里面要用到创建时间
+	if o.CreateTime.IsZero() {
+		o.CreateTime = time.Now()
+	}
+	now := time.Now().UnixMilli()
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Table(table).Create(o).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "uid"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"order_count": gorm.Expr("order_count + ?", 1),
+				"utime":       now,
+			}),
+		}).Create(&MerchantStat{
+			Uid:        o.Uid,
+			OrderCount: 1,
+			Storage:    StorageShared,
+			Location:   SharedTable,
+			Utime:      now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		for _, h := range d.hooks {
+			err = h(tx, o)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FindByUid 商家 since 之后的订单，按照创建时间倒序
+// 正在搬迁的商家要查两张表，先查 orders 再查独占的表，
+// 这样一批订单刚好在两次查询之间搬过去的话，只会查到两次（按照 ID 去重），不会漏掉
+// 已经归档的订单按照月份从新到旧逐个查询归档表，够了 limit 条就不再往前查
+func (d *OrderDAO) FindByUid(ctx context.Context, uid int64, since time.Time, limit int) ([]Order, error) {
+	route := d.Route(uid)
+	query := func(table string) ([]Order, error) {
+		var res []Order
+		err := d.db.WithContext(ctx).Table(table).
+			Where("uid = ? AND create_time >= ?", uid, since).
+			Order("create_time DESC, id DESC").Limit(limit).Find(&res).Error
+		return res, err
+	}
+	tables := []string{route.Location}
+	if route.Storage == StorageMigrating {
+		tables = []string{SharedTable, route.Location}
+	}
+	var res []Order
+	for _, table := range tables {
+		orders, err := query(table)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, orders...)
+	}
+	res = mergeOrders(res, limit)
+	// 查完在线的表之后再看有哪些归档表，Archiver 先登记归档表再搬订单，
+	// 所以查询在线的表的时候已经搬走的订单，一定在这里拿到的归档表里面
+	d.mu.RLock()
+	archives := d.archivesSince(since)
+	d.mu.RUnlock()
+	for _, a := range archives {
+		// 归档表按照月份从新到旧排列，已经有 limit 条比这个月更新的订单了，前面的月份不用再查
+		if len(res) == limit && !res[limit-1].CreateTime.Before(a.end()) {
+			break
+		}
+		orders, err := query(a.Location)
+		if err != nil {
+			return nil, err
+		}
+		res = mergeOrders(append(res, orders...), limit)
+	}
+	return res, nil
+}
+
+// tablesBetween 可能有 [start, end) 之间订单的表，uid 为 0 的时候返回所有商家的表
+func (d *OrderDAO) tablesBetween(uid int64, start, end time.Time) []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var tables []string
+	if uid == 0 {
+		tables = d.liveTables()
+	} else {
+		route := d.route(uid)
+		tables = append(tables, route.Location)
+		if route.Storage == StorageMigrating {
+			tables = append(tables, SharedTable)
+		}
+	}
+	for _, a := range d.archivesSince(start) {
+		if a.start().Before(end) {
+			tables = append(tables, a.Location)
+		}
+	}
+	slices.Sort(tables)
+	return slices.Compact(tables)
+}
+
+// liveTables 在线的表，也就是 orders 和所有独占的表，调用方要持有读锁
+func (d *OrderDAO) liveTables() []string {
+	tables := []string{SharedTable}
+	for _, s := range d.routes {
+		tables = append(tables, s.Location)
+	}
+	// 搬迁中的商家会同时出现在 orders 和独占的表里面
+	slices.Sort(tables)
+	return slices.Compact(tables)
+}
+
+// unionAll 把多张表上同样的查询用 UNION ALL 拼成一条语句，
+// 一条语句看到的是同一个快照，订单在两张表之间搬迁也不会重复或者遗漏
+func unionAll(tables []string, sel string, where string, args ...any) (string, []any) {
+	var sb strings.Builder
+	vars := make([]any, 0, len(tables)*(len(args)+1))
+	for i, table := range tables {
+		if i > 0 {
+			sb.WriteString(" UNION ALL ")
+		}
+		sb.WriteString("SELECT " + sel + " FROM ? WHERE " + where)
+		vars = append(vars, clause.Table{Name: table})
+		vars = append(vars, args...)
+	}
+	return sb.String(), vars
+}
+
+// mergeOrders 按照 ID 去重，然后按照创建时间倒序排列，只保留前 limit 条
+func mergeOrders(orders []Order, limit int) []Order {
+	seen := make(map[int]struct{}, len(orders))
+	res := orders[:0]
+	for _, o := range orders {
+		if _, ok := seen[o.ID]; !ok {
+			seen[o.ID] = struct{}{}
+			res = append(res, o)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].CreateTime.Equal(res[j].CreateTime) {
+			return res[i].CreateTime.After(res[j].CreateTime)
+		}
+		return res[i].ID > res[j].ID
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res
+}
+
+// RebuildStats 重新统计每个商家的订单数，例如数据是 InitData 直接写进去的，没有经过 Insert
+func (d *OrderDAO) RebuildStats(ctx context.Context) error {
+	d.mu.RLock()
+	tables := []string{SharedTable}
+	for _, s := range d.routes {
+		tables = append(tables, s.Location)
+	}
+	d.mu.RUnlock()
+
+	counts := make(map[int64]int64)
+	for _, table := range tables {
+		var rows []struct {
+			Uid int64
+			Cnt int64
+		}
+		err := d.db.WithContext(ctx).Table(table).Select("uid, COUNT(*) AS cnt").
+			Group("uid").Scan(&rows).Error
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			counts[r.Uid] += r.Cnt
+		}
+	}
+	if len(counts) == 0 {
+		return nil
+	}
+	now := time.Now().UnixMilli()
+	stats := make([]MerchantStat, 0, len(counts))
+	for uid, cnt := range counts {
+		stats = append(stats, MerchantStat{
+			Uid:        uid,
+			OrderCount: cnt,
+			Storage:    StorageShared,
+			Location:   SharedTable,
+			Utime:      now,
+		})
+	}
+	return d.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "uid"}},
+		DoUpdates: clause.AssignmentColumns([]string{"order_count", "utime"}),
+	}).Create(&stats).Error
+}
@@ -0,0 +1,210 @@
+package case2
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+	"interview-cases/pkg/explain"
+	"interview-cases/pkg/fixture"
+	"interview-cases/test"
+	"sync"
+	"testing"
+	"time"
+)
+
+// DAOTestSuite 大商家 123 的订单数超过阈值之后搬到独占的表，234 和 456 继续留在 orders
+type DAOTestSuite struct {
+	suite.Suite
+	db  *gorm.DB
+	dao *OrderDAO
+}
+
+func (s *DAOTestSuite) SetupTest() {
+	s.db = test.InitDB()
+	s.dao = initDAO(s.T(), s.db)
+}
+
+func (s *DAOTestSuite) TearDownSuite() {
+	require.NoError(s.T(), dropTables(s.db))
+}
+
+func (s *DAOTestSuite) TestPromote() {
+	ctx := context.Background()
+	since := fixture.BaseTime.Add(-500 * time.Hour)
+	before := map[int64][]Order{}
+	for _, uid := range []int64{123, 234, 456} {
+		res, err := s.dao.FindByUid(ctx, uid, since, 100)
+		require.NoError(s.T(), err)
+		require.Len(s.T(), res, 100)
+		before[uid] = res
+	}
+
+	// 搬迁的同时还在下单，新订单比 InitData 里面的都要晚
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var inserted []Order
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			o := Order{Uid: 123, Credit: i, CreateTime: fixture.BaseTime.Add(time.Duration(i+1) * time.Second)}
+			if i%3 == 0 {
+				o.Uid = 234
+			}
+			if assert.NoError(s.T(), s.dao.Insert(ctx, &o)) {
+				inserted = append(inserted, o)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	p := NewPromoter(s.dao, 5000)
+	p.BatchSize = 1000
+	promoted, err := p.RunOnce(ctx)
+	close(stop)
+	wg.Wait()
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []int64{123}, promoted)
+
+	assert.Equal(s.T(), StorageDedicated, s.dao.Route(123).Storage)
+	assert.Equal(s.T(), StorageShared, s.dao.Route(234).Storage)
+	var n int64
+	require.NoError(s.T(), s.db.Table(SharedTable).Where("uid = ?", 123).Count(&n).Error)
+	assert.Zero(s.T(), n)
+
+	// 新下的订单排在最前面，后面接着搬迁之前的订单
+	for _, uid := range []int64{123, 234} {
+		var want []Order
+		for i := len(inserted) - 1; i >= 0; i-- {
+			if inserted[i].Uid == uid {
+				want = append(want, inserted[i])
+			}
+		}
+		want = append(want, before[uid]...)[:100]
+		res, err := s.dao.FindByUid(ctx, uid, since, 100)
+		require.NoError(s.T(), err)
+		assert.Equal(s.T(), ids(want), ids(res))
+	}
+
+	// 订单数是准确的，重新加载路由也一样
+	var stat MerchantStat
+	require.NoError(s.T(), s.db.Where("uid = ?", 123).First(&stat).Error)
+	require.NoError(s.T(), s.db.Table(DedicatedTable(123)).Count(&n).Error)
+	assert.Equal(s.T(), n, stat.OrderCount)
+	require.NoError(s.T(), s.dao.Reload(ctx))
+	assert.Equal(s.T(), stat, s.dao.Route(123))
+	s.checkPlan(since)
+}
+
+// TestMigrating 搬了一半的时候，查询合并两张表的结果和搬迁之前一样，再运行一次就能搬完
+func (s *DAOTestSuite) TestMigrating() {
+	ctx := context.Background()
+	since := fixture.BaseTime.Add(-500 * time.Hour)
+	before, err := s.dao.FindByUid(ctx, 123, since, 200)
+	require.NoError(s.T(), err)
+
+	p := NewPromoter(s.dao, 5000)
+	table := DedicatedTable(123)
+	require.NoError(s.T(), s.db.Table(table).AutoMigrate(&MerchantOrder{}))
+	require.NoError(s.T(), p.switchRoute(ctx, 123, table))
+	_, err = p.moveBatch(ctx, 123, table)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), StorageMigrating, s.dao.Route(123).Storage)
+
+	res, err := s.dao.FindByUid(ctx, 123, since, 200)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), ids(before), ids(res))
+
+	// 新订单写到独占的表，其他商家的新订单还是写到 orders，两边的 ID 不会冲突
+	o := Order{Uid: 123, CreateTime: fixture.BaseTime.Add(time.Hour)}
+	require.NoError(s.T(), s.dao.Insert(ctx, &o))
+	other := Order{Uid: 234, CreateTime: fixture.BaseTime.Add(time.Hour)}
+	require.NoError(s.T(), s.dao.Insert(ctx, &other))
+	assert.NotEqual(s.T(), o.ID, other.ID)
+	var n int64
+	require.NoError(s.T(), s.db.Table(table).Where("id = ?", o.ID).Count(&n).Error)
+	assert.Equal(s.T(), int64(1), n)
+	require.NoError(s.T(), s.db.Table(SharedTable).Where("id = ?", o.ID).Count(&n).Error)
+	assert.Zero(s.T(), n)
+	require.NoError(s.T(), s.db.Table(table).Where("id = ?", other.ID).Count(&n).Error)
+	assert.Zero(s.T(), n)
+
+	promoted, err := p.RunOnce(ctx)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []int64{123}, promoted)
+	res, err = s.dao.FindByUid(ctx, 123, since, 200)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), append([]int{o.ID}, ids(before)[:199]...), ids(res))
+}
+
+// checkPlan 独占的表上按照 uid 查询走 (uid, create_time) 索引，不需要排序
+func (s *DAOTestSuite) checkPlan(since time.Time) {
+	if !test.Supports(s.T(), s.db, test.CapExplain) {
+		return
+	}
+	table := DedicatedTable(123)
+	plan, err := explain.Explain(s.db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Table(table).Where("uid = ? AND create_time >= ?", 123, since).
+			Order("create_time DESC, id DESC").Limit(100).Find(&[]Order{})
+	})
+	require.NoError(s.T(), err)
+	s.T().Log(plan)
+	row, ok := plan.Table(table)
+	require.True(s.T(), ok)
+	assert.Equal(s.T(), "idx_"+table+"_uid_create_time", row.Key)
+	assert.False(s.T(), plan.HasExtra(explain.ExtraUsingFilesort))
+}
+
+// initDAO 重新生成订单，上一次测试留下来的独占表和归档表都删掉
+func initDAO(t *testing.T, db *gorm.DB) *OrderDAO {
+	require.NoError(t, dropTables(db))
+	require.NoError(t, InitTable(db))
+	require.NoError(t, InitData(db, test.FixtureOptions()))
+	dao, err := NewOrderDAO(db, 1)
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, dao.Init(ctx))
+	require.NoError(t, dao.RebuildStats(ctx))
+	return dao
+}
+
+func dropTables(db *gorm.DB) error {
+	tables := []any{&MerchantStat{}, &OrderArchive{}, DedicatedTable(123)}
+	if db.Migrator().HasTable(&OrderArchive{}) {
+		var archives []OrderArchive
+		err := db.Find(&archives).Error
+		if err != nil {
+			return err
+		}
+		for _, a := range archives {
+			tables = append(tables, a.Location)
+		}
+	}
+	return db.Migrator().DropTable(tables...)
+}
+
+func ids(orders []Order) []int {
+	res := make([]int, 0, len(orders))
+	for _, o := range orders {
+		res = append(res, o.ID)
+	}
+	return res
+}
+
+func TestDAO(t *testing.T) {
+	suite.Run(t, new(DAOTestSuite))
+}
+
+// TestNewOrderDAO 节点编号超出 snowflake 的范围要返回错误，不能静默地用 0
+func TestNewOrderDAO(t *testing.T) {
+	_, err := NewOrderDAO(nil, 1024)
+	assert.Error(t, err)
+	_, err = NewOrderDAO(nil, -1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,12 @@
+package case2
+
+import (
+	"interview-cases/test"
+	"os"
+	"testing"
+)
+
+// TestMain 配置了 slow_log.dir 的时候，测试结束之后会输出慢查询报告
+func TestMain(m *testing.M) {
+	os.Exit(test.RunMain(m))
+}
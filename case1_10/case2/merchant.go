@@ -0,0 +1,48 @@
+package case2
+
+import (
+	"fmt"
+	"time"
+)
+
+// SharedTable 小商家共用的订单表
+const SharedTable = "orders"
+
+// 商家订单的存储方式
+const (
+	// StorageShared 和其他小商家一起放在 orders 表
+	StorageShared = "shared"
+	// StorageMigrating 正在从 orders 搬到独占的表，新订单已经写到独占的表了，查询要合并两张表
+	StorageMigrating = "migrating"
+	// StorageDedicated 独占一张表
+	StorageDedicated = "dedicated"
+)
+
+// MerchantStat 商家的订单统计，以及订单存在哪张表里面
+type MerchantStat struct {
+	Uid        int64 `gorm:"primaryKey;autoIncrement:false"`
+	OrderCount int64
+	Storage    string `gorm:"type:varchar(16);default:shared"`
+	// Location 订单所在的表，StorageShared 的时候是 orders
+	Location string `gorm:"type:varchar(64)"`
+	Utime    int64
+}
+
+func (MerchantStat) TableName() string {
+	return "merchant_stats"
+}
+
+// MerchantOrder 大商家独占的订单表，列和 Order 一样，
+// 但是索引换成了 (uid, create_time)，这样按照 uid 查询一段时间的订单总是能走索引
+// 索引名字里面带了表名，不然 SQLite 上多张表的索引会重名
+type MerchantOrder struct {
+	ID         int   `gorm:"primaryKey;autoIncrement"`
+	Uid        int64 `gorm:"type:int;index:,composite:uid_create_time,priority:1"`
+	Credit     int
+	CreateTime time.Time `gorm:"type:datetime;default:CURRENT_TIMESTAMP;index:,composite:uid_create_time,priority:2"`
+}
+
+// DedicatedTable 大商家独占的表名
+func DedicatedTable(uid int64) string {
+	return fmt.Sprintf("orders_merchant_%d", uid)
+}
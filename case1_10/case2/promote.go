@@ -0,0 +1,137 @@
+package case2
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log/slog"
+	"time"
+)
+
+// Promoter 把订单数超过阈值的商家搬到独占的表
+//
+// 搬迁分三步：
+//  1. 建好独占的表，订单 ID 是 OrderDAO 统一生成的，搬过去的订单保留原来的 ID
+//  2. 路由切换成 StorageMigrating，新订单写到独占的表，查询合并两张表
+//  3. 按照 ID 分批把 orders 里面的订单搬过去，搬完之后切换成 StorageDedicated
+//
+// 中途失败了重新运行就可以，StorageMigrating 的商家会接着搬
+type Promoter struct {
+	dao *OrderDAO
+	// Threshold 订单数达到这个值就搬到独占的表
+	Threshold int64
+	// BatchSize 每个事务搬多少条订单
+	BatchSize int
+}
+
+func NewPromoter(dao *OrderDAO, threshold int64) *Promoter {
+	return &Promoter{dao: dao, Threshold: threshold, BatchSize: 500}
+}
+
+// RunOnce 搬迁所有需要搬迁的商家，返回搬完的商家
+func (p *Promoter) RunOnce(ctx context.Context) ([]int64, error) {
+	var uids []int64
+	err := p.dao.db.WithContext(ctx).Model(&MerchantStat{}).
+		Where("(storage = ? AND order_count >= ?) OR storage = ?", StorageShared, p.Threshold, StorageMigrating).
+		Order("uid").Pluck("uid", &uids).Error
+	if err != nil {
+		return nil, err
+	}
+	var done []int64
+	for _, uid := range uids {
+		err = p.Promote(ctx, uid)
+		if err != nil {
+			return done, fmt.Errorf("搬迁商家 %d 失败 %w", uid, err)
+		}
+		done = append(done, uid)
+	}
+	return done, nil
+}
+
+// Promote 把一个商家的订单搬到独占的表
+func (p *Promoter) Promote(ctx context.Context, uid int64) error {
+	p.dao.job.Lock()
+	defer p.dao.job.Unlock()
+	db := p.dao.db.WithContext(ctx)
+	var stat MerchantStat
+	err := db.Where("uid = ?", uid).First(&stat).Error
+	if err != nil {
+		return err
+	}
+	if stat.Storage == StorageDedicated {
+		return nil
+	}
+	table := DedicatedTable(uid)
+	if stat.Storage == StorageShared {
+		err = db.Table(table).AutoMigrate(&MerchantOrder{})
+		if err != nil {
+			return err
+		}
+		err = p.switchRoute(ctx, uid, table)
+		if err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	var moved int
+	for {
+		n, err := p.moveBatch(ctx, uid, table)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		moved += n
+	}
+
+	stat.Storage, stat.Location, stat.Utime = StorageDedicated, table, time.Now().UnixMilli()
+	err = db.Model(&MerchantStat{}).Where("uid = ?", uid).
+		Updates(map[string]any{"storage": stat.Storage, "location": stat.Location, "utime": stat.Utime}).Error
+	if err != nil {
+		return err
+	}
+	p.dao.setRoute(stat)
+	slog.Info("商家搬迁完成", slog.Int64("uid", uid), slog.String("table", table),
+		slog.Int("moved", moved), slog.Duration("cost", time.Since(start)))
+	return nil
+}
+
+// switchRoute 加写锁等正在写 orders 的 Insert 结束，然后切换成 StorageMigrating
+func (p *Promoter) switchRoute(ctx context.Context, uid int64, table string) error {
+	db := p.dao.db.WithContext(ctx)
+	p.dao.mu.Lock()
+	defer p.dao.mu.Unlock()
+	stat := MerchantStat{Uid: uid, Storage: StorageMigrating, Location: table, Utime: time.Now().UnixMilli()}
+	err := db.Model(&MerchantStat{}).Where("uid = ?", uid).
+		Updates(map[string]any{"storage": stat.Storage, "location": stat.Location, "utime": stat.Utime}).Error
+	if err != nil {
+		return err
+	}
+	p.dao.routes[uid] = stat
+	return nil
+}
+
+// moveBatch 在一个事务里面把一批订单复制到独占的表，再从 orders 里面删掉，返回搬了多少条
+func (p *Promoter) moveBatch(ctx context.Context, uid int64, table string) (int, error) {
+	var n int
+	err := p.dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var ids []int64
+		err := tx.Table(SharedTable).Where("uid = ?", uid).Order("id").
+			Limit(p.BatchSize).Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		err = tx.Exec("INSERT INTO ? (id, uid, credit, create_time) SELECT id, uid, credit, create_time FROM ? WHERE id IN ?",
+			clause.Table{Name: table}, clause.Table{Name: SharedTable}, ids).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Exec("DELETE FROM ? WHERE id IN ?", clause.Table{Name: SharedTable}, ids).Error
+		n = len(ids)
+		return err
+	})
+	return n, err
+}
@@ -0,0 +1,218 @@
+package case2
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log/slog"
+	"time"
+)
+
+// dateLayout 汇总表里面日期的格式，字符串比较的顺序和日期的顺序一样
+const dateLayout = "2006-01-02"
+
+// MerchantDaily 商家每天的订单数和积分总和
+type MerchantDaily struct {
+	Uid        int64  `gorm:"primaryKey;autoIncrement:false"`
+	Date       string `gorm:"type:varchar(10);primaryKey"`
+	OrderCount int64
+	CreditSum  int64
+	Utime      int64
+}
+
+func (MerchantDaily) TableName() string {
+	return "merchant_daily_credits"
+}
+
+// CreditSummary 一段时间内的订单数和积分总和
+type CreditSummary struct {
+	OrderCount int64
+	CreditSum  int64
+}
+
+// Rollup 按天汇总商家的积分，报表不用再扫描订单表
+//
+// 写订单的时候通过 OrderDAO 的钩子在同一个事务里面累加，
+// 每天凌晨再用订单表重新计算前一天的汇总，修正钩子之外写进去的订单（例如 InitData 直接导入的数据）
+type Rollup struct {
+	dao *OrderDAO
+	// loc 按照哪个时区划分每一天
+	loc *time.Location
+}
+
+func NewRollup(dao *OrderDAO) *Rollup {
+	return &Rollup{dao: dao, loc: time.Local}
+}
+
+// Init 建表，并且注册写订单的钩子
+func (r *Rollup) Init(ctx context.Context) error {
+	err := r.dao.db.WithContext(ctx).AutoMigrate(&MerchantDaily{})
+	if err != nil {
+		return err
+	}
+	r.dao.OnInsert(r.onInsert)
+	return nil
+}
+
+func (r *Rollup) onInsert(tx *gorm.DB, o *Order) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "uid"}, {Name: "date"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"order_count": gorm.Expr("order_count + ?", 1),
+			"credit_sum":  gorm.Expr("credit_sum + ?", o.Credit),
+			"utime":       time.Now().UnixMilli(),
+		}),
+	}).Create(&MerchantDaily{
+		Uid:        o.Uid,
+		Date:       r.date(o.CreateTime),
+		OrderCount: 1,
+		CreditSum:  int64(o.Credit),
+		Utime:      time.Now().UnixMilli(),
+	}).Error
+}
+
+// Query 商家在 [start, end) 之间的订单数和积分总和
+// 完整的天数直接读汇总表，只有两头不满一天的部分才查订单表
+func (r *Rollup) Query(ctx context.Context, uid int64, start, end time.Time) (CreditSummary, error) {
+	var res CreditSummary
+	if !start.Before(end) {
+		return res, nil
+	}
+	first, last := r.ceilDay(start), r.floorDay(end)
+	if !first.Before(last) {
+		return r.raw(ctx, uid, start, end)
+	}
+	err := r.dao.db.WithContext(ctx).Model(&MerchantDaily{}).
+		Select("COALESCE(SUM(order_count), 0) AS order_count, COALESCE(SUM(credit_sum), 0) AS credit_sum").
+		Where("uid = ? AND date >= ? AND date < ?", uid, r.date(first), r.date(last)).
+		Scan(&res).Error
+	if err != nil {
+		return res, err
+	}
+	for _, edge := range [][2]time.Time{{start, first}, {last, end}} {
+		if !edge[0].Before(edge[1]) {
+			continue
+		}
+		s, err := r.raw(ctx, uid, edge[0], edge[1])
+		if err != nil {
+			return res, err
+		}
+		res.OrderCount += s.OrderCount
+		res.CreditSum += s.CreditSum
+	}
+	return res, nil
+}
+
+// raw 直接查订单表，包括独占的表和归档表
+func (r *Rollup) raw(ctx context.Context, uid int64, start, end time.Time) (CreditSummary, error) {
+	var res CreditSummary
+	tables := r.dao.tablesBetween(uid, start, end)
+	sub, vars := unionAll(tables, "credit", "uid = ? AND create_time >= ? AND create_time < ?", uid, start, end)
+	err := r.dao.db.WithContext(ctx).
+		Raw("SELECT COUNT(*) AS order_count, COALESCE(SUM(credit), 0) AS credit_sum FROM ("+sub+") t", vars...).
+		Scan(&res).Error
+	return res, err
+}
+
+// Reconcile 用订单表重新计算 day 这一天所有商家的汇总，返回修正了多少个商家
+// 只应该用来计算已经过去的日期，这一天还有订单在写的话，重新计算的同时累加的订单可能会算错
+func (r *Rollup) Reconcile(ctx context.Context, day time.Time) (int, error) {
+	start := r.floorDay(day)
+	end := start.AddDate(0, 0, 1)
+	date := r.date(start)
+	tables := r.dao.tablesBetween(0, start, end)
+	sub, vars := unionAll(tables, "uid, credit", "create_time >= ? AND create_time < ?", start, end)
+	var want []MerchantDaily
+	db := r.dao.db.WithContext(ctx)
+	err := db.Raw("SELECT uid, COUNT(*) AS order_count, COALESCE(SUM(credit), 0) AS credit_sum FROM ("+sub+") t GROUP BY uid", vars...).
+		Scan(&want).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var fixed int
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var got []MerchantDaily
+		err := tx.Where("date = ?", date).Find(&got).Error
+		if err != nil {
+			return err
+		}
+		old := make(map[int64]MerchantDaily, len(got))
+		for _, d := range got {
+			old[d.Uid] = d
+		}
+		now := time.Now().UnixMilli()
+		for i := range want {
+			want[i].Date, want[i].Utime = date, now
+			o, ok := old[want[i].Uid]
+			delete(old, want[i].Uid)
+			if ok && o.OrderCount == want[i].OrderCount && o.CreditSum == want[i].CreditSum {
+				continue
+			}
+			fixed++
+			slog.Warn("修正商家积分汇总", slog.Int64("uid", want[i].Uid), slog.String("date", date),
+				slog.Any("old", CreditSummary{OrderCount: o.OrderCount, CreditSum: o.CreditSum}),
+				slog.Any("new", CreditSummary{OrderCount: want[i].OrderCount, CreditSum: want[i].CreditSum}))
+		}
+		// 剩下的是这一天其实没有订单的商家
+		fixed += len(old)
+		err = tx.Where("date = ?", date).Delete(&MerchantDaily{}).Error
+		if err != nil || len(want) == 0 {
+			return err
+		}
+		return tx.CreateInBatches(&want, 500).Error
+	})
+	return fixed, err
+}
+
+// ReconcileRange 重新计算 [start, end) 之间每一天的汇总，例如第一次上线的时候补全历史数据
+func (r *Rollup) ReconcileRange(ctx context.Context, start, end time.Time) (int, error) {
+	var fixed int
+	for day := r.floorDay(start); day.Before(end); day = day.AddDate(0, 0, 1) {
+		n, err := r.Reconcile(ctx, day)
+		if err != nil {
+			return fixed, err
+		}
+		fixed += n
+	}
+	return fixed, nil
+}
+
+// RunNightly 每天凌晨重新计算前一天的汇总，直到 ctx 结束
+// delay 是过了零点之后等多久再计算，留给还没有提交的订单
+func (r *Rollup) RunNightly(ctx context.Context, delay time.Duration) error {
+	for {
+		next := r.floorDay(time.Now()).AddDate(0, 0, 1).Add(delay)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		day := next.AddDate(0, 0, -1)
+		n, err := r.Reconcile(ctx, day)
+		if err != nil {
+			slog.Error("重新计算积分汇总失败", slog.String("date", r.date(day)), slog.Any("err", err))
+			continue
+		}
+		slog.Info("重新计算积分汇总", slog.String("date", r.date(day)), slog.Int("fixed", n))
+	}
+}
+
+func (r *Rollup) date(t time.Time) string {
+	return t.In(r.loc).Format(dateLayout)
+}
+
+func (r *Rollup) floorDay(t time.Time) time.Time {
+	t = t.In(r.loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, r.loc)
+}
+
+func (r *Rollup) ceilDay(t time.Time) time.Time {
+	day := r.floorDay(t)
+	if day.Equal(t) {
+		return day
+	}
+	return day.AddDate(0, 0, 1)
+}
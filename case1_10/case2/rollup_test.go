@@ -0,0 +1,130 @@
+package case2
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+	"interview-cases/pkg/fixture"
+	"interview-cases/test"
+	"testing"
+	"time"
+)
+
+// RollupTestSuite 订单分散在 orders、独占的表和归档表里面，汇总和直接统计订单的结果一样
+type RollupTestSuite struct {
+	suite.Suite
+	db     *gorm.DB
+	dao    *OrderDAO
+	rollup *Rollup
+}
+
+func (s *RollupTestSuite) SetupTest() {
+	s.db = test.InitDB()
+	require.NoError(s.T(), s.db.Migrator().DropTable(&MerchantDaily{}))
+	s.dao = initDAO(s.T(), s.db)
+	s.rollup = NewRollup(s.dao)
+	ctx := context.Background()
+	require.NoError(s.T(), s.rollup.Init(ctx))
+
+	// InitData 没有经过钩子，先补全历史数据
+	fixed, err := s.rollup.ReconcileRange(ctx, fixture.BaseTime.Add(-1000*time.Hour), fixture.BaseTime.Add(time.Hour))
+	require.NoError(s.T(), err)
+	assert.Greater(s.T(), fixed, 0)
+
+	_, err = NewPromoter(s.dao, 5000).RunOnce(ctx)
+	require.NoError(s.T(), err)
+	a := NewArchiver(s.dao, 500*time.Hour)
+	a.now = func() time.Time {
+		return fixture.BaseTime
+	}
+	_, err = a.RunOnce(ctx)
+	require.NoError(s.T(), err)
+}
+
+func (s *RollupTestSuite) TearDownSuite() {
+	require.NoError(s.T(), s.db.Migrator().DropTable(&MerchantDaily{}))
+	require.NoError(s.T(), dropTables(s.db))
+}
+
+func (s *RollupTestSuite) TestQuery() {
+	ctx := context.Background()
+	// 之后下的订单通过钩子累加
+	for i := 0; i < 50; i++ {
+		o := Order{Uid: []int64{123, 234, 456}[i%3], Credit: i, CreateTime: fixture.BaseTime.Add(time.Duration(i) * 17 * time.Minute)}
+		require.NoError(s.T(), s.dao.Insert(ctx, &o))
+	}
+	ranges := [][2]time.Time{
+		// 不满一天
+		{fixture.BaseTime.Add(-5 * time.Hour), fixture.BaseTime.Add(-2 * time.Hour)},
+		// 刚好是完整的天
+		{fixture.BaseTime.AddDate(0, 0, -10), fixture.BaseTime},
+		// 两头都不满一天，中间跨过了归档的时间
+		{fixture.BaseTime.Add(-800*time.Hour - 30*time.Minute), fixture.BaseTime.Add(10 * time.Hour)},
+		{fixture.BaseTime.Add(-2000 * time.Hour), fixture.BaseTime.Add(2000 * time.Hour)},
+	}
+	for _, uid := range []int64{123, 234, 456} {
+		for _, rg := range ranges {
+			got, err := s.rollup.Query(ctx, uid, rg[0], rg[1])
+			require.NoError(s.T(), err)
+			assert.Equal(s.T(), s.scan(uid, rg[0], rg[1]), got, "uid %d %v", uid, rg)
+		}
+	}
+}
+
+func (s *RollupTestSuite) TestReconcile() {
+	ctx := context.Background()
+	day := fixture.BaseTime.AddDate(0, 0, -3)
+	start, end := day.Add(-3*time.Hour), day.AddDate(0, 0, 2)
+	want := s.scan(123, start, end)
+
+	// 汇总被改错了，还有一个这一天其实没有订单的商家
+	err := s.db.Model(&MerchantDaily{}).Where("uid = ? AND date = ?", 123, s.rollup.date(day)).
+		Update("credit_sum", 0).Error
+	require.NoError(s.T(), err)
+	err = s.db.Create(&MerchantDaily{Uid: 789, Date: s.rollup.date(day), OrderCount: 1, CreditSum: 1}).Error
+	require.NoError(s.T(), err)
+	got, err := s.rollup.Query(ctx, 123, start, end)
+	require.NoError(s.T(), err)
+	assert.NotEqual(s.T(), want, got)
+
+	fixed, err := s.rollup.Reconcile(ctx, day)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, fixed)
+	got, err = s.rollup.Query(ctx, 123, start, end)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), want, got)
+	var n int64
+	require.NoError(s.T(), s.db.Model(&MerchantDaily{}).Where("uid = ?", 789).Count(&n).Error)
+	assert.Zero(s.T(), n)
+
+	// 没有变化的时候不需要修正
+	fixed, err = s.rollup.Reconcile(ctx, day)
+	require.NoError(s.T(), err)
+	assert.Zero(s.T(), fixed)
+}
+
+// scan 把所有的表都读出来，在内存里面统计
+func (s *RollupTestSuite) scan(uid int64, start, end time.Time) CreditSummary {
+	var res CreditSummary
+	tables := []string{SharedTable, DedicatedTable(123)}
+	for _, a := range s.dao.archives {
+		tables = append(tables, a.Location)
+	}
+	for _, table := range tables {
+		var orders []Order
+		require.NoError(s.T(), s.db.Table(table).Where("uid = ?", uid).Find(&orders).Error)
+		for _, o := range orders {
+			if !o.CreateTime.Before(start) && o.CreateTime.Before(end) {
+				res.OrderCount++
+				res.CreditSum += int64(o.Credit)
+			}
+		}
+	}
+	return res
+}
+
+func TestRollup(t *testing.T) {
+	suite.Run(t, new(RollupTestSuite))
+}
@@ -0,0 +1,194 @@
+// Package case4 把 case4 的结论落地成一个真正的订单列表接口：
+// GET /buyers/:id/orders 只允许按照下单时间排序，这样查询永远可以走 (buyer, ctime) 联合索引，
+// 不会退化成 orders 表那种先按照 buyer 过滤、再 filesort 的执行计划
+package case4
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"interview-cases/case1_10"
+	"interview-cases/pkg/explain"
+	"interview-cases/pkg/keyset"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// IndexName OrderV1 上 (buyer, ctime) 联合索引的名字
+	IndexName = "buyer_ctime"
+
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+var (
+	// ErrMissingIndex 没有 (buyer, ctime) 联合索引，或者 MySQL 不用这个索引排序
+	ErrMissingIndex = errors.New("case4: 订单列表查询没有办法利用 buyer_ctime 索引")
+
+	// columns 允许投影的列，id 和 ctime 是游标需要的，所以一定会查询出来
+	columns = map[string]func(o case1_10.OrderV1) any{
+		"id":    func(o case1_10.OrderV1) any { return o.Id },
+		"sn":    func(o case1_10.OrderV1) any { return o.SN },
+		"buyer": func(o case1_10.OrderV1) any { return o.Buyer },
+		"ctime": func(o case1_10.OrderV1) any { return o.Ctime },
+		"utime": func(o case1_10.OrderV1) any { return o.Utime },
+		"extra": func(o case1_10.OrderV1) any { return o.Extra },
+	}
+	defaultFields = []string{"id", "sn", "buyer", "ctime", "utime"}
+)
+
+// OrderHandler 买家订单列表
+//
+// 因为 buyer 是等值条件，所以 ORDER BY ctime DESC, id DESC 可以直接倒序扫描 (buyer, ctime) 索引
+// （InnoDB 的二级索引后面隐含了主键 id）。
+// 反过来，只要排序里面出现了别的列，或者 ctime 和 id 的方向不一样，MySQL 就只能 filesort，
+// 所以 sort 参数只接受 -ctime 和 ctime 两种，其他的直接返回 400
+type OrderHandler struct {
+	db *gorm.DB
+	// paginators 的 key 是 sort 参数，两种排序生成的游标签名不一样，不能混用
+	paginators map[string]*keyset.Paginator
+}
+
+// NewOrderHandler 启动的时候就检查索引，没有索引宁可启动失败，也不要上线之后才发现查询变慢了
+func NewOrderHandler(db *gorm.DB, secret []byte) (*OrderHandler, error) {
+	err := CheckIndex(db)
+	if err != nil {
+		return nil, err
+	}
+	return &OrderHandler{
+		db: db,
+		paginators: map[string]*keyset.Paginator{
+			"-ctime": keyset.New(secret, keyset.Desc("ctime"), keyset.Desc("id")),
+			"ctime":  keyset.New(secret, keyset.Asc("ctime"), keyset.Asc("id")),
+		},
+	}, nil
+}
+
+// CheckIndex 检查 orders_v1 上有没有 buyer_ctime 索引，
+// 如果是 MySQL，还会 EXPLAIN 一下列表查询，确认用的是这个索引并且没有 Using filesort
+func CheckIndex(db *gorm.DB) error {
+	if !db.Migrator().HasIndex(&case1_10.OrderV1{}, IndexName) {
+		return fmt.Errorf("%w: 索引不存在", ErrMissingIndex)
+	}
+	if db.Dialector.Name() != "mysql" {
+		return nil
+	}
+	plan, err := explain.Explain(db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("buyer = ?", 0).Order("ctime DESC, id DESC").Limit(defaultLimit).
+			Find(&[]case1_10.OrderV1{})
+	})
+	if err != nil {
+		return err
+	}
+	row, ok := plan.Table(case1_10.OrderV1{}.TableName())
+	if !ok || row.Key != IndexName || row.HasExtra(explain.ExtraUsingFilesort) {
+		return fmt.Errorf("%w: 执行计划\n%s", ErrMissingIndex, plan)
+	}
+	return nil
+}
+
+func (h *OrderHandler) RegisterRouter(server *gin.Engine) {
+	server.GET("/buyers/:id/orders", h.List)
+}
+
+type ListResp struct {
+	Orders []map[string]any `json:"orders"`
+	// Next 和 Prev 是下一页和上一页的游标，为空说明没有了
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// List 查询参数：
+//   - start、end：下单时间的范围，毫秒时间戳，start <= ctime < end，都可以不传
+//   - sort：-ctime（默认）或者 ctime
+//   - limit：每页多少条，默认 20，最多 100
+//   - cursor：上一次返回的 next 或者 prev
+//   - fields：逗号分隔的列名，例如 id,sn,ctime
+func (h *OrderHandler) List(c *gin.Context) {
+	buyer, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "买家 ID 不对")
+		return
+	}
+	sort := c.DefaultQuery("sort", "-ctime")
+	paginator, ok := h.paginators[sort]
+	if !ok {
+		c.String(http.StatusBadRequest, "只支持按照下单时间排序（-ctime 或者 ctime），其他排序方式用不上 buyer_ctime 索引，会导致 filesort")
+		return
+	}
+	limit, err := intQuery(c, "limit", defaultLimit)
+	if err != nil || limit <= 0 || limit > maxLimit {
+		c.String(http.StatusBadRequest, "limit 必须在 1 到 %d 之间", maxLimit)
+		return
+	}
+	fields, err := parseFields(c.Query("fields"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	query := h.db.WithContext(c.Request.Context()).Select(fields).Where("buyer = ?", buyer)
+	for _, r := range []struct {
+		param string
+		cond  string
+	}{{param: "start", cond: "ctime >= ?"}, {param: "end", cond: "ctime < ?"}} {
+		if c.Query(r.param) == "" {
+			continue
+		}
+		val, err := intQuery(c, r.param, 0)
+		if err != nil {
+			c.String(http.StatusBadRequest, "%s 必须是毫秒时间戳", r.param)
+			return
+		}
+		query = query.Where(r.cond, val)
+	}
+
+	page, err := keyset.Paginate[case1_10.OrderV1](query, paginator, c.Query("cursor"), int(limit))
+	if errors.Is(err, keyset.ErrInvalidCursor) {
+		c.String(http.StatusBadRequest, "游标不合法")
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, "系统错误")
+		return
+	}
+	resp := ListResp{Orders: make([]map[string]any, 0, len(page.Items)), Next: page.Next, Prev: page.Prev}
+	for _, o := range page.Items {
+		item := make(map[string]any, len(fields))
+		for _, f := range fields {
+			item[f] = columns[f](o)
+		}
+		resp.Orders = append(resp.Orders, item)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// parseFields 去重并且补上 id 和 ctime，不认识的列直接报错，避免拼接任意的列名
+func parseFields(raw string) ([]string, error) {
+	if raw == "" {
+		return defaultFields, nil
+	}
+	res := []string{"id", "ctime"}
+	for _, f := range strings.Split(raw, ",") {
+		f = strings.TrimSpace(strings.ToLower(f))
+		if _, ok := columns[f]; !ok {
+			return nil, fmt.Errorf("不支持的字段 %q", f)
+		}
+		if f != "id" && f != "ctime" && !slices.Contains(res, f) {
+			res = append(res, f)
+		}
+	}
+	return res, nil
+}
+
+func intQuery(c *gin.Context, key string, def int64) (int64, error) {
+	val := c.Query(key)
+	if val == "" {
+		return def, nil
+	}
+	return strconv.ParseInt(val, 10, 64)
+}
@@ -0,0 +1,153 @@
+package case4
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+	"interview-cases/case1_10"
+	"interview-cases/test"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// OrderHandlerTestSuite 买家 1 有 25 个订单，每 3 个订单的 ctime 一样，买家 2 有 5 个订单
+type OrderHandlerTestSuite struct {
+	suite.Suite
+	db     *gorm.DB
+	server *gin.Engine
+}
+
+func (s *OrderHandlerTestSuite) SetupSuite() {
+	s.db = test.InitDB()
+	err := s.db.AutoMigrate(&case1_10.OrderV1{})
+	require.NoError(s.T(), err)
+	err = test.Truncate(s.db, "orders_v1")
+	require.NoError(s.T(), err)
+	orders := make([]case1_10.OrderV1, 0, 30)
+	for i := 0; i < 30; i++ {
+		buyer := int64(1)
+		if i%6 == 5 {
+			buyer = 2
+		}
+		orders = append(orders, case1_10.OrderV1{
+			SN:    fmt.Sprintf("sn_%d", i),
+			Extra: "extra",
+			Buyer: buyer,
+			Ctime: int64(i / 3),
+			Utime: int64(i),
+		})
+	}
+	err = s.db.Create(&orders).Error
+	require.NoError(s.T(), err)
+
+	hdl, err := NewOrderHandler(s.db, []byte("case4"))
+	require.NoError(s.T(), err)
+	gin.SetMode(gin.ReleaseMode)
+	s.server = gin.New()
+	hdl.RegisterRouter(s.server)
+}
+
+func (s *OrderHandlerTestSuite) TearDownSuite() {
+	err := test.Truncate(s.db, "orders_v1")
+	require.NoError(s.T(), err)
+}
+
+func (s *OrderHandlerTestSuite) TestList() {
+	var want []case1_10.OrderV1
+	err := s.db.Where("buyer = ? AND ctime >= ? AND ctime < ?", 1, 2, 8).
+		Order("ctime DESC, id DESC").Find(&want).Error
+	require.NoError(s.T(), err)
+
+	// 往后翻到最后一页
+	var pages []ListResp
+	var got []int64
+	cursor := ""
+	for {
+		resp := s.list(http.StatusOK, "/buyers/1/orders?start=2&end=8&limit=4&fields=sn,buyer&cursor="+cursor)
+		pages = append(pages, resp)
+		for _, o := range resp.Orders {
+			assert.Len(s.T(), o, 4)
+			assert.Equal(s.T(), float64(1), o["buyer"])
+			got = append(got, int64(o["id"].(float64)))
+		}
+		if resp.Next == "" {
+			break
+		}
+		cursor = resp.Next
+	}
+	require.Len(s.T(), got, len(want))
+	for i, o := range want {
+		assert.Equal(s.T(), o.Id, got[i])
+	}
+	assert.Empty(s.T(), pages[0].Prev)
+	assert.Len(s.T(), pages, (len(want)+3)/4)
+
+	// 再往前翻一页
+	prev := s.list(http.StatusOK, "/buyers/1/orders?start=2&end=8&limit=4&fields=sn,buyer&cursor="+pages[len(pages)-1].Prev)
+	assert.Equal(s.T(), pages[len(pages)-2].Orders, prev.Orders)
+
+	// 正序，默认的字段
+	resp := s.list(http.StatusOK, "/buyers/2/orders?sort=ctime")
+	require.Len(s.T(), resp.Orders, 5)
+	assert.Len(s.T(), resp.Orders[0], 5)
+	assert.Equal(s.T(), "sn_5", resp.Orders[0]["sn"])
+	assert.Empty(s.T(), resp.Next)
+}
+
+func (s *OrderHandlerTestSuite) TestBadRequest() {
+	resp := s.list(http.StatusOK, "/buyers/1/orders?limit=1")
+	testCases := []struct {
+		name string
+		url  string
+	}{
+		{name: "买家 ID", url: "/buyers/abc/orders"},
+		{name: "会导致 filesort 的排序", url: "/buyers/1/orders?sort=utime"},
+		{name: "ctime 和 id 方向不一样", url: "/buyers/1/orders?sort=-ctime,id"},
+		{name: "limit 太大", url: "/buyers/1/orders?limit=1000"},
+		{name: "limit 不是数字", url: "/buyers/1/orders?limit=abc"},
+		{name: "不认识的字段", url: "/buyers/1/orders?fields=sn,password"},
+		{name: "时间", url: "/buyers/1/orders?start=yesterday"},
+		{name: "篡改过的游标", url: "/buyers/1/orders?cursor=x" + resp.Next},
+		// 倒序生成的游标不能用在正序上
+		{name: "换了排序", url: "/buyers/1/orders?sort=ctime&cursor=" + resp.Next},
+	}
+	for _, tc := range testCases {
+		s.T().Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.url, nil))
+			assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+		})
+	}
+}
+
+func (s *OrderHandlerTestSuite) TestCheckIndex() {
+	type orderWithoutIndex struct {
+		Id    int64
+		Buyer int64
+		Ctime int64
+	}
+	// 只有一张表，换个表名模拟没有建索引的 orders_v1
+	db := s.db.Table("orders_v1_without_index")
+	require.NoError(s.T(), db.AutoMigrate(&orderWithoutIndex{}))
+	defer s.db.Migrator().DropTable("orders_v1_without_index")
+	assert.NoError(s.T(), CheckIndex(s.db))
+	assert.ErrorIs(s.T(), CheckIndex(db), ErrMissingIndex)
+}
+
+func (s *OrderHandlerTestSuite) list(code int, url string) ListResp {
+	rec := httptest.NewRecorder()
+	s.server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	require.Equal(s.T(), code, rec.Code, rec.Body.String())
+	var resp ListResp
+	require.NoError(s.T(), json.Unmarshal(rec.Body.Bytes(), &resp))
+	return resp
+}
+
+func TestOrderHandler(t *testing.T) {
+	suite.Run(t, new(OrderHandlerTestSuite))
+}
@@ -16,6 +16,7 @@ package case1_10
 
 import (
 	"github.com/stretchr/testify/suite"
+	"testing"
 )
 
 type Case5TestSuite struct {
@@ -37,3 +38,7 @@ func (s *Case5TestSuite) TestArraySlice() {
 	ch1 <- User{Name: "Tom"}
 	ch2 <- &User{Name: "Jerry"}
 }
+
+func TestCase5(t *testing.T) {
+	suite.Run(t, new(Case5TestSuite))
+}
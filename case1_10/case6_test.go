@@ -15,15 +15,15 @@
 package case1_10
 
 import (
-	"fmt"
+	"context"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"gorm.io/gorm"
+	"interview-cases/pkg/bench"
+	"interview-cases/pkg/explain"
 	"interview-cases/test"
-	"math/rand"
 	"testing"
-	"time"
 )
 
 type Case6TestSuite struct {
@@ -46,35 +46,64 @@ func (s *Case6TestSuite) TearDownSuite() {
 
 func (s *Case6TestSuite) TestPageWithSubQuery() {
 	// 准备数据，你可以调整里面参数来控制数据量
-	//s.prepareData()
-	// 执行查询，为了方便你查看，我这里直接手写 SQL
-	start := time.Now()
-	var res []Order
-	// LIMIT 10000, 10
-	err := s.db.Offset(10000).Limit(10).Find(&res).Error
-	duration := time.Since(start)
+	s.prepareData()
+	test.RunBench(s.T(), "case6 deep pagination", bench.DefaultOptions(),
+		map[string]string{"db": test.DialectOf(s.db).Name, "offset": "10000"},
+		bench.Variant{Name: "LIMIT 10000, 10", Run: func(ctx context.Context) error {
+			var res []Order
+			return s.db.WithContext(ctx).Offset(10000).Limit(10).Find(&res).Error
+		}},
+		// 执行的 SQL 是
+		// SELECT * FROM orders WHERE id >(SELECT id FROM orders LIMIT 10000, 1)
+		// 这个优化的核心是子查询是命中索引的，所以可以快速执行。
+		// 反过来说，如果要是子查询不能命中索引，不能借助索引来排序分页，那么这个优化就毫无意义
+		bench.Variant{Name: "子查询", Run: func(ctx context.Context) error {
+			var res []Order
+			return s.db.WithContext(ctx).Where("id > (SELECT id FROM orders LIMIT ?,1 )", 10000).
+				Limit(10).Offset(0).Find(&res).Error
+		}},
+	)
+	s.checkPlan()
+}
+
+// checkPlan 确认子查询只扫描了索引，外层查询是主键上的范围查询
+func (s *Case6TestSuite) checkPlan() {
+	if !test.Supports(s.T(), s.db, test.CapExplain) {
+		return
+	}
+	deep, err := explain.Explain(s.db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Offset(10000).Limit(10).Find(&[]Order{})
+	})
 	require.NoError(s.T(), err)
-	s.T().Log("深度分页 耗时（ms）:", duration.Milliseconds())
+	s.T().Log(deep)
+	row, ok := deep.Table("orders")
+	require.True(s.T(), ok)
+	assert.Equal(s.T(), explain.TypeAll, row.Type)
 
-	start = time.Now()
-	res = res[:0]
-	// 执行的 SQL 是
-	// SELECT * FROM orders WHERE id >(SELECT id FROM orders LIMIT 10000, 1)
-	// 这个优化的核心是子查询是命中索引的，所以可以快速执行。
-	// 反过来说，如果要是子查询不能命中索引，不能借助索引来排序分页，那么这个优化就毫无意义
-	err = s.db.Where("id > (SELECT id FROM orders LIMIT ?,1 )", 10000).
-		Limit(10).Offset(0).Find(&res).Error
-	duration = time.Since(start)
+	sub, err := explain.Explain(s.db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("id > (SELECT id FROM orders LIMIT ?,1 )", 10000).
+			Limit(10).Find(&[]Order{})
+	})
 	require.NoError(s.T(), err)
-	s.T().Log("深度分页优化后 耗时（ms）:", duration.Milliseconds())
+	s.T().Log(sub)
+	for _, r := range sub.Rows {
+		switch r.SelectType {
+		case "SUBQUERY":
+			// 子查询只要 id，任何一个二级索引都包含了 id，所以扫描索引就够了
+			assert.True(s.T(), r.HasExtra(explain.ExtraUsingIndex))
+		case "PRIMARY":
+			assert.Equal(s.T(), "PRIMARY", r.Key)
+			assert.Equal(s.T(), explain.TypeRange, r.Type)
+		}
+	}
 }
 
 func (s *Case6TestSuite) iterateOrder() {
 	offset, limit := 0, 10
 	for i := 0; i < 100; i++ {
 		var res []Order
-		err := s.db.Where("buyer=?", 11).
-			Find(&res).Limit(limit).Offset(offset).Error
+		err := s.db.Where("buyer=?", 11).Order("ctime DESC").
+			Limit(limit).Offset(offset).Find(&res).Error
 		assert.NoError(s.T(), err)
 		if len(res) < limit {
 			return
@@ -84,41 +113,9 @@ func (s *Case6TestSuite) iterateOrder() {
 }
 
 func (s *Case6TestSuite) prepareData() {
-	// 首先准备数据。这里你可以调整批次大小以及总批次来调整数据库中的数据
-	const batchSize = 100
-	const batch = 1000
-	orders := make([]Order, 0, 100)
-	now := time.Now().UnixMilli()
-	for i := 0; i < batch; i++ {
-		for j := 0; j < batchSize; j++ {
-			idx := i*batchSize + j
-			orders = append(orders, Order{
-				SN:    fmt.Sprintf("sn_%d", idx),
-				Buyer: int64(idx % 10),
-				Extra: `
-// Licensed under the Apache License, Version 2.0 (the "License");
-// you may not use this file except in compliance with the License.
-// You may obtain a copy of the License at
-//
-// http://www.apache.org/licenses/LICENSE-2.0
-//
-// Unless required by applicable law or agreed to in writing, software
-// distributed under the License is distributed on an "AS IS" BASIS,
-// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
-// See the License for the specific language governing permissions and
-// limitations under the License.
-`,
-				Ctime: now + rand.Int63(),
-				Utime: now,
-			})
-
-		}
-
-		err := s.db.Create(&orders).Error
-		require.NoError(s.T(), err)
-		// 重置数据，下一轮复用
-		orders = orders[:0]
-	}
+	// 数据量可以通过 fixture.size 调整
+	err := prepareOrders(s.db, 10)
+	require.NoError(s.T(), err)
 }
 
 func TestCase6(t *testing.T) {
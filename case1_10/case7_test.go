@@ -15,17 +15,22 @@
 package case1_10
 
 import (
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"gorm.io/gorm"
+	"interview-cases/pkg/bench"
+	"interview-cases/pkg/explain"
+	"interview-cases/pkg/keyset"
 	"interview-cases/test"
-	"math/rand"
+	"strconv"
 	"testing"
-	"time"
 )
 
+const case7Buyers = 5
+
 type Case7TestSuite struct {
 	suite.Suite
 	db *gorm.DB
@@ -46,33 +51,74 @@ func (s *Case7TestSuite) TearDownSuite() {
 
 func (s *Case7TestSuite) TestPageWithWhere() {
 	// 准备数据，你可以调整里面参数来控制数据量
-	//s.prepareData()
-	// 执行查询，为了方便你查看，我这里直接手写 SQL
-	start := time.Now()
+	s.prepareData()
+	// 每个买家订单数的一半，medium 档位下就是 LIMIT 10000, 10
+	offset := test.FixtureOptions().Size.Rows(orderRows) / case7Buyers / 2
+	// 上一页的最大 id，我们就直接用深度分页查出来的
 	var res []Order
-	err := s.db.Where("buyer=?", 1).Limit(10).Offset(10000).Find(&res).Error
-	duration := time.Since(start)
+	err := s.db.Where("buyer=?", 1).Limit(10).Offset(offset).Find(&res).Error
 	require.NoError(s.T(), err)
-	s.T().Log("深度分页 耗时（ms）:", duration.Milliseconds())
-
-	start = time.Now()
-	// 上一页的最大 id，我们就直接用前一个查询查出来的
+	require.NotEmpty(s.T(), res)
 	lastId := res[0].Id
-	res = res[:0]
-	err = s.db.Where("buyer=? AND id >= ?", 1, lastId).
-		Limit(10).Offset(0).Find(&res).Error
-	duration = time.Since(start)
+
+	// 游标分页就是把 id >= ? 这种写法通用化了，游标里面记住的是上一页最后一行的 (buyer, id)
+	paginator := keyset.New([]byte("case7"), keyset.Asc("buyer"), keyset.Asc("id"))
+	cursor, err := keyset.Cursor(s.db, paginator, res[0])
+	require.NoError(s.T(), err)
+	page, err := keyset.Paginate[Order](s.db.Where("buyer=?", 1), paginator, cursor, 10)
+	require.NoError(s.T(), err)
+	var want []Order
+	err = s.db.Where("buyer=? AND id > ?", 1, lastId).Order("id").Limit(10).Find(&want).Error
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), want, page.Items)
+
+	test.RunBench(s.T(), "case7 deep pagination with where", bench.DefaultOptions(),
+		map[string]string{"db": test.DialectOf(s.db).Name, "offset": strconv.Itoa(offset)},
+		bench.Variant{Name: fmt.Sprintf("LIMIT %d, 10", offset), Run: func(ctx context.Context) error {
+			var res []Order
+			return s.db.WithContext(ctx).Where("buyer=?", 1).Limit(10).Offset(offset).Find(&res).Error
+		}},
+		bench.Variant{Name: "id >= 上一页的最大 id", Run: func(ctx context.Context) error {
+			var res []Order
+			return s.db.WithContext(ctx).Where("buyer=? AND id >= ?", 1, lastId).
+				Limit(10).Offset(0).Find(&res).Error
+		}},
+		bench.Variant{Name: "keyset", Run: func(ctx context.Context) error {
+			_, err := keyset.Paginate[Order](s.db.WithContext(ctx).Where("buyer=?", 1), paginator, cursor, 10)
+			return err
+		}},
+	)
+	s.checkPlan(offset, lastId)
+}
+
+// checkPlan 确认带上 id 条件之后变成了范围查询，估计扫描的行数也更少
+func (s *Case7TestSuite) checkPlan(offset int, lastId int64) {
+	if !test.Supports(s.T(), s.db, test.CapExplain) {
+		return
+	}
+	deep, err := explain.Explain(s.db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("buyer=?", 1).Limit(10).Offset(offset).Find(&[]Order{})
+	})
 	require.NoError(s.T(), err)
-	s.T().Log("深度分页优化后 耗时（ms）:", duration.Milliseconds())
+	s.T().Log(deep)
 
+	seek, err := explain.Explain(s.db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("buyer=? AND id >= ?", 1, lastId).Limit(10).Find(&[]Order{})
+	})
+	require.NoError(s.T(), err)
+	s.T().Log(seek)
+	row, ok := seek.Table("orders")
+	require.True(s.T(), ok)
+	assert.Equal(s.T(), explain.TypeRange, row.Type)
+	assert.LessOrEqual(s.T(), seek.TotalRows(), deep.TotalRows())
 }
 
 func (s *Case7TestSuite) iterateOrder() {
 	offset, limit := 0, 10
 	for i := 0; i < 100; i++ {
 		var res []Order
-		err := s.db.Where("buyer=?", 11).
-			Find(&res).Limit(limit).Offset(offset).Error
+		err := s.db.Where("buyer=?", 11).Order("ctime DESC").
+			Limit(limit).Offset(offset).Find(&res).Error
 		assert.NoError(s.T(), err)
 		if len(res) < limit {
 			return
@@ -82,41 +128,10 @@ func (s *Case7TestSuite) iterateOrder() {
 }
 
 func (s *Case7TestSuite) prepareData() {
-	// 首先准备数据。这里你可以调整批次大小以及总批次来调整数据库中的数据
-	const batchSize = 100
-	const batch = 1000
-	orders := make([]Order, 0, 100)
-	now := time.Now().UnixMilli()
-	for i := 0; i < batch; i++ {
-		for j := 0; j < batchSize; j++ {
-			idx := i*batchSize + j
-			orders = append(orders, Order{
-				SN:    fmt.Sprintf("sn_%d", idx),
-				Buyer: int64(idx % 10),
-				Extra: `
-// Licensed under the Apache License, Version 2.0 (the "License");
-// you may not use this file except in compliance with the License.
-// You may obtain a copy of the License at
-//
-// http://www.apache.org/licenses/LICENSE-2.0
-//
-// Unless required by applicable law or agreed to in writing, software
-// distributed under the License is distributed on an "AS IS" BASIS,
-// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
-// See the License for the specific language governing permissions and
-// limitations under the License.
-`,
-				Ctime: now + rand.Int63(),
-				Utime: now,
-			})
-
-		}
-
-		err := s.db.Create(&orders).Error
-		require.NoError(s.T(), err)
-		// 重置数据，下一轮复用
-		orders = orders[:0]
-	}
+	// 数据量可以通过 fixture.size 调整
+	// medium 档位下每个买家有两万条数据，这样 LIMIT 10000, 10 才能查到数据
+	err := prepareOrders(s.db, case7Buyers)
+	require.NoError(s.T(), err)
 }
 
 func TestCase7(t *testing.T) {
@@ -1,26 +1,45 @@
 package case8
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	kafkago "github.com/segmentio/kafka-go"
 	"golang.org/x/sync/errgroup"
-	"io"
+	"interview-cases/pkg/mq"
+	"interview-cases/pkg/mq/handler"
 	"log/slog"
-	"net/http"
+	"sync"
 	"time"
 )
 
 type AsyncConsumer struct {
-	reader    *kafkago.Reader
+	consumer  mq.Consumer
 	batchSize int
+	// retryHandler 提供了 Retry 和 DeadLetter 两个配置
+	retryHandler
+	// Limiter 根据下游的反馈调整并发，并发用完了或者下游过载的时候不再拉取消息
+	Limiter *handler.AIMD
+	// MaxPending 每个分区最多有多少条没有提交的消息，到了就暂停这个分区，提交之后再恢复
+	MaxPending int
+
+	tracker *offsetTracker
+	// retries 没有处理完的消息（重试完了并且死信队列也发送失败），下一批先处理它们
+	mu      sync.Mutex
+	retries []mq.Message
 }
 
-func NewAsyncConsumer(reader *kafkago.Reader, batchSize int) *AsyncConsumer {
+// NewAsyncConsumer biz 是业务逻辑，在实践中可能是发起 rpc 调用，也可能是发起 http 调用，
+// 也可能就是自己执行业务逻辑，例如插入数据库，对应 handler 里面的几种实现
+func NewAsyncConsumer(consumer mq.Consumer, batchSize int, biz handler.Handler) *AsyncConsumer {
 	// batchSize 你也可以做成参数
-	return &AsyncConsumer{reader: reader, batchSize: batchSize}
+	return &AsyncConsumer{
+		consumer:     consumer,
+		batchSize:    batchSize,
+		retryHandler: newRetryHandler(biz),
+		Limiter:      handler.NewAIMD(handler.DefaultAIMDOptions(batchSize)),
+		MaxPending:   1000,
+		tracker:      newOffsetTracker(),
+	}
 }
 
 func (a *AsyncConsumer) Consume(ctx context.Context) {
@@ -38,9 +57,27 @@ func (a *AsyncConsumer) Consume(ctx context.Context) {
 
 // 消费一批
 func (a *AsyncConsumer) batchAsyncConsume(ctx context.Context) error {
-	var lastMsg kafkago.Message
 	// 异步消费
 	var eg errgroup.Group
+	// 上一批没有处理完的消息已经在 tracker 里面了，不需要再添加
+	a.mu.Lock()
+	retries := a.retries
+	a.retries = nil
+	a.mu.Unlock()
+	for i, msg := range retries {
+		// 下游过载的时候这里会等到冷却期结束
+		if err := a.Limiter.Acquire(ctx); err != nil {
+			a.mu.Lock()
+			a.retries = append(a.retries, retries[i:]...)
+			a.mu.Unlock()
+			retries = retries[:i]
+			break
+		}
+		eg.Go(func() error {
+			return a.process(ctx, msg)
+		})
+	}
+	cnt := len(retries)
 	// 获取一批数据
 	// 要注意，如果你的并发不够，你可能很难凑够一批，所以要加上超时控制
 	// 举个极端例子，你可能已经异步消费了 3 条数据，但是一两个小时都没等到更多的消息，
@@ -49,58 +86,69 @@ func (a *AsyncConsumer) batchAsyncConsume(ctx context.Context) error {
 	batchCtx, cancel := context.WithTimeout(ctx, time.Second)
 	defer cancel()
 	for i := 0; i < a.batchSize; i++ {
-		msg, err := a.reader.ReadMessage(batchCtx)
+		// 拿到并发之后才拉取消息，下游扛不住的时候就停在这里，不会把消息拉下来堆在内存里面
+		if err := a.Limiter.Acquire(batchCtx); err != nil {
+			break
+		}
+		msg, err := a.consumer.Fetch(batchCtx)
 		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			a.Limiter.Cancel()
 			// 没有凑够一批，但是还是要考虑提交，也就是不要等后面的消息了
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("获取消息失败 %w", err)
+			a.Limiter.Cancel()
+			// 已经开始处理的消息还是要等它们结束，不然下一批又会处理一次
+			return errors.Join(fmt.Errorf("获取消息失败 %w", err), eg.Wait())
 		}
-		lastMsg = msg
+		if !a.tracker.add(msg) {
+			a.Limiter.Cancel()
+			continue
+		}
+		if err = a.tracker.throttle(a.consumer, a.MaxPending); err != nil {
+			slog.Error("限制没有提交的消息失败", slog.Any("err", err))
+		}
+		cnt++
 		eg.Go(func() error {
-			err1 := a.doBiz(msg)
-			if err1 != nil {
-				return fmt.Errorf("执行业务失败 offset %d, topic %s, 原因 %w", msg.Offset, msg.Topic, err1)
-			}
-			return nil
+			return a.process(ctx, msg)
 		})
 	}
 
 	// 说明在 1 秒钟之内，一条数据都没有获取到，没关系，可以尝试获取下一批
-	if lastMsg.Offset == 0 {
+	if cnt == 0 {
 		return nil
 	}
 
+	// 有消息失败了也要提交，只是失败的消息和它后面的消息不会被提交
 	err := eg.Wait()
-	if err != nil {
-		return err
-	}
-	// 在这里可以只提交偏移量最大的，也就是最后一条
+	// 每个分区只提交连续处理完的最后一条
 	// 因为 Kafka 的特性是你提交了后面的，就认为前面的也被消费了
-	err = a.reader.CommitMessages(ctx, lastMsg)
-	if err != nil {
-		return fmt.Errorf("提交消息失败 offset %d topic %s, 原因 %w", lastMsg.Offset, lastMsg.Topic, err)
+	ready := a.tracker.ready()
+	if len(ready) > 0 {
+		err1 := a.consumer.Commit(ctx, ready...)
+		if err1 != nil {
+			err = errors.Join(err, fmt.Errorf("提交消息失败 %w", err1))
+		}
 	}
-	return nil
+	// 提交之后没有提交的消息变少了，恢复暂停的分区
+	return errors.Join(err, a.tracker.throttle(a.consumer, a.MaxPending))
 }
 
-// 执行业务逻辑
-func (a *AsyncConsumer) doBiz(msg kafkago.Message) error {
-	// 在实践中，这部分可能是发起 rpc 调用
-	// 也可能是发起 http 调用
-	// 也可能就是自己执行业务逻辑，例如插入数据库
-	// 这里我们假设是发起 http 请求
-	resp, err := http.Post(
-		"http://localhost:8080/handle", "application/json", bytes.NewBuffer(msg.Value))
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	respBody, err := io.ReadAll(resp.Body)
+// process 处理一条消息，成功了或者进了死信队列就标记为处理完，否则留到下一批重试
+// 调用之前要拿到 Limiter 的并发
+func (a *AsyncConsumer) process(ctx context.Context, msg mq.Message) error {
+	err := a.handle(ctx, msg)
+	a.Limiter.Release(err)
 	if err != nil {
+		a.mu.Lock()
+		a.retries = append(a.retries, msg)
+		a.mu.Unlock()
+		// 下游过载的时候 Limiter 已经降低并发并且暂停了，不算消费失败
+		if _, ok := handler.IsOverload(err); ok {
+			return nil
+		}
 		return err
 	}
-	slog.Debug("处理完毕", slog.String("resp", string(respBody)))
+	a.tracker.markDone(msg)
 	return nil
 }
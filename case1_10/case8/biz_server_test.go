@@ -3,9 +3,11 @@ package case8
 import (
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"interview-cases/pkg/overload"
 	"interview-cases/test"
 	"log/slog"
 	"net/http"
+	"time"
 )
 
 // StartServer 模拟业务服务器
@@ -13,9 +15,7 @@ func StartServer(addr string) {
 	r := gin.Default()
 	db := InitDb()
 
-	hdl := &BizHandler{
-		db: db,
-	}
+	hdl := NewBizHandler(db)
 	hdl.RegisterRouter(r)
 	r.Run(addr)
 }
@@ -23,10 +23,20 @@ func StartServer(addr string) {
 type BizHandler struct {
 	count int64
 	db    *gorm.DB
+	// shedder 数据库变慢或者请求太多的时候返回 429，而不是等数据库超时之后返回 500
+	shedder *overload.Shedder
+}
+
+func NewBizHandler(db *gorm.DB) *BizHandler {
+	return &BizHandler{db: db, shedder: overload.New(overload.DefaultOptions())}
 }
 
 func (t *BizHandler) RegisterRouter(server *gin.Engine) {
-	server.POST("/handle", func(c *gin.Context) {
+	server.GET("/overload/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, t.shedder.Stats())
+	})
+	g := server.Group("", t.shedder.Middleware())
+	g.POST("/handle", func(c *gin.Context) {
 		var u UserCase8
 		// 拿到 UserCase8 的数据
 		if err := c.Bind(&u); err != nil {
@@ -35,7 +45,9 @@ func (t *BizHandler) RegisterRouter(server *gin.Engine) {
 			return
 		}
 		// 我们这里可以简单模拟一下，真实的业务场景不会那么简单
+		start := time.Now()
 		err := t.db.Create(&u).Error
+		t.shedder.Observe(time.Since(start))
 		if err != nil {
 			c.String(http.StatusInternalServerError, "系统错误")
 			slog.Error("系统错误", slog.Any("err", err))
@@ -0,0 +1,13 @@
+package case8
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:       8,
+		Title:    "Kafka 消费者异步消费，批量提交",
+		Requires: []cases.Infra{cases.MySQL, cases.Kafka},
+		Package:  "./case1_10/case8",
+		Tests:    []string{"TestAsyncConsumer"},
+	})
+}
@@ -0,0 +1,194 @@
+package case8
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"interview-cases/pkg/mq"
+	"interview-cases/pkg/mq/handler"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// OrderedConsumer 按照 Key 把消息分到 N 条通道上，每条通道一个 goroutine 按顺序处理
+// 同一个 Key（case8 里面就是用户 id）的消息一定在同一条通道上，所以不会乱序，不同的 Key 之间是并发的
+// 和 AsyncConsumer 不同，它不是一批一批处理的，慢的消息只会拖慢它所在的通道，其他通道照常消费
+type OrderedConsumer struct {
+	consumer mq.Consumer
+	lanes    int
+	// retryHandler 提供了 Retry 和 DeadLetter 两个配置
+	retryHandler
+	// LaneBuffer 每条通道上最多排队多少条消息，排满了就不再拉取，避免一条慢通道把内存撑爆
+	LaneBuffer int
+	// MaxPending 每个分区最多有多少条没有提交的消息，一个 Key 卡住了，同一个分区上别的 Key 还在处理，
+	// 但是分区提交不了，到了上限就暂停这个分区，提交之后再恢复
+	MaxPending int
+	// CommitInterval 多久提交一次
+	CommitInterval time.Duration
+	// Limiter 根据下游的反馈调整同时处理的通道数，下游过载的时候通道排满，也就不再拉取消息
+	Limiter *handler.AIMD
+
+	tracker *offsetTracker
+}
+
+func NewOrderedConsumer(consumer mq.Consumer, lanes int, biz handler.Handler) *OrderedConsumer {
+	return &OrderedConsumer{
+		consumer:       consumer,
+		lanes:          lanes,
+		retryHandler:   newRetryHandler(biz),
+		LaneBuffer:     64,
+		MaxPending:     1000,
+		CommitInterval: time.Second,
+		Limiter:        handler.NewAIMD(handler.DefaultAIMDOptions(lanes)),
+		tracker:        newOffsetTracker(),
+	}
+}
+
+// Consume 一直消费到 ctx 被取消，退出之前会把已经处理完的消息提交掉
+func (o *OrderedConsumer) Consume(ctx context.Context) {
+	lanes := make([]chan mq.Message, o.lanes)
+	var wg sync.WaitGroup
+	for i := range lanes {
+		lanes[i] = make(chan mq.Message, o.LaneBuffer)
+		wg.Add(1)
+		go func(ch <-chan mq.Message) {
+			defer wg.Done()
+			o.runLane(ctx, ch)
+		}(lanes[i])
+	}
+	commitDone := make(chan struct{})
+	go func() {
+		defer close(commitDone)
+		o.commitLoop(ctx)
+	}()
+
+	o.dispatch(ctx, lanes)
+	for _, ch := range lanes {
+		close(ch)
+	}
+	wg.Wait()
+	<-commitDone
+	// ctx 已经取消了，用一个新的 ctx 做最后一次提交
+	commitCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second)
+	defer cancel()
+	if err := o.commit(commitCtx); err != nil {
+		slog.Error("退出之前提交失败", slog.Any("err", err))
+	}
+	slog.Info("退出消费循环", slog.Any("err", ctx.Err()))
+}
+
+// dispatch 拉取消息并且分到对应的通道上，通道排满了会阻塞在这里，也就是不再拉取
+func (o *OrderedConsumer) dispatch(ctx context.Context, lanes []chan mq.Message) {
+	for {
+		msg, err := o.consumer.Fetch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Error("获取消息失败", slog.Any("err", err))
+			continue
+		}
+		// 重新分配分区之后拿到的重复消息，已经在某条通道上了
+		if !o.tracker.add(msg) {
+			continue
+		}
+		if err = o.tracker.throttle(o.consumer, o.MaxPending); err != nil {
+			slog.Error("限制没有提交的消息失败", slog.Any("err", err))
+		}
+		select {
+		case lanes[laneOf(msg, len(lanes))] <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// laneOf 同一个 Key 总是在同一条通道上
+// 没有 Key 的消息不需要保证顺序，按照偏移量打散
+// 不能直接用选分区的哈希，同一个分区上的 Key 对分区数取模的结果都一样，再对通道数取模只会落到少数几条通道上，
+// 所以先用 murmur3 的 fmix32 把 FNV-1a 的结果重新打散
+func laneOf(msg mq.Message, lanes int) int {
+	if len(msg.Key) == 0 {
+		return int(msg.Offset % int64(lanes))
+	}
+	h := fnv.New32a()
+	_, _ = h.Write(msg.Key)
+	return int(fmix32(h.Sum32()) % uint32(lanes))
+}
+
+func fmix32(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+func (o *OrderedConsumer) runLane(ctx context.Context, ch <-chan mq.Message) {
+	for msg := range ch {
+		// 退出的时候剩下的消息不处理了，它们没有标记为处理完，所以也不会被提交
+		if ctx.Err() != nil {
+			continue
+		}
+		o.process(ctx, msg)
+	}
+}
+
+// process 同一个 Key 后面的消息不能越过这一条，所以重试完了并且死信队列也发送失败的话，只能一直重试下去
+// 下游过载的时候 Limiter 会暂停所有的通道，冷却期结束之后再重试
+func (o *OrderedConsumer) process(ctx context.Context, msg mq.Message) {
+	for {
+		if o.Limiter.Acquire(ctx) != nil {
+			return
+		}
+		err := o.handle(ctx, msg)
+		o.Limiter.Release(err)
+		if err == nil {
+			o.tracker.markDone(msg)
+			return
+		}
+		if _, ok := handler.IsOverload(err); ok {
+			continue
+		}
+		slog.Error("处理消息失败，阻塞所在的通道", slog.String("key", string(msg.Key)),
+			slog.Int64("offset", msg.Offset), slog.Any("err", err))
+		timer := time.NewTimer(o.Retry.MaxBackoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (o *OrderedConsumer) commitLoop(ctx context.Context) {
+	ticker := time.NewTicker(o.CommitInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := o.commit(ctx); err != nil {
+				slog.Error("定时提交失败", slog.Any("err", err))
+			}
+		}
+	}
+}
+
+// commit 每个分区只提交连续处理完的最后一条，前面有消息卡住的话，这个分区就停在它前面
+func (o *OrderedConsumer) commit(ctx context.Context) error {
+	ready := o.tracker.ready()
+	if len(ready) == 0 {
+		return nil
+	}
+	err := o.consumer.Commit(ctx, ready...)
+	if err != nil {
+		return fmt.Errorf("提交消息失败 %w", err)
+	}
+	// 提交之后没有提交的消息变少了，恢复暂停的分区
+	return o.tracker.throttle(o.consumer, o.MaxPending)
+}
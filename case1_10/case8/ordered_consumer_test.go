@@ -0,0 +1,231 @@
+package case8
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/pkg/mq"
+	"interview-cases/pkg/mq/handler"
+	"interview-cases/pkg/mq/memory"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestOrderedConsumer 10 个用户每人 20 条更新，同一个用户的更新按顺序执行，不同用户之间是并发的
+func TestOrderedConsumer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	const users, updates = 10, 20
+	var msgs []mq.Message
+	for i := 0; i < updates; i++ {
+		for u := 0; u < users; u++ {
+			key := fmt.Sprintf("user_%d", u)
+			msgs = append(msgs, mq.Message{Key: []byte(key), Value: []byte(fmt.Sprintf("%s:%d", key, i))})
+		}
+	}
+	recorder, last := initOrderedTopic(t, ctx, "case8_ordered", 2, msgs)
+
+	biz := newOrderedBiz()
+	consumer := NewOrderedConsumer(recorder, 4, handler.HandlerFunc(biz.handle))
+	consumer.CommitInterval = 10 * time.Millisecond
+	consumer.Retry.Backoff = time.Millisecond
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.Consume(ctx)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(last, recorder.committed())
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	for u := 0; u < users; u++ {
+		key := fmt.Sprintf("user_%d", u)
+		want := make([]string, 0, updates)
+		for i := 0; i < updates; i++ {
+			want = append(want, fmt.Sprintf("%s:%d", key, i))
+		}
+		assert.Equal(t, want, biz.history(key))
+	}
+	assert.Greater(t, biz.maxRunning(), 1)
+}
+
+// TestOrderedConsumer_Blocked 只有一个分区，a 的第一条卡住了：a 后面的消息要等着，b 照常处理，
+// 但是分区不能越过 a 的第一条提交
+func TestOrderedConsumer_Blocked(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var msgs []mq.Message
+	for i := 0; i < 5; i++ {
+		for _, key := range []string{"a", "b"} {
+			msgs = append(msgs, mq.Message{Key: []byte(key), Value: []byte(fmt.Sprintf("%s:%d", key, i))})
+		}
+	}
+	recorder, last := initOrderedTopic(t, ctx, "case8_ordered_blocked", 1, msgs)
+
+	biz := newOrderedBiz()
+	release := make(chan struct{})
+	biz.block = map[string]chan struct{}{"a:0": release}
+	consumer := NewOrderedConsumer(recorder, 2, handler.HandlerFunc(biz.handle))
+	consumer.CommitInterval = 10 * time.Millisecond
+	// 保证 a 和 b 在不同的通道上
+	require.NotEqual(t, laneOf(msgs[0], 2), laneOf(msgs[1], 2))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.Consume(ctx)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return len(biz.history("b")) == 5
+	}, 5*time.Second, 10*time.Millisecond)
+	// 多等几次提交
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, biz.history("a"))
+	assert.Empty(t, recorder.committed())
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(last, recorder.committed())
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+	assert.Equal(t, []string{"a:0", "a:1", "a:2", "a:3", "a:4"}, biz.history("a"))
+}
+
+// TestOrderedConsumer_SinglePartition 消费者只拿到一个分区的时候，这个分区上的 Key 也要分散到多条通道上
+func TestOrderedConsumer_SinglePartition(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	const partitions, lanes = 4, 4
+	// 只有落在分区 0 上的用户有更新，其他分区是空的
+	var msgs []mq.Message
+	used := map[int]struct{}{}
+	for u := 0; len(msgs) < 200; u++ {
+		key := fmt.Sprintf("user_%d", u)
+		if mq.HashPartition([]byte(key), partitions) != 0 {
+			continue
+		}
+		for i := 0; i < 10; i++ {
+			msgs = append(msgs, mq.Message{Key: []byte(key), Value: []byte(fmt.Sprintf("%s:%d", key, i))})
+		}
+		used[laneOf(msgs[len(msgs)-1], lanes)] = struct{}{}
+	}
+	assert.Greater(t, len(used), 1)
+	recorder, last := initOrderedTopic(t, ctx, "case8_ordered_single", partitions, msgs)
+	require.Len(t, last, 1)
+
+	biz := newOrderedBiz()
+	consumer := NewOrderedConsumer(recorder, lanes, handler.HandlerFunc(biz.handle))
+	consumer.CommitInterval = 10 * time.Millisecond
+	consumer.Retry.Backoff = time.Millisecond
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.Consume(ctx)
+	}()
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(last, recorder.committed())
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+	assert.Greater(t, biz.maxRunning(), 1)
+}
+
+func TestLaneOf(t *testing.T) {
+	msg := mq.Message{Key: []byte("user_1"), Offset: 1}
+	lane := laneOf(msg, 8)
+	msg.Partition, msg.Offset = 1, 100
+	assert.Equal(t, lane, laneOf(msg, 8))
+	assert.Equal(t, 3, laneOf(mq.Message{Offset: 11}, 8))
+}
+
+// initOrderedTopic 同一个 Key 的消息在同一个分区上，和生产者的默认行为一样
+// 返回的是每个分区最后一条消息的偏移量
+func initOrderedTopic(t *testing.T, ctx context.Context, topic string, partitions int,
+	msgs []mq.Message) (*commitRecorder, map[int32]int64) {
+	broker := memory.NewBroker()
+	require.NoError(t, broker.CreateTopic(ctx, topic, partitions))
+	producer, err := broker.Producer()
+	require.NoError(t, err)
+	last := make(map[int32]int64, partitions)
+	for i := range msgs {
+		msgs[i].Topic = topic
+		msgs[i].Partition = mq.HashPartition(msgs[i].Key, partitions)
+		last[msgs[i].Partition]++
+	}
+	for p := range last {
+		last[p]--
+	}
+	require.NoError(t, producer.Produce(ctx, msgs...))
+	reader, err := broker.Consumer(topic+"_group", topic)
+	require.NoError(t, err)
+	return newCommitRecorder(reader), last
+}
+
+// orderedBiz 记录每个 Key 成功执行的顺序，以及最多有多少条消息同时在执行
+// 每个 Key 的第 5 条第一次会失败，block 里面的消息会一直等到 channel 关闭
+type orderedBiz struct {
+	mu      sync.Mutex
+	block   map[string]chan struct{}
+	failed  map[string]bool
+	done    map[string][]string
+	running int
+	peak    int
+}
+
+func newOrderedBiz() *orderedBiz {
+	return &orderedBiz{failed: make(map[string]bool), done: make(map[string][]string)}
+}
+
+func (b *orderedBiz) handle(ctx context.Context, msg mq.Message) error {
+	val := string(msg.Value)
+	b.mu.Lock()
+	b.running++
+	b.peak = max(b.peak, b.running)
+	ch := b.block[val]
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.running--
+		b.mu.Unlock()
+	}()
+
+	if ch != nil {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if strings.HasSuffix(val, ":5") && !b.failed[val] {
+		b.failed[val] = true
+		return errors.New("数据库超时")
+	}
+	key := string(msg.Key)
+	b.done[key] = append(b.done[key], val)
+	return nil
+}
+
+func (b *orderedBiz) history(key string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.done[key]...)
+}
+
+func (b *orderedBiz) maxRunning() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.peak
+}
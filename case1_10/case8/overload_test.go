@@ -0,0 +1,62 @@
+package case8
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/pkg/mq"
+	"interview-cases/pkg/mq/handler"
+	"interview-cases/pkg/overload"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestAsyncConsumer_Overload 一批 10 条消息，业务服务器同时只能处理两个请求，多出来的返回 429，
+// 消费者降低并发、暂停一会儿之后再处理被拒绝的消息，它们不会进死信队列，最后所有消息都提交了
+func TestAsyncConsumer_Overload(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	broker, reader := initRetryTopic(t, ctx, "case8_overload", 10)
+	recorder := newCommitRecorder(reader)
+	require.NoError(t, broker.CreateTopic(ctx, "case8_overload_dlq", 1))
+	producer, err := broker.Producer()
+	require.NoError(t, err)
+
+	shedder := overload.New(overload.Options{
+		MaxInflight:   2,
+		MaxLatency:    time.Second,
+		RetryAfter:    100 * time.Millisecond,
+		MaxRetryAfter: time.Second,
+	})
+	var handled atomic.Int64
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.POST("/handle", shedder.Middleware(), func(c *gin.Context) {
+		time.Sleep(20 * time.Millisecond)
+		handled.Add(1)
+		c.String(http.StatusOK, "OK")
+	})
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	biz := handler.Wrap(handler.NewHTTPHandler(server.Client(), server.URL+"/handle"), handler.Timeout(time.Second))
+	consumer := NewAsyncConsumer(recorder, 10, biz)
+	consumer.Retry.Backoff = time.Millisecond
+	consumer.DeadLetter = handler.NewDeadLetter(producer, "case8_overload_dlq")
+	// 第一批有 8 条被拒绝，但是不算消费失败
+	require.NoError(t, consumer.batchAsyncConsume(ctx))
+	assert.Less(t, consumer.Limiter.Limit(), 10)
+	assert.True(t, consumer.Limiter.Saturated())
+	for consumer.tracker.pending() > 0 || recorder.committed()[0] < 4 || recorder.committed()[1] < 4 {
+		require.NoError(t, consumer.batchAsyncConsume(ctx))
+	}
+	assert.Equal(t, int64(10), handled.Load())
+	assert.Positive(t, shedder.Stats().Rejected)
+	_, high, err := broker.Watermarks(ctx, mq.TopicPartition{Topic: "case8_overload_dlq"})
+	require.NoError(t, err)
+	assert.Zero(t, high)
+}
@@ -0,0 +1,179 @@
+package case8
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"interview-cases/pkg/mq"
+	"interview-cases/pkg/mq/handler"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// retryHandler 执行业务逻辑，失败了按照 Retry 重试，重试完了还是失败就发到 DeadLetter
+type retryHandler struct {
+	// Retry 每条消息失败之后单独重试，不影响同一批的其他消息
+	Retry handler.RetryOptions
+	// DeadLetter 重试之后还是失败的消息发到死信队列，然后当作处理完了
+	// 为 nil 的时候，失败的消息会一直留在 tracker 里面，它所在的分区在它成功之前不会再提交，
+	// 分区上没有提交的消息达到 MaxPending 之后暂停这个分区
+	DeadLetter *handler.DeadLetter
+	biz        handler.Handler
+}
+
+func newRetryHandler(biz handler.Handler) retryHandler {
+	return retryHandler{Retry: handler.DefaultRetryOptions(), biz: biz}
+}
+
+// handle 按照 Retry 重试，重试完了还是失败就发到死信队列，返回 nil 说明这条消息处理完了
+// 下游过载的时候直接返回 *handler.OverloadError，既不重试也不进死信队列
+func (h *retryHandler) handle(ctx context.Context, msg mq.Message) error {
+	var err error
+	attempts := 0
+	for attempts < h.Retry.MaxAttempts {
+		attempts++
+		err = h.biz.Handle(ctx, msg)
+		if err == nil {
+			return nil
+		}
+		// 下游过载不是消息的问题，不能进死信队列，交给调用方降低并发之后再处理
+		if _, ok := handler.IsOverload(err); ok {
+			return fmt.Errorf("下游过载 offset %d, topic %s, 原因 %w", msg.Offset, msg.Topic, err)
+		}
+		if errors.Is(err, handler.ErrNonRetryable) || attempts == h.Retry.MaxAttempts {
+			break
+		}
+		timer := time.NewTimer(h.Retry.Delay(attempts))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("等待重试的时候退出 offset %d, topic %s, 原因 %w", msg.Offset, msg.Topic, ctx.Err())
+		case <-timer.C:
+		}
+	}
+	err = fmt.Errorf("执行业务失败 offset %d, topic %s, 重试 %d 次, 原因 %w", msg.Offset, msg.Topic, attempts, err)
+	if h.DeadLetter == nil {
+		return err
+	}
+	err1 := h.DeadLetter.Send(ctx, msg, attempts, err)
+	if err1 != nil {
+		return errors.Join(err, err1)
+	}
+	slog.Warn("消息进入死信队列", slog.String("topic", msg.Topic), slog.Int("partition", int(msg.Partition)),
+		slog.Int64("offset", msg.Offset), slog.Any("err", err))
+	return nil
+}
+
+// offsetTracker 记录每个分区上已经拿到、但是还没有提交的消息
+// 一条消息成功了或者进了死信队列才算处理完，每个分区只能提交从头开始连续处理完的那一段，
+// 不然前面的消息还没处理完就提交了后面的，重启之后前面的消息就丢了
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[mq.TopicPartition]*inflight
+}
+
+type inflight struct {
+	// msgs 按照偏移量排序，偏移量不一定连续，例如开启了日志压缩的 topic
+	msgs []mq.Message
+	done map[int64]struct{}
+	// last 添加过的最大的偏移量
+	last int64
+	// paused 没有提交的消息太多，已经暂停了这个分区
+	paused bool
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: make(map[mq.TopicPartition]*inflight)}
+}
+
+// add 按照 Fetch 返回的顺序添加，同一个分区的偏移量是递增的
+// 重新分配分区之后，没有提交的消息会再拿到一次，这些消息正在处理或者已经处理完了，返回 false
+func (t *offsetTracker) add(msg mq.Message) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.partitions[msg.TopicPartition()]
+	if !ok {
+		p = &inflight{done: make(map[int64]struct{}), last: -1}
+		t.partitions[msg.TopicPartition()] = p
+	}
+	if msg.Offset <= p.last {
+		return false
+	}
+	p.last = msg.Offset
+	p.msgs = append(p.msgs, msg)
+	return true
+}
+
+func (t *offsetTracker) markDone(msg mq.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p, ok := t.partitions[msg.TopicPartition()]; ok {
+		p.done[msg.Offset] = struct{}{}
+	}
+}
+
+// ready 返回每个分区上连续处理完的最后一条消息，并且不再跟踪这些消息
+func (t *offsetTracker) ready() []mq.Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var res []mq.Message
+	for _, p := range t.partitions {
+		n := 0
+		for n < len(p.msgs) {
+			if _, ok := p.done[p.msgs[n].Offset]; !ok {
+				break
+			}
+			delete(p.done, p.msgs[n].Offset)
+			n++
+		}
+		if n > 0 {
+			res = append(res, p.msgs[n-1])
+			p.msgs = p.msgs[n:]
+		}
+	}
+	return res
+}
+
+// pending 还没有提交的消息数量
+func (t *offsetTracker) pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var cnt int
+	for _, p := range t.partitions {
+		cnt += len(p.msgs)
+	}
+	return cnt
+}
+
+// throttle 暂停没有提交的消息达到 limit 条的分区，恢复已经降到 limit 以下的分区，limit 小于等于 0 的时候不限制
+// 分区的第一条消息一直失败的话，后面的消息处理完了也不能提交，不暂停的话 tracker 会越来越大
+// 暂停之前已经拉取的消息还是会添加进来，所以会稍微超过 limit
+func (t *offsetTracker) throttle(c mq.Consumer, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var err error
+	for tp, p := range t.partitions {
+		full := len(p.msgs) >= limit
+		switch {
+		case full && !p.paused:
+			if err1 := c.Pause(tp); err1 != nil {
+				err = errors.Join(err, fmt.Errorf("暂停分区失败 %w", err1))
+				continue
+			}
+			p.paused = true
+			slog.Warn("没有提交的消息太多，暂停分区", slog.String("topic", tp.Topic),
+				slog.Int("partition", int(tp.Partition)), slog.Int("pending", len(p.msgs)))
+		case !full && p.paused:
+			if err1 := c.Resume(tp); err1 != nil {
+				err = errors.Join(err, fmt.Errorf("恢复分区失败 %w", err1))
+				continue
+			}
+			p.paused = false
+		}
+	}
+	return err
+}
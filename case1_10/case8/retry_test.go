@@ -0,0 +1,317 @@
+package case8
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/pkg/mq"
+	"interview-cases/pkg/mq/handler"
+	"interview-cases/pkg/mq/memory"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOffsetTracker(t *testing.T) {
+	tracker := newOffsetTracker()
+	p0 := func(offset int64) mq.Message {
+		return mq.Message{Topic: "t", Partition: 0, Offset: offset}
+	}
+	p1 := mq.Message{Topic: "t", Partition: 1, Offset: 7}
+	// 偏移量 3 没有了，例如被日志压缩掉了
+	for _, msg := range []mq.Message{p0(1), p0(2), p1, p0(4), p0(5)} {
+		tracker.add(msg)
+	}
+	// 重新分配分区之后又拿到了同一条消息
+	assert.False(t, tracker.add(p0(2)))
+	tracker.markDone(p0(2))
+	tracker.markDone(p0(4))
+	tracker.markDone(p1)
+	// 分区 0 的 1 还没处理完，所以一条都不能提交
+	assert.Equal(t, []mq.Message{p1}, tracker.ready())
+	assert.Equal(t, 4, tracker.pending())
+
+	tracker.markDone(p0(1))
+	assert.Equal(t, []mq.Message{p0(4)}, tracker.ready())
+	assert.Empty(t, tracker.ready())
+	tracker.markDone(p0(5))
+	assert.Equal(t, []mq.Message{p0(5)}, tracker.ready())
+	assert.Zero(t, tracker.pending())
+}
+
+func TestOffsetTracker_Throttle(t *testing.T) {
+	ctx := context.Background()
+	broker := memory.NewBroker()
+	require.NoError(t, broker.CreateTopic(ctx, "case8_throttle", 1))
+	reader, err := broker.Consumer("case8_throttle_group", "case8_throttle")
+	require.NoError(t, err)
+	paused := &pauseRecorder{Consumer: reader}
+
+	tracker := newOffsetTracker()
+	msg := func(offset int64) mq.Message {
+		return mq.Message{Topic: "case8_throttle", Offset: offset}
+	}
+	tracker.add(msg(0))
+	require.NoError(t, tracker.throttle(paused, 2))
+	assert.Empty(t, paused.calls)
+	tracker.add(msg(1))
+	require.NoError(t, tracker.throttle(paused, 2))
+	require.NoError(t, tracker.throttle(paused, 2))
+	assert.Equal(t, []string{"pause 0"}, paused.calls)
+
+	// 第一条处理完并且提交了之后恢复
+	tracker.markDone(msg(0))
+	tracker.ready()
+	require.NoError(t, tracker.throttle(paused, 2))
+	assert.Equal(t, []string{"pause 0", "resume 0"}, paused.calls)
+	// 不限制
+	tracker.add(msg(2))
+	require.NoError(t, tracker.throttle(paused, 0))
+	assert.Len(t, paused.calls, 2)
+}
+
+// TestAsyncConsumer_DeadLetter 两个分区一共 20 条消息：
+// 3 失败两次之后成功，7 是参数错误，12 一直失败，7 和 12 最后都进了死信队列，所有消息都提交了
+func TestAsyncConsumer_DeadLetter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	broker, reader := initRetryTopic(t, ctx, "case8_retry", 20)
+	recorder := newCommitRecorder(reader)
+	producer, err := broker.Producer()
+	require.NoError(t, err)
+
+	biz := newFakeBiz(map[string]int{"3": 2, "12": 100}, "7")
+	consumer := NewAsyncConsumer(recorder, 5, handler.HandlerFunc(biz.handle))
+	consumer.Retry.Backoff = time.Millisecond
+	consumer.DeadLetter = handler.NewDeadLetter(producer, "case8_retry_dlq")
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, consumer.batchAsyncConsume(ctx))
+	}
+	assert.Zero(t, consumer.tracker.pending())
+	assert.Equal(t, map[int32]int64{0: 9, 1: 9}, recorder.committed())
+	assert.Equal(t, 3, biz.attempts("3"))
+	assert.Equal(t, 1, biz.attempts("7"))
+	assert.Equal(t, 3, biz.attempts("12"))
+
+	// 死信消息带着原始的位置和失败原因
+	dlq, err := broker.Consumer("case8_retry_dlq_group", "case8_retry_dlq")
+	require.NoError(t, err)
+	got := make(map[string]mq.Message, 2)
+	for i := 0; i < 2; i++ {
+		msg, err := dlq.Fetch(ctx)
+		require.NoError(t, err)
+		got[string(msg.Value)] = msg
+	}
+	for val, attempts := range map[string]string{"7": "1", "12": "3"} {
+		msg, ok := got[val]
+		require.True(t, ok, val)
+		i, _ := strconv.Atoi(val)
+		assertHeader(t, msg, handler.HeaderOriginTopic, "case8_retry")
+		assertHeader(t, msg, handler.HeaderOriginPartition, strconv.Itoa(i%2))
+		assertHeader(t, msg, handler.HeaderOriginOffset, strconv.Itoa(i/2))
+		assertHeader(t, msg, handler.HeaderAttempts, attempts)
+		errMsg, _ := msg.HeaderValue(handler.HeaderError)
+		assert.Contains(t, string(errMsg), "业务失败")
+	}
+}
+
+// TestAsyncConsumer_DeadLetterUnavailable 死信队列也发不出去的时候，
+// 分区 1 只能提交到失败的消息之前，等死信队列恢复之后再接着提交
+func TestAsyncConsumer_DeadLetterUnavailable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	broker, reader := initRetryTopic(t, ctx, "case8_retry_unavailable", 20)
+	recorder := newCommitRecorder(reader)
+	producer, err := broker.Producer()
+	require.NoError(t, err)
+	dlqProducer := &flakyProducer{Producer: producer, fail: true}
+
+	// 5 在分区 1 的偏移量 2 上
+	biz := newFakeBiz(map[string]int{"5": 100}, "")
+	consumer := NewAsyncConsumer(recorder, 5, handler.HandlerFunc(biz.handle))
+	consumer.Retry.Backoff = time.Millisecond
+	consumer.DeadLetter = handler.NewDeadLetter(dlqProducer, "case8_retry_unavailable_dlq")
+	for i := 0; i < 4; i++ {
+		err = consumer.batchAsyncConsume(ctx)
+		if i == 1 {
+			assert.Error(t, err)
+		}
+	}
+	assert.Equal(t, map[int32]int64{0: 9, 1: 1}, recorder.committed())
+	assert.Equal(t, 8, consumer.tracker.pending())
+
+	// 死信队列恢复之后，下一批会先处理失败的消息
+	dlqProducer.setFail(false)
+	require.NoError(t, consumer.batchAsyncConsume(ctx))
+	assert.Equal(t, map[int32]int64{0: 9, 1: 9}, recorder.committed())
+	assert.Zero(t, consumer.tracker.pending())
+	assert.Equal(t, 3*(4-1)+3, biz.attempts("5"))
+}
+
+// TestAsyncConsumer_MaxPending 没有死信队列，分区 1 的第一条消息一直失败，
+// 分区 1 没有提交的消息到了 MaxPending 就暂停，分区 0 照常消费，第一条消息成功之后分区 1 恢复
+func TestAsyncConsumer_MaxPending(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, reader := initRetryTopic(t, ctx, "case8_retry_max_pending", 20)
+	recorder := newCommitRecorder(reader)
+
+	// 1 在分区 1 的偏移量 0 上
+	biz := newFakeBiz(map[string]int{"1": 1000}, "")
+	consumer := NewAsyncConsumer(recorder, 5, handler.HandlerFunc(biz.handle))
+	consumer.Retry.Backoff = time.Millisecond
+	consumer.MaxPending = 3
+	for recorder.committed()[0] < 9 {
+		_ = consumer.batchAsyncConsume(ctx)
+		require.NoError(t, ctx.Err())
+		assert.LessOrEqual(t, consumer.tracker.pending(), 3)
+	}
+	_ = consumer.batchAsyncConsume(ctx)
+	assert.Equal(t, map[int32]int64{0: 9}, recorder.committed())
+	assert.Equal(t, 3, consumer.tracker.pending())
+
+	biz.mu.Lock()
+	biz.failures["1"] = 0
+	biz.mu.Unlock()
+	for recorder.committed()[1] < 9 {
+		require.NoError(t, consumer.batchAsyncConsume(ctx))
+		require.NoError(t, ctx.Err())
+	}
+	assert.Zero(t, consumer.tracker.pending())
+}
+
+// initRetryTopic 两个分区，消息 i 在分区 i%2 上，偏移量是 i/2
+func initRetryTopic(t *testing.T, ctx context.Context, topic string, n int) (*memory.Broker, mq.Consumer) {
+	broker := memory.NewBroker()
+	require.NoError(t, broker.CreateTopic(ctx, topic, 2))
+	producer, err := broker.Producer()
+	require.NoError(t, err)
+	msgs := make([]mq.Message, 0, n)
+	for i := 0; i < n; i++ {
+		msgs = append(msgs, mq.Message{
+			Topic:     topic,
+			Partition: int32(i % 2),
+			Key:       []byte(strconv.Itoa(i)),
+			Value:     []byte(strconv.Itoa(i)),
+		})
+	}
+	require.NoError(t, producer.Produce(ctx, msgs...))
+	reader, err := broker.Consumer(topic+"_group", topic)
+	require.NoError(t, err)
+	return broker, reader
+}
+
+func assertHeader(t *testing.T, msg mq.Message, key, want string) {
+	val, ok := msg.HeaderValue(key)
+	assert.True(t, ok, key)
+	assert.Equal(t, want, string(val), key)
+}
+
+// fakeBiz failures 是每条消息前几次会失败，invalid 是参数错误的消息
+type fakeBiz struct {
+	mu       sync.Mutex
+	failures map[string]int
+	invalid  string
+	calls    map[string]int
+}
+
+func newFakeBiz(failures map[string]int, invalid string) *fakeBiz {
+	return &fakeBiz{failures: failures, invalid: invalid, calls: make(map[string]int)}
+}
+
+func (b *fakeBiz) handle(ctx context.Context, msg mq.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	val := string(msg.Value)
+	b.calls[val]++
+	if val == b.invalid {
+		return fmt.Errorf("%w: 参数错误", handler.ErrNonRetryable)
+	}
+	if b.calls[val] <= b.failures[val] {
+		return errors.New("数据库超时")
+	}
+	return nil
+}
+
+func (b *fakeBiz) attempts(val string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.calls[val]
+}
+
+// commitRecorder 记录每个分区提交的最大偏移量
+type commitRecorder struct {
+	mq.Consumer
+	mu      sync.Mutex
+	offsets map[int32]int64
+}
+
+func newCommitRecorder(c mq.Consumer) *commitRecorder {
+	return &commitRecorder{Consumer: c, offsets: make(map[int32]int64)}
+}
+
+func (c *commitRecorder) Commit(ctx context.Context, msgs ...mq.Message) error {
+	c.mu.Lock()
+	for _, msg := range msgs {
+		if off, ok := c.offsets[msg.Partition]; !ok || msg.Offset > off {
+			c.offsets[msg.Partition] = msg.Offset
+		}
+	}
+	c.mu.Unlock()
+	return c.Consumer.Commit(ctx, msgs...)
+}
+
+func (c *commitRecorder) committed() map[int32]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := make(map[int32]int64, len(c.offsets))
+	for p, off := range c.offsets {
+		res[p] = off
+	}
+	return res
+}
+
+// pauseRecorder 记录暂停和恢复了哪些分区
+type pauseRecorder struct {
+	mq.Consumer
+	calls []string
+}
+
+func (c *pauseRecorder) Pause(partitions ...mq.TopicPartition) error {
+	for _, tp := range partitions {
+		c.calls = append(c.calls, fmt.Sprintf("pause %d", tp.Partition))
+	}
+	return c.Consumer.Pause(partitions...)
+}
+
+func (c *pauseRecorder) Resume(partitions ...mq.TopicPartition) error {
+	for _, tp := range partitions {
+		c.calls = append(c.calls, fmt.Sprintf("resume %d", tp.Partition))
+	}
+	return c.Consumer.Resume(partitions...)
+}
+
+type flakyProducer struct {
+	mq.Producer
+	mu   sync.Mutex
+	fail bool
+}
+
+func (p *flakyProducer) Produce(ctx context.Context, msgs ...mq.Message) error {
+	p.mu.Lock()
+	fail := p.fail
+	p.mu.Unlock()
+	if fail {
+		return errors.New("死信队列不可用")
+	}
+	return p.Producer.Produce(ctx, msgs...)
+}
+
+func (p *flakyProducer) setFail(fail bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fail = fail
+}
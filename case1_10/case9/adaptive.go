@@ -0,0 +1,156 @@
+package case9
+
+import (
+	"sync"
+	"time"
+)
+
+// BatchOptions 一批消息在数量、字节数、等待时间三个条件里面任何一个满足的时候就结束
+// 数量是自适应的，在 [MinSize, MaxSize] 之间根据批量接口的耗时和错误率调整，MinSize 和 MaxSize 相等就是固定大小
+type BatchOptions struct {
+	MinSize int
+	MaxSize int
+	// MaxBytes 一批消息的 Value 加起来最多多少字节，单条消息超过了也会单独成为一批
+	MaxBytes int
+	// MaxWait 从拿到这一批的第一条消息开始，最多等多久
+	MaxWait time.Duration
+	// TargetLatency 一批的耗时超过它就认为批量接口扛不住了，批次减半
+	TargetLatency time.Duration
+	// MaxErrorRate 失败之后最近的错误率超过它，批次减半
+	MaxErrorRate float64
+}
+
+func DefaultBatchOptions() BatchOptions {
+	return BatchOptions{
+		MinSize:       1,
+		MaxSize:       1000,
+		MaxBytes:      1 << 20,
+		MaxWait:       time.Second,
+		TargetLatency: 500 * time.Millisecond,
+		MaxErrorRate:  0.1,
+	}
+}
+
+// BatchStats 当前的批次参数和最近的表现，耗时、错误率和吞吐量都是指数加权平均
+type BatchStats struct {
+	Size       int           `json:"size"`
+	MaxBytes   int           `json:"maxBytes"`
+	MaxWait    time.Duration `json:"maxWait"`
+	Latency    time.Duration `json:"latency"`
+	ErrorRate  float64       `json:"errorRate"`
+	Throughput float64       `json:"throughput"`
+	Batches    int64         `json:"batches"`
+	Messages   int64         `json:"messages"`
+	// ByCount、ByBytes、ByWait 分别是因为数量、字节数、等待时间结束的批次数
+	ByCount int64 `json:"byCount"`
+	ByBytes int64 `json:"byBytes"`
+	ByWait  int64 `json:"byWait"`
+	// Overloads 下游返回过载的次数
+	Overloads int64 `json:"overloads"`
+}
+
+type closeReason int
+
+const (
+	closeByCount closeReason = iota
+	closeByBytes
+	closeByWait
+)
+
+// ewmaAlpha 越大越看重最近的批次
+const ewmaAlpha = 0.2
+
+// batchSizer 用爬山法调整批次大小：
+// 满的批次才能说明批次大小好不好，吞吐量（每秒处理的消息数）比上一次好就继续往同一个方向调，变差了就掉头；
+// 耗时超过 TargetLatency 或者失败之后错误率超过 MaxErrorRate 的时候直接减半，之后重新从变大开始尝试
+type batchSizer struct {
+	mu    sync.Mutex
+	stats BatchStats
+	// dir 1 是变大，-1 是变小
+	dir            int
+	lastThroughput float64
+}
+
+func newBatchSizer(size int) *batchSizer {
+	return &batchSizer{stats: BatchStats{Size: size}, dir: 1}
+}
+
+// size 返回下一批的大小，顺便把它限制在 opts 的范围里面
+func (s *batchSizer) size(opts BatchOptions) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Size = min(max(s.stats.Size, opts.MinSize), opts.MaxSize)
+	return s.stats.Size
+}
+
+func (s *batchSizer) observe(opts BatchOptions, n int, reason closeReason, latency time.Duration, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := &s.stats
+	st.MaxBytes, st.MaxWait = opts.MaxBytes, opts.MaxWait
+	st.Batches++
+	st.Messages += int64(n)
+	switch reason {
+	case closeByCount:
+		st.ByCount++
+	case closeByBytes:
+		st.ByBytes++
+	case closeByWait:
+		st.ByWait++
+	}
+	errVal := 0.0
+	if failed {
+		errVal = 1
+	}
+	st.ErrorRate = ewma(st.ErrorRate, errVal, st.Batches)
+	st.Latency = time.Duration(ewma(float64(st.Latency), float64(latency), st.Batches))
+	throughput := float64(n) / max(latency.Seconds(), 1e-6)
+	if !failed {
+		st.Throughput = ewma(st.Throughput, throughput, st.Batches)
+	}
+
+	// 错误率是平均值，失败一次之后要过好几批才会降下来，所以只有失败的那一批才按照错误率减半，
+	// 不然一次偶然的失败后面跟着的每一批成功的都会减半
+	if (failed && st.ErrorRate > opts.MaxErrorRate) || latency > opts.TargetLatency {
+		s.resize(opts, st.Size/2)
+		s.dir, s.lastThroughput = 1, 0
+		return
+	}
+	if failed || reason != closeByCount {
+		return
+	}
+	if s.lastThroughput > 0 && throughput < s.lastThroughput {
+		s.dir = -s.dir
+	}
+	s.lastThroughput = throughput
+	// 每次调整 10%，至少调整 1
+	s.resize(opts, st.Size+s.dir*max(st.Size/10, 1))
+}
+
+// overload 下游明确说了过载，不管最近的表现怎么样，批次至少是过载的那一次的一半，n 是那一次的消息数
+// 消费者一次只有一批在处理，批次大小就是它对下游的并发，加上面的爬山法就是 AIMD
+func (s *batchSizer) overload(opts BatchOptions, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Overloads++
+	s.resize(opts, min(s.stats.Size, n/2))
+	s.dir, s.lastThroughput = 1, 0
+}
+
+func (s *batchSizer) resize(opts BatchOptions, size int) {
+	s.stats.Size = min(max(size, opts.MinSize), opts.MaxSize)
+}
+
+func (s *batchSizer) snapshot() BatchStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// ewma 第一个值直接作为平均值
+func ewma(avg, val float64, cnt int64) float64 {
+	if cnt <= 1 {
+		return val
+	}
+	return avg + ewmaAlpha*(val-avg)
+}
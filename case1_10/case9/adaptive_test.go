@@ -0,0 +1,201 @@
+package case9
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/pkg/mq"
+	"interview-cases/pkg/mq/handler"
+	"interview-cases/pkg/mq/memory"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestBatchSizer_Grow 批量接口每次调用固定 20ms，每条消息再加 0.2ms，批次越大吞吐量越高，
+// 但是超过 400 条耗时就超过 100ms 了，所以批次会涨到 400 附近，然后在下面来回调整
+func TestBatchSizer_Grow(t *testing.T) {
+	opts := DefaultBatchOptions()
+	opts.TargetLatency = 100 * time.Millisecond
+	sizer := newBatchSizer(10)
+	var peak int
+	for i := 0; i < 300; i++ {
+		n := sizer.size(opts)
+		peak = max(peak, n)
+		latency := 20*time.Millisecond + time.Duration(n)*200*time.Microsecond
+		sizer.observe(opts, n, closeByCount, latency, false)
+	}
+	stats := sizer.snapshot()
+	assert.Greater(t, stats.Size, 100)
+	assert.LessOrEqual(t, stats.Size, 400)
+	assert.LessOrEqual(t, peak, 440)
+	assert.Equal(t, int64(300), stats.Batches)
+	assert.Equal(t, int64(300), stats.ByCount)
+	assert.Zero(t, stats.ErrorRate)
+}
+
+// TestBatchSizer_Shrink 耗时飙升或者一直失败的时候，批次减半，最小到 MinSize
+func TestBatchSizer_Shrink(t *testing.T) {
+	opts := DefaultBatchOptions()
+	sizer := newBatchSizer(200)
+	sizer.observe(opts, 200, closeByCount, time.Second, false)
+	assert.Equal(t, 100, sizer.size(opts))
+
+	for i := 0; i < 10; i++ {
+		sizer.observe(opts, sizer.size(opts), closeByCount, time.Millisecond, true)
+	}
+	stats := sizer.snapshot()
+	assert.Equal(t, opts.MinSize, stats.Size)
+	assert.Greater(t, stats.ErrorRate, opts.MaxErrorRate)
+
+	// 没有满的批次说明不了批次大小好不好，不调整
+	sizer = newBatchSizer(50)
+	sizer.observe(opts, 3, closeByWait, time.Millisecond, false)
+	sizer.observe(opts, 10, closeByBytes, time.Millisecond, false)
+	assert.Equal(t, 50, sizer.size(opts))
+}
+
+// TestBatchSizer_TransientFailure 偶然失败一次，后面都成功了，最多只减半一次，第一批就失败也一样
+func TestBatchSizer_TransientFailure(t *testing.T) {
+	opts := DefaultBatchOptions()
+	testCases := []struct {
+		name string
+		// ok 失败之前成功了多少批
+		ok int
+	}{
+		{name: "第一批失败", ok: 0},
+		{name: "中途失败", ok: 5},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sizer := newBatchSizer(200)
+			latency := func(n int) time.Duration {
+				return 20*time.Millisecond + time.Duration(n)*200*time.Microsecond
+			}
+			for i := 0; i < tc.ok; i++ {
+				n := sizer.size(opts)
+				sizer.observe(opts, n, closeByCount, latency(n), false)
+			}
+			before := sizer.size(opts)
+			sizer.observe(opts, before, closeByCount, latency(before), true)
+			assert.Equal(t, before/2, sizer.size(opts))
+			for i := 0; i < 20; i++ {
+				n := sizer.size(opts)
+				assert.GreaterOrEqual(t, n, before/2)
+				sizer.observe(opts, n, closeByCount, latency(n), false)
+			}
+			// 后面成功的批次不会再减半，批次又涨回来了
+			stats := sizer.snapshot()
+			assert.Greater(t, stats.Size, before/2)
+		})
+	}
+}
+
+// TestBatchConsumer_Close 10 条消息，每条 4 个字节，分别按照数量和字节数结束批次
+func TestBatchConsumer_Close(t *testing.T) {
+	testCases := []struct {
+		name      string
+		maxBytes  int
+		wantSizes []int
+		wantStats func(t *testing.T, stats BatchStats)
+	}{
+		{
+			name:      "数量",
+			maxBytes:  1 << 20,
+			wantSizes: []int{4, 4, 2},
+			wantStats: func(t *testing.T, stats BatchStats) {
+				assert.Equal(t, int64(2), stats.ByCount)
+				assert.Equal(t, int64(1), stats.ByWait)
+			},
+		},
+		{
+			name:      "字节数",
+			maxBytes:  10,
+			wantSizes: []int{2, 2, 2, 2, 2},
+			wantStats: func(t *testing.T, stats BatchStats) {
+				// 最后一批因为没有更多的消息结束
+				assert.Equal(t, int64(4), stats.ByBytes)
+				assert.Equal(t, int64(1), stats.ByWait)
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			reader := initBatchTopic(t, ctx, 10)
+			var sizes []int
+			biz := handler.BatchHandlerFunc(func(ctx context.Context, msgs []mq.Message) error {
+				sizes = append(sizes, len(msgs))
+				return nil
+			})
+			consumer := NewBatchConsumer(reader, 4, biz)
+			// 固定批次大小
+			consumer.Options.MinSize, consumer.Options.MaxSize = 4, 4
+			consumer.Options.MaxBytes = tc.maxBytes
+			consumer.Options.MaxWait = 50 * time.Millisecond
+			for i := 0; i < len(tc.wantSizes); i++ {
+				require.NoError(t, consumer.batchConsume(ctx))
+			}
+			assert.Equal(t, tc.wantSizes, sizes)
+			stats := consumer.Stats()
+			assert.Equal(t, int64(10), stats.Messages)
+			tc.wantStats(t, stats)
+		})
+	}
+}
+
+func TestBatchConsumer_Stats(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reader := initBatchTopic(t, ctx, 5)
+	failed := false
+	consumer := NewBatchConsumer(reader, 5, handler.BatchHandlerFunc(func(ctx context.Context, msgs []mq.Message) error {
+		if !failed {
+			failed = true
+			return errors.New("数据库超时")
+		}
+		return nil
+	}))
+	consumer.Options.MaxWait = 50 * time.Millisecond
+	assert.Error(t, consumer.batchConsume(ctx))
+
+	gin.SetMode(gin.ReleaseMode)
+	server := gin.New()
+	consumer.RegisterRouter(server)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/batch/stats", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var stats BatchStats
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &stats))
+	// 失败了一次，错误率超过了 10%，批次减半
+	assert.Equal(t, BatchStats{
+		Size:      2,
+		MaxBytes:  1 << 20,
+		MaxWait:   50 * time.Millisecond,
+		Latency:   stats.Latency,
+		ErrorRate: 1,
+		Batches:   1,
+		Messages:  5,
+		ByCount:   1,
+	}, stats)
+}
+
+func initBatchTopic(t *testing.T, ctx context.Context, n int) mq.Consumer {
+	broker := memory.NewBroker()
+	producer, err := broker.Producer()
+	require.NoError(t, err)
+	msgs := make([]mq.Message, 0, n)
+	for i := 0; i < n; i++ {
+		msgs = append(msgs, mq.Message{Topic: "case9_batch", Value: []byte(fmt.Sprintf("%04d", i))})
+	}
+	require.NoError(t, producer.Produce(ctx, msgs...))
+	reader, err := broker.Consumer("case9_batch_group", "case9_batch")
+	require.NoError(t, err)
+	return reader
+}
@@ -1,87 +1,236 @@
 package case9
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/ecodeclub/ekit/slice"
-	kafkago "github.com/segmentio/kafka-go"
-	"io"
+	"github.com/gin-gonic/gin"
+	"interview-cases/pkg/mq"
+	"interview-cases/pkg/mq/handler"
 	"log/slog"
 	"net/http"
+	"slices"
 	"time"
 )
 
 type BatchConsumer struct {
-	reader    *kafkago.Reader
-	batchSize int
+	consumer mq.Consumer
+	biz      handler.BatchHandler
+	// Options 批次大小在 MinSize 和 MaxSize 之间自动调整
+	Options BatchOptions
+
+	// DeadLetter 有问题的消息（例如格式不对）发到死信队列，为 nil 的时候只打日志然后跳过
+	DeadLetter *handler.DeadLetter
+	// RetryInterval 批量接口暂时不可用（例如数据库超时）的时候，隔多久重试没有处理完的消息
+	// 下游过载并且给了 Retry-After 的时候，等两者之中大的那个
+	RetryInterval time.Duration
+
+	sizer *batchSizer
+	// carry 上一批放不下的消息，放到下一批的开头
+	carry *mq.Message
+	// batch 已经拿到但是还没有提交的一批，rest 是里面还没有处理完的消息
+	batch []mq.Message
+	rest  []mq.Message
 }
 
-func NewBatchConsumer(reader *kafkago.Reader, batchSize int) *BatchConsumer {
-	return &BatchConsumer{reader: reader, batchSize: 5}
+// NewBatchConsumer biz 一次处理一批消息，可以调用批量接口，也可以直接批量插入数据库
+// batchSize 是一开始的批次大小，之后会根据批量接口的表现自动调整
+func NewBatchConsumer(consumer mq.Consumer, batchSize int, biz handler.BatchHandler) *BatchConsumer {
+	return &BatchConsumer{
+		consumer: consumer,
+		biz:      biz,
+		Options:  DefaultBatchOptions(),
+		sizer:    newBatchSizer(batchSize),
+
+		RetryInterval: time.Second,
+	}
 }
 
+// Consume 出错了不会退出，等 RetryInterval 之后继续处理没有处理完的消息
+// 等待的时候不会拉取新的消息，下游过载的时候消费就慢下来了
 func (c *BatchConsumer) Consume(ctx context.Context) {
 	for {
 		if ctx.Err() != nil {
 			return
 		}
 		err := c.batchConsume(ctx)
-		if err != nil {
+		if err == nil {
+			continue
+		}
+		delay := c.RetryInterval
+		if retryAfter, ok := handler.IsOverload(err); ok {
+			// 过载是预期之内的，批次已经减半了，不需要打 Error 日志
+			delay = max(delay, retryAfter)
+			slog.Warn("下游过载，暂停消费", slog.Int("size", c.sizer.size(c.Options)),
+				slog.Duration("pause", delay), slog.Int("rest", len(c.rest)))
+		} else {
 			slog.Error("消费失败", slog.Any("err", err))
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
 			return
+		case <-timer.C:
 		}
 	}
 }
 
-func (c *BatchConsumer) batchConsume(ctx context.Context) error {
-	batchCtx, cancel := context.WithTimeout(ctx, time.Second)
-	defer cancel()
-	msgs := make([]kafkago.Message, 0, c.batchSize)
-	// 获取一批数据
+// Stats 当前的批次大小和最近的耗时、错误率、吞吐量
+func (c *BatchConsumer) Stats() BatchStats {
+	return c.sizer.snapshot()
+}
 
-	for i := 0; i < c.batchSize; i++ {
-		msg, err := c.reader.ReadMessage(batchCtx)
+// RegisterRouter 通过 GET /batch/stats 查看 Stats
+func (c *BatchConsumer) RegisterRouter(server *gin.Engine) {
+	server.GET("/batch/stats", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, c.Stats())
+	})
+}
+
+func (c *BatchConsumer) batchConsume(ctx context.Context) error {
+	var (
+		err error
+		// n 这一次调用批量接口的消息数
+		n int
+	)
+	if len(c.rest) > 0 {
+		// 上一批没有处理完，先把它处理完，重试的耗时不用来调整批次大小
+		// 下游过载之后批次变小了，所以每次最多重试一个批次那么多条
+		n = min(len(c.rest), c.sizer.size(c.Options))
+		var rest []mq.Message
+		rest, err = c.process(ctx, c.rest[:n])
+		c.rest = slices.Concat(rest, c.rest[n:])
+		if len(c.rest) > 0 && err == nil {
+			return nil
+		}
+	} else {
+		var reason closeReason
+		c.batch, reason, err = c.fetchBatch(ctx)
+		if len(c.batch) == 0 {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("获取消息失败 %w", err)
+		}
 		if err != nil {
-			// 取出来多少就处理多少
-			break
+			slog.Error("获取消息失败，先处理已经拿到的消息", slog.Any("err", err))
 		}
-		msgs = append(msgs, msg)
+		// 批量消费
+		start := time.Now()
+		n = len(c.batch)
+		c.rest, err = c.process(ctx, c.batch)
+		c.sizer.observe(c.Options, len(c.batch), reason, time.Since(start), err != nil)
 	}
-	if len(msgs) == 0 {
-		return nil
+	if _, ok := handler.IsOverload(err); ok {
+		c.sizer.overload(c.Options, n)
 	}
-	// 批量消费
-	err := c.batchBiz(msgs)
 	if err != nil {
-		return fmt.Errorf("批量消费消息失败 %w", err)
+		return fmt.Errorf("批量消费消息失败，还有 %d 条没有处理完 %w", len(c.rest), err)
 	}
-	err = c.reader.CommitMessages(ctx, msgs...)
+	// 有问题的消息已经进了死信队列，整批都可以提交
+	msgs := c.batch
+	c.batch = nil
+	err = c.consumer.Commit(ctx, msgs...)
 	if err != nil {
 		return fmt.Errorf("提交消息失败 %w", err)
 	}
 	return nil
 }
 
-func (c *BatchConsumer) batchBiz(msgs []kafkago.Message) error {
-	// 从实际业务上来说，你这里可能是调用 rpc，也可能是调用 Http，或者直接处理
-	// 这里我们用 http 接口来模拟。
-	// 直接调用批量接口
-	vals := slice.Map(msgs, func(idx int, src kafkago.Message) string {
-		return string(src.Value)
-	})
-	data, _ := json.Marshal(vals)
-	resp, err := http.Post(
-		"http://localhost:8080/batch", "application/json", bytes.NewBuffer(data))
-	if err != nil {
-		return err
+// process 返回没有处理完、需要稍后重试的消息
+// 批量接口返回了每条消息的结果的话，参数错误的消息直接进死信队列；
+// 只知道整批参数错误的话，把这一批分成两半分别处理，直到找出有问题的那几条
+func (c *BatchConsumer) process(ctx context.Context, msgs []mq.Message) ([]mq.Message, error) {
+	err := c.biz.HandleBatch(ctx, msgs)
+	if err == nil {
+		return nil, nil
 	}
-	defer resp.Body.Close()
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
+	var be *handler.BatchError
+	if errors.As(err, &be) {
+		var (
+			rest []mq.Message
+			errs []error
+		)
+		for i, msg := range msgs {
+			itemErr, ok := be.Failed[i]
+			if !ok {
+				continue
+			}
+			if errors.Is(itemErr, handler.ErrNonRetryable) {
+				itemErr = c.deadLetter(ctx, msg, itemErr)
+			}
+			if itemErr != nil {
+				rest = append(rest, msg)
+				errs = append(errs, itemErr)
+			}
+		}
+		return rest, errors.Join(errs...)
 	}
-	slog.Debug("处理完毕", slog.String("resp", string(respBody)))
-	return nil
+	if !errors.Is(err, handler.ErrNonRetryable) {
+		return msgs, err
+	}
+	if len(msgs) == 1 {
+		err = c.deadLetter(ctx, msgs[0], err)
+		if err != nil {
+			return msgs, err
+		}
+		return nil, nil
+	}
+	mid := len(msgs) / 2
+	left, err1 := c.process(ctx, msgs[:mid])
+	right, err2 := c.process(ctx, msgs[mid:])
+	// left 可能就是 msgs[:mid]，直接 append 会覆盖 msgs[mid:]
+	return slices.Concat(left, right), errors.Join(err1, err2)
+}
+
+func (c *BatchConsumer) deadLetter(ctx context.Context, msg mq.Message, cause error) error {
+	slog.Error("隔离有问题的消息", slog.String("topic", msg.Topic), slog.Int("partition", int(msg.Partition)),
+		slog.Int64("offset", msg.Offset), slog.Any("err", cause))
+	if c.DeadLetter == nil {
+		return nil
+	}
+	return c.DeadLetter.Send(ctx, msg, 1, cause)
+}
+
+// fetchBatch 第一条消息会一直等，拿到第一条之后最多再等 MaxWait
+// 数量够了、字节数够了或者时间到了就结束这一批，放不下的那一条留给下一批
+func (c *BatchConsumer) fetchBatch(ctx context.Context) ([]mq.Message, closeReason, error) {
+	size := c.sizer.size(c.Options)
+	msgs := make([]mq.Message, 0, size)
+	bytes := 0
+	if c.carry != nil {
+		msgs = append(msgs, *c.carry)
+		bytes += len(c.carry.Value)
+		c.carry = nil
+	} else {
+		msg, err := c.consumer.Fetch(ctx)
+		if err != nil {
+			return nil, closeByWait, err
+		}
+		msgs = append(msgs, msg)
+		bytes += len(msg.Value)
+	}
+
+	batchCtx, cancel := context.WithTimeout(ctx, c.Options.MaxWait)
+	defer cancel()
+	for len(msgs) < size {
+		if bytes >= c.Options.MaxBytes {
+			return msgs, closeByBytes, nil
+		}
+		msg, err := c.consumer.Fetch(batchCtx)
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			return msgs, closeByWait, nil
+		}
+		if err != nil {
+			return msgs, closeByWait, err
+		}
+		if bytes+len(msg.Value) > c.Options.MaxBytes {
+			c.carry = &msg
+			return msgs, closeByBytes, nil
+		}
+		msgs = append(msgs, msg)
+		bytes += len(msg.Value)
+	}
+	return msgs, closeByCount, nil
 }
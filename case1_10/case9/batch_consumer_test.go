@@ -19,28 +19,27 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/ecodeclub/ekit/randx"
-	kafkago "github.com/segmentio/kafka-go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"interview-cases/pkg/mq"
+	"interview-cases/pkg/mq/handler"
+	"interview-cases/test"
+	"net/http"
 	"testing"
 	"time"
 )
 
 type Case9TestSuite struct {
 	suite.Suite
-	brokers []string
-	topic   string
+	broker mq.Broker
+	topic  string
 }
 
 func (s *Case9TestSuite) SetupSuite() {
 	// 在这里启动了生产者
-	producer := &kafkago.Writer{
-		Addr:     kafkago.TCP(s.brokers...),
-		Topic:    s.topic,
-		Balancer: &kafkago.Hash{},
-	}
-
-	producer.AllowAutoTopicCreation = true
+	producer, err := s.broker.Producer()
+	require.NoError(s.T(), err)
 	go func() {
 		// 启动业务服务器
 		StartServer(":8080")
@@ -50,7 +49,7 @@ func (s *Case9TestSuite) SetupSuite() {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*300)
 	defer cancel()
 	now := time.Now().UnixMilli()
-	msgs := make([]kafkago.Message, 0, 100)
+	msgs := make([]mq.Message, 0, 100)
 	for i := 0; i < 100; i++ {
 		id := now + int64(i)
 		name, _ := randx.RandCode(8, randx.TypeMixed)
@@ -62,26 +61,28 @@ func (s *Case9TestSuite) SetupSuite() {
 			CreatedAt: now,
 		}
 		val, _ := json.Marshal(user)
-		msgs = append(msgs, kafkago.Message{
-			Key:   []byte(fmt.Sprintf("%d", id)),
-			Value: val,
+		msgs = append(msgs, mq.Message{
+			Topic:     s.topic,
+			Partition: mq.PartitionAny,
+			Key:       []byte(fmt.Sprintf("%d", id)),
+			Value:     val,
 		})
 	}
-	err := producer.WriteMessages(ctx, msgs...)
+	err = producer.Produce(ctx, msgs...)
 	assert.NoError(s.T(), err)
 }
 
 func (s *Case9TestSuite) TestBatchConsume() {
-	reader := kafkago.NewReader(kafkago.ReaderConfig{
-		Brokers: s.brokers,
-		Topic:   s.topic,
-		GroupID: "test_group",
-	})
+	reader, err := s.broker.Consumer("test_group", s.topic)
+	require.NoError(s.T(), err)
 	// 批次大小也会影响性能
 	const batchSize = 10
 	s.T().Log("开始消费")
 	// 这边你可以换成 SyncConsumer 试试挨个消费的效果
-	consumer := NewBatchConsumer(reader, batchSize)
+	// 业务逻辑是调用业务服务器的批量接口，你也可以换成 handler.NewGormHandler 直接批量插入数据库
+	biz := handler.WrapBatch(handler.NewHTTPBatchHandler(http.DefaultClient, "http://localhost:8080/batch"),
+		handler.Logging(nil), handler.Retry(handler.DefaultRetryOptions()), handler.Timeout(time.Second))
+	consumer := NewBatchConsumer(reader, batchSize, biz)
 	// 十秒之后就会退出消费。正常在生产环境是不会用 WithTimeout，而是 WithCancel
 	// 而后在应用退出的时候 cancel 掉
 	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
@@ -92,8 +93,8 @@ func (s *Case9TestSuite) TestBatchConsume() {
 
 func TestBatchConsumer(t *testing.T) {
 	suite.Run(t, &Case9TestSuite{
-		// 记得换你的 Kafka 地址
-		brokers: []string{"localhost:9092"},
-		topic:   "case9_user",
+		// 连不上 Kafka 的时候会使用进程内的消息队列
+		broker: test.InitMQ(),
+		topic:  "case9_user",
 	})
 }
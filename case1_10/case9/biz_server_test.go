@@ -4,9 +4,12 @@ import (
 	"encoding/json"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"interview-cases/pkg/mq/handler"
+	"interview-cases/pkg/overload"
 	"interview-cases/test"
 	"log/slog"
 	"net/http"
+	"time"
 )
 
 // StartServer 模拟业务服务器
@@ -14,9 +17,7 @@ func StartServer(addr string) {
 	r := gin.Default()
 	db := InitDb()
 
-	hdl := &BizHandler{
-		db: db,
-	}
+	hdl := NewBizHandler(db)
 	hdl.RegisterRouter(r)
 	r.Run(addr)
 }
@@ -24,11 +25,21 @@ func StartServer(addr string) {
 type BizHandler struct {
 	count int64
 	db    *gorm.DB
+	// shedder 数据库变慢或者请求太多的时候返回 429，而不是等数据库超时之后返回 500
+	shedder *overload.Shedder
+}
+
+func NewBizHandler(db *gorm.DB) *BizHandler {
+	return &BizHandler{db: db, shedder: overload.New(overload.DefaultOptions())}
 }
 
 func (t *BizHandler) RegisterRouter(server *gin.Engine) {
+	server.GET("/overload/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, t.shedder.Stats())
+	})
+	g := server.Group("", t.shedder.Middleware())
 	// 单个接口
-	server.POST("/single", func(c *gin.Context) {
+	g.POST("/single", func(c *gin.Context) {
 		var u UserCase9
 		// 拿到 UserCase9 的数据
 		if err := c.Bind(&u); err != nil {
@@ -37,7 +48,9 @@ func (t *BizHandler) RegisterRouter(server *gin.Engine) {
 			return
 		}
 		// 我们这里可以简单模拟一下，真实的业务场景不会那么简单
+		start := time.Now()
 		err := t.db.Create(&u).Error
+		t.shedder.Observe(time.Since(start))
 		if err != nil {
 			c.String(http.StatusInternalServerError, "系统错误")
 			slog.Error("系统错误", slog.Any("err", err))
@@ -47,7 +60,9 @@ func (t *BizHandler) RegisterRouter(server *gin.Engine) {
 		c.String(http.StatusOK, "OK")
 	})
 
-	server.POST("/batch", func(c *gin.Context) {
+	// 批量接口，每条消息单独校验，参数错误的跳过，全部成功返回 200，
+	// 有失败的返回 207 和每条消息的结果，消费者只需要处理失败的那几条
+	g.POST("/batch", func(c *gin.Context) {
 		var vals []string
 		if err := c.Bind(&vals); err != nil {
 			c.String(http.StatusBadRequest, "参数错误")
@@ -55,40 +70,41 @@ func (t *BizHandler) RegisterRouter(server *gin.Engine) {
 			return
 		}
 
+		results := make([]handler.ItemResult, len(vals))
 		users := make([]UserCase9, 0, len(vals))
-		for _, val := range vals {
+		for i, val := range vals {
 			var u UserCase9
 			err1 := json.Unmarshal([]byte(val), &u)
 			if err1 != nil {
-				c.String(http.StatusBadRequest, "参数错误")
+				results[i] = handler.ItemResult{Code: http.StatusBadRequest, Msg: "参数错误"}
 				slog.Error("参数错误",
 					slog.String("data", val),
 					slog.Any("err", err1))
-				return
+				continue
 			}
+			results[i] = handler.ItemResult{Code: http.StatusOK}
 			users = append(users, u)
 		}
 		// 一次性插入到数据库中。在实践中，批量插入远比单个插入性能要好
-		err := t.db.Create(&users).Error
-		if err != nil {
-			c.String(http.StatusInternalServerError, "系统错误")
-			slog.Error("系统错误", slog.Any("err", err))
-			return
+		if len(users) > 0 {
+			start := time.Now()
+			err := t.db.Create(&users).Error
+			t.shedder.Observe(time.Since(start))
+			if err != nil {
+				c.String(http.StatusInternalServerError, "系统错误")
+				slog.Error("系统错误", slog.Any("err", err))
+				return
+			}
 		}
 		slog.Info("处理成功", slog.Int("size", len(users)))
+		if len(users) < len(vals) {
+			c.JSON(http.StatusMultiStatus, handler.BatchResult{Results: results})
+			return
+		}
 		c.String(http.StatusOK, "OK")
 	})
 }
 
-type UserCase9 struct {
-	ID        int64 `gorm:"primaryKey;autoIncrement"`
-	Name      string
-	Email     string
-	Password  string
-	CreatedAt int64
-	UpdatedAt int64
-}
-
 func InitDb() *gorm.DB {
 	db := test.InitDB()
 	err := db.AutoMigrate(&UserCase9{})
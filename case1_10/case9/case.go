@@ -0,0 +1,13 @@
+package case9
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:       9,
+		Title:    "Kafka 消费者批量消费，调用批量接口",
+		Requires: []cases.Infra{cases.MySQL, cases.Kafka},
+		Package:  "./case1_10/case9",
+		Tests:    []string{"TestBatchConsumer"},
+	})
+}
@@ -0,0 +1,245 @@
+package case9
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/pkg/mq"
+	"interview-cases/pkg/mq/handler"
+	"interview-cases/pkg/mq/memory"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestBatchConsumer_Bisect 批量接口只会说整批参数错误，消费者把批次一分为二，
+// 找出 3 和 7 两条有问题的消息发到死信队列，其他的消息都只处理了一次，整批都提交了
+func TestBatchConsumer_Bisect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	env := initPoisonEnv(t, ctx, "case9_bisect", 10, 3, 7)
+	var handled []string
+	biz := handler.BatchHandlerFunc(func(ctx context.Context, msgs []mq.Message) error {
+		for _, msg := range msgs {
+			if strings.HasPrefix(string(msg.Key), "bad") {
+				return fmt.Errorf("%w: 参数错误", handler.ErrNonRetryable)
+			}
+		}
+		for _, msg := range msgs {
+			handled = append(handled, string(msg.Key))
+		}
+		return nil
+	})
+	consumer := env.newConsumer(biz)
+	require.NoError(t, consumer.batchConsume(ctx))
+
+	slices.Sort(handled)
+	assert.Equal(t, []string{"ok_0", "ok_1", "ok_2", "ok_4", "ok_5", "ok_6", "ok_8", "ok_9"}, handled)
+	assert.Equal(t, []string{"bad_3", "bad_7"}, env.deadLetters(t, ctx, 2))
+	env.assertCommitted(t, ctx)
+}
+
+// TestBatchConsumer_PartialResult 批量接口返回了每条消息的结果，不需要二分
+func TestBatchConsumer_PartialResult(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	env := initPoisonEnv(t, ctx, "case9_partial", 5, 1)
+	db := InitDb()
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	NewBizHandler(db).RegisterRouter(r)
+	server := httptest.NewServer(r)
+	defer server.Close()
+	metrics := handler.NewMetrics()
+	biz := handler.WrapBatch(handler.NewHTTPBatchHandler(server.Client(), server.URL+"/batch"), metrics.Middleware())
+	consumer := env.newConsumer(biz)
+	require.NoError(t, consumer.batchConsume(ctx))
+
+	assert.Equal(t, int64(1), metrics.Stats().Calls)
+	assert.Equal(t, []string{"bad_1"}, env.deadLetters(t, ctx, 1))
+	env.assertCommitted(t, ctx)
+	var cnt int64
+	err := db.Model(&UserCase9{}).Where("id IN ?", env.ids).Count(&cnt).Error
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), cnt)
+}
+
+// TestBatchConsumer_Unavailable 数据库超时的时候不提交，下一次只重试没有处理完的消息，
+// 死信队列发送失败的消息也一样
+func TestBatchConsumer_Unavailable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	env := initPoisonEnv(t, ctx, "case9_unavailable", 4, 2)
+	var calls [][]string
+	biz := handler.BatchHandlerFunc(func(ctx context.Context, msgs []mq.Message) error {
+		vals := make([]string, 0, len(msgs))
+		for _, msg := range msgs {
+			vals = append(vals, string(msg.Key))
+		}
+		calls = append(calls, vals)
+		if len(calls) == 1 {
+			return errors.New("数据库超时")
+		}
+		if len(calls) == 2 {
+			// ok_3 因为数据库超时失败了
+			return &handler.BatchError{Failed: map[int]error{
+				2: fmt.Errorf("%w: 参数错误", handler.ErrNonRetryable),
+				3: errors.New("数据库超时"),
+			}}
+		}
+		return &handler.BatchError{Failed: map[int]error{
+			0: fmt.Errorf("%w: 参数错误", handler.ErrNonRetryable),
+		}}
+	})
+	consumer := env.newConsumer(biz)
+	dlq := &flakyProducer{Producer: env.producer, fail: true}
+	consumer.DeadLetter = handler.NewDeadLetter(dlq, env.topic+"_dlq")
+	assert.Error(t, consumer.batchConsume(ctx))
+	assert.Error(t, consumer.batchConsume(ctx))
+	assert.Len(t, consumer.rest, 2)
+	dlq.setFail(false)
+	// bad_2 和 ok_3 一起重试，bad_2 再次被拒绝之后进死信队列
+	require.NoError(t, consumer.batchConsume(ctx))
+
+	assert.Equal(t, [][]string{
+		{"ok_0", "ok_1", "bad_2", "ok_3"},
+		{"ok_0", "ok_1", "bad_2", "ok_3"},
+		{"bad_2", "ok_3"},
+	}, calls)
+	assert.Equal(t, []string{"bad_2"}, env.deadLetters(t, ctx, 1))
+	env.assertCommitted(t, ctx)
+}
+
+// TestBatchConsumer_Overload 下游过载的时候不提交，批次减半，之后每次只重试一个批次那么多条
+func TestBatchConsumer_Overload(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	env := initPoisonEnv(t, ctx, "case9_overload", 8)
+	var sizes []int
+	biz := handler.BatchHandlerFunc(func(ctx context.Context, msgs []mq.Message) error {
+		sizes = append(sizes, len(msgs))
+		if len(sizes) == 1 {
+			return &handler.OverloadError{RetryAfter: time.Second, Err: errors.New("状态码 429")}
+		}
+		return nil
+	})
+	consumer := env.newConsumer(biz)
+	consumer.sizer = newBatchSizer(8)
+	err := consumer.batchConsume(ctx)
+	retryAfter, ok := handler.IsOverload(err)
+	require.True(t, ok)
+	assert.Equal(t, time.Second, retryAfter)
+	assert.Equal(t, 4, consumer.Stats().Size)
+	assert.Equal(t, int64(1), consumer.Stats().Overloads)
+	assert.Len(t, consumer.rest, 8)
+
+	require.NoError(t, consumer.batchConsume(ctx))
+	assert.Len(t, consumer.rest, 4)
+	require.NoError(t, consumer.batchConsume(ctx))
+	assert.Empty(t, consumer.rest)
+	assert.Equal(t, []int{8, 4, 4}, sizes)
+	assert.Empty(t, env.deadLetters(t, ctx, 0))
+	env.assertCommitted(t, ctx)
+}
+
+type poisonEnv struct {
+	broker   *memory.Broker
+	producer mq.Producer
+	topic    string
+	reader   mq.Consumer
+	// ids 正常消息里面的用户 id
+	ids []int64
+}
+
+// initPoisonEnv n 条消息，bad 里面的是格式不对的消息，Key 是 bad_i，
+// 正常消息的 Key 是 ok_i，Value 是 UserCase9 的 JSON
+func initPoisonEnv(t *testing.T, ctx context.Context, topic string, n int, bad ...int) *poisonEnv {
+	env := &poisonEnv{broker: memory.NewBroker(), topic: topic}
+	var err error
+	env.producer, err = env.broker.Producer()
+	require.NoError(t, err)
+	now := time.Now().UnixNano()
+	msgs := make([]mq.Message, 0, n)
+	for i := 0; i < n; i++ {
+		id := now + int64(i)
+		val, _ := json.Marshal(UserCase9{ID: id, Name: fmt.Sprintf("ok_%d", i)})
+		key := fmt.Sprintf("ok_%d", i)
+		if slices.Contains(bad, i) {
+			// 不是 JSON，/batch 接口会返回参数错误
+			key, val = fmt.Sprintf("bad_%d", i), []byte(fmt.Sprintf("bad_%d", i))
+		} else {
+			env.ids = append(env.ids, id)
+		}
+		msgs = append(msgs, mq.Message{Topic: topic, Key: []byte(key), Value: val})
+	}
+	require.NoError(t, env.producer.Produce(ctx, msgs...))
+	env.reader, err = env.broker.Consumer(topic+"_group", topic)
+	require.NoError(t, err)
+	return env
+}
+
+// newConsumer 一批就能拿到全部的消息
+func (e *poisonEnv) newConsumer(biz handler.BatchHandler) *BatchConsumer {
+	c := NewBatchConsumer(e.reader, 100, biz)
+	c.Options.MaxWait = 50 * time.Millisecond
+	c.DeadLetter = handler.NewDeadLetter(e.producer, e.topic+"_dlq")
+	return c
+}
+
+// deadLetters 读出 n 条死信消息的 Key
+func (e *poisonEnv) deadLetters(t *testing.T, ctx context.Context, n int) []string {
+	dlq, err := e.broker.Consumer(e.topic+"_dlq_group", e.topic+"_dlq")
+	require.NoError(t, err)
+	res := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		msg, err := dlq.Fetch(ctx)
+		require.NoError(t, err)
+		res = append(res, string(msg.Key))
+	}
+	fetchCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = dlq.Fetch(fetchCtx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	slices.Sort(res)
+	return res
+}
+
+// assertCommitted 同一个消费者组里面新的消费者拿不到任何消息，说明全部提交了
+func (e *poisonEnv) assertCommitted(t *testing.T, ctx context.Context) {
+	require.NoError(t, e.reader.Close())
+	reader, err := e.broker.Consumer(e.topic+"_group", e.topic)
+	require.NoError(t, err)
+	fetchCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = reader.Fetch(fetchCtx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+type flakyProducer struct {
+	mq.Producer
+	mu   sync.Mutex
+	fail bool
+}
+
+func (p *flakyProducer) Produce(ctx context.Context, msgs ...mq.Message) error {
+	p.mu.Lock()
+	fail := p.fail
+	p.mu.Unlock()
+	if fail {
+		return errors.New("死信队列不可用")
+	}
+	return p.Producer.Produce(ctx, msgs...)
+}
+
+func (p *flakyProducer) setFail(fail bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fail = fail
+}
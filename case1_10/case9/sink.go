@@ -0,0 +1,278 @@
+package case9
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"interview-cases/pkg/mq"
+	"log/slog"
+	"time"
+)
+
+type UserCase9 struct {
+	ID        int64 `gorm:"primaryKey;autoIncrement"`
+	Name      string
+	Email     string
+	Password  string
+	CreatedAt int64
+	UpdatedAt int64
+}
+
+// ConsumerOffset 消费者组在每个分区上下一条要处理的消息，和业务数据在同一个事务里面写入
+// partition 在 MySQL 里面是保留字，所以列名用 partition_id
+type ConsumerOffset struct {
+	GroupID    string `gorm:"primaryKey;type:varchar(128)"`
+	Topic      string `gorm:"primaryKey;type:varchar(128)"`
+	Partition  int32  `gorm:"primaryKey;column:partition_id"`
+	NextOffset int64
+	Utime      int64
+}
+
+func (ConsumerOffset) TableName() string {
+	return "consumer_offsets"
+}
+
+// UserSink 把消息批量插入到 UserCase9 里面，同一个事务里面更新 consumer_offsets，
+// 所以数据库里面的偏移量和插入的数据总是一致的，Kafka 上的提交只是顺带的：
+// 插入成功了但是还没有提交就崩溃的话，重新投递的消息会因为偏移量小于数据库里面的偏移量被跳过，不会重复插入
+//
+// 消费者支持 mq.Seeker 的时候，启动和每次重新分配分区之后都会从数据库里面恢复消费位置，
+// 不支持的时候只能依赖事务里面的过滤，重复的消息还是会拉下来，但是不会插入
+type UserSink struct {
+	consumer mq.Consumer
+	db       *gorm.DB
+	groupID  string
+
+	// BatchSize 一批最多多少条消息，MaxWait 拿到第一条消息之后最多等多久
+	BatchSize int
+	MaxWait   time.Duration
+	// RetryInterval 事务失败之后隔多久重新处理
+	RetryInterval time.Duration
+
+	// generation 上一次恢复消费位置的时候的分配，-1 代表还没有恢复过
+	generation int64
+	// pending 事务失败了的一批，下一次直接重试，不用重新拉取
+	pending []mq.Message
+}
+
+// NewUserSink groupID 要和创建 consumer 的时候用的消费者组一致，偏移量按照消费者组保存
+func NewUserSink(consumer mq.Consumer, db *gorm.DB, groupID string) *UserSink {
+	return &UserSink{
+		consumer:      consumer,
+		db:            db,
+		groupID:       groupID,
+		BatchSize:     100,
+		MaxWait:       time.Second,
+		RetryInterval: time.Second,
+		generation:    -1,
+	}
+}
+
+// Init 建表
+func (s *UserSink) Init() error {
+	return s.db.AutoMigrate(&UserCase9{}, &ConsumerOffset{})
+}
+
+// Consume 出错了不会退出，等 RetryInterval 之后重试
+func (s *UserSink) Consume(ctx context.Context) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		err := s.consume(ctx)
+		if err == nil {
+			continue
+		}
+		slog.Error("消费失败", slog.Any("err", err))
+		timer := time.NewTimer(s.RetryInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *UserSink) consume(ctx context.Context) error {
+	if len(s.pending) > 0 {
+		// 重试的时候可能已经重新分配了分区，别的消费者处理过的消息会在事务里面被过滤掉
+		return s.saveAndCommit(ctx, s.pending)
+	}
+	err := s.restore(ctx)
+	if err != nil {
+		return fmt.Errorf("恢复消费位置失败 %w", err)
+	}
+	msgs, err := s.fetchBatch(ctx)
+	if len(msgs) == 0 {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("获取消息失败 %w", err)
+	}
+	if err != nil {
+		slog.Error("获取消息失败，先处理已经拿到的消息", slog.Any("err", err))
+	}
+	if seeker, ok := s.consumer.(mq.Seeker); ok {
+		_, gen, err := seeker.Assignment()
+		if err != nil {
+			return fmt.Errorf("获取分区分配失败 %w", err)
+		}
+		if gen != s.generation {
+			// 拉取的过程中重新分配了分区，这一批里面可能有已经不属于自己的分区，丢掉之后重新恢复消费位置
+			slog.Info("分区重新分配，丢弃这一批消息", slog.Int("size", len(msgs)))
+			return nil
+		}
+	}
+	return s.saveAndCommit(ctx, msgs)
+}
+
+func (s *UserSink) saveAndCommit(ctx context.Context, msgs []mq.Message) error {
+	err := s.save(ctx, msgs)
+	if err != nil {
+		s.pending = msgs
+		return fmt.Errorf("保存消息失败，还有 %d 条没有处理完 %w", len(msgs), err)
+	}
+	s.pending = nil
+	// 偏移量已经在数据库里面了，Kafka 上提交失败也没有关系
+	err = s.consumer.Commit(ctx, msgs...)
+	if err != nil {
+		slog.Warn("提交消息失败，下一次会从数据库里面的偏移量开始", slog.Any("err", err))
+	}
+	return nil
+}
+
+// restore 分配的分区变了就把消费位置移动到数据库里面的偏移量，数据库里面没有的分区用 Kafka 上提交的偏移量
+func (s *UserSink) restore(ctx context.Context) error {
+	seeker, ok := s.consumer.(mq.Seeker)
+	if !ok {
+		return nil
+	}
+	tps, gen, err := seeker.Assignment()
+	if err != nil || gen == s.generation {
+		return err
+	}
+	if len(tps) > 0 {
+		offsets, err := s.loadOffsets(ctx, s.db, tps)
+		if err != nil {
+			return err
+		}
+		for tp, next := range offsets {
+			err = seeker.Seek(tp, next)
+			if err != nil {
+				return err
+			}
+		}
+		slog.Info("恢复消费位置", slog.Int("partitions", len(tps)), slog.Int("restored", len(offsets)))
+	}
+	s.generation = gen
+	return nil
+}
+
+// save 在一个事务里面插入数据和更新偏移量，偏移量小于数据库里面的偏移量的消息已经插入过了，直接跳过
+func (s *UserSink) save(ctx context.Context, msgs []mq.Message) error {
+	tps := make([]mq.TopicPartition, 0, 1)
+	seen := make(map[mq.TopicPartition]bool)
+	for _, msg := range msgs {
+		tp := msg.TopicPartition()
+		if !seen[tp] {
+			seen[tp] = true
+			tps = append(tps, tp)
+		}
+	}
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 锁住这几个分区的偏移量，同一个分区同时只有一个事务能够写入
+		offsets, err := s.loadOffsets(ctx, tx.Clauses(clause.Locking{Strength: "UPDATE"}), tps)
+		if err != nil {
+			return err
+		}
+		users := make([]UserCase9, 0, len(msgs))
+		nexts := make(map[mq.TopicPartition]int64, len(tps))
+		for _, msg := range msgs {
+			tp := msg.TopicPartition()
+			next, ok := offsets[tp]
+			if ok && msg.Offset < next {
+				continue
+			}
+			nexts[tp] = max(nexts[tp], msg.Offset+1)
+			var u UserCase9
+			err = json.Unmarshal(msg.Value, &u)
+			if err != nil {
+				// 格式不对的消息重试也没用，跳过，偏移量照样往前走
+				slog.Error("消息格式不对，跳过", slog.String("topic", msg.Topic), slog.Int("partition", int(msg.Partition)),
+					slog.Int64("offset", msg.Offset), slog.Any("err", err))
+				continue
+			}
+			users = append(users, u)
+		}
+		if len(nexts) == 0 {
+			slog.Info("整批都是重复的消息", slog.Int("size", len(msgs)))
+			return nil
+		}
+		if len(users) > 0 {
+			err = tx.Create(&users).Error
+			if err != nil {
+				return err
+			}
+		}
+		now := time.Now().UnixMilli()
+		rows := make([]ConsumerOffset, 0, len(nexts))
+		for tp, next := range nexts {
+			rows = append(rows, ConsumerOffset{
+				GroupID:    s.groupID,
+				Topic:      tp.Topic,
+				Partition:  tp.Partition,
+				NextOffset: next,
+				Utime:      now,
+			})
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "group_id"}, {Name: "topic"}, {Name: "partition_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"next_offset", "utime"}),
+		}).Create(&rows).Error
+	})
+}
+
+func (s *UserSink) loadOffsets(ctx context.Context, db *gorm.DB, tps []mq.TopicPartition) (map[mq.TopicPartition]int64, error) {
+	conds := make([][]any, 0, len(tps))
+	for _, tp := range tps {
+		conds = append(conds, []any{tp.Topic, tp.Partition})
+	}
+	var rows []ConsumerOffset
+	err := db.WithContext(ctx).Where("group_id = ? AND (topic, partition_id) IN ?", s.groupID, conds).
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[mq.TopicPartition]int64, len(rows))
+	for _, row := range rows {
+		res[mq.TopicPartition{Topic: row.Topic, Partition: row.Partition}] = row.NextOffset
+	}
+	return res, nil
+}
+
+// fetchBatch 第一条消息会一直等，拿到第一条之后最多再等 MaxWait
+func (s *UserSink) fetchBatch(ctx context.Context) ([]mq.Message, error) {
+	msg, err := s.consumer.Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]mq.Message, 0, s.BatchSize)
+	msgs = append(msgs, msg)
+	batchCtx, cancel := context.WithTimeout(ctx, s.MaxWait)
+	defer cancel()
+	for len(msgs) < s.BatchSize {
+		msg, err = s.consumer.Fetch(batchCtx)
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			return msgs, nil
+		}
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
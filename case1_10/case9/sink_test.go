@@ -0,0 +1,245 @@
+package case9
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"interview-cases/pkg/mq"
+	"interview-cases/pkg/mq/kafkago"
+	"interview-cases/pkg/mq/memory"
+	"interview-cases/test"
+	"testing"
+	"time"
+)
+
+// TestUserSink_Crash 数据写进数据库之后、提交到 Kafka 之前崩溃，
+// 重新启动的消费者会拿到同样的消息，但是不会重复插入
+func TestUserSink_Crash(t *testing.T) {
+	testCases := []struct {
+		name string
+		// seek 为 false 的时候消费者不支持 mq.Seeker，只能靠事务里面的过滤
+		seek bool
+	}{
+		{name: "恢复消费位置", seek: true},
+		{name: "过滤重复消息", seek: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			env := initSinkEnv(t, ctx, memory.NewBroker(), 2)
+			env.produce(t, ctx, 0, 10)
+			// 在消息中间插一条格式不对的，跳过之后偏移量照样往前走
+			require.NoError(t, env.producer.Produce(ctx, mq.Message{Topic: env.topic, Partition: 0, Value: []byte("bad")}))
+
+			sink := env.newSink(t, tc.seek, true)
+			require.NoError(t, sink.consume(ctx))
+			env.assertUsers(t, 0, 10)
+			env.assertOffsets(t, map[int32]int64{0: 6, 1: 5})
+			// 崩溃了，Kafka 上什么都没有提交
+			require.NoError(t, sink.consumer.Close())
+
+			sink = env.newSink(t, tc.seek, false)
+			env.produce(t, ctx, 10, 4)
+			// 不能 Seek 的时候重复的消息和新的消息在同一批里面，重复的被过滤掉
+			require.NoError(t, sink.consume(ctx))
+			env.assertUsers(t, 0, 14)
+			env.assertOffsets(t, map[int32]int64{0: 8, 1: 7})
+		})
+	}
+}
+
+// TestUserSink_Rebalance 两个消费者在同一个组里面，新的消费者加入之后
+// 两个消费者都回到 Kafka 上已提交的偏移量（这里一直提交失败，所以是 0），
+// 需要从数据库里面恢复消费位置
+func TestUserSink_Rebalance(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	env := initSinkEnv(t, ctx, memory.NewBroker(), 2)
+	env.produce(t, ctx, 0, 6)
+	sink1 := env.newSink(t, true, true)
+	require.NoError(t, sink1.consume(ctx))
+	env.assertUsers(t, 0, 6)
+
+	sink2 := env.newSink(t, true, true)
+	env.produce(t, ctx, 6, 4)
+	require.NoError(t, sink1.consume(ctx))
+	require.NoError(t, sink2.consume(ctx))
+	env.assertUsers(t, 0, 10)
+	env.assertOffsets(t, map[int32]int64{0: 5, 1: 5})
+}
+
+// TestUserSink_Retry 事务失败之后重试同一批，不需要重新拉取
+func TestUserSink_Retry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	env := initSinkEnv(t, ctx, memory.NewBroker(), 1)
+	env.produce(t, ctx, 0, 3)
+	sink := env.newSink(t, false, false)
+	broken := true
+	cb := "sink_test:fail"
+	require.NoError(t, env.db.Callback().Create().Before("gorm:create").Register(cb, func(db *gorm.DB) {
+		if broken && db.Statement.Table != "consumer_offsets" {
+			_ = db.AddError(errors.New("数据库超时"))
+		}
+	}))
+	defer func() {
+		_ = env.db.Callback().Create().Remove(cb)
+	}()
+	assert.Error(t, sink.consume(ctx))
+	assert.Len(t, sink.pending, 3)
+	env.assertUsers(t, 0, 0)
+	env.assertOffsets(t, map[int32]int64{})
+
+	broken = false
+	require.NoError(t, sink.consume(ctx))
+	assert.Empty(t, sink.pending)
+	env.assertUsers(t, 0, 3)
+	env.assertOffsets(t, map[int32]int64{0: 3})
+}
+
+// TestUserSink_Kafka 用真实的 Kafka 验证重新启动之后从数据库里面的偏移量开始拉取，
+// 已经插入过的消息不会再拉下来
+func TestUserSink_Kafka(t *testing.T) {
+	brokers := test.RequireKafka(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	broker := kafkago.NewBroker(brokers...)
+	defer broker.Close()
+	env := initSinkEnv(t, ctx, broker, 2)
+	env.produce(t, ctx, 0, 10)
+	sink := env.newSink(t, true, true)
+	env.consumeUntil(t, ctx, sink, 10)
+	// 崩溃了，Kafka 上什么都没有提交
+	require.NoError(t, sink.consumer.Close())
+
+	sink = env.newSink(t, true, false)
+	defer sink.consumer.Close()
+	seeker := sink.consumer.(mq.Seeker)
+	// 等加入消费者组，分配之前的消息在恢复消费位置之后会被丢弃，不算在里面
+	require.Eventually(t, func() bool {
+		tps, _, err := seeker.Assignment()
+		return err == nil && len(tps) == 2
+	}, 30*time.Second, 100*time.Millisecond)
+	counter := &countConsumer{Consumer: sink.consumer, Seeker: seeker}
+	sink.consumer = counter
+	env.produce(t, ctx, 10, 4)
+	env.consumeUntil(t, ctx, sink, 14)
+	env.assertUsers(t, 0, 14)
+	assert.Equal(t, 4, counter.fetched)
+}
+
+type sinkEnv struct {
+	broker   mq.Broker
+	producer mq.Producer
+	db       *gorm.DB
+	topic    string
+	// base 这一次测试的用户名前缀，共享的数据库里面用来区分不同的测试
+	base string
+}
+
+func initSinkEnv(t *testing.T, ctx context.Context, broker mq.Broker, partitions int) *sinkEnv {
+	base := fmt.Sprintf("sink_%d", time.Now().UnixNano())
+	env := &sinkEnv{broker: broker, db: InitDb(), topic: base, base: base}
+	require.NoError(t, env.broker.CreateTopic(ctx, env.topic, partitions))
+	var err error
+	env.producer, err = env.broker.Producer()
+	require.NoError(t, err)
+	return env
+}
+
+// produce 用户名是 base_i，i 从 start 开始，轮流发到每个分区
+func (e *sinkEnv) produce(t *testing.T, ctx context.Context, start, n int) {
+	msgs := make([]mq.Message, 0, n)
+	for i := start; i < start+n; i++ {
+		val, _ := json.Marshal(UserCase9{Name: fmt.Sprintf("%s_%d", e.base, i)})
+		msgs = append(msgs, mq.Message{Topic: e.topic, Partition: mq.PartitionAny, Value: val})
+	}
+	require.NoError(t, e.producer.Produce(ctx, msgs...))
+}
+
+func (e *sinkEnv) newSink(t *testing.T, seek bool, failCommit bool) *UserSink {
+	consumer, err := e.broker.Consumer(e.topic+"_group", e.topic)
+	require.NoError(t, err)
+	if failCommit {
+		consumer = &crashConsumer{Consumer: consumer, Seeker: consumer.(mq.Seeker)}
+	}
+	if !seek {
+		consumer = &noSeekConsumer{Consumer: consumer}
+	}
+	sink := NewUserSink(consumer, e.db, e.topic+"_group")
+	sink.MaxWait = 50 * time.Millisecond
+	require.NoError(t, sink.Init())
+	return sink
+}
+
+// consumeUntil 一直消费到数据库里面有 n 个用户，Kafka 上加入消费者组和拉取消息都要一点时间
+func (e *sinkEnv) consumeUntil(t *testing.T, ctx context.Context, sink *UserSink, n int64) {
+	for {
+		var cnt int64
+		err := e.db.Model(&UserCase9{}).Where("name LIKE ?", e.base+"_%").Count(&cnt).Error
+		require.NoError(t, err)
+		if cnt >= n {
+			return
+		}
+		require.NoError(t, sink.consume(ctx))
+		require.NoError(t, ctx.Err())
+	}
+}
+
+// assertUsers 数据库里面刚好有 base_start 到 base_{start+n-1} 这些用户，每个只有一条
+func (e *sinkEnv) assertUsers(t *testing.T, start, n int) {
+	var names []string
+	err := e.db.Model(&UserCase9{}).Where("name LIKE ?", e.base+"_%").Order("id").Pluck("name", &names).Error
+	require.NoError(t, err)
+	want := make([]string, 0, n)
+	for i := start; i < start+n; i++ {
+		want = append(want, fmt.Sprintf("%s_%d", e.base, i))
+	}
+	assert.ElementsMatch(t, want, names)
+}
+
+func (e *sinkEnv) assertOffsets(t *testing.T, want map[int32]int64) {
+	var rows []ConsumerOffset
+	err := e.db.Where("group_id = ?", e.topic+"_group").Find(&rows).Error
+	require.NoError(t, err)
+	got := make(map[int32]int64, len(rows))
+	for _, row := range rows {
+		got[row.Partition] = row.NextOffset
+	}
+	assert.Equal(t, want, got)
+}
+
+// crashConsumer 提交总是失败，模拟写完数据库之后、提交之前崩溃
+type crashConsumer struct {
+	mq.Consumer
+	mq.Seeker
+}
+
+func (c *crashConsumer) Commit(ctx context.Context, msgs ...mq.Message) error {
+	return errors.New("崩溃了")
+}
+
+// noSeekConsumer 隐藏 mq.Seeker，模拟不能移动消费位置的消费者
+type noSeekConsumer struct {
+	mq.Consumer
+}
+
+// countConsumer 统计拉取了多少条消息
+type countConsumer struct {
+	mq.Consumer
+	mq.Seeker
+	fetched int
+}
+
+func (c *countConsumer) Fetch(ctx context.Context) (mq.Message, error) {
+	msg, err := c.Consumer.Fetch(ctx)
+	if err == nil {
+		c.fetched++
+	}
+	return msg, err
+}
@@ -0,0 +1,57 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package case1_10
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+	"interview-cases/pkg/export"
+	"interview-cases/test"
+	"testing"
+)
+
+// ExportTestSuite 导出整张 orders 表，不用 LIMIT offset, n 一页一页地翻，
+// 而是按照主键切成范围并发导出，每个范围里面都是 id > ? 的顺序扫描
+type ExportTestSuite struct {
+	suite.Suite
+	db *gorm.DB
+}
+
+func (s *ExportTestSuite) SetupSuite() {
+	s.db = test.InitDB()
+	err := prepareOrders(s.db, 10)
+	require.NoError(s.T(), err)
+}
+
+func (s *ExportTestSuite) TestExport() {
+	opts := export.DefaultOptions()
+	opts.Dir, opts.Gzip = s.T().TempDir(), true
+	e, err := export.New(s.db, Order{}.TableName(), opts)
+	require.NoError(s.T(), err)
+	res, err := e.Run(context.Background())
+	require.NoError(s.T(), err)
+	s.T().Logf("导出了 %d 行，%d 个文件，checksum %s", res.Rows, len(res.Files), res.Checksum)
+
+	var count int64
+	err = s.db.Model(&Order{}).Count(&count).Error
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), count, res.Rows)
+}
+
+func TestExport(t *testing.T) {
+	suite.Run(t, new(ExportTestSuite))
+}
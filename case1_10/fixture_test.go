@@ -0,0 +1,84 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package case1_10
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"interview-cases/pkg/fixture"
+	"interview-cases/test"
+	"math/rand/v2"
+)
+
+// orderRows medium 档位下 orders 的行数，可以用 fixture.size 调整
+const orderRows = 100000
+
+// orderExtra 模拟别的字段，占据了空间
+const orderExtra = `
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+`
+
+// prepareOrders 清空 orders，重新生成数据，买家 ID 是行号对 buyers 取余
+// Ctime 是随机的，所以 ctime 上的索引和买家 ID 没有任何关系
+func prepareOrders(db *gorm.DB, buyers int) error {
+	ctx := context.Background()
+	opts := test.FixtureOptions()
+	err := fixture.Reset(ctx, db, &Order{})
+	if err != nil {
+		return err
+	}
+	base := fixture.BaseTime.UnixMilli()
+	return fixture.Load(ctx, db, opts, "orders", opts.Size.Rows(orderRows), func(r *rand.Rand, idx int) Order {
+		return Order{
+			SN:    fmt.Sprintf("sn_%d", idx),
+			Buyer: int64(idx % buyers),
+			Extra: orderExtra,
+			// 一年之内的随机时间
+			Ctime: base + r.Int64N(365*24*3600*1000),
+			Utime: base,
+		}
+	})
+}
+
+// prepareOrderV1s 和 prepareOrders 一样，只不过 Ctime 是递增的
+func prepareOrderV1s(db *gorm.DB, buyers int) error {
+	ctx := context.Background()
+	opts := test.FixtureOptions()
+	err := fixture.Reset(ctx, db, &OrderV1{})
+	if err != nil {
+		return err
+	}
+	base := fixture.BaseTime.UnixMilli()
+	return fixture.Load(ctx, db, opts, "orders_v1", opts.Size.Rows(orderRows), func(r *rand.Rand, idx int) OrderV1 {
+		return OrderV1{
+			SN:    fmt.Sprintf("sn_%d", idx),
+			Buyer: int64(idx % buyers),
+			Extra: orderExtra,
+			Ctime: base + int64(idx),
+			Utime: base + int64(idx),
+		}
+	})
+}
@@ -0,0 +1,26 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package case1_10
+
+import (
+	"interview-cases/test"
+	"os"
+	"testing"
+)
+
+// TestMain 配置了 slow_log.dir 的时候，测试结束之后会输出慢查询报告
+func TestMain(m *testing.M) {
+	os.Exit(test.RunMain(m))
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package case1_10
+
+// Order 和 OrderV1 是 case4、case6、case7 共用的订单表，
+// case4 目录下的订单列表服务也是基于 OrderV1 的
+type Order struct {
+	Id int64
+	// SN 是订单编号，就是正常你在订单列表看到的那一长串字符串
+	SN string
+
+	// 买家 ID，我们同样是在买家 ID 上创建一个索引
+	Buyer int64 `gorm:"index"`
+
+	// 模拟别的字段，占据了空间
+	Extra string
+
+	// 毫秒时间戳
+	// 假设说我们现在的主要业务场景是根据买家 ID 查询订单，然后按照下单时间倒序排序
+	// Ctime 上创建了一个索引，独立的索引
+	Ctime int64 `gorm:"index"`
+	Utime int64
+}
+
+func (o Order) TableName() string {
+	return "orders"
+}
+
+type OrderV1 struct {
+	Id int64
+	// SN 是订单编号，就是正常你在订单列表看到的那一长串字符串
+	SN string
+
+	// 模拟别的字段，占据了空间
+	Extra string
+
+	// 买家 ID
+	// 我们在 Buyer 和 Ctime 上创建一个联合索引
+	// 并且我们的索引列的顺序是（buyer，ctime）
+	Buyer int64 `gorm:"index:buyer_ctime"`
+	Ctime int64 `gorm:"index:buyer_ctime"`
+
+	Utime int64
+}
+
+func (o OrderV1) TableName() string {
+	return "orders_v1"
+}
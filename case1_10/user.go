@@ -0,0 +1,54 @@
+// Copyright 2023 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package case1_10
+
+// UserBefore 改造前
+// case1 目录下的在线表结构变更演示了怎么在不停机的情况下把 users_before 改成 UserAfter 的结构
+type UserBefore struct {
+	ID       uint   `gorm:"primaryKey"`
+	Username string `gorm:"unique;type:varchar(255)"`
+	// PasswordHash 不要保存明文密码，用 pkg/credential 计算哈希值
+	PasswordHash string `gorm:"type:varchar(255)"`
+	Age          int
+	// 头像
+	Avatar string `gorm:"type:varchar(255)"`
+
+	// 其它字段，一般来说，额外字段越多，改进的效果就越好
+
+	// 时间戳
+	Utime int64
+	Ctime int64
+}
+
+func (UserBefore) TableName() string {
+	return "users_before"
+}
+
+// UserAfter 改造后
+type UserAfter struct {
+	ID uint `gorm:"primaryKey"`
+	// 正常这需要唯一索引确保不冲突，也需要联合索引来加速查询
+	// 登录的时候只需要查 id、username 和 password_hash，都在 name_pwd 索引上，不需要回表
+	Username     string `gorm:"index:name_pwd;type:varchar(255)"`
+	PasswordHash string `gorm:"index:name_pwd;type:varchar(255)"`
+	Age          int
+	Avatar       string `gorm:"type:varchar(255)"`
+	Utime        int64
+	Ctime        int64
+}
+
+func (UserAfter) TableName() string {
+	return "users_after"
+}
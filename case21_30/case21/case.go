@@ -0,0 +1,13 @@
+package case21
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:       21,
+		Title:    "基于 Redis 的排行榜 TopN",
+		Requires: []cases.Infra{cases.Redis},
+		Package:  "./case21_30/case21",
+		Tests:    []string{"TestTopN"},
+	})
+}
@@ -0,0 +1,13 @@
+package case22
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:       22,
+		Title:    "本地缓存加 Redis 的排行榜",
+		Requires: []cases.Infra{cases.MySQL, cases.Redis},
+		Package:  "./case21_30/case22",
+		Tests:    []string{"TestTopN"},
+	})
+}
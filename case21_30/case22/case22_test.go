@@ -2,6 +2,7 @@ package case22
 
 import (
 	"context"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -186,6 +187,13 @@ func (t *TestSuite) initServer() {
 
 func (t *TestSuite) initArticleSvc() {
 	db := test.InitDB()
+	// 表是 .scripts/mysql/init.sql 建的，嵌入式数据库里面没有，
+	// 而且 SQLite 的 ON CONFLICT 要求 article_id 上有唯一索引
+	for _, tab := range []string{"article_static_tab0", "article_static_tab1"} {
+		err := db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id BIGINT PRIMARY KEY,"+
+			"article_id INTEGER NOT NULL UNIQUE,like_cnt INTEGER NOT NULL DEFAULT 0)", tab)).Error
+		require.NoError(t.T(), err)
+	}
 	articleDao := dao.NewArticleStaticDAO(db)
 	articleSvc := cronjob.NewArticleSvc(articleDao)
 	t.activitySvc = articleSvc
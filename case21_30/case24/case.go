@@ -0,0 +1,13 @@
+package case24
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:       24,
+		Title:    "Redis 崩溃之后降级到本地缓存",
+		Requires: []cases.Infra{cases.MySQL, cases.Redis},
+		Package:  "./case21_30/case24",
+		Tests:    []string{"TestDelayMsg"},
+	})
+}
@@ -46,7 +46,7 @@ func (o *orderDao) Save(ctx context.Context, order Order) error {
 			"price",
 			"utime",
 		}),
-	}).Create(order).Error
+	}).Create(&order).Error
 }
 
 func (o *orderDao) Get(ctx context.Context, id int64) (Order, error) {
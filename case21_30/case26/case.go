@@ -0,0 +1,13 @@
+package case26
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:       26,
+		Title:    "用布隆过滤器抢优惠券",
+		Requires: []cases.Infra{cases.RedisBloom},
+		Package:  "./case21_30/case26",
+		Tests:    []string{"TestPreempt"},
+	})
+}
@@ -0,0 +1,12 @@
+package case27
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:      27,
+		Title:   "读写分离的负载均衡",
+		Package: "./case21_30/case27",
+		Tests:   []string{"TestRWBalancer_Pick"},
+	})
+}
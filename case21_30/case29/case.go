@@ -0,0 +1,14 @@
+package case29
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:       29,
+		Title:    "MySQL 间隙锁导致的死锁",
+		Requires: []cases.Infra{cases.MySQLOnly},
+		Package:  "./case21_30/case29",
+		Tests:    []string{"TestCase29_DeadLock", "TestCase29_RepairDeadLock"},
+		Doc:      "case21_30/case29/README.md",
+	})
+}
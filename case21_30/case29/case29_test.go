@@ -6,7 +6,8 @@ import (
 )
 
 func TestCase29_DeadLock(t *testing.T) {
-	db := test.InitDB()
+	// 死锁是 InnoDB 的间隙锁导致的
+	db := test.RequireDB(t, test.CapGapLock)
 	InitDB(db)
 	membershipDao := NewMembershipDao(db)
 	// 触发死锁
@@ -14,7 +15,7 @@ func TestCase29_DeadLock(t *testing.T) {
 }
 
 func TestCase29_RepairDeadLock(t *testing.T) {
-	db := test.InitDB()
+	db := test.RequireDB(t, test.CapGapLock)
 	InitDB(db)
 	membershipDao := NewMembershipDao(db)
 	_ = membershipDao.RepairDeadLock()
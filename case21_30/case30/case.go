@@ -0,0 +1,14 @@
+package case30
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:       30,
+		Title:    "扣积分的时候 next-key lock 阻塞插入",
+		Requires: []cases.Infra{cases.MySQLOnly},
+		Package:  "./case21_30/case30",
+		Tests:    []string{"TestCase30"},
+		Doc:      "case21_30/case30/case30.md",
+	})
+}
@@ -28,7 +28,8 @@ type TestSuite struct {
 }
 
 func (t *TestSuite) SetupSuite() {
-	t.db = test.InitDB()
+	// 案例演示的是 InnoDB 的 next-key lock，别的数据库没有这个行为
+	t.db = test.RequireDB(t.T(), test.CapGapLock)
 	// 借助 GORM 来初始化表结构
 	err := t.db.AutoMigrate(&User{})
 	require.NoError(t.T(), err)
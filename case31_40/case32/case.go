@@ -0,0 +1,14 @@
+package case32
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:      32,
+		Title:   "根据服务状态调整的限流",
+		Package: "./case31_40/case32",
+		Tests:   []string{"TestCase32"},
+		Doc:     "case31_40/case32/case32.md",
+		Server:  true,
+	})
+}
@@ -0,0 +1,13 @@
+package case33
+
+import "interview-cases/pkg/cases"
+
+func init() {
+	cases.Register(cases.Case{
+		ID:       33,
+		Title:    "关联查询先聚合再 JOIN",
+		Requires: []cases.Infra{cases.MySQL},
+		Package:  "./case31_40/case33",
+		Tests:    []string{"TestCase33"},
+	})
+}
@@ -1,34 +1,47 @@
 package case33
 
 import (
+	"context"
+	"fmt"
 	"gorm.io/gorm"
-	"math/rand"
-	"time"
+	"interview-cases/pkg/fixture"
+	"math/rand/v2"
+	"strconv"
 )
 
-// 初始化用户和订单数据
-func InitializeData(db *gorm.DB) error {
-	rand.Seed(time.Now().UnixNano())
+// userRows medium 档位下的用户数
+const userRows = 20000
 
-	var users []User
-	for i := 1; i <= 20000; i++ {
-		user := User{Name: "User" + string(i)}
-		if err := db.Create(&user).Error; err != nil {
-			return err // 如果不使用事务，则直接返回错误
-		}
-		users = append(users, user)
-
-		// 每个用户有3到6个订单
-		orderCount := rand.Intn(4) + 3 // 生成3到6之间的随机数
-		for j := 0; j < orderCount; j++ {
-			order := Order{UserID: user.ID, TotalAmount: rand.Intn(100)} // 随机生成TotalAmount
-			if err := db.Create(&order).Error; err != nil {
-				return err // 如果不使用事务，则直接返回错误
+// InitializeData 清空用户和订单，按照 opts 生成用户，每个用户有 3 到 6 个订单
+func InitializeData(db *gorm.DB, opts fixture.Options) error {
+	ctx := context.Background()
+	err := fixture.Reset(ctx, db, &User{}, &Order{})
+	if err != nil {
+		return err
+	}
+	n := opts.Size.Rows(userRows)
+	// 主键是自己指定的，因为 LOAD DATA 不会回填自增主键
+	err = fixture.Load(ctx, db, opts, "case33_users", n, func(r *rand.Rand, i int) User {
+		return User{ID: i + 1, Name: "User" + strconv.Itoa(i+1)}
+	})
+	if err != nil {
+		return err
+	}
+	r := opts.Rand("case33_orders")
+	return fixture.Write(ctx, db, opts, func(w *fixture.Writer[Order]) error {
+		for uid := 1; uid <= n; uid++ {
+			// 生成3到6之间的随机数
+			orderCount := r.IntN(4) + 3
+			for j := 0; j < orderCount; j++ {
+				// 随机生成TotalAmount
+				err := w.Add(Order{UserID: uid, TotalAmount: r.IntN(100)})
+				if err != nil {
+					return err
+				}
 			}
 		}
-	}
-
-	return nil
+		return nil
+	})
 }
 
 func GetUserTotalsV1(db *gorm.DB) ([]UserTotal, error) {
@@ -48,17 +61,21 @@ func GetUserTotalsV1(db *gorm.DB) ([]UserTotal, error) {
 	return results, nil
 }
 
-func CreateUserIdx(db *gorm.DB) {
-	err := db.Exec("CREATE INDEX IF NOT EXISTS idx_user_id  ON orders(user_id)").Error
+// CreateUserIdx 在 orders.user_id 上创建索引，已经存在的话什么也不做
+// MySQL 不支持 CREATE INDEX IF NOT EXISTS，所以先检查一下
+func CreateUserIdx(db *gorm.DB) error {
+	if db.Migrator().HasIndex(&Order{}, "idx_user_id") {
+		return nil
+	}
+	err := db.Exec("CREATE INDEX idx_user_id ON orders(user_id)").Error
 	if err != nil {
-		// 处理错误
+		return fmt.Errorf("创建索引 idx_user_id 失败 %w", err)
 	}
+	return nil
 }
 
-func GetUserTotalsV2(db *gorm.DB) ([]UserTotal, error) {
-	var results []UserTotal
-
-	query := `
+// userTotalsV2SQL 先在子查询里面按照 user_id 聚合，再和 users JOIN
+const userTotalsV2SQL = `
         SELECT u.id AS user_id, u.name, COALESCE(o.total_amount, 0) AS total_amount
         FROM users u
         LEFT JOIN (
@@ -68,8 +85,11 @@ func GetUserTotalsV2(db *gorm.DB) ([]UserTotal, error) {
         ) o ON u.id = o.user_id
     `
 
+func GetUserTotalsV2(db *gorm.DB) ([]UserTotal, error) {
+	var results []UserTotal
+
 	// 使用Raw方法执行自定义SQL，并扫描到results
-	err := db.Raw(query).Scan(&results).Error
+	err := db.Raw(userTotalsV2SQL).Scan(&results).Error
 
 	if err != nil {
 		return nil, err
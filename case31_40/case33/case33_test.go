@@ -1 +1,91 @@
 package case33
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm"
+	"interview-cases/pkg/bench"
+	"interview-cases/pkg/explain"
+	"interview-cases/test"
+	"testing"
+)
+
+type TestSuite struct {
+	suite.Suite
+	db *gorm.DB
+}
+
+func (s *TestSuite) SetupSuite() {
+	s.db = test.InitDB()
+	err := InitializeData(s.db, test.FixtureOptions())
+	require.NoError(s.T(), err)
+}
+
+func (s *TestSuite) TestGetUserTotals() {
+	v1, err := GetUserTotalsV1(s.db)
+	require.NoError(s.T(), err)
+	err = CreateUserIdx(s.db)
+	require.NoError(s.T(), err)
+	// 重复创建也不会报错
+	err = CreateUserIdx(s.db)
+	require.NoError(s.T(), err)
+	v2, err := GetUserTotalsV2(s.db)
+	require.NoError(s.T(), err)
+	// 两种写法的结果是一样的
+	assert.ElementsMatch(s.T(), v1, v2)
+
+	test.RunBench(s.T(), "case33 GetUserTotalsV1 vs V2", bench.DefaultOptions(),
+		map[string]string{"db": test.DialectOf(s.db).Name, "users": "20000"},
+		// 先 JOIN 再聚合
+		bench.Variant{Name: "GetUserTotalsV1", Run: func(ctx context.Context) error {
+			_, err := GetUserTotalsV1(s.db.WithContext(ctx))
+			return err
+		}},
+		// 先聚合再 JOIN
+		bench.Variant{Name: "GetUserTotalsV2", Run: func(ctx context.Context) error {
+			_, err := GetUserTotalsV2(s.db.WithContext(ctx))
+			return err
+		}},
+	)
+	s.checkPlan()
+}
+
+// checkPlan 确认 V2 是先在派生表里面聚合的，而 V1 没有派生表
+func (s *TestSuite) checkPlan() {
+	if !test.Supports(s.T(), s.db, test.CapExplain) {
+		return
+	}
+	v1, err := explain.Explain(s.db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Model(&User{}).
+			Select("users.id AS user_id, users.name, COALESCE(SUM(orders.total_amount), 0) AS total_amount").
+			Joins("LEFT JOIN orders ON users.id = orders.user_id").
+			Group("users.id").
+			Find(&[]UserTotal{})
+	})
+	require.NoError(s.T(), err)
+	s.T().Log(v1)
+	v2, err := explain.ExplainSQL(s.db, userTotalsV2SQL)
+	require.NoError(s.T(), err)
+	s.T().Log(v2)
+	assert.False(s.T(), hasDerived(v1))
+	assert.True(s.T(), hasDerived(v2))
+	// orders 那一部分应该用上 user_id 的索引
+	row, ok := v2.Table("orders")
+	require.True(s.T(), ok)
+	assert.Equal(s.T(), "idx_user_id", row.Key)
+}
+
+func hasDerived(p explain.Plan) bool {
+	for _, r := range p.Rows {
+		if r.SelectType == "DERIVED" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCase33(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"interview-cases/pkg/cases"
+	"interview-cases/pkg/slowlog"
+	"interview-cases/test"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func list(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\t标题\t依赖")
+	for _, c := range cases.List() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Name(), c.Title, requires(c))
+	}
+	return tw.Flush()
+}
+
+func describe(w io.Writer, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	cs, err := lookup(args)
+	if err != nil {
+		return err
+	}
+	c := cs[0]
+	fmt.Fprintf(w, "%s %s\n", c.Name(), c.Title)
+	fmt.Fprintf(w, "  依赖: %s\n", requires(c))
+	fmt.Fprintf(w, "  入口: go test -v -run '%s' %s\n", c.RunPattern(), c.Package)
+	if c.Doc != "" {
+		fmt.Fprintf(w, "  文档: %s\n", c.Doc)
+	}
+	if c.Server {
+		fmt.Fprintln(w, "  注意: 入口会启动服务器一直运行，需要按 Ctrl+C 停止")
+	}
+	return nil
+}
+
+func requires(c cases.Case) string {
+	if len(c.Requires) == 0 {
+		return "无"
+	}
+	names := make([]string, 0, len(c.Requires))
+	for _, r := range c.Requires {
+		names = append(names, string(r))
+	}
+	return strings.Join(names, ", ")
+}
+
+var errPrerequisite = errors.New("前置条件不满足")
+
+func check(w io.Writer, args []string) error {
+	cs := cases.List()
+	if len(args) > 0 {
+		var err error
+		cs, err = lookup(args)
+		if err != nil {
+			return err
+		}
+	}
+	p := newProber()
+	var failed []string
+	for _, c := range cs {
+		if !checkCase(w, p, c) {
+			failed = append(failed, c.Name())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %s", errPrerequisite, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// checkCase 输出每一项依赖的检查结果，全部满足才返回 true
+func checkCase(w io.Writer, p *prober, c cases.Case) bool {
+	fmt.Fprintf(w, "%s %s\n", c.Name(), c.Title)
+	if len(c.Requires) == 0 {
+		fmt.Fprintln(w, "  [ok] 不依赖任何基础设施")
+		return true
+	}
+	ok := true
+	for _, r := range c.Requires {
+		res := p.probe(r)
+		mark := "ok"
+		if !res.ok {
+			mark = "失败"
+			ok = false
+		}
+		fmt.Fprintf(w, "  [%s] %s: %s\n", mark, r, res.msg)
+	}
+	return ok
+}
+
+type probeResult struct {
+	ok  bool
+	msg string
+}
+
+// prober 同一个基础设施只检查一次
+type prober struct {
+	results map[cases.Infra]probeResult
+}
+
+func newProber() *prober {
+	return &prober{results: map[cases.Infra]probeResult{}}
+}
+
+func (p *prober) probe(infra cases.Infra) probeResult {
+	if res, ok := p.results[infra]; ok {
+		return res
+	}
+	res := doProbe(infra)
+	p.results[infra] = res
+	return res
+}
+
+func doProbe(infra cases.Infra) probeResult {
+	mode := test.InitConfig().MySQL.Mode
+	switch infra {
+	case cases.MySQL, cases.MySQLOnly:
+		if mode == test.DBModeEmbedded {
+			if infra == cases.MySQLOnly {
+				return probeResult{msg: "配置了只使用嵌入式数据库，案例会被跳过"}
+			}
+			return probeResult{ok: true, msg: "使用嵌入式数据库"}
+		}
+		err := test.PingMySQL()
+		switch {
+		case err == nil:
+			return probeResult{ok: true, msg: "可用"}
+		case infra == cases.MySQL && mode == test.DBModeAuto:
+			return probeResult{ok: true, msg: fmt.Sprintf("连不上（%v），会使用嵌入式数据库", err)}
+		default:
+			return probeResult{msg: fmt.Sprintf("连不上（%v）", err)}
+		}
+	case cases.Redis:
+		return probeFallback(test.PingRedis())
+	case cases.RedisBloom:
+		return probeFallback(test.PingRedisBloom())
+	case cases.Kafka:
+		return probeFallback(test.PingKafka())
+	default:
+		return probeResult{msg: "未知的依赖"}
+	}
+}
+
+// probeFallback Redis 和 Kafka 连不上都会退化成进程内的实现，所以不算失败
+func probeFallback(err error) probeResult {
+	if err != nil {
+		return probeResult{ok: true, msg: fmt.Sprintf("连不上（%v），会使用进程内的实现", err)}
+	}
+	return probeResult{ok: true, msg: "可用"}
+}
+
+func run(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	timeout := fs.Duration("timeout", 30*time.Minute, "单个案例的超时时间")
+	slowLogDir := fs.String("slowlog", "", "把慢查询报告写到这个目录，运行结束之后输出汇总")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() == 0 {
+		return errUsage
+	}
+	var env []string
+	start := time.Now()
+	if *slowLogDir != "" {
+		dir, err := filepath.Abs(*slowLogDir)
+		if err != nil {
+			return err
+		}
+		*slowLogDir = dir
+		env = append(env, test.EnvSlowLogDir+"="+dir)
+	}
+	cs, err := lookup(fs.Args())
+	if err != nil {
+		return err
+	}
+	root, err := test.ModuleRoot()
+	if err != nil {
+		return err
+	}
+	p := newProber()
+	for _, c := range cs {
+		if !checkCase(os.Stdout, p, c) {
+			return fmt.Errorf("%s %w", c.Name(), errPrerequisite)
+		}
+		err = runCase(ctx, root, c, *timeout, env)
+		if err != nil {
+			return err
+		}
+	}
+	if *slowLogDir != "" {
+		return printSlowLog(os.Stdout, *slowLogDir, slowlog.SortByTotal, 20, start)
+	}
+	return nil
+}
+
+// slowLog 汇总多个测试包生成的慢查询报告，不指定目录就用配置里面的 slow_log.dir
+func slowLog(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("slowlog", flag.ContinueOnError)
+	by := fs.String("sort", string(slowlog.SortByTotal), "排序方式，total、p99、count 或者 slow")
+	top := fs.Int("top", 20, "只输出前几个指纹，0 表示全部")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 1 {
+		return errUsage
+	}
+	dir := fs.Arg(0)
+	if dir == "" {
+		var err error
+		dir, err = test.SlowLogDir()
+		if err != nil {
+			return err
+		}
+		if dir == "" {
+			return fmt.Errorf("%w: 没有指定目录，也没有配置 slow_log.dir", errUsage)
+		}
+	}
+	return printSlowLog(w, dir, slowlog.SortBy(*by), *top, time.Time{})
+}
+
+// printSlowLog 只汇总 since 之后生成的报告，避免混进之前运行留下来的文件
+func printSlowLog(w io.Writer, dir string, by slowlog.SortBy, top int, since time.Time) error {
+	reports, err := slowlog.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	res := reports[:0]
+	for _, r := range reports {
+		if !r.CreatedAt.Before(since) {
+			res = append(res, r)
+		}
+	}
+	if len(res) == 0 {
+		return fmt.Errorf("%s 下面没有慢查询报告", dir)
+	}
+	r := slowlog.Merge("慢查询", res...)
+	r.Sort(by)
+	fmt.Fprint(w, r.Top(top).Markdown())
+	return nil
+}
+
+// tour 新人按照顺序把所有案例走一遍
+// 前置条件不满足和需要手动停止的案例会被跳过，最后输出汇总
+func tour(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("tour", flag.ContinueOnError)
+	from := fs.String("from", "1", "从哪个案例开始")
+	keepGoing := fs.Bool("keep-going", false, "案例失败之后继续运行后面的案例")
+	timeout := fs.Duration("timeout", 30*time.Minute, "单个案例的超时时间")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		return errUsage
+	}
+	start, err := lookup([]string{*from})
+	if err != nil {
+		return err
+	}
+	root, err := test.ModuleRoot()
+	if err != nil {
+		return err
+	}
+	p := newProber()
+	var passed, skipped, failed []string
+	all := cases.List()
+	for i, c := range all {
+		if c.ID < start[0].ID {
+			continue
+		}
+		fmt.Printf("\n==> [%d/%d] ", i+1, len(all))
+		if err = describe(os.Stdout, []string{c.Name()}); err != nil {
+			return err
+		}
+		if c.Server {
+			fmt.Printf("跳过，需要手动运行 cases run %d\n", c.ID)
+			skipped = append(skipped, c.Name())
+			continue
+		}
+		if !checkCase(os.Stdout, p, c) {
+			fmt.Println("跳过，前置条件不满足")
+			skipped = append(skipped, c.Name())
+			continue
+		}
+		err = runCase(ctx, root, c, *timeout, nil)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			failed = append(failed, c.Name())
+			if !*keepGoing {
+				fmt.Printf("%s 失败，修复之后可以用 cases tour -from %d 继续\n", c.Name(), c.ID)
+				return err
+			}
+			continue
+		}
+		passed = append(passed, c.Name())
+	}
+	fmt.Printf("\n通过: %v\n跳过: %v\n失败: %v\n", passed, skipped, failed)
+	if len(failed) > 0 {
+		return fmt.Errorf("%d 个案例失败", len(failed))
+	}
+	return nil
+}
+
+// runCase env 是额外的环境变量，例如 KEY=VALUE
+func runCase(ctx context.Context, root string, c cases.Case, timeout time.Duration, env []string) error {
+	cmd := exec.CommandContext(ctx, "go", "test", "-v", "-count=1",
+		"-timeout", timeout.String(), "-run", c.RunPattern(), c.Package)
+	cmd.Dir = root
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	fmt.Println("$", strings.Join(cmd.Args, " "))
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("运行 %s 失败 %w", c.Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// cases 按照 id 查看、检查和运行案例
+//
+//	go run ./cmd/cases list
+//	go run ./cmd/cases describe 8
+//	go run ./cmd/cases check 8
+//	go run ./cmd/cases run 8
+//	go run ./cmd/cases tour -from 1
+//	go run ./cmd/cases run -slowlog slowlog 2 4 6 7
+//	go run ./cmd/cases slowlog -sort p99 slowlog
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"interview-cases/pkg/cases"
+	"os"
+	"os/signal"
+)
+
+const usage = `用法: cases <命令> [参数]
+
+命令:
+  list                     列出所有案例
+  describe <id>            查看案例的详细信息
+  check [id...]            检查案例依赖的基础设施，不指定 id 就检查所有案例
+  run [-timeout 30m] [-slowlog dir] <id>
+                           运行案例，多个 id 会依次运行，-slowlog 会在最后输出慢查询汇总
+  tour [-from id]          从 -from 开始按顺序检查并运行所有案例
+  slowlog [-sort total] [-top 20] [dir]
+                           汇总 dir 下面的慢查询报告，默认是配置里面的 slow_log.dir
+
+id 可以写成 8 或者 case8
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	args := flag.Args()[1:]
+	var err error
+	switch flag.Arg(0) {
+	case "list":
+		err = list(os.Stdout)
+	case "describe":
+		err = describe(os.Stdout, args)
+	case "check":
+		err = check(os.Stdout, args)
+	case "run":
+		err = run(ctx, args)
+	case "tour":
+		err = tour(ctx, args)
+	case "slowlog":
+		err = slowLog(os.Stdout, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if errors.Is(err, errUsage) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+var errUsage = errors.New("参数错误，运行 cases -h 查看用法")
+
+// lookup 把 id 转换成案例，有一个找不到就返回错误
+func lookup(ids []string) ([]cases.Case, error) {
+	res := make([]cases.Case, 0, len(ids))
+	for _, id := range ids {
+		c, ok := cases.Get(id)
+		if !ok {
+			return nil, fmt.Errorf("没有案例 %s，运行 cases list 查看所有案例", id)
+		}
+		res = append(res, c)
+	}
+	return res, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"interview-cases/pkg/cases"
+	"interview-cases/test"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRegistry 确保注册的入口真的存在，避免改了测试名字之后 cases run 什么都不跑
+func TestRegistry(t *testing.T) {
+	root, err := test.ModuleRoot()
+	require.NoError(t, err)
+	all := cases.List()
+	require.NotEmpty(t, all)
+	for _, c := range all {
+		t.Run(c.Name(), func(t *testing.T) {
+			assert.NotEmpty(t, c.Title)
+			tests := testFuncs(t, filepath.Join(root, c.Package))
+			for _, name := range c.Tests {
+				assert.Contains(t, tests, name)
+			}
+			if c.Doc != "" {
+				_, err := os.Stat(filepath.Join(root, c.Doc))
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// testFuncs 找出目录下面所有的顶层测试，dir 以 ... 结尾的时候包括子目录
+func testFuncs(t *testing.T, dir string) map[string]struct{} {
+	recursive := strings.HasSuffix(dir, "...")
+	dir = strings.TrimSuffix(dir, "...")
+	res := map[string]struct{}{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != filepath.Clean(dir) && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return err
+		}
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if ok && fn.Recv == nil && strings.HasPrefix(fn.Name.Name, "Test") {
+				res[fn.Name.Name] = struct{}{}
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	return res
+}
@@ -0,0 +1,27 @@
+package main
+
+// 每个案例在自己的包里面注册，这里只需要引入
+import (
+	_ "interview-cases/case11_20/case11"
+	_ "interview-cases/case11_20/case12"
+	_ "interview-cases/case11_20/case13"
+	_ "interview-cases/case11_20/case14"
+	_ "interview-cases/case11_20/case15"
+	_ "interview-cases/case11_20/case16"
+	_ "interview-cases/case11_20/case17"
+	_ "interview-cases/case11_20/case18"
+	_ "interview-cases/case11_20/case20"
+	_ "interview-cases/case1_10"
+	_ "interview-cases/case1_10/case2"
+	_ "interview-cases/case1_10/case8"
+	_ "interview-cases/case1_10/case9"
+	_ "interview-cases/case21_30/case21"
+	_ "interview-cases/case21_30/case22"
+	_ "interview-cases/case21_30/case24"
+	_ "interview-cases/case21_30/case26"
+	_ "interview-cases/case21_30/case27"
+	_ "interview-cases/case21_30/case29"
+	_ "interview-cases/case21_30/case30"
+	_ "interview-cases/case31_40/case32"
+	_ "interview-cases/case31_40/case33"
+)
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"interview-cases/pkg/mq"
+	"interview-cases/pkg/mq/handler"
+	"interview-cases/pkg/mq/offset"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func reset(ctx context.Context, w io.Writer, broker mq.Broker, args []string) error {
+	fs := flag.NewFlagSet("reset", flag.ContinueOnError)
+	group := fs.String("group", "test_group", "消费者组")
+	topic := fs.String("topic", "case8_user", "topic")
+	partitions := fs.String("partitions", "", "逗号分隔的分区，不指定就是所有分区")
+	to := fs.String("to", "", "earliest、latest、偏移量或者 RFC3339 格式的时间")
+	execute := fs.Bool("execute", false, "真正修改偏移量，不加的时候只输出变化")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 || *to == "" {
+		return errUsage
+	}
+	target, err := offset.ParseTarget(*to)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+	admin, tps, err := prepare(ctx, broker, *topic, *partitions)
+	if err != nil {
+		return err
+	}
+	changes, err := offset.Plan(ctx, admin, *group, tps, target)
+	if err != nil {
+		return err
+	}
+	printChanges(w, *group, changes)
+	if !*execute {
+		fmt.Fprintln(w, "dry run，没有修改任何偏移量，确认无误之后加上 -execute")
+		return nil
+	}
+	err = offset.Apply(ctx, admin, *group, changes)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "已经把 %s 在 %d 个分区上的偏移量重置到 %s\n", *group, len(changes), target)
+	return nil
+}
+
+func printChanges(w io.Writer, group string, changes []offset.Change) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "消费者组 %s\n", group)
+	fmt.Fprintln(tw, "分区\t最早\t最新\t当前\t目标\t积压\t重置之后的积压\t变化")
+	var before, after int64
+	for _, c := range changes {
+		current := "-"
+		if c.Current >= 0 {
+			current = strconv.FormatInt(c.Current, 10)
+		}
+		fmt.Fprintf(tw, "%s/%d\t%d\t%d\t%s\t%d\t%d\t%d\t%+d\n", c.Topic, c.Partition, c.Low, c.High,
+			current, c.Target, c.LagBefore(), c.LagAfter(), c.LagAfter()-c.LagBefore())
+		before += c.LagBefore()
+		after += c.LagAfter()
+	}
+	fmt.Fprintf(tw, "合计\t\t\t\t\t%d\t%d\t%+d\n", before, after, after-before)
+	_ = tw.Flush()
+}
+
+func replay(ctx context.Context, w io.Writer, broker mq.Broker, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	topic := fs.String("topic", "case8_user", "topic")
+	partitions := fs.String("partitions", "", "逗号分隔的分区，不指定就是所有分区")
+	from := fs.String("from", "", "从哪里开始，包括这一条")
+	to := fs.String("to", "latest", "到哪里结束，不包括这一条")
+	name := fs.String("handler", "print", "print 输出到标准输出，http 调用 -url")
+	url := fs.String("url", "http://localhost:8080/handle", "-handler 是 http 的时候调用的接口")
+	dryRun := fs.Bool("dry-run", false, "只输出每个分区要重放的范围")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 || *from == "" {
+		return errUsage
+	}
+	start, err := offset.ParseTarget(*from)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+	end, err := offset.ParseTarget(*to)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+	h, err := newHandler(w, *name, *url)
+	if err != nil {
+		return err
+	}
+	admin, tps, err := prepare(ctx, broker, *topic, *partitions)
+	if err != nil {
+		return err
+	}
+	ranges, err := offset.Ranges(ctx, admin, tps, start, end)
+	if err != nil {
+		return err
+	}
+	var total int64
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "分区\t开始\t结束\t消息数")
+	for _, r := range ranges {
+		fmt.Fprintf(tw, "%s/%d\t%d\t%d\t%d\n", r.Topic, r.Partition, r.Start, r.End, r.Len())
+		total += r.Len()
+	}
+	_ = tw.Flush()
+	if *dryRun {
+		fmt.Fprintf(w, "dry run，一共 %d 条消息\n", total)
+		return nil
+	}
+	cnt, err := offset.Replay(ctx, admin, ranges, h)
+	fmt.Fprintf(w, "重放了 %d/%d 条消息\n", cnt, total)
+	return err
+}
+
+// newHandler http 的时候和 case8 的消费者一样，失败了会重试几次
+func newHandler(w io.Writer, name, url string) (handler.Handler, error) {
+	switch name {
+	case "print":
+		return handler.HandlerFunc(func(ctx context.Context, msg mq.Message) error {
+			_, err := fmt.Fprintf(w, "%s/%d@%d %s %s %s\n", msg.Topic, msg.Partition, msg.Offset,
+				msg.Timestamp.Format(time.RFC3339), msg.Key, msg.Value)
+			return err
+		}), nil
+	case "http":
+		return handler.Wrap(handler.NewHTTPHandler(http.DefaultClient, url),
+			handler.Logging(nil), handler.Retry(handler.DefaultRetryOptions()), handler.Timeout(time.Second)), nil
+	default:
+		return nil, fmt.Errorf("%w: 不支持的 handler %s", errUsage, name)
+	}
+}
+
+func prepare(ctx context.Context, broker mq.Broker, topic, partitions string) (mq.Admin, []mq.TopicPartition, error) {
+	admin, err := offset.AdminOf(broker)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids, err := parsePartitions(partitions)
+	if err != nil {
+		return nil, nil, err
+	}
+	tps, err := offset.TopicPartitions(ctx, broker, topic, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	return admin, tps, nil
+}
+
+// parsePartitions 例如 0,2,3
+func parsePartitions(s string) ([]int32, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ",")
+	res := make([]int32, 0, len(parts))
+	for _, p := range parts {
+		id, err := strconv.ParseInt(strings.TrimSpace(p), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: 分区 %q 不是数字", errUsage, p)
+		}
+		res = append(res, int32(id))
+	}
+	return res, nil
+}
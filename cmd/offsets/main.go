@@ -0,0 +1,68 @@
+// offsets 重置消费者组的偏移量，或者把一段消息重新交给某个 Handler 处理
+//
+//	go run ./cmd/offsets reset -to 2024-05-01T10:00:00+08:00
+//	go run ./cmd/offsets reset -partitions 0,2 -to earliest -execute
+//	go run ./cmd/offsets replay -from 2024-05-01T10:00:00+08:00 -to 2024-05-01T11:00:00+08:00 -handler http
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"interview-cases/pkg/mq/kafkago"
+	"interview-cases/test"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+const usage = `用法: offsets [-brokers 地址] <命令> [参数]
+
+命令:
+  reset [-group test_group] [-topic case8_user] [-partitions 0,1] -to <目标> [-execute]
+                           重置消费者组的偏移量，不加 -execute 只输出每个分区的变化和积压
+  replay [-topic case8_user] [-partitions 0,1] -from <目标> [-to latest] [-handler print] [-url 地址] [-dry-run]
+                           把 [-from, -to) 之间的消息交给 Handler 处理，不会修改消费者组的偏移量
+
+目标可以是 earliest、latest、偏移量或者 RFC3339 格式的时间，例如 2024-05-01T10:00:00+08:00
+不指定 -partitions 就是所有分区
+重置之前要先停掉消费者组里面的所有消费者
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	brokers := flag.String("brokers", strings.Join(test.InitConfig().Kafka.Brokers, ","), "Kafka 的地址，多个地址用逗号分隔")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	broker := kafkago.NewBroker(strings.Split(*brokers, ",")...)
+	defer broker.Close()
+	args := flag.Args()[1:]
+	var err error
+	switch flag.Arg(0) {
+	case "reset":
+		err = reset(ctx, os.Stdout, broker, args)
+	case "replay":
+		err = replay(ctx, os.Stdout, broker, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if errors.Is(err, errUsage) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+var errUsage = errors.New("参数错误，运行 offsets -h 查看用法")
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/pkg/mq"
+	"interview-cases/pkg/mq/kafkago"
+	"interview-cases/pkg/mq/memory"
+	"interview-cases/pkg/mq/offset"
+	"interview-cases/test"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReset(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	b := initBroker(t, ctx)
+	tp := mq.TopicPartition{Topic: "case8_user", Partition: 1}
+
+	var out bytes.Buffer
+	require.NoError(t, reset(ctx, &out, b, []string{"-partitions", "1", "-to", "2"}))
+	assert.Contains(t, out.String(), "dry run")
+	// 分区 1 提交到了 4，积压 1 条，重置到 2 之后积压 3 条
+	assert.Regexp(t, `case8_user/1\s+0\s+5\s+4\s+2\s+1\s+3\s+\+2`, out.String())
+	committed, err := b.Committed(ctx, "test_group", tp)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), committed[tp])
+
+	out.Reset()
+	require.NoError(t, reset(ctx, &out, b, []string{"-partitions", "1", "-to", "2", "-execute"}))
+	committed, err = b.Committed(ctx, "test_group", tp)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), committed[tp])
+
+	assert.ErrorIs(t, reset(ctx, &out, b, []string{"-partitions", "x", "-to", "2"}), errUsage)
+	assert.ErrorIs(t, reset(ctx, &out, b, []string{"-to", "yesterday"}), errUsage)
+	assert.ErrorIs(t, reset(ctx, &out, b, nil), errUsage)
+}
+
+func TestReplay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	b := initBroker(t, ctx)
+
+	var out bytes.Buffer
+	require.NoError(t, replay(ctx, &out, b, []string{"-partitions", "0", "-from", "1", "-to", "3"}))
+	var replayed []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, "case8_user/0@") {
+			replayed = append(replayed, line[strings.LastIndex(line, " ")+1:])
+		}
+	}
+	assert.Equal(t, []string{"0_1", "0_2"}, replayed)
+	assert.Contains(t, out.String(), "重放了 2/2 条消息")
+	// 重放不会提交
+	committed, err := b.Committed(ctx, "test_group", mq.TopicPartition{Topic: "case8_user", Partition: 0})
+	require.NoError(t, err)
+	assert.Empty(t, committed)
+}
+
+// TestKafka 命令行用的是 kafkago，在真实的 Kafka 上重置和重放一遍，连不上 Kafka 的时候跳过
+func TestKafka(t *testing.T) {
+	brokers := test.RequireKafka(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	b := kafkago.NewBroker(brokers...)
+	defer b.Close()
+	topic := fmt.Sprintf("test_offsets_cmd_%d", time.Now().UnixNano())
+	group := topic + "_group"
+	initTopic(t, ctx, b, topic, group)
+	tp := mq.TopicPartition{Topic: topic, Partition: 1}
+
+	var out bytes.Buffer
+	require.NoError(t, reset(ctx, &out, b, []string{"-group", group, "-topic", topic, "-partitions", "1", "-to", "2", "-execute"}))
+	assert.Regexp(t, topic+`/1\s+0\s+5\s+4\s+2\s+1\s+3\s+\+2`, out.String())
+	committed, err := b.Committed(ctx, group, tp)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), committed[tp])
+
+	out.Reset()
+	require.NoError(t, replay(ctx, &out, b, []string{"-topic", topic, "-partitions", "0", "-from", "1", "-to", "3"}))
+	assert.Contains(t, out.String(), "重放了 2/2 条消息")
+}
+
+// initBroker 两个分区，每个分区 5 条消息，test_group 在分区 1 上提交到了 4
+func initBroker(t *testing.T, ctx context.Context) *memory.Broker {
+	b := memory.NewBroker()
+	initTopic(t, ctx, b, "case8_user", "test_group")
+	return b
+}
+
+// initTopic 在 b 上准备 initBroker 描述的数据
+func initTopic(t *testing.T, ctx context.Context, b mq.Broker, topic, group string) {
+	require.NoError(t, b.CreateTopic(ctx, topic, 2))
+	p, err := b.Producer()
+	require.NoError(t, err)
+	defer p.Close()
+	for partition := int32(0); partition < 2; partition++ {
+		for i := 0; i < 5; i++ {
+			require.NoError(t, p.Produce(ctx, mq.Message{
+				Topic:     topic,
+				Partition: partition,
+				Value:     []byte(fmt.Sprintf("%d_%d", partition, i)),
+			}))
+		}
+	}
+	admin, err := offset.AdminOf(b)
+	require.NoError(t, err)
+	require.NoError(t, admin.CommitOffsets(ctx, group, map[mq.TopicPartition]int64{{Topic: topic, Partition: 1}: 4}))
+}
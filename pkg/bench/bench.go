@@ -0,0 +1,154 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"interview-cases/pkg/stats"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Variant 对比里面的一个方案，例如优化前或者优化后的查询
+type Variant struct {
+	Name string
+	// Run 执行一次，返回 error 会中止整个对比
+	Run func(ctx context.Context) error
+}
+
+type Options struct {
+	// Warmup 正式计时之前每个方案先跑几次，让缓存、连接池之类的热起来
+	Warmup int `json:"warmup"`
+	// Repetitions 每个方案计时的次数
+	Repetitions int `json:"repetitions"`
+}
+
+// DefaultOptions 数据库查询之类的慢操作用这个就够了，很快的操作可以调大 Repetitions
+func DefaultOptions() Options {
+	return Options{
+		Warmup:      1,
+		Repetitions: 10,
+	}
+}
+
+// Result 一个方案的统计结果，时间的单位都是毫秒
+type Result struct {
+	Name      string    `json:"name"`
+	Runs      int       `json:"runs"`
+	MinMs     float64   `json:"min_ms"`
+	MeanMs    float64   `json:"mean_ms"`
+	P50Ms     float64   `json:"p50_ms"`
+	P90Ms     float64   `json:"p90_ms"`
+	P99Ms     float64   `json:"p99_ms"`
+	MaxMs     float64   `json:"max_ms"`
+	SamplesMs []float64 `json:"samples_ms"`
+}
+
+// Env 运行的环境，用来区分不同机器、不同提交的报告
+type Env struct {
+	GoVersion string `json:"go_version"`
+	GOOS      string `json:"goos"`
+	GOARCH    string `json:"goarch"`
+	NumCPU    int    `json:"num_cpu"`
+	Hostname  string `json:"hostname"`
+	// Commit 拿不到的时候为空
+	Commit string `json:"commit"`
+}
+
+type Report struct {
+	Name string `json:"name"`
+	// Labels 额外的信息，例如数据库类型、数据量
+	Labels    map[string]string `json:"labels,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	Env       Env               `json:"env"`
+	Options   Options           `json:"options"`
+	// Results 第一个是基线，Markdown 报告里面的倍数都是相对它计算的
+	Results []Result `json:"results"`
+}
+
+// Compare 依次运行所有方案，第一个方案是基线
+// 计时的时候每一轮把所有方案都跑一次，而不是一个方案跑完再跑下一个，
+// 这样机器负载的波动对每个方案的影响差不多
+func Compare(ctx context.Context, name string, opts Options, variants ...Variant) (Report, error) {
+	if len(variants) == 0 {
+		return Report{}, errors.New("bench: 至少需要一个方案")
+	}
+	if opts.Repetitions <= 0 {
+		return Report{}, fmt.Errorf("bench: 重复次数必须大于 0，现在是 %d", opts.Repetitions)
+	}
+	for i := 0; i < opts.Warmup; i++ {
+		for _, v := range variants {
+			if err := v.Run(ctx); err != nil {
+				return Report{}, fmt.Errorf("bench: 预热 %s 失败 %w", v.Name, err)
+			}
+		}
+	}
+	samples := make([][]time.Duration, len(variants))
+	for i := 0; i < opts.Repetitions; i++ {
+		for j, v := range variants {
+			start := time.Now()
+			err := v.Run(ctx)
+			duration := time.Since(start)
+			if err != nil {
+				return Report{}, fmt.Errorf("bench: 运行 %s 失败 %w", v.Name, err)
+			}
+			samples[j] = append(samples[j], duration)
+		}
+	}
+	results := make([]Result, 0, len(variants))
+	for i, v := range variants {
+		results = append(results, summarize(v.Name, samples[i]))
+	}
+	return Report{
+		Name:      name,
+		CreatedAt: time.Now(),
+		Env:       currentEnv(),
+		Options:   opts,
+		Results:   results,
+	}, nil
+}
+
+func summarize(name string, samples []time.Duration) Result {
+	ms := make([]float64, 0, len(samples))
+	var sum float64
+	for _, s := range samples {
+		v := stats.Ms(s)
+		ms = append(ms, v)
+		sum += v
+	}
+	sorted := make([]float64, len(ms))
+	copy(sorted, ms)
+	sort.Float64s(sorted)
+	return Result{
+		Name:      name,
+		Runs:      len(ms),
+		MinMs:     sorted[0],
+		MeanMs:    stats.Round(sum / float64(len(ms))),
+		P50Ms:     stats.Percentile(sorted, 50),
+		P90Ms:     stats.Percentile(sorted, 90),
+		P99Ms:     stats.Percentile(sorted, 99),
+		MaxMs:     sorted[len(sorted)-1],
+		SamplesMs: ms,
+	}
+}
+
+func currentEnv() Env {
+	hostname, _ := os.Hostname()
+	env := Env{
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		NumCPU:    runtime.NumCPU(),
+		Hostname:  hostname,
+	}
+	// 不在 git 仓库里面或者没有安装 git 就算了
+	out, err := exec.Command("git", "rev-parse", "--short", "HEAD").Output()
+	if err == nil {
+		env.Commit = strings.TrimSpace(string(out))
+	}
+	return env
+}
@@ -0,0 +1,74 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompare(t *testing.T) {
+	var fastCnt, slowCnt int
+	report, err := Compare(context.Background(), "case0 slow vs fast", Options{Warmup: 2, Repetitions: 5},
+		Variant{Name: "slow", Run: func(ctx context.Context) error {
+			slowCnt++
+			time.Sleep(4 * time.Millisecond)
+			return nil
+		}},
+		Variant{Name: "fast", Run: func(ctx context.Context) error {
+			fastCnt++
+			return nil
+		}},
+	)
+	require.NoError(t, err)
+	// 预热的次数不计入结果
+	assert.Equal(t, 7, slowCnt)
+	assert.Equal(t, 7, fastCnt)
+	require.Len(t, report.Results, 2)
+	slow, fast := report.Results[0], report.Results[1]
+	assert.Equal(t, 5, slow.Runs)
+	assert.Len(t, slow.SamplesMs, 5)
+	assert.GreaterOrEqual(t, slow.MinMs, 4.0)
+	assert.LessOrEqual(t, slow.MinMs, slow.P50Ms)
+	assert.LessOrEqual(t, slow.P50Ms, slow.P90Ms)
+	assert.LessOrEqual(t, slow.P99Ms, slow.MaxMs)
+	assert.Less(t, fast.P50Ms, slow.P50Ms)
+
+	md := report.Markdown()
+	assert.Contains(t, md, "## case0 slow vs fast")
+	assert.Contains(t, md, "| slow | 5 |")
+	assert.Contains(t, md, "1.00x")
+
+	dir := t.TempDir()
+	jsonPath, mdPath, err := report.WriteFiles(dir)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "case0_slow_vs_fast.json"), jsonPath)
+	data, err := os.ReadFile(jsonPath)
+	require.NoError(t, err)
+	var decoded Report
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, report.Results, decoded.Results)
+	data, err = os.ReadFile(mdPath)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "## case0"))
+}
+
+func TestCompareError(t *testing.T) {
+	_, err := Compare(context.Background(), "err", DefaultOptions(), Variant{
+		Name: "err",
+		Run: func(ctx context.Context) error {
+			return errors.New("mock error")
+		},
+	})
+	assert.Error(t, err)
+	_, err = Compare(context.Background(), "empty", DefaultOptions())
+	assert.Error(t, err)
+	_, err = Compare(context.Background(), "zero", Options{}, Variant{Name: "noop"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,86 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func (r Report) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// Markdown 对比表格，倍数是方案的 P50 除以基线的 P50，小于 1 说明比基线快
+func (r Report) Markdown() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "## %s\n\n", r.Name)
+	fmt.Fprintf(&sb, "- 时间: %s\n", r.CreatedAt.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&sb, "- 环境: %s %s/%s, %d CPU, %s\n",
+		r.Env.GoVersion, r.Env.GOOS, r.Env.GOARCH, r.Env.NumCPU, r.Env.Hostname)
+	if r.Env.Commit != "" {
+		fmt.Fprintf(&sb, "- 提交: %s\n", r.Env.Commit)
+	}
+	fmt.Fprintf(&sb, "- 预热 %d 次，计时 %d 次\n", r.Options.Warmup, r.Options.Repetitions)
+	keys := make([]string, 0, len(r.Labels))
+	for k := range r.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&sb, "- %s: %s\n", k, r.Labels[k])
+	}
+	sb.WriteString("\n| 方案 | 次数 | 平均 (ms) | P50 (ms) | P90 (ms) | P99 (ms) | 最大 (ms) | P50 相对基线 |\n")
+	sb.WriteString("| --- | ---: | ---: | ---: | ---: | ---: | ---: | ---: |\n")
+	for _, res := range r.Results {
+		fmt.Fprintf(&sb, "| %s | %d | %.3f | %.3f | %.3f | %.3f | %.3f | %s |\n",
+			res.Name, res.Runs, res.MeanMs, res.P50Ms, res.P90Ms, res.P99Ms, res.MaxMs, r.ratio(res))
+	}
+	return sb.String()
+}
+
+func (r Report) ratio(res Result) string {
+	base := r.Results[0].P50Ms
+	if base == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2fx", res.P50Ms/base)
+}
+
+// WriteFiles 在 dir 下面生成 <文件名>.json 和 <文件名>.md，返回两个文件的路径
+// 文件名由报告名字转换而来，同名的报告会被覆盖
+func (r Report) WriteFiles(dir string) (string, string, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return "", "", err
+	}
+	data, err := r.JSON()
+	if err != nil {
+		return "", "", err
+	}
+	base := filepath.Join(dir, fileName(r.Name))
+	jsonPath, mdPath := base+".json", base+".md"
+	err = os.WriteFile(jsonPath, data, 0o644)
+	if err != nil {
+		return "", "", err
+	}
+	err = os.WriteFile(mdPath, []byte(r.Markdown()), 0o644)
+	if err != nil {
+		return "", "", err
+	}
+	return jsonPath, mdPath, nil
+}
+
+// fileName 只保留字母、数字、下划线和中划线，其余的都换成下划线
+func fileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
@@ -0,0 +1,98 @@
+package cases
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Infra 案例依赖的基础设施
+type Infra string
+
+const (
+	// MySQL 连不上的时候可以用嵌入式数据库代替
+	MySQL Infra = "MySQL"
+	// MySQLOnly 必须是真的 MySQL，例如依赖 InnoDB 的锁或者跨库查询，嵌入式数据库上案例会被跳过
+	MySQLOnly  Infra = "MySQL(不能用嵌入式数据库代替)"
+	Redis      Infra = "Redis"
+	RedisBloom Infra = "RedisBloom"
+	Kafka      Infra = "Kafka"
+)
+
+// Case 一个案例的元数据
+type Case struct {
+	// ID 就是 README 里面说的案例 id，比如 8 对应 case8
+	ID    int
+	Title string
+	// Requires 运行案例需要的基础设施
+	Requires []Infra
+	// Package 案例所在的包，相对于仓库根目录，例如 ./case1_10/case8
+	Package string
+	// Tests 案例的入口，也就是传给 go test -run 的测试名字，可以有多个
+	Tests []string
+	// Doc 案例的说明文档，相对于仓库根目录，可以为空
+	Doc string
+	// Server 为 true 说明入口会启动一个服务器一直运行，需要手动停止
+	Server bool
+}
+
+// Name 案例的名字，例如 case8
+func (c Case) Name() string {
+	return "case" + strconv.Itoa(c.ID)
+}
+
+// RunPattern 只匹配 Tests 里面的顶层测试，避免同一个包里面别的案例也跑起来
+func (c Case) RunPattern() string {
+	names := make([]string, 0, len(c.Tests))
+	for _, t := range c.Tests {
+		names = append(names, regexp.QuoteMeta(t))
+	}
+	return "^(" + strings.Join(names, "|") + ")$"
+}
+
+var (
+	mu    sync.RWMutex
+	cases = map[int]Case{}
+)
+
+// Register 在案例所在包的 init 里面调用，id 重复或者缺少入口会直接 panic
+func Register(c Case) {
+	if c.ID <= 0 || c.Package == "" || len(c.Tests) == 0 {
+		panic(fmt.Sprintf("cases: 案例 %d 缺少 id、包或者入口", c.ID))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := cases[c.ID]; ok {
+		panic(fmt.Sprintf("cases: %s 重复注册", c.Name()))
+	}
+	cases[c.ID] = c
+}
+
+// List 按照 id 从小到大返回所有的案例
+func List() []Case {
+	mu.RLock()
+	defer mu.RUnlock()
+	res := make([]Case, 0, len(cases))
+	for _, c := range cases {
+		res = append(res, c)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+// Get 支持 8 和 case8 两种写法
+func Get(id string) (Case, bool) {
+	n, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(id), "case"))
+	if err != nil {
+		return Case{}, false
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	c, ok := cases[n]
+	return c, ok
+}
@@ -0,0 +1,40 @@
+package cases
+
+import (
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	Register(Case{ID: 1002, Title: "b", Package: "./b", Tests: []string{"TestB"}})
+	Register(Case{ID: 1001, Title: "a", Package: "./a", Tests: []string{"TestA", "TestA_V1"}})
+
+	c, ok := Get("case1001")
+	assert.True(t, ok)
+	assert.Equal(t, "a", c.Title)
+	c, ok = Get("1002")
+	assert.True(t, ok)
+	assert.Equal(t, "case1002", c.Name())
+	_, ok = Get("case")
+	assert.False(t, ok)
+
+	var ids []int
+	for _, c := range List() {
+		ids = append(ids, c.ID)
+	}
+	assert.Equal(t, []int{1001, 1002}, ids)
+
+	assert.Panics(t, func() {
+		Register(Case{ID: 1001, Package: "./a", Tests: []string{"TestA"}})
+	})
+	assert.Panics(t, func() {
+		Register(Case{ID: 1003})
+	})
+
+	// 只能匹配完整的测试名字
+	c, _ = Get("1001")
+	re := regexp.MustCompile(c.RunPattern())
+	assert.True(t, re.MatchString("TestA_V1"))
+	assert.False(t, re.MatchString("TestAB"))
+}
@@ -0,0 +1,183 @@
+// Package credential 密码的哈希和校验
+//
+// 数据库里面只保存哈希值，格式是自描述的，算法和参数都编码在哈希值里面：
+//   - bcrypt：$2a$12$...
+//   - argon2id：$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+//
+// 这样调整了参数或者换了算法之后，旧的哈希值仍然能校验，
+// 用户下一次登录成功的时候再用新的参数重新计算（Verify 返回 rehash = true）
+package credential
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+var (
+	// ErrMismatch 密码不正确
+	ErrMismatch = errors.New("credential: 密码不正确")
+	// ErrUnknownHash 不是 bcrypt 也不是 argon2id 的哈希值，例如数据库里面还是明文
+	ErrUnknownHash = errors.New("credential: 无法识别的哈希格式")
+)
+
+type Algorithm string
+
+const (
+	Bcrypt   Algorithm = "bcrypt"
+	Argon2id Algorithm = "argon2id"
+)
+
+// Argon2Params argon2id 的参数，Memory 的单位是 KiB
+type Argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// Params 计算新的哈希值用的算法和参数
+type Params struct {
+	Algorithm  Algorithm
+	BcryptCost int
+	Argon2     Argon2Params
+}
+
+// DefaultParams 新的哈希值用 argon2id，参数参考 OWASP 的建议，一次计算大概几十毫秒
+func DefaultParams() Params {
+	return Params{
+		Algorithm:  Argon2id,
+		BcryptCost: 12,
+		Argon2: Argon2Params{
+			Memory:  64 * 1024,
+			Time:    3,
+			Threads: 2,
+			SaltLen: 16,
+			KeyLen:  32,
+		},
+	}
+}
+
+// Hasher 可以并发使用
+type Hasher struct {
+	params Params
+	// dummy 用户不存在的时候也校验一次，这样不能通过响应时间判断用户名是否存在
+	dummy string
+}
+
+func NewHasher(params Params) (*Hasher, error) {
+	switch params.Algorithm {
+	case Bcrypt:
+		if params.BcryptCost < bcrypt.MinCost || params.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("credential: bcrypt cost %d 不合法", params.BcryptCost)
+		}
+	case Argon2id:
+		a := params.Argon2
+		if a.Memory == 0 || a.Time == 0 || a.Threads == 0 || a.SaltLen < 8 || a.KeyLen < 16 {
+			return nil, fmt.Errorf("credential: argon2id 参数 %+v 不合法", a)
+		}
+	default:
+		return nil, fmt.Errorf("credential: 不支持的算法 %q", params.Algorithm)
+	}
+	h := &Hasher{params: params}
+	dummy, err := h.Hash("dummy password")
+	if err != nil {
+		return nil, err
+	}
+	h.dummy = dummy
+	return h, nil
+}
+
+// Hash 用当前的参数计算哈希值，每次的盐都不一样
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.params.Algorithm == Bcrypt {
+		res, err := bcrypt.GenerateFromPassword([]byte(password), h.params.BcryptCost)
+		return string(res), err
+	}
+	p := h.params.Argon2
+	salt := make([]byte, p.SaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return encodeArgon2(p, salt, key), nil
+}
+
+// Verify 校验密码，密码不正确的时候返回 ErrMismatch
+// rehash 表示哈希值用的不是当前的算法或者参数，调用方应该用 Hash 重新计算并且保存
+func (h *Hasher) Verify(encoded, password string) (rehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		p, salt, key, err := decodeArgon2(encoded)
+		if err != nil {
+			return false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+		// 比较的时间和哪一个字节不一样没有关系
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, ErrMismatch
+		}
+		return h.params.Algorithm != Argon2id || p != h.params.Argon2, nil
+	case strings.HasPrefix(encoded, "$2"):
+		// bcrypt 内部也是用 subtle.ConstantTimeCompare 比较的
+		err = bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, ErrMismatch
+		}
+		if err != nil {
+			return false, fmt.Errorf("%w: %w", ErrUnknownHash, err)
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return false, err
+		}
+		return h.params.Algorithm != Bcrypt || cost != h.params.BcryptCost, nil
+	default:
+		return false, ErrUnknownHash
+	}
+}
+
+// VerifyDummy 用户不存在的时候调用，花的时间和校验一个真实的哈希值差不多
+func (h *Hasher) VerifyDummy(password string) {
+	_, _ = h.Verify(h.dummy, password)
+}
+
+func encodeArgon2(p Argon2Params, salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeArgon2(encoded string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, ErrUnknownHash
+	}
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("%w: argon2 版本 %s", ErrUnknownHash, parts[2])
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads)
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("%w: argon2 参数 %s", ErrUnknownHash, parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("%w: %w", ErrUnknownHash, err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, fmt.Errorf("%w: argon2 哈希值 %s", ErrUnknownHash, parts[5])
+	}
+	p.SaltLen, p.KeyLen = uint32(len(salt)), uint32(len(key))
+	return p, salt, key, nil
+}
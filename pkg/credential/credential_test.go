@@ -0,0 +1,87 @@
+package credential
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+// testParams 测试用的参数，比默认值快很多
+func testParams(alg Algorithm) Params {
+	p := DefaultParams()
+	p.Algorithm, p.BcryptCost = alg, 4
+	p.Argon2.Memory, p.Argon2.Time = 1024, 1
+	return p
+}
+
+func TestHasher(t *testing.T) {
+	testCases := []struct {
+		name   string
+		alg    Algorithm
+		prefix string
+	}{
+		{name: "bcrypt", alg: Bcrypt, prefix: "$2a$04$"},
+		{name: "argon2id", alg: Argon2id, prefix: "$argon2id$v=19$m=1024,t=1,p=2$"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, err := NewHasher(testParams(tc.alg))
+			require.NoError(t, err)
+			encoded, err := h.Hash("password_1")
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(encoded, tc.prefix), encoded)
+			// 同样的密码每次的盐都不一样
+			other, err := h.Hash("password_1")
+			require.NoError(t, err)
+			assert.NotEqual(t, encoded, other)
+
+			rehash, err := h.Verify(encoded, "password_1")
+			require.NoError(t, err)
+			assert.False(t, rehash)
+			_, err = h.Verify(encoded, "password_2")
+			assert.ErrorIs(t, err, ErrMismatch)
+			_, err = h.Verify("password_1", "password_1")
+			assert.ErrorIs(t, err, ErrUnknownHash)
+		})
+	}
+}
+
+// TestRehash 算法或者参数变了之后，旧的哈希值还能校验，但是需要重新计算
+func TestRehash(t *testing.T) {
+	old, err := NewHasher(testParams(Bcrypt))
+	require.NoError(t, err)
+	encoded, err := old.Hash("password_1")
+	require.NoError(t, err)
+
+	h, err := NewHasher(testParams(Argon2id))
+	require.NoError(t, err)
+	rehash, err := h.Verify(encoded, "password_1")
+	require.NoError(t, err)
+	assert.True(t, rehash)
+
+	encoded, err = h.Hash("password_1")
+	require.NoError(t, err)
+	p := testParams(Argon2id)
+	p.Argon2.Time = 2
+	stronger, err := NewHasher(p)
+	require.NoError(t, err)
+	rehash, err = stronger.Verify(encoded, "password_1")
+	require.NoError(t, err)
+	assert.True(t, rehash)
+	_, err = stronger.Verify(encoded, "password_2")
+	assert.ErrorIs(t, err, ErrMismatch)
+}
+
+func TestNewHasher(t *testing.T) {
+	p := testParams(Bcrypt)
+	p.BcryptCost = 1
+	_, err := NewHasher(p)
+	assert.Error(t, err)
+	p = testParams(Argon2id)
+	p.Argon2.SaltLen = 0
+	_, err = NewHasher(p)
+	assert.Error(t, err)
+	_, err = NewHasher(Params{Algorithm: "md5"})
+	assert.Error(t, err)
+}
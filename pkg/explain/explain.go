@@ -0,0 +1,247 @@
+// Package explain 对 gorm 的查询执行 MySQL 的 EXPLAIN，让案例可以断言查询确实用上了想要的索引
+package explain
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// Extra 里面常见的标记
+const (
+	// ExtraUsingIndex 覆盖索引，不需要回表
+	ExtraUsingIndex = "Using index"
+	// ExtraUsingFilesort 没有办法利用索引排序
+	ExtraUsingFilesort  = "Using filesort"
+	ExtraUsingTemporary = "Using temporary"
+	ExtraUsingWhere     = "Using where"
+	// ExtraUsingIndexCondition 索引下推
+	ExtraUsingIndexCondition = "Using index condition"
+)
+
+// type 列常见的取值，从好到坏大致是 const、eq_ref、ref、range、index、ALL
+const (
+	TypeConst = "const"
+	TypeEqRef = "eq_ref"
+	TypeRef   = "ref"
+	TypeRange = "range"
+	// TypeIndex 扫描整个索引
+	TypeIndex = "index"
+	// TypeAll 全表扫描
+	TypeAll = "ALL"
+)
+
+// Row EXPLAIN 输出的一行，为 NULL 的列是空字符串
+type Row struct {
+	ID           int64
+	SelectType   string
+	Table        string
+	Partitions   string
+	Type         string
+	PossibleKeys string
+	Key          string
+	KeyLen       string
+	Ref          string
+	// Rows 优化器估计要扫描的行数
+	Rows     int64
+	Filtered float64
+	Extra    string
+}
+
+// Extras Extra 列是用分号分隔的
+func (r Row) Extras() []string {
+	if r.Extra == "" {
+		return nil
+	}
+	parts := strings.Split(r.Extra, ";")
+	res := make([]string, 0, len(parts))
+	for _, p := range parts {
+		res = append(res, strings.TrimSpace(p))
+	}
+	return res
+}
+
+// HasExtra 精确匹配，所以 Using index 不会匹配到 Using index condition
+func (r Row) HasExtra(flag string) bool {
+	for _, e := range r.Extras() {
+		if e == flag {
+			return true
+		}
+	}
+	return false
+}
+
+type Plan struct {
+	// SQL 被分析的语句，参数已经填进去了，方便复制到控制台里面执行
+	SQL  string
+	Rows []Row
+}
+
+// Table 返回第一个访问 table 的行，table 可以是别名，例如 <derived2>
+func (p Plan) Table(table string) (Row, bool) {
+	for _, r := range p.Rows {
+		if r.Table == table {
+			return r, true
+		}
+	}
+	return Row{}, false
+}
+
+// HasExtra 任意一行带有 flag 就返回 true
+func (p Plan) HasExtra(flag string) bool {
+	for _, r := range p.Rows {
+		if r.HasExtra(flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// TotalRows 所有行估计扫描行数的和，可以粗略比较两个查询的代价
+func (p Plan) TotalRows() int64 {
+	var total int64
+	for _, r := range p.Rows {
+		total += r.Rows
+	}
+	return total
+}
+
+func (p Plan) String() string {
+	var sb strings.Builder
+	sb.WriteString(p.SQL)
+	sb.WriteString("\nid\tselect_type\ttable\ttype\tkey\trows\tExtra\n")
+	for _, r := range p.Rows {
+		fmt.Fprintf(&sb, "%d\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			r.ID, r.SelectType, r.Table, r.Type, r.Key, r.Rows, r.Extra)
+	}
+	return sb.String()
+}
+
+// Query 构造查询，例如 func(tx *gorm.DB) *gorm.DB { return tx.Where("buyer = ?", 1).Find(&[]Order{}) }
+// 查询运行在 DryRun 模式下，所以不会真的执行，只是用来拿到 SQL
+type Query func(tx *gorm.DB) *gorm.DB
+
+// Explain 对 query 生成的 SQL 执行 EXPLAIN
+func Explain(db *gorm.DB, query Query) (Plan, error) {
+	sqlStr, vars, err := build(db, query)
+	if err != nil {
+		return Plan{}, err
+	}
+	return ExplainSQL(db, sqlStr, vars...)
+}
+
+// ExplainSQL 和 Explain 一样，只不过直接传入 SQL
+func ExplainSQL(db *gorm.DB, sqlStr string, vars ...any) (Plan, error) {
+	rows, err := db.Raw("EXPLAIN "+sqlStr, vars...).Rows()
+	if err != nil {
+		return Plan{}, fmt.Errorf("explain: 执行 EXPLAIN 失败 %w", err)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return Plan{}, err
+	}
+	plan := Plan{SQL: db.Dialector.Explain(sqlStr, vars...)}
+	for rows.Next() {
+		vals := make([]sql.NullString, len(cols))
+		dest := make([]any, len(cols))
+		for i := range vals {
+			dest[i] = &vals[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return Plan{}, err
+		}
+		row, err := toRow(cols, vals)
+		if err != nil {
+			return Plan{}, err
+		}
+		plan.Rows = append(plan.Rows, row)
+	}
+	return plan, rows.Err()
+}
+
+// Analyze 执行 EXPLAIN ANALYZE，返回 MySQL 输出的树形文本
+// 注意 EXPLAIN ANALYZE 会真的执行查询，MySQL 8.0.18 之后才支持
+func Analyze(db *gorm.DB, query Query) (string, error) {
+	return explainText(db, "EXPLAIN ANALYZE ", query)
+}
+
+// JSON 执行 EXPLAIN FORMAT=JSON，里面有 cost 之类的传统格式没有的信息
+func JSON(db *gorm.DB, query Query) (json.RawMessage, error) {
+	res, err := explainText(db, "EXPLAIN FORMAT=JSON ", query)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(res), nil
+}
+
+func explainText(db *gorm.DB, prefix string, query Query) (string, error) {
+	sqlStr, vars, err := build(db, query)
+	if err != nil {
+		return "", err
+	}
+	var res string
+	err = db.Raw(prefix+sqlStr, vars...).Row().Scan(&res)
+	if err != nil {
+		return "", fmt.Errorf("explain: 执行 %s失败 %w", prefix, err)
+	}
+	return res, nil
+}
+
+func build(db *gorm.DB, query Query) (string, []any, error) {
+	stmt := query(db.Session(&gorm.Session{DryRun: true})).Statement
+	if stmt.Error != nil {
+		return "", nil, stmt.Error
+	}
+	sqlStr := stmt.SQL.String()
+	if sqlStr == "" {
+		return "", nil, fmt.Errorf("explain: 没有生成 SQL，query 需要调用 Find、First 之类的方法")
+	}
+	return sqlStr, stmt.Vars, nil
+}
+
+func toRow(cols []string, vals []sql.NullString) (Row, error) {
+	var r Row
+	for i, col := range cols {
+		v := vals[i].String
+		var err error
+		switch strings.ToLower(col) {
+		case "id":
+			if v != "" {
+				_, err = fmt.Sscan(v, &r.ID)
+			}
+		case "select_type":
+			r.SelectType = v
+		case "table":
+			r.Table = v
+		case "partitions":
+			r.Partitions = v
+		case "type":
+			r.Type = v
+		case "possible_keys":
+			r.PossibleKeys = v
+		case "key":
+			r.Key = v
+		case "key_len":
+			r.KeyLen = v
+		case "ref":
+			r.Ref = v
+		case "rows":
+			if v != "" {
+				_, err = fmt.Sscan(v, &r.Rows)
+			}
+		case "filtered":
+			if v != "" {
+				_, err = fmt.Sscan(v, &r.Filtered)
+			}
+		case "extra":
+			r.Extra = v
+		}
+		if err != nil {
+			return Row{}, fmt.Errorf("explain: 解析 %s 列失败 %w", col, err)
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,87 @@
+package explain
+
+import (
+	"database/sql"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"testing"
+)
+
+func TestToRow(t *testing.T) {
+	cols := []string{"id", "select_type", "table", "partitions", "type", "possible_keys",
+		"key", "key_len", "ref", "rows", "filtered", "Extra"}
+	vals := []sql.NullString{
+		{String: "1", Valid: true},
+		{String: "SIMPLE", Valid: true},
+		{String: "orders_v1", Valid: true},
+		{},
+		{String: "ref", Valid: true},
+		{String: "buyer_ctime", Valid: true},
+		{String: "buyer_ctime", Valid: true},
+		{String: "9", Valid: true},
+		{String: "const", Valid: true},
+		{String: "1000", Valid: true},
+		{String: "100.00", Valid: true},
+		{String: "Using where; Using index condition", Valid: true},
+	}
+	row, err := toRow(cols, vals)
+	require.NoError(t, err)
+	assert.Equal(t, Row{
+		ID:           1,
+		SelectType:   "SIMPLE",
+		Table:        "orders_v1",
+		Type:         TypeRef,
+		PossibleKeys: "buyer_ctime",
+		Key:          "buyer_ctime",
+		KeyLen:       "9",
+		Ref:          "const",
+		Rows:         1000,
+		Filtered:     100,
+		Extra:        "Using where; Using index condition",
+	}, row)
+	assert.Equal(t, []string{ExtraUsingWhere, ExtraUsingIndexCondition}, row.Extras())
+	assert.True(t, row.HasExtra(ExtraUsingIndexCondition))
+	// 不能把 Using index condition 当成覆盖索引
+	assert.False(t, row.HasExtra(ExtraUsingIndex))
+
+	_, err = toRow([]string{"rows"}, []sql.NullString{{String: "abc", Valid: true}})
+	assert.Error(t, err)
+}
+
+func TestPlan(t *testing.T) {
+	p := Plan{Rows: []Row{
+		{ID: 1, SelectType: "PRIMARY", Table: "orders", Type: TypeRange, Key: "PRIMARY", Rows: 10},
+		{ID: 2, SelectType: "SUBQUERY", Table: "orders", Type: TypeIndex, Key: "idx_orders_buyer",
+			Rows: 10001, Extra: ExtraUsingIndex},
+	}}
+	row, ok := p.Table("orders")
+	assert.True(t, ok)
+	assert.Equal(t, "PRIMARY", row.Key)
+	_, ok = p.Table("users")
+	assert.False(t, ok)
+	assert.True(t, p.HasExtra(ExtraUsingIndex))
+	assert.False(t, p.HasExtra(ExtraUsingFilesort))
+	assert.Equal(t, int64(10011), p.TotalRows())
+}
+
+func TestBuild(t *testing.T) {
+	type Order struct {
+		ID    int64
+		Buyer int64
+	}
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlStr, vars, err := build(db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("buyer = ?", 11).Order("id DESC").Limit(10).Find(&[]Order{})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM `orders` WHERE buyer = ? ORDER BY id DESC LIMIT 10", sqlStr)
+	assert.Equal(t, []any{11}, vars)
+	// DryRun 不会真的执行，表不存在也没关系
+	_, _, err = build(db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("buyer = ?", 11)
+	})
+	assert.Error(t, err)
+}
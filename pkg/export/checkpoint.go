@@ -0,0 +1,79 @@
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+)
+
+// part 主键范围 [Lo, Hi]，导出到 File
+type part struct {
+	Index    int    `json:"index"`
+	Lo       int64  `json:"lo"`
+	Hi       int64  `json:"hi"`
+	File     string `json:"file"`
+	Done     bool   `json:"done"`
+	Rows     int64  `json:"rows"`
+	Checksum string `json:"checksum"`
+}
+
+// checkpoint 检查点，Min 和 Max 是第一次导出的时候的主键范围，之后新插入的数据不会导出
+type checkpoint struct {
+	Table     string   `json:"table"`
+	Format    Format   `json:"format"`
+	Gzip      bool     `json:"gzip"`
+	Key       string   `json:"key"`
+	Columns   []string `json:"columns"`
+	ChunkSize int64    `json:"chunk_size"`
+	Min       int64    `json:"min"`
+	Max       int64    `json:"max"`
+	Parts     []part   `json:"parts"`
+}
+
+// readCheckpoint 文件不存在的时候返回 nil
+func readCheckpoint(path string) (*checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	err = json.Unmarshal(data, &cp)
+	if err != nil {
+		return nil, fmt.Errorf("export: 解析检查点 %s 失败 %w", path, err)
+	}
+	return &cp, nil
+}
+
+func (c *checkpoint) matches(table string, opts Options) bool {
+	return c.Table == table && c.Format == opts.Format && c.Gzip == opts.Gzip &&
+		c.Key == opts.Key && c.ChunkSize == opts.ChunkSize &&
+		(len(opts.Columns) == 0 || slices.Equal(c.Columns, opts.Columns))
+}
+
+// save 先写临时文件再改名，这样崩溃的时候检查点要么是旧的，要么是新的，不会只写了一半
+func (c *checkpoint) save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	err = errors.Join(err, f.Close())
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,370 @@
+// Package export 把整张表导出成文件，不用 LIMIT offset, n 翻页：
+// 先按照主键把表切成若干个范围，N 个 worker 并发导出，每个范围里面用 WHERE id > ? ORDER BY id LIMIT n 往后扫，
+// 每个范围写一个文件。每导出完一个范围就更新一次检查点文件，进程崩溃之后重新运行会跳过已经完成的范围。
+// 全部导出完之后重新读一遍文件，校验行数和 checksum
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+var (
+	// ErrCheckpointMismatch 检查点文件是用别的参数生成的，需要删掉检查点和导出的文件重新导出
+	ErrCheckpointMismatch = errors.New("export: 检查点和当前的参数不一致")
+	// ErrVerify 文件被改过、不完整，或者和数据库里面的行数对不上
+	ErrVerify = errors.New("export: 校验失败")
+)
+
+type Format string
+
+const (
+	FormatJSONL Format = "jsonl"
+	FormatCSV   Format = "csv"
+)
+
+type Options struct {
+	// Dir 导出文件和检查点文件所在的目录
+	Dir    string
+	Format Format
+	Gzip   bool
+	// Workers 并发导出的范围数
+	Workers int
+	// ChunkSize 每个范围的主键跨度，主键不连续的时候一个范围里面的行数会少一些
+	ChunkSize int64
+	// BatchSize 每次查询多少行
+	BatchSize int
+	// Key 主键，必须是整数
+	Key string
+	// Columns 导出哪些列，为空的时候导出全部的列
+	Columns []string
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Format:    FormatJSONL,
+		Workers:   4,
+		ChunkSize: 10000,
+		BatchSize: 1000,
+		Key:       "id",
+	}
+}
+
+// Result Checksum 是所有文件 checksum 按照顺序合起来的 checksum
+type Result struct {
+	Rows     int64
+	Checksum string
+	Files    []string
+	// Resumed 上一次已经导出完、这一次跳过的文件数
+	Resumed int
+}
+
+type Exporter struct {
+	db    *gorm.DB
+	table string
+	opts  Options
+
+	mu sync.Mutex
+	cp *checkpoint
+	// afterPart 测试用，每导出完一个范围调用一次
+	afterPart func(p part)
+}
+
+func New(db *gorm.DB, table string, opts Options) (*Exporter, error) {
+	if opts.Dir == "" {
+		return nil, fmt.Errorf("export: Dir 不能为空")
+	}
+	if opts.Format != FormatJSONL && opts.Format != FormatCSV {
+		return nil, fmt.Errorf("export: 不支持的格式 %s", opts.Format)
+	}
+	if opts.Workers <= 0 || opts.ChunkSize <= 0 || opts.BatchSize <= 0 {
+		return nil, fmt.Errorf("export: Workers、ChunkSize 和 BatchSize 都必须大于 0")
+	}
+	if opts.Key == "" {
+		opts.Key = "id"
+	}
+	return &Exporter{db: db, table: table, opts: opts}, nil
+}
+
+// Run 导出并且校验，ctx 被取消或者出错的时候，已经完成的范围都记在检查点里面，下一次 Run 会接着导出
+func (e *Exporter) Run(ctx context.Context) (Result, error) {
+	err := os.MkdirAll(e.opts.Dir, 0o755)
+	if err != nil {
+		return Result{}, err
+	}
+	cp, err := e.load(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	e.cp = cp
+
+	var pending []part
+	resumed := 0
+	for _, p := range cp.Parts {
+		if p.Done {
+			resumed++
+			continue
+		}
+		pending = append(pending, p)
+	}
+	if resumed > 0 {
+		slog.Info("从检查点继续导出", slog.String("table", e.table),
+			slog.Int("done", resumed), slog.Int("pending", len(pending)))
+	}
+
+	ch := make(chan part)
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		defer close(ch)
+		for _, p := range pending {
+			select {
+			case ch <- p:
+			case <-egCtx.Done():
+				return egCtx.Err()
+			}
+		}
+		return nil
+	})
+	for i := 0; i < e.opts.Workers; i++ {
+		eg.Go(func() error {
+			for p := range ch {
+				err := e.exportPart(egCtx, p)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	err = eg.Wait()
+	if err != nil {
+		return Result{}, err
+	}
+	res, err := e.verify(ctx)
+	res.Resumed = resumed
+	return res, err
+}
+
+// load 读取检查点，没有检查点的时候按照最小和最大的主键划分范围，生成新的检查点
+func (e *Exporter) load(ctx context.Context) (*checkpoint, error) {
+	cp, err := readCheckpoint(e.checkpointPath())
+	if err != nil {
+		return nil, err
+	}
+	if cp != nil {
+		if !cp.matches(e.table, e.opts) {
+			return nil, fmt.Errorf("%w: %s", ErrCheckpointMismatch, e.checkpointPath())
+		}
+		return cp, nil
+	}
+
+	db := e.db.WithContext(ctx)
+	cols := e.opts.Columns
+	if len(cols) == 0 {
+		types, err := db.Migrator().ColumnTypes(e.table)
+		if err != nil {
+			return nil, fmt.Errorf("export: 读取 %s 的列失败 %w", e.table, err)
+		}
+		for _, t := range types {
+			cols = append(cols, t.Name())
+		}
+	}
+	var bounds struct {
+		Min *int64
+		Max *int64
+	}
+	key := clause.Column{Name: e.opts.Key}
+	err = db.Table(e.table).Select("MIN(?) AS min, MAX(?) AS max", key, key).Scan(&bounds).Error
+	if err != nil {
+		return nil, err
+	}
+	cp = &checkpoint{
+		Table:     e.table,
+		Format:    e.opts.Format,
+		Gzip:      e.opts.Gzip,
+		Key:       e.opts.Key,
+		Columns:   cols,
+		ChunkSize: e.opts.ChunkSize,
+	}
+	// 空表也生成检查点，只不过一个范围都没有
+	if bounds.Min != nil && bounds.Max != nil {
+		cp.Min, cp.Max = *bounds.Min, *bounds.Max
+		for lo := cp.Min; lo <= cp.Max; lo += e.opts.ChunkSize {
+			idx := len(cp.Parts)
+			cp.Parts = append(cp.Parts, part{
+				Index: idx,
+				Lo:    lo,
+				Hi:    min(lo+e.opts.ChunkSize-1, cp.Max),
+				File:  e.fileName(idx),
+			})
+		}
+	}
+	return cp, cp.save(e.checkpointPath())
+}
+
+// exportPart 导出 [Lo, Hi]，文件先从头开始写，写完并且刷盘之后才在检查点里面标记为完成
+func (e *Exporter) exportPart(ctx context.Context, p part) error {
+	w, err := newFileWriter(filepath.Join(e.opts.Dir, p.File), e.opts.Format, e.opts.Gzip, e.cp.Columns)
+	if err != nil {
+		return err
+	}
+	defer w.abort()
+	keyIdx := -1
+	for i, c := range e.cp.Columns {
+		if c == e.opts.Key {
+			keyIdx = i
+		}
+	}
+	cols := e.cp.Columns
+	if keyIdx < 0 {
+		// 没有导出主键也要查出来，不然没办法往后翻
+		cols = append(append([]string(nil), cols...), e.opts.Key)
+		keyIdx = len(cols) - 1
+	}
+	key := clause.Column{Name: e.opts.Key}
+	selects := make([]clause.Column, 0, len(cols))
+	for _, c := range cols {
+		selects = append(selects, clause.Column{Name: c})
+	}
+
+	last := p.Lo - 1
+	for {
+		rows, err := e.db.WithContext(ctx).Table(e.table).
+			Clauses(clause.Select{Columns: selects}).
+			Where("? > ? AND ? <= ?", key, last, key, p.Hi).
+			Order(clause.OrderByColumn{Column: key}).
+			Limit(e.opts.BatchSize).Rows()
+		if err != nil {
+			return fmt.Errorf("export: 查询 [%d, %d] 失败 %w", p.Lo, p.Hi, err)
+		}
+		n := 0
+		for rows.Next() {
+			vals := make([]any, len(cols))
+			dest := make([]any, len(cols))
+			for i := range vals {
+				dest[i] = &vals[i]
+			}
+			if err = rows.Scan(dest...); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			last, err = toInt64(vals[keyIdx])
+			if err != nil {
+				_ = rows.Close()
+				return err
+			}
+			if err = w.write(vals[:len(e.cp.Columns)]); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			n++
+		}
+		err = errors.Join(rows.Err(), rows.Close())
+		if err != nil {
+			return err
+		}
+		if n < e.opts.BatchSize {
+			break
+		}
+	}
+
+	p.Rows, p.Checksum, err = w.close()
+	if err != nil {
+		return err
+	}
+	p.Done = true
+	err = e.markDone(p)
+	if err != nil {
+		return err
+	}
+	if e.afterPart != nil {
+		e.afterPart(p)
+	}
+	return nil
+}
+
+func (e *Exporter) markDone(p part) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cp.Parts[p.Index] = p
+	return e.cp.save(e.checkpointPath())
+}
+
+// verify 重新读一遍所有的文件，行数和 checksum 要和检查点一致，总行数要和数据库一致
+// 导出的过程中表还在写入的话，行数可能会对不上
+func (e *Exporter) verify(ctx context.Context) (Result, error) {
+	var res Result
+	sums := make([]string, 0, len(e.cp.Parts))
+	for _, p := range e.cp.Parts {
+		path := filepath.Join(e.opts.Dir, p.File)
+		rows, sum, err := readFile(path, e.cp.Format, e.cp.Gzip, e.cp.Columns)
+		if err != nil {
+			return Result{}, fmt.Errorf("%w: 读取 %s 失败 %w", ErrVerify, path, err)
+		}
+		if rows != p.Rows || sum != p.Checksum {
+			return Result{}, fmt.Errorf("%w: %s 应该有 %d 行，实际 %d 行，checksum %s / %s",
+				ErrVerify, path, p.Rows, rows, p.Checksum, sum)
+		}
+		res.Rows += rows
+		res.Files = append(res.Files, path)
+		sums = append(sums, sum)
+	}
+	res.Checksum = combine(sums)
+
+	var count int64
+	key := clause.Column{Name: e.cp.Key}
+	err := e.db.WithContext(ctx).Table(e.table).
+		Where("? >= ? AND ? <= ?", key, e.cp.Min, key, e.cp.Max).Count(&count).Error
+	if err != nil {
+		return Result{}, err
+	}
+	if len(e.cp.Parts) == 0 {
+		count = 0
+	}
+	if count != res.Rows {
+		return Result{}, fmt.Errorf("%w: 数据库里面有 %d 行，导出了 %d 行", ErrVerify, count, res.Rows)
+	}
+	slog.Info("导出完成", slog.String("table", e.table), slog.Int64("rows", res.Rows),
+		slog.Int("files", len(res.Files)), slog.String("checksum", res.Checksum))
+	return res, nil
+}
+
+func (e *Exporter) checkpointPath() string {
+	return filepath.Join(e.opts.Dir, e.table+".checkpoint.json")
+}
+
+func (e *Exporter) fileName(idx int) string {
+	name := fmt.Sprintf("%s-%05d.%s", e.table, idx, e.opts.Format)
+	if e.opts.Gzip {
+		name += ".gz"
+	}
+	return name
+}
+
+func toInt64(val any) (int64, error) {
+	switch v := val.(type) {
+	case int64:
+		return v, nil
+	case int32:
+		return int64(v), nil
+	case int:
+		return int64(v), nil
+	case uint64:
+		return int64(v), nil
+	case []byte:
+		return strconv.ParseInt(string(v), 10, 64)
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	default:
+		return 0, fmt.Errorf("export: 主键必须是整数，实际是 %T", val)
+	}
+}
@@ -0,0 +1,167 @@
+package export
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"interview-cases/test"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type exportOrder struct {
+	ID    int64
+	SN    string
+	Buyer int64
+	// Extra 里面有换行和引号，CSV 需要转义
+	Extra string
+}
+
+func initDB(t *testing.T) *gorm.DB {
+	db := test.InitMemoryDB(t)
+	require.NoError(t, db.AutoMigrate(&exportOrder{}))
+	orders := make([]exportOrder, 0, 1000)
+	for i := 1; i <= 1000; i++ {
+		// 主键不连续，每 7 个空一个
+		if i%7 == 0 {
+			continue
+		}
+		orders = append(orders, exportOrder{ID: int64(i), SN: fmt.Sprintf("sn_%d", i), Buyer: int64(i % 10), Extra: "a,\"b\"\nc"})
+	}
+	require.NoError(t, db.CreateInBatches(&orders, 100).Error)
+	return db
+}
+
+func TestExport(t *testing.T) {
+	db := initDB(t)
+	testCases := []struct {
+		name   string
+		format Format
+		gzip   bool
+	}{
+		{name: "jsonl", format: FormatJSONL},
+		{name: "jsonl gzip", format: FormatJSONL, gzip: true},
+		{name: "csv", format: FormatCSV},
+		{name: "csv gzip", format: FormatCSV, gzip: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.Dir, opts.Format, opts.Gzip = t.TempDir(), tc.format, tc.gzip
+			opts.ChunkSize, opts.BatchSize = 100, 30
+			e, err := New(db, "export_orders", opts)
+			require.NoError(t, err)
+			res, err := e.Run(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, int64(858), res.Rows)
+			assert.Len(t, res.Files, 10)
+			assert.Equal(t, 0, res.Resumed)
+
+			// 读出来的数据和数据库里面的一样
+			var want []exportOrder
+			require.NoError(t, db.Order("id").Find(&want).Error)
+			assert.Equal(t, want, readOrders(t, res.Files, tc.format, tc.gzip))
+		})
+	}
+}
+
+// TestResume 导出了两个范围之后崩溃，重新运行只导出剩下的范围
+func TestResume(t *testing.T) {
+	db := initDB(t)
+	opts := DefaultOptions()
+	opts.Dir, opts.Workers, opts.ChunkSize, opts.Gzip = t.TempDir(), 1, 100, true
+	e, err := New(db, "export_orders", opts)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := 0
+	e.afterPart = func(p part) {
+		done++
+		if done == 2 {
+			cancel()
+		}
+	}
+	_, err = e.Run(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+
+	e, err = New(db, "export_orders", opts)
+	require.NoError(t, err)
+	res, err := e.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.Resumed)
+	assert.Equal(t, int64(858), res.Rows)
+
+	// 全部完成之后再运行一次只会校验
+	res2, err := e.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 10, res2.Resumed)
+	assert.Equal(t, res.Checksum, res2.Checksum)
+
+	// 文件被改过就校验不通过
+	require.NoError(t, os.WriteFile(res.Files[3], []byte("{}\n"), 0o644))
+	_, err = e.Run(context.Background())
+	assert.ErrorIs(t, err, ErrVerify)
+
+	// 参数不一样不能接着导出
+	opts.Gzip = false
+	e, err = New(db, "export_orders", opts)
+	require.NoError(t, err)
+	_, err = e.Run(context.Background())
+	assert.ErrorIs(t, err, ErrCheckpointMismatch)
+}
+
+func readOrders(t *testing.T, files []string, format Format, gz bool) []exportOrder {
+	var res []exportOrder
+	for _, path := range files {
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer f.Close()
+		in := bufio.NewReader(f)
+		if gz {
+			zr, err := gzip.NewReader(f)
+			require.NoError(t, err)
+			in = bufio.NewReader(zr)
+		}
+		assert.Equal(t, "."+string(format), filepath.Ext(trimGz(path, gz)))
+		if format == FormatCSV {
+			records, err := csv.NewReader(in).ReadAll()
+			require.NoError(t, err)
+			assert.Equal(t, []string{"id", "sn", "buyer", "extra"}, records[0])
+			for _, r := range records[1:] {
+				var o exportOrder
+				_, err = fmt.Sscan(r[0], &o.ID)
+				require.NoError(t, err)
+				_, err = fmt.Sscan(r[2], &o.Buyer)
+				require.NoError(t, err)
+				o.SN, o.Extra = r[1], r[3]
+				res = append(res, o)
+			}
+			continue
+		}
+		dec := json.NewDecoder(in)
+		for dec.More() {
+			var o struct {
+				ID    int64  `json:"id"`
+				SN    string `json:"sn"`
+				Buyer int64  `json:"buyer"`
+				Extra string `json:"extra"`
+			}
+			require.NoError(t, dec.Decode(&o))
+			res = append(res, exportOrder(o))
+		}
+	}
+	return res
+}
+
+func trimGz(path string, gz bool) string {
+	if gz {
+		return path[:len(path)-len(".gz")]
+	}
+	return path
+}
@@ -0,0 +1,220 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// fileWriter 每一行先编码到 rec 里面，算完 checksum 再写进文件
+// CSV 的表头不算在行数和 checksum 里面
+type fileWriter struct {
+	f      *os.File
+	gz     *gzip.Writer
+	buf    *bufio.Writer
+	format Format
+	cols   []string
+	hash   hash.Hash
+	rows   int64
+	rec    bytes.Buffer
+	csv    *csv.Writer
+	closed bool
+}
+
+func newFileWriter(path string, format Format, gz bool, cols []string) (*fileWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &fileWriter{f: f, format: format, cols: cols, hash: sha256.New()}
+	var out io.Writer = f
+	if gz {
+		w.gz = gzip.NewWriter(f)
+		out = w.gz
+	}
+	w.buf = bufio.NewWriter(out)
+	w.csv = csv.NewWriter(&w.rec)
+	if format == FormatCSV {
+		err = w.csv.Write(cols)
+		if err == nil {
+			w.csv.Flush()
+			_, err = w.buf.Write(w.rec.Bytes())
+		}
+		w.rec.Reset()
+		if err != nil {
+			w.abort()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *fileWriter) write(vals []any) error {
+	w.rec.Reset()
+	var err error
+	if w.format == FormatCSV {
+		err = encodeCSV(w.csv, vals)
+	} else {
+		err = encodeJSON(&w.rec, w.cols, vals)
+	}
+	if err != nil {
+		return err
+	}
+	w.hash.Write(w.rec.Bytes())
+	w.rows++
+	_, err = w.buf.Write(w.rec.Bytes())
+	return err
+}
+
+// close 刷盘之后返回行数和 checksum
+func (w *fileWriter) close() (int64, string, error) {
+	err := w.buf.Flush()
+	if err == nil && w.gz != nil {
+		err = w.gz.Close()
+	}
+	if err == nil {
+		err = w.f.Sync()
+	}
+	w.closed = true
+	err = errors.Join(err, w.f.Close())
+	return w.rows, hex.EncodeToString(w.hash.Sum(nil)), err
+}
+
+// abort 出错的时候关掉文件，写了一半的文件留着也没关系，下一次会从头开始写
+func (w *fileWriter) abort() {
+	if !w.closed {
+		w.closed = true
+		_ = w.f.Close()
+	}
+}
+
+// readFile 重新读一遍文件，计算行数和 checksum，算法和 fileWriter 一样
+func readFile(path string, format Format, gz bool, cols []string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	var in io.Reader = f
+	if gz {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, "", err
+		}
+		defer zr.Close()
+		in = zr
+	}
+	h := sha256.New()
+	var rows int64
+	if format == FormatCSV {
+		r := csv.NewReader(in)
+		header, err := r.Read()
+		if err != nil {
+			return 0, "", err
+		}
+		if !slices.Equal(header, cols) {
+			return 0, "", fmt.Errorf("表头 %v 和 %v 不一致", header, cols)
+		}
+		var rec bytes.Buffer
+		cw := csv.NewWriter(&rec)
+		for {
+			record, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return 0, "", err
+			}
+			rec.Reset()
+			if err = cw.Write(record); err != nil {
+				return 0, "", err
+			}
+			cw.Flush()
+			h.Write(rec.Bytes())
+			rows++
+		}
+		return rows, hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	br := bufio.NewReader(in)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return 0, "", err
+		}
+		if !json.Valid(line) {
+			return 0, "", fmt.Errorf("第 %d 行不是合法的 JSON", rows+1)
+		}
+		h.Write(line)
+		rows++
+	}
+	return rows, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// encodeJSON 按照列的顺序输出，而不是像 map 一样按照字母排序
+func encodeJSON(buf *bytes.Buffer, cols []string, vals []any) error {
+	buf.WriteByte('{')
+	for i, c := range cols {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(c)
+		buf.Write(key)
+		buf.WriteByte(':')
+		val, err := json.Marshal(normalize(vals[i]))
+		if err != nil {
+			return fmt.Errorf("export: 编码 %s 失败 %w", c, err)
+		}
+		buf.Write(val)
+	}
+	buf.WriteString("}\n")
+	return nil
+}
+
+// encodeCSV NULL 输出成空字符串
+func encodeCSV(w *csv.Writer, vals []any) error {
+	record := make([]string, 0, len(vals))
+	for _, v := range vals {
+		switch val := normalize(v).(type) {
+		case nil:
+			record = append(record, "")
+		case time.Time:
+			record = append(record, val.Format(time.RFC3339Nano))
+		default:
+			record = append(record, fmt.Sprint(val))
+		}
+	}
+	err := w.Write(record)
+	if err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// normalize MySQL 的驱动会把字符串返回成 []byte
+func normalize(v any) any {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
+
+func combine(sums []string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(sums, ","))))
+}
@@ -0,0 +1,123 @@
+// Package fixture 生成可以复现的测试数据，并且尽可能快地写进数据库
+//
+// 同一个种子、同一个数据量生成的数据是一样的，所以不同的机器、不同的提交跑出来的结果可以放在一起比较。
+// 数据量和种子由调用方通过 Options 传进来，测试里面用 test.FixtureOptions 按照配置生成 Options。
+package fixture
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"sort"
+	"time"
+)
+
+// BaseTime 生成时间相关的数据都以它为基准，而不是 time.Now()，否则每次生成的数据都不一样
+var BaseTime = time.Date(2024, 11, 1, 0, 0, 0, 0, time.Local)
+
+// Size 数据量的档位，每个数据集自己决定 Medium 有多少数据
+type Size string
+
+const (
+	// Small 是 Medium 的十分之一，适合快速验证
+	Small Size = "small"
+	// Medium 是案例原本的数据量
+	Medium Size = "medium"
+	// Large 是 Medium 的十倍，用来放大优化前后的差距
+	Large Size = "large"
+)
+
+// Rows 根据档位换算 Medium 的行数，至少返回 1
+func (s Size) Rows(medium int) int {
+	var n int
+	switch s {
+	case Small:
+		n = medium / 10
+	case Large:
+		n = medium * 10
+	default:
+		n = medium
+	}
+	return max(n, 1)
+}
+
+// Method 写入数据的方式
+type Method string
+
+const (
+	// MethodAuto 在 MySQL 上用 LOAD DATA LOCAL INFILE，服务端不允许的话退化成 MethodBatch
+	MethodAuto Method = "auto"
+	// MethodBatch 多行 INSERT
+	MethodBatch Method = "batch"
+)
+
+type Options struct {
+	Seed   uint64
+	Size   Size
+	Method Method
+	// BatchSize 每条 INSERT 语句或者每次 LOAD DATA 写入多少行
+	BatchSize int
+}
+
+func NewOptions(size string, seed uint64, method string) (Options, error) {
+	opts := Options{
+		Seed:      seed,
+		Size:      Size(size),
+		Method:    Method(method),
+		BatchSize: 1000,
+	}
+	switch opts.Size {
+	case Small, Medium, Large:
+	default:
+		return Options{}, fmt.Errorf("fixture: 未知的数据量 %s", size)
+	}
+	switch opts.Method {
+	case MethodAuto, MethodBatch:
+	default:
+		return Options{}, fmt.Errorf("fixture: 未知的写入方式 %s", method)
+	}
+	return opts, nil
+}
+
+// Rand 同一个种子下面，不同名字的随机数序列互不影响，
+// 所以一个数据集多生成几行，不会改变另外一个数据集的数据
+func (o Options) Rand(name string) *rand.Rand {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return rand.New(rand.NewPCG(o.Seed, h.Sum64()))
+}
+
+// Weighted 按照权重随机选择，用来制造数据倾斜，例如一个大商家占了大部分订单
+type Weighted[T any] struct {
+	items []T
+	// cum 是权重的前缀和
+	cum   []int
+	total int
+}
+
+type WeightedItem[T any] struct {
+	Item   T
+	Weight int
+}
+
+func NewWeighted[T any](items ...WeightedItem[T]) *Weighted[T] {
+	w := &Weighted[T]{}
+	for _, item := range items {
+		if item.Weight <= 0 {
+			continue
+		}
+		w.total += item.Weight
+		w.items = append(w.items, item.Item)
+		w.cum = append(w.cum, w.total)
+	}
+	if w.total == 0 {
+		panic("fixture: 权重之和必须大于 0")
+	}
+	return w
+}
+
+func (w *Weighted[T]) Pick(r *rand.Rand) T {
+	n := r.IntN(w.total)
+	idx := sort.SearchInts(w.cum, n+1)
+	return w.items[idx]
+}
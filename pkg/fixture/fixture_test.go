@@ -0,0 +1,110 @@
+package fixture
+
+import (
+	"context"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"math/rand/v2"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fixtureItem struct {
+	ID       int64 `gorm:"primaryKey;autoIncrement"`
+	Merchant int64
+	Note     string
+	Ctime    time.Time
+}
+
+func TestLoad(t *testing.T) {
+	// test 包依赖这个包，所以这里不能用 test.InitMemoryDB
+	db, err := gorm.Open(sqlite.Open("file:fixture_load?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	defer sqlDB.Close()
+	ctx := context.Background()
+	opts, err := NewOptions("small", 42, "auto")
+	require.NoError(t, err)
+	opts.BatchSize = 7
+	merchants := NewWeighted(
+		WeightedItem[int64]{Item: 123, Weight: 8},
+		WeightedItem[int64]{Item: 234, Weight: 1},
+		WeightedItem[int64]{Item: 456, Weight: 1},
+	)
+	gen := func(r *rand.Rand, i int) fixtureItem {
+		return fixtureItem{
+			Merchant: merchants.Pick(r),
+			Note:     "a\tb\nc",
+			Ctime:    BaseTime.Add(-time.Duration(r.IntN(1000)) * time.Hour),
+		}
+	}
+	load := func() []fixtureItem {
+		require.NoError(t, Reset(ctx, db, &fixtureItem{}))
+		require.NoError(t, Load(ctx, db, opts, "items", opts.Size.Rows(1000), gen))
+		var items []fixtureItem
+		require.NoError(t, db.Order("id").Find(&items).Error)
+		return items
+	}
+	first := load()
+	// small 是 medium 的十分之一，而且不能被批次大小整除的部分也要写进去
+	require.Len(t, first, 100)
+	assert.Equal(t, int64(1), first[0].ID)
+	// 同一个种子生成的数据是一样的，Reset 之后自增主键也从头开始
+	second := load()
+	assert.Equal(t, first, second)
+
+	cnt := map[int64]int{}
+	for _, item := range first {
+		cnt[item.Merchant]++
+	}
+	assert.Greater(t, cnt[123], cnt[234]+cnt[456])
+}
+
+func TestOptions(t *testing.T) {
+	_, err := NewOptions("huge", 1, "auto")
+	assert.Error(t, err)
+	_, err = NewOptions("small", 1, "csv")
+	assert.Error(t, err)
+
+	assert.Equal(t, 10, Small.Rows(100))
+	assert.Equal(t, 100, Medium.Rows(100))
+	assert.Equal(t, 1000, Large.Rows(100))
+	assert.Equal(t, 1, Small.Rows(5))
+
+	opts, err := NewOptions("medium", 1, "batch")
+	require.NoError(t, err)
+	// 不同名字的随机数序列不一样
+	assert.NotEqual(t, opts.Rand("a").Uint64(), opts.Rand("b").Uint64())
+	assert.Equal(t, opts.Rand("a").Uint64(), opts.Rand("a").Uint64())
+}
+
+func TestWriteValue(t *testing.T) {
+	str := "x"
+	testCases := []struct {
+		name string
+		val  any
+		want string
+	}{
+		{name: "转义", val: "a\\b\tc\nd", want: `a\\b\tc\nd`},
+		{name: "整数", val: int32(12), want: "12"},
+		{name: "布尔", val: true, want: "1"},
+		{name: "空指针", val: (*string)(nil), want: `\N`},
+		{name: "指针", val: &str, want: "x"},
+		{name: "时间", val: time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local), want: "2024-01-02 03:04:05"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var sb strings.Builder
+			require.NoError(t, writeValue(&sb, tc.val))
+			assert.Equal(t, tc.want, sb.String())
+		})
+	}
+}
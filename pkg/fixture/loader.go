@@ -0,0 +1,246 @@
+package fixture
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Reset 建表并且清空数据，models 是 gorm 的模型
+func Reset(ctx context.Context, db *gorm.DB, models ...any) error {
+	db = db.WithContext(ctx)
+	err := db.AutoMigrate(models...)
+	if err != nil {
+		return err
+	}
+	for _, m := range models {
+		stmt := &gorm.Statement{DB: db}
+		err = stmt.Parse(m)
+		if err != nil {
+			return err
+		}
+		err = Truncate(db, stmt.Table)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Truncate 清空表，SQLite 没有 TRUNCATE，用 DELETE 再把自增主键重置
+func Truncate(db *gorm.DB, tables ...string) error {
+	for _, table := range tables {
+		var err error
+		switch db.Dialector.Name() {
+		case "sqlite":
+			err = db.Exec(fmt.Sprintf("DELETE FROM `%s`", table)).Error
+			if err == nil && db.Migrator().HasTable("sqlite_sequence") {
+				err = db.Exec("DELETE FROM sqlite_sequence WHERE name = ?", table).Error
+			}
+		default:
+			err = db.Exec(fmt.Sprintf("TRUNCATE TABLE `%s`", table)).Error
+		}
+		if err != nil {
+			return fmt.Errorf("清空表 %s 失败 %w", table, err)
+		}
+	}
+	return nil
+}
+
+// Load 生成 n 行数据写进数据库，gen 的第二个参数是行号，从 0 开始
+// 随机数序列以 name 区分，同一个 name 生成的数据总是一样的
+func Load[T any](ctx context.Context, db *gorm.DB, opts Options, name string, n int,
+	gen func(r *rand.Rand, i int) T) error {
+	r := opts.Rand(name)
+	return Write(ctx, db, opts, func(w *Writer[T]) error {
+		for i := 0; i < n; i++ {
+			err := w.Add(gen(r, i))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Write 在一个事务里面写入数据，适合行数事先不知道的情况，例如每个用户随机生成几个订单
+func Write[T any](ctx context.Context, db *gorm.DB, opts Options, fn func(w *Writer[T]) error) error {
+	// 写入的时候逐行打印 SQL 没有意义，还特别慢
+	db = db.WithContext(ctx).Session(&gorm.Session{Logger: db.Logger.LogMode(logger.Warn)})
+	return db.Transaction(func(tx *gorm.DB) error {
+		w, err := newWriter[T](tx, opts)
+		if err != nil {
+			return err
+		}
+		err = fn(w)
+		if err != nil {
+			return err
+		}
+		return w.flush()
+	})
+}
+
+// Writer 攒够一批之后再写入
+type Writer[T any] struct {
+	db        *gorm.DB
+	schema    *schema.Schema
+	batchSize int
+	loadData  bool
+	buf       []T
+}
+
+func newWriter[T any](db *gorm.DB, opts Options) (*Writer[T], error) {
+	stmt := &gorm.Statement{DB: db}
+	err := stmt.Parse(new(T))
+	if err != nil {
+		return nil, err
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	return &Writer[T]{
+		db:        db,
+		schema:    stmt.Schema,
+		batchSize: batchSize,
+		loadData:  opts.Method == MethodAuto && db.Dialector.Name() == "mysql",
+		buf:       make([]T, 0, batchSize),
+	}, nil
+}
+
+func (w *Writer[T]) Add(row T) error {
+	w.buf = append(w.buf, row)
+	if len(w.buf) < w.batchSize {
+		return nil
+	}
+	return w.flush()
+}
+
+func (w *Writer[T]) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	if w.loadData {
+		err := w.loadDataInfile()
+		if err == nil {
+			w.buf = w.buf[:0]
+			return nil
+		}
+		// 最常见的原因是服务端没有开启 local_infile，这一批和后面的数据都用 INSERT
+		slog.Warn("LOAD DATA 失败，改用多行 INSERT", slog.String("table", w.schema.Table), slog.Any("err", err))
+		w.loadData = false
+	}
+	err := w.db.Create(&w.buf).Error
+	if err != nil {
+		return fmt.Errorf("fixture: 写入 %s 失败 %w", w.schema.Table, err)
+	}
+	w.buf = w.buf[:0]
+	return nil
+}
+
+var readerSeq atomic.Int64
+
+// loadDataInfile 把这一批数据编码成制表符分隔的文本，通过驱动的 Reader:: 机制交给 LOAD DATA LOCAL INFILE
+// 要求服务端开启 local_infile，docker-compose.yml 里面的 MySQL 已经开启了
+func (w *Writer[T]) loadDataInfile() error {
+	fields := make([]*schema.Field, 0, len(w.schema.Fields))
+	cols := make([]string, 0, len(w.schema.Fields))
+	for _, f := range w.schema.Fields {
+		if f.DBName == "" || !f.Creatable {
+			continue
+		}
+		fields = append(fields, f)
+		cols = append(cols, "`"+f.DBName+"`")
+	}
+	var sb strings.Builder
+	ctx := w.db.Statement.Context
+	for i := range w.buf {
+		rv := reflect.ValueOf(&w.buf[i]).Elem()
+		for j, f := range fields {
+			if j > 0 {
+				sb.WriteByte('\t')
+			}
+			val, zero := f.ValueOf(ctx, rv)
+			// 自增主键写 NULL，让 MySQL 自己分配
+			if zero && f.AutoIncrement {
+				sb.WriteString(`\N`)
+				continue
+			}
+			err := writeValue(&sb, val)
+			if err != nil {
+				return fmt.Errorf("字段 %s %w", f.Name, err)
+			}
+		}
+		sb.WriteByte('\n')
+	}
+	name := "fixture_" + strconv.FormatInt(readerSeq.Add(1), 10)
+	data := sb.String()
+	mysqldriver.RegisterReaderHandler(name, func() io.Reader {
+		return strings.NewReader(data)
+	})
+	defer mysqldriver.DeregisterReaderHandler(name)
+	sql := fmt.Sprintf("LOAD DATA LOCAL INFILE 'Reader::%s' INTO TABLE `%s` CHARACTER SET utf8mb4 "+
+		"FIELDS TERMINATED BY '\\t' ESCAPED BY '\\\\' LINES TERMINATED BY '\\n' (%s)",
+		name, w.schema.Table, strings.Join(cols, ","))
+	return w.db.Exec(sql).Error
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`, "\x00", `\0`)
+
+func writeValue(sb *strings.Builder, val any) error {
+	if v, ok := val.(driver.Valuer); ok {
+		var err error
+		val, err = v.Value()
+		if err != nil {
+			return err
+		}
+	}
+	rv := reflect.ValueOf(val)
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			sb.WriteString(`\N`)
+			return nil
+		}
+		rv = rv.Elem()
+		val = rv.Interface()
+	}
+	switch v := val.(type) {
+	case nil:
+		sb.WriteString(`\N`)
+	case string:
+		sb.WriteString(escaper.Replace(v))
+	case []byte:
+		sb.WriteString(escaper.Replace(string(v)))
+	case bool:
+		if v {
+			sb.WriteByte('1')
+		} else {
+			sb.WriteByte('0')
+		}
+	case time.Time:
+		// 和驱动默认的 loc=Local 保持一致
+		sb.WriteString(v.Local().Format("2006-01-02 15:04:05.999999"))
+	default:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			_, _ = fmt.Fprint(sb, v)
+		default:
+			return fmt.Errorf("不支持的类型 %T", val)
+		}
+	}
+	return nil
+}
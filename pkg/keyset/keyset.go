@@ -0,0 +1,307 @@
+// Package keyset 基于 gorm 的游标分页（keyset pagination，也叫 seek method）
+//
+// 和 LIMIT offset, n 不一样，游标分页记住的是上一页最后一行排序列的值，
+// 下一页直接从这个值开始查，例如 WHERE (buyer, id) > (?, ?) ORDER BY buyer, id LIMIT n，
+// 只要排序列上有索引，不管翻到第几页都只需要扫描 n 行
+package keyset
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+)
+
+var (
+	// ErrInvalidCursor 游标格式不对、被篡改过，或者不是同一种排序生成的
+	ErrInvalidCursor = errors.New("keyset: 游标不合法")
+	// ErrNotUnique 最后一个排序列必须是主键或者唯一索引，否则排序列的值一样的行会被跳过或者重复返回
+	ErrNotUnique = errors.New("keyset: 最后一个排序列必须是唯一的")
+)
+
+// Column 排序列，Name 是数据库里面的列名
+type Column struct {
+	Name string
+	Desc bool
+}
+
+func Asc(name string) Column {
+	return Column{Name: name}
+}
+
+func Desc(name string) Column {
+	return Column{Name: name, Desc: true}
+}
+
+// Paginator 描述了一种排序方式，可以并发使用
+// 例如买家的订单列表按照下单时间倒序：New(secret, Asc("buyer"), Desc("ctime"), Asc("id"))
+type Paginator struct {
+	secret  []byte
+	columns []Column
+	// signature 排序方式的描述，参与签名，这样别的排序方式生成的游标不能混用
+	signature string
+}
+
+// New secret 用来给游标签名，防止客户端篡改游标的内容
+// 最后一个排序列必须是唯一的，一般就是主键，这样排序列的值完全一样的行也有确定的先后顺序
+// 排序列不能有 NULL，因为 NULL 和任何值比较的结果都不是 true
+func New(secret []byte, columns ...Column) *Paginator {
+	parts := make([]string, 0, len(columns))
+	for _, c := range columns {
+		if c.Desc {
+			parts = append(parts, c.Name+" desc")
+		} else {
+			parts = append(parts, c.Name)
+		}
+	}
+	return &Paginator{
+		secret:    secret,
+		columns:   columns,
+		signature: strings.Join(parts, ","),
+	}
+}
+
+// Page 一页数据，Next 和 Prev 为空说明没有下一页或者上一页
+type Page[T any] struct {
+	Items []T
+	Next  string
+	Prev  string
+}
+
+const (
+	forward  = "n"
+	backward = "p"
+)
+
+// token 游标里面的内容，Values 是锚点行的排序列的值
+type token struct {
+	Dir    string            `json:"d"`
+	Values []json.RawMessage `json:"v"`
+}
+
+// Paginate 返回 cursor 指向的那一页，cursor 为空返回第一页
+// db 可以带上过滤条件，例如 db.Where("buyer = ?", 11)，但是不能带 ORDER BY 和 LIMIT
+func Paginate[T any](db *gorm.DB, p *Paginator, cursor string, limit int) (Page[T], error) {
+	if limit <= 0 {
+		return Page[T]{}, fmt.Errorf("keyset: limit 必须大于 0")
+	}
+	fields, err := p.fields(db, new(T))
+	if err != nil {
+		return Page[T]{}, err
+	}
+	dir := forward
+	var values []any
+	if cursor != "" {
+		var tk token
+		tk, err = p.decode(cursor)
+		if err != nil {
+			return Page[T]{}, err
+		}
+		dir = tk.Dir
+		values, err = decodeValues(tk.Values, fields)
+		if err != nil {
+			return Page[T]{}, err
+		}
+	}
+
+	// 往回翻页的时候排序方向全部反过来，查完之后再倒过来
+	reverse := dir == backward
+	tx := db.Session(&gorm.Session{})
+	if values != nil {
+		tx = tx.Where(p.seek(values, reverse))
+	}
+	for _, c := range p.columns {
+		tx = tx.Order(clause.OrderByColumn{
+			Column: clause.Column{Table: clause.CurrentTable, Name: c.Name},
+			Desc:   c.Desc != reverse,
+		})
+	}
+	// 多查一行，用来判断后面还有没有数据
+	var items []T
+	err = tx.Limit(limit + 1).Find(&items).Error
+	if err != nil {
+		return Page[T]{}, err
+	}
+	more := len(items) > limit
+	if more {
+		items = items[:limit]
+	}
+	if reverse {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	page := Page[T]{Items: items}
+	if len(items) == 0 {
+		return page, nil
+	}
+	// 正着翻的时候，有游标说明前面还有数据；倒着翻的时候，游标所在的行就在后面
+	hasNext, hasPrev := more, cursor != ""
+	if reverse {
+		hasNext, hasPrev = true, more
+	}
+	if hasNext {
+		page.Next, err = p.encode(db, fields, forward, items[len(items)-1])
+		if err != nil {
+			return Page[T]{}, err
+		}
+	}
+	if hasPrev {
+		page.Prev, err = p.encode(db, fields, backward, items[0])
+		if err != nil {
+			return Page[T]{}, err
+		}
+	}
+	return page, nil
+}
+
+// Cursor 生成指向 item 之后那一页的游标，例如从某一行开始往后翻页
+func Cursor(db *gorm.DB, p *Paginator, item any) (string, error) {
+	fields, err := p.fields(db, item)
+	if err != nil {
+		return "", err
+	}
+	return p.encode(db, fields, forward, item)
+}
+
+// fields 找到排序列对应的字段，并且检查最后一列是不是唯一的
+func (p *Paginator) fields(db *gorm.DB, model any) ([]*schema.Field, error) {
+	if len(p.columns) == 0 {
+		return nil, errors.New("keyset: 至少需要一个排序列")
+	}
+	stmt := &gorm.Statement{DB: db}
+	err := stmt.Parse(model)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]*schema.Field, 0, len(p.columns))
+	for _, c := range p.columns {
+		f := stmt.Schema.LookUpField(c.Name)
+		if f == nil {
+			return nil, fmt.Errorf("keyset: %s 没有字段 %s", stmt.Schema.Table, c.Name)
+		}
+		fields = append(fields, f)
+	}
+	last := fields[len(fields)-1]
+	if !last.PrimaryKey && !last.Unique {
+		return nil, fmt.Errorf("%w: %s", ErrNotUnique, last.DBName)
+	}
+	return fields, nil
+}
+
+// seek 生成 "排在锚点后面" 的条件
+// 方向都一样的时候直接用行构造器 (a, b) > (?, ?)，方向不一样的时候展开成 a > ? OR (a = ? AND b < ?) 这种形式。
+// 不管哪一种，前面都会再加上一个冗余的 a >= ?，因为优化器不一定能把上面两种写法转换成索引上的范围查询
+func (p *Paginator) seek(values []any, reverse bool) clause.Expression {
+	same := true
+	for _, c := range p.columns[1:] {
+		if c.Desc != p.columns[0].Desc {
+			same = false
+			break
+		}
+	}
+	cols := make([]any, 0, len(p.columns))
+	for _, c := range p.columns {
+		cols = append(cols, clause.Column{Table: clause.CurrentTable, Name: c.Name})
+	}
+	bound := clause.Expr{SQL: "? " + p.op(p.columns[0], reverse) + "= ?", Vars: []any{cols[0], values[0]}}
+	if len(p.columns) == 1 {
+		return clause.Expr{SQL: "? " + p.op(p.columns[0], reverse) + " ?", Vars: []any{cols[0], values[0]}}
+	}
+	if same {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(cols)), ",")
+		op := p.op(p.columns[0], reverse)
+		return clause.And(bound, clause.Expr{
+			SQL:  fmt.Sprintf("(%s) %s (%s)", placeholders, op, placeholders),
+			Vars: append(cols, values...),
+		})
+	}
+	ors := make([]clause.Expression, 0, len(p.columns))
+	for i, c := range p.columns {
+		ands := make([]clause.Expression, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, clause.Expr{SQL: "? = ?", Vars: []any{cols[j], values[j]}})
+		}
+		ands = append(ands, clause.Expr{SQL: "? " + p.op(c, reverse) + " ?", Vars: []any{cols[i], values[i]}})
+		ors = append(ors, clause.And(ands...))
+	}
+	return clause.And(bound, clause.Or(ors...))
+}
+
+func (p *Paginator) op(c Column, reverse bool) string {
+	if c.Desc != reverse {
+		return "<"
+	}
+	return ">"
+}
+
+func (p *Paginator) encode(db *gorm.DB, fields []*schema.Field, dir string, item any) (string, error) {
+	rv := reflect.Indirect(reflect.ValueOf(item))
+	tk := token{Dir: dir, Values: make([]json.RawMessage, 0, len(fields))}
+	for _, f := range fields {
+		val, _ := f.ValueOf(db.Statement.Context, rv)
+		data, err := json.Marshal(val)
+		if err != nil {
+			return "", fmt.Errorf("keyset: 编码 %s 失败 %w", f.DBName, err)
+		}
+		tk.Values = append(tk.Values, data)
+	}
+	payload, err := json.Marshal(tk)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(p.sign(payload)), nil
+}
+
+func (p *Paginator) decode(cursor string) (token, error) {
+	enc := base64.RawURLEncoding
+	payloadStr, sigStr, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return token{}, ErrInvalidCursor
+	}
+	payload, err := enc.DecodeString(payloadStr)
+	if err != nil {
+		return token{}, ErrInvalidCursor
+	}
+	sig, err := enc.DecodeString(sigStr)
+	if err != nil || !hmac.Equal(sig, p.sign(payload)) {
+		return token{}, ErrInvalidCursor
+	}
+	var tk token
+	err = json.Unmarshal(payload, &tk)
+	if err != nil || len(tk.Values) != len(p.columns) || (tk.Dir != forward && tk.Dir != backward) {
+		return token{}, ErrInvalidCursor
+	}
+	return tk, nil
+}
+
+func (p *Paginator) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(p.signature))
+	mac.Write([]byte{0})
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// decodeValues 按照字段的类型解码，这样 int64 不会丢精度，time.Time 也能还原
+func decodeValues(raw []json.RawMessage, fields []*schema.Field) ([]any, error) {
+	values := make([]any, 0, len(raw))
+	for i, f := range fields {
+		ptr := reflect.New(f.FieldType)
+		err := json.Unmarshal(raw[i], ptr.Interface())
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		values = append(values, ptr.Elem().Interface())
+	}
+	return values, nil
+}
@@ -0,0 +1,186 @@
+package keyset
+
+import (
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"strings"
+	"testing"
+)
+
+type keysetOrder struct {
+	ID    int64
+	Buyer int64
+	Ctime int64
+	SN    string
+}
+
+func initDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&keysetOrder{}))
+	orders := make([]keysetOrder, 0, 100)
+	for i := 0; i < 100; i++ {
+		// 只有 10 种 ctime，所以同一个买家有很多 ctime 一样的订单
+		orders = append(orders, keysetOrder{Buyer: int64(i % 3), Ctime: int64(i % 10), SN: "sn"})
+	}
+	require.NoError(t, db.Create(&orders).Error)
+	return db
+}
+
+func TestPaginate(t *testing.T) {
+	db := initDB(t)
+	testCases := []struct {
+		name    string
+		columns []Column
+		order   string
+	}{
+		{
+			name:    "方向不一样",
+			columns: []Column{Asc("buyer"), Desc("ctime"), Asc("id")},
+			order:   "buyer, ctime DESC, id",
+		},
+		{
+			name:    "方向一样",
+			columns: []Column{Desc("ctime"), Desc("id")},
+			order:   "ctime DESC, id DESC",
+		},
+		{
+			name:    "只有一列",
+			columns: []Column{Desc("id")},
+			order:   "id DESC",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := New([]byte("secret"), tc.columns...)
+			// 开启新的 Session，不然后面的 Order 会修改 query 本身
+			query := db.Where("buyer <> ?", 2).Session(&gorm.Session{})
+			var want []keysetOrder
+			require.NoError(t, query.Order(tc.order).Find(&want).Error)
+
+			// 往后翻到最后一页
+			var pages []Page[keysetOrder]
+			var got []keysetOrder
+			cursor := ""
+			for {
+				page, err := Paginate[keysetOrder](query, p, cursor, 7)
+				require.NoError(t, err)
+				pages = append(pages, page)
+				got = append(got, page.Items...)
+				if page.Next == "" {
+					break
+				}
+				cursor = page.Next
+			}
+			assert.Equal(t, want, got)
+			assert.Empty(t, pages[0].Prev)
+			assert.Len(t, pages, (len(want)+6)/7)
+
+			// 再从最后一页往前翻，每一页都应该和往后翻的时候一样
+			for i := len(pages) - 1; i > 0; i-- {
+				require.NotEmpty(t, pages[i].Prev)
+				page, err := Paginate[keysetOrder](query, p, pages[i].Prev, 7)
+				require.NoError(t, err)
+				assert.Equal(t, pages[i-1].Items, page.Items)
+				assert.NotEmpty(t, page.Next)
+				if i == 1 {
+					assert.Empty(t, page.Prev)
+				}
+			}
+		})
+	}
+}
+
+// TestSeek 多列的时候在第一列上再加一个冗余的范围条件，优化器才能用上索引的范围扫描
+func TestSeek(t *testing.T) {
+	db := initDB(t).Session(&gorm.Session{DryRun: true})
+	testCases := []struct {
+		name    string
+		columns []Column
+		values  []any
+		reverse bool
+		want    string
+	}{
+		{
+			name:    "只有一列",
+			columns: []Column{Asc("id")},
+			values:  []any{1},
+			want:    "WHERE `keyset_orders`.`id` > ?",
+		},
+		{
+			name:    "方向一样",
+			columns: []Column{Desc("ctime"), Desc("id")},
+			values:  []any{1, 2},
+			want:    "WHERE `keyset_orders`.`ctime` <= ? AND (`keyset_orders`.`ctime`,`keyset_orders`.`id`) < (?,?)",
+		},
+		{
+			name:    "方向不一样",
+			columns: []Column{Asc("buyer"), Desc("ctime")},
+			values:  []any{1, 2},
+			want: "WHERE `keyset_orders`.`buyer` >= ? AND (`keyset_orders`.`buyer` > ? OR " +
+				"(`keyset_orders`.`buyer` = ? AND `keyset_orders`.`ctime` < ?))",
+		},
+		{
+			name:    "往前翻",
+			columns: []Column{Asc("buyer"), Desc("ctime")},
+			values:  []any{1, 2},
+			reverse: true,
+			want: "WHERE `keyset_orders`.`buyer` <= ? AND (`keyset_orders`.`buyer` < ? OR " +
+				"(`keyset_orders`.`buyer` = ? AND `keyset_orders`.`ctime` > ?))",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := New([]byte("secret"), tc.columns...)
+			stmt := db.Where(p.seek(tc.values, tc.reverse)).Find(&[]keysetOrder{}).Statement
+			assert.Contains(t, stmt.SQL.String(), tc.want)
+		})
+	}
+}
+
+func TestCursor(t *testing.T) {
+	db := initDB(t)
+	p := New([]byte("secret"), Asc("buyer"), Desc("ctime"), Asc("id"))
+	var anchor keysetOrder
+	require.NoError(t, db.Where("id = ?", 50).First(&anchor).Error)
+	cursor, err := Cursor(db, p, anchor)
+	require.NoError(t, err)
+	page, err := Paginate[keysetOrder](db, p, cursor, 3)
+	require.NoError(t, err)
+	var all []keysetOrder
+	require.NoError(t, db.Order("buyer, ctime DESC, id").Find(&all).Error)
+	for i, o := range all {
+		if o.ID == anchor.ID {
+			assert.Equal(t, all[i+1:i+4], page.Items)
+		}
+	}
+
+	// 篡改内容、换一个密钥或者换一种排序方式都不行
+	payload, sig, _ := strings.Cut(cursor, ".")
+	invalid := []string{
+		"abc",
+		payload + "x." + sig,
+		payload + "." + sig[1:],
+	}
+	other, err := Cursor(db, New([]byte("secret"), Asc("buyer"), Asc("ctime"), Asc("id")), anchor)
+	require.NoError(t, err)
+	invalid = append(invalid, other)
+	other, err = Cursor(db, New([]byte("other"), Asc("buyer"), Desc("ctime"), Asc("id")), anchor)
+	require.NoError(t, err)
+	invalid = append(invalid, other)
+	for _, c := range invalid {
+		_, err = Paginate[keysetOrder](db, p, c, 3)
+		assert.ErrorIs(t, err, ErrInvalidCursor, c)
+	}
+
+	// 最后一列不唯一的时候，ctime 一样的行会被跳过
+	_, err = Paginate[keysetOrder](db, New([]byte("secret"), Asc("buyer"), Desc("ctime")), "", 3)
+	assert.ErrorIs(t, err, ErrNotUnique)
+	_, err = Paginate[keysetOrder](db, New([]byte("secret"), Asc("price"), Asc("id")), "", 3)
+	assert.Error(t, err)
+}
@@ -0,0 +1,115 @@
+package confluent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"interview-cases/pkg/mq"
+	"time"
+)
+
+// readerGroup Reader 不会提交，但是 librdkafka 的消费者必须有 group.id
+const readerGroup = "mq-reader"
+
+func (b *Broker) Watermarks(ctx context.Context, tp mq.TopicPartition) (int64, int64, error) {
+	c, err := b.newConsumer(readerGroup)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer c.Close()
+	return c.QueryWatermarkOffsets(tp.Topic, tp.Partition, timeoutMs(ctx))
+}
+
+func (b *Broker) OffsetForTime(ctx context.Context, tp mq.TopicPartition, ts time.Time) (int64, error) {
+	c, err := b.newConsumer(readerGroup)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	// 查询的时候 Offset 里面放的是毫秒时间戳
+	res, err := c.OffsetsForTimes([]kafka.TopicPartition{toKafkaOffset(tp, ts.UnixMilli())}, timeoutMs(ctx))
+	if err != nil {
+		return 0, err
+	}
+	if len(res) == 1 && res[0].Error != nil {
+		return 0, res[0].Error
+	}
+	if len(res) == 1 && res[0].Offset >= 0 {
+		return int64(res[0].Offset), nil
+	}
+	// 没有时间戳不早于 ts 的消息
+	_, high, err := c.QueryWatermarkOffsets(tp.Topic, tp.Partition, timeoutMs(ctx))
+	return high, err
+}
+
+func (b *Broker) Committed(ctx context.Context, groupID string, tps ...mq.TopicPartition) (map[mq.TopicPartition]int64, error) {
+	c, err := b.newConsumer(groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	committed, err := c.Committed(toKafkaPartitions(tps), timeoutMs(ctx))
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[mq.TopicPartition]int64, len(committed))
+	for _, tp := range committed {
+		// 没有提交过是 kafka.OffsetInvalid
+		if tp.Offset >= 0 {
+			res[mq.TopicPartition{Topic: *tp.Topic, Partition: tp.Partition}] = int64(tp.Offset)
+		}
+	}
+	return res, nil
+}
+
+// CommitOffsets 不加入消费者组直接提交，Kafka 只允许在消费者组为空的时候这么做，
+// 不为空的时候 Kafka 会返回 UNKNOWN_MEMBER_ID
+func (b *Broker) CommitOffsets(ctx context.Context, groupID string, offsets map[mq.TopicPartition]int64) error {
+	c, err := b.newConsumer(groupID)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	tps := make([]kafka.TopicPartition, 0, len(offsets))
+	for tp, off := range offsets {
+		tps = append(tps, toKafkaOffset(tp, off))
+	}
+	res, err := c.CommitOffsets(tps)
+	var kerr kafka.Error
+	if errors.As(err, &kerr) && kerr.Code() == kafka.ErrUnknownMemberID {
+		return fmt.Errorf("%w: %s %w", mq.ErrGroupActive, groupID, err)
+	}
+	if err != nil {
+		return err
+	}
+	for _, tp := range res {
+		if tp.Error != nil {
+			return fmt.Errorf("提交 %s/%d 的偏移量失败 %w", *tp.Topic, tp.Partition, tp.Error)
+		}
+	}
+	return nil
+}
+
+func (b *Broker) Reader(tp mq.TopicPartition, offset int64) (mq.Reader, error) {
+	c, err := b.newConsumer(readerGroup)
+	if err != nil {
+		return nil, err
+	}
+	err = c.Assign([]kafka.TopicPartition{toKafkaOffset(tp, offset)})
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	// 只用到 Fetch 和 Close，所以复用 consumer
+	return &consumer{consumer: c}, nil
+}
+
+// newConsumer 不订阅任何 topic 的消费者，用来查询和提交偏移量
+func (b *Broker) newConsumer(groupID string) (*kafka.Consumer, error) {
+	return kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":  b.servers,
+		"group.id":           groupID,
+		"enable.auto.commit": "false",
+	})
+}
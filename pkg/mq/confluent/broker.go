@@ -0,0 +1,178 @@
+package confluent
+
+import (
+	"context"
+	"fmt"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"interview-cases/pkg/mq"
+	"strings"
+	"time"
+)
+
+// Broker 基于 confluent-kafka-go 的实现
+type Broker struct {
+	servers string
+}
+
+func NewBroker(brokers ...string) *Broker {
+	return &Broker{servers: strings.Join(brokers, ",")}
+}
+
+func (b *Broker) Producer() (mq.Producer, error) {
+	p, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers": b.servers,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &producer{producer: p}, nil
+}
+
+func (b *Broker) Consumer(groupID string, topics ...string) (mq.Consumer, error) {
+	c, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":  b.servers,
+		"group.id":           groupID,
+		"auto.offset.reset":  "earliest",
+		"enable.auto.commit": "false",
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := &consumer{consumer: c}
+	err = c.SubscribeTopics(topics, res.rebalance)
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return res, nil
+}
+
+func (b *Broker) CreateTopic(ctx context.Context, topic string, partitions int) error {
+	admin, err := kafka.NewAdminClient(&kafka.ConfigMap{
+		"bootstrap.servers": b.servers,
+	})
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	results, err := admin.CreateTopics(ctx, []kafka.TopicSpecification{
+		{
+			Topic:             topic,
+			NumPartitions:     partitions,
+			ReplicationFactor: 1,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("创建 topic %s 失败 %w", topic, err)
+	}
+	for _, res := range results {
+		if res.Error.Code() != kafka.ErrNoError && res.Error.Code() != kafka.ErrTopicAlreadyExists {
+			return fmt.Errorf("创建 topic %s 失败 %w", topic, res.Error)
+		}
+	}
+	return nil
+}
+
+func (b *Broker) Partitions(ctx context.Context, topic string) ([]int32, error) {
+	admin, err := kafka.NewAdminClient(&kafka.ConfigMap{
+		"bootstrap.servers": b.servers,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer admin.Close()
+	meta, err := admin.GetMetadata(&topic, false, timeoutMs(ctx))
+	if err != nil {
+		return nil, err
+	}
+	t, ok := meta.Topics[topic]
+	if !ok || t.Error.Code() != kafka.ErrNoError {
+		return nil, fmt.Errorf("%w %s", mq.ErrUnknownTopic, topic)
+	}
+	res := make([]int32, 0, len(t.Partitions))
+	for _, p := range t.Partitions {
+		res = append(res, p.ID)
+	}
+	return res, nil
+}
+
+func (b *Broker) Close() error {
+	return nil
+}
+
+// timeoutMs 把 ctx 的超时时间转换成 confluent 需要的毫秒数，没有超时时间就用 10 秒
+func timeoutMs(ctx context.Context) int {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return int((10 * time.Second).Milliseconds())
+	}
+	return int(time.Until(deadline).Milliseconds())
+}
+
+type producer struct {
+	producer *kafka.Producer
+}
+
+func (p *producer) Produce(ctx context.Context, msgs ...mq.Message) error {
+	// confluent 的 Produce 是异步的，所以这里要等待每条消息的发送结果
+	deliveryChan := make(chan kafka.Event, len(msgs))
+	for _, msg := range msgs {
+		err := p.producer.Produce(toKafkaMessage(msg), deliveryChan)
+		if err != nil {
+			return err
+		}
+	}
+	for i := 0; i < len(msgs); i++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e := <-deliveryChan:
+			m, ok := e.(*kafka.Message)
+			if ok && m.TopicPartition.Error != nil {
+				return m.TopicPartition.Error
+			}
+		}
+	}
+	return nil
+}
+
+func (p *producer) Close() error {
+	p.producer.Flush(int((10 * time.Second).Milliseconds()))
+	p.producer.Close()
+	return nil
+}
+
+func toKafkaMessage(msg mq.Message) *kafka.Message {
+	topic := msg.Topic
+	headers := make([]kafka.Header, 0, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers = append(headers, kafka.Header{Key: h.Key, Value: h.Value})
+	}
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{
+			Topic: &topic,
+			// mq.PartitionAny 和 kafka.PartitionAny 都是 -1
+			Partition: msg.Partition,
+		},
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   headers,
+		Timestamp: msg.Timestamp,
+	}
+}
+
+func fromKafkaMessage(msg *kafka.Message) mq.Message {
+	headers := make([]mq.Header, 0, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers = append(headers, mq.Header{Key: h.Key, Value: h.Value})
+	}
+	return mq.Message{
+		Topic:     *msg.TopicPartition.Topic,
+		Partition: msg.TopicPartition.Partition,
+		Offset:    int64(msg.TopicPartition.Offset),
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   headers,
+		Timestamp: msg.Timestamp,
+	}
+}
@@ -0,0 +1,107 @@
+package confluent
+
+import (
+	"context"
+	"errors"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"interview-cases/pkg/mq"
+	"sync/atomic"
+)
+
+// pollInterval 每次 ReadMessage 最多等多久，等完了会检查一下 ctx
+const pollInterval = 100
+
+type consumer struct {
+	consumer *kafka.Consumer
+	// generation 每次分配到分区加一，在 ReadMessage 里面回调的时候修改
+	generation atomic.Int64
+}
+
+// rebalance 没有调用 Assign，由 confluent-kafka-go 按照默认的方式分配
+func (c *consumer) rebalance(_ *kafka.Consumer, ev kafka.Event) error {
+	if _, ok := ev.(kafka.AssignedPartitions); ok {
+		c.generation.Add(1)
+	}
+	return nil
+}
+
+func (c *consumer) Fetch(ctx context.Context) (mq.Message, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return mq.Message{}, err
+		}
+		msg, err := c.consumer.ReadMessage(pollInterval)
+		var kerr kafka.Error
+		if errors.As(err, &kerr) && kerr.Code() == kafka.ErrTimedOut {
+			continue
+		}
+		if err != nil {
+			return mq.Message{}, err
+		}
+		return fromKafkaMessage(msg), nil
+	}
+}
+
+func (c *consumer) Commit(ctx context.Context, msgs ...mq.Message) error {
+	offsets := make(map[mq.TopicPartition]int64, len(msgs))
+	for _, msg := range msgs {
+		tp := msg.TopicPartition()
+		if off, ok := offsets[tp]; !ok || msg.Offset+1 > off {
+			offsets[tp] = msg.Offset + 1
+		}
+	}
+	tps := make([]kafka.TopicPartition, 0, len(offsets))
+	for tp, off := range offsets {
+		tps = append(tps, toKafkaOffset(tp, off))
+	}
+	_, err := c.consumer.CommitOffsets(tps)
+	return err
+}
+
+func (c *consumer) Pause(partitions ...mq.TopicPartition) error {
+	return c.consumer.Pause(toKafkaPartitions(partitions))
+}
+
+func (c *consumer) Resume(partitions ...mq.TopicPartition) error {
+	return c.consumer.Resume(toKafkaPartitions(partitions))
+}
+
+func (c *consumer) Seek(tp mq.TopicPartition, offset int64) error {
+	return c.consumer.Seek(toKafkaOffset(tp, offset), 0)
+}
+
+func (c *consumer) Assignment() ([]mq.TopicPartition, int64, error) {
+	// 先读 generation，这样读完分配之后又重新分配的话，下一次还会发现 generation 变了
+	gen := c.generation.Load()
+	tps, err := c.consumer.Assignment()
+	if err != nil {
+		return nil, 0, err
+	}
+	res := make([]mq.TopicPartition, 0, len(tps))
+	for _, tp := range tps {
+		res = append(res, mq.TopicPartition{Topic: *tp.Topic, Partition: tp.Partition})
+	}
+	return res, gen, nil
+}
+
+func (c *consumer) Close() error {
+	return c.consumer.Close()
+}
+
+func toKafkaPartitions(partitions []mq.TopicPartition) []kafka.TopicPartition {
+	res := make([]kafka.TopicPartition, 0, len(partitions))
+	for _, tp := range partitions {
+		topic := tp.Topic
+		res = append(res, kafka.TopicPartition{Topic: &topic, Partition: tp.Partition})
+	}
+	return res
+}
+
+func toKafkaOffset(tp mq.TopicPartition, offset int64) kafka.TopicPartition {
+	topic := tp.Topic
+	return kafka.TopicPartition{
+		Topic:     &topic,
+		Partition: tp.Partition,
+		Offset:    kafka.Offset(offset),
+	}
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"interview-cases/pkg/mq"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// AIMDOptions 和 TCP 的拥塞控制一样：成功的时候并发慢慢加，过载的时候并发直接减半
+type AIMDOptions struct {
+	// 并发在 Min 和 Max 之间调整，一开始是 Max
+	Min int
+	Max int
+	// Decrease 过载的时候并发乘以它
+	Decrease float64
+	// Cooldown 过载的时候暂停多久，下游给了 Retry-After 的时候用大的那个
+	Cooldown time.Duration
+}
+
+func DefaultAIMDOptions(max int) AIMDOptions {
+	return AIMDOptions{
+		Min:      1,
+		Max:      max,
+		Decrease: 0.5,
+		Cooldown: 100 * time.Millisecond,
+	}
+}
+
+// AIMD 根据下游的反馈调整并发：每次成功加 1/limit，也就是每一轮加一；
+// 下游过载（*OverloadError 或者超时）的时候并发乘以 Decrease，并且在冷却期里面谁都拿不到并发，
+// 冷却期里面再收到的过载只是延长冷却期，不会再减，不然同一批失败的请求会把并发一直减到 Min
+type AIMD struct {
+	opts AIMDOptions
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	until    time.Time
+	// changed 在释放并发的时候被关闭并替换，阻塞在 Acquire 上的调用方靠它来唤醒
+	changed chan struct{}
+}
+
+func NewAIMD(opts AIMDOptions) *AIMD {
+	return &AIMD{
+		opts:    opts,
+		limit:   float64(opts.Max),
+		changed: make(chan struct{}),
+	}
+}
+
+// Acquire 等到有空闲的并发并且不在冷却期里面
+func (l *AIMD) Acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		wait := time.Until(l.until)
+		if wait <= 0 && l.inflight < int(l.limit) {
+			l.inflight++
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		// 不在冷却期里面的时候只能等别人释放并发
+		if wait <= 0 {
+			wait = time.Hour
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Release 归还并发，err 是这一次调用的结果
+func (l *AIMD) Release(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	retryAfter, overload := IsOverload(err)
+	switch {
+	case overload || errors.Is(err, context.DeadlineExceeded):
+		now := time.Now()
+		until := now.Add(max(retryAfter, l.opts.Cooldown))
+		if now.After(l.until) {
+			old := int(l.limit)
+			l.limit = max(l.limit*l.opts.Decrease, float64(l.opts.Min))
+			slog.Warn("下游过载，降低并发并暂停", slog.Int("from", old), slog.Int("to", int(l.limit)),
+				slog.Duration("pause", until.Sub(now)))
+		}
+		if until.After(l.until) {
+			l.until = until
+		}
+	case err == nil:
+		l.limit = min(l.limit+1/l.limit, float64(l.opts.Max))
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// Cancel 拿到并发之后没有调用下游，例如没有拉取到消息，归还并发但是不调整
+func (l *AIMD) Cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// Limit 当前的并发上限
+func (l *AIMD) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// Saturated 并发用完了或者在冷却期里面，这个时候消费者应该暂停拉取消息
+func (l *AIMD) Saturated() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inflight >= int(l.limit) || time.Now().Before(l.until)
+}
+
+// Middleware 每次调用之前拿到并发，调用完了根据结果调整
+func (l *AIMD) Middleware() Middleware {
+	return func(ctx context.Context, msgs []mq.Message, next func(ctx context.Context) error) error {
+		if err := l.Acquire(ctx); err != nil {
+			return err
+		}
+		err := next(ctx)
+		l.Release(err)
+		return err
+	}
+}
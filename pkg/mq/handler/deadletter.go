@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"interview-cases/pkg/mq"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// 死信消息的头部，记录了原始的位置和失败的原因，方便排查之后重新投递
+const (
+	HeaderOriginTopic     = "x-origin-topic"
+	HeaderOriginPartition = "x-origin-partition"
+	HeaderOriginOffset    = "x-origin-offset"
+	HeaderError           = "x-error"
+	HeaderAttempts        = "x-attempts"
+	HeaderFailedAt        = "x-failed-at"
+)
+
+// DeadLetter 把重试之后还是失败的消息发到死信 topic
+type DeadLetter struct {
+	producer mq.Producer
+	topic    string
+}
+
+func NewDeadLetter(producer mq.Producer, topic string) *DeadLetter {
+	return &DeadLetter{producer: producer, topic: topic}
+}
+
+// Send Key、Value 和原来的头部都保留，再加上原始的位置和失败的原因
+func (d *DeadLetter) Send(ctx context.Context, msg mq.Message, attempts int, cause error) error {
+	headers := slices.Clone(msg.Headers)
+	headers = append(headers,
+		mq.Header{Key: HeaderOriginTopic, Value: []byte(msg.Topic)},
+		mq.Header{Key: HeaderOriginPartition, Value: []byte(strconv.Itoa(int(msg.Partition)))},
+		mq.Header{Key: HeaderOriginOffset, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		mq.Header{Key: HeaderError, Value: []byte(cause.Error())},
+		mq.Header{Key: HeaderAttempts, Value: []byte(strconv.Itoa(attempts))},
+		mq.Header{Key: HeaderFailedAt, Value: []byte(strconv.FormatInt(time.Now().UnixMilli(), 10))},
+	)
+	err := d.producer.Produce(ctx, mq.Message{
+		Topic:     d.topic,
+		Partition: mq.PartitionAny,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   headers,
+	})
+	if err != nil {
+		return fmt.Errorf("发送死信消息失败 offset %d, topic %s, 原因 %w", msg.Offset, msg.Topic, err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"interview-cases/pkg/mq"
+)
+
+// GormHandler 不经过业务服务器，把消息的 Value 按照 JSON 解析成 T 之后直接插入数据库
+type GormHandler[T any] struct {
+	db *gorm.DB
+}
+
+func NewGormHandler[T any](db *gorm.DB) *GormHandler[T] {
+	return &GormHandler[T]{db: db}
+}
+
+func (h *GormHandler[T]) Handle(ctx context.Context, msg mq.Message) error {
+	t, err := decode[T](msg)
+	if err != nil {
+		return err
+	}
+	return h.db.WithContext(ctx).Create(&t).Error
+}
+
+// HandleBatch 解析成功的消息用一条 INSERT 语句插入，解析失败的消息通过 *BatchError 返回
+// 插入失败的话整批都算失败
+func (h *GormHandler[T]) HandleBatch(ctx context.Context, msgs []mq.Message) error {
+	ts := make([]T, 0, len(msgs))
+	failed := make(map[int]error)
+	for i, msg := range msgs {
+		t, err := decode[T](msg)
+		if err != nil {
+			failed[i] = err
+			continue
+		}
+		ts = append(ts, t)
+	}
+	if len(ts) > 0 {
+		err := h.db.WithContext(ctx).Create(&ts).Error
+		if err != nil {
+			return err
+		}
+	}
+	if len(failed) > 0 {
+		return &BatchError{Failed: failed}
+	}
+	return nil
+}
+
+func decode[T any](msg mq.Message) (T, error) {
+	var t T
+	err := json.Unmarshal(msg.Value, &t)
+	if err != nil {
+		return t, fmt.Errorf("%w: 解析消息失败 offset %d, topic %s, 原因 %w", ErrNonRetryable, msg.Offset, msg.Topic, err)
+	}
+	return t, nil
+}
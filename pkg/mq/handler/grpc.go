@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"interview-cases/pkg/mq"
+)
+
+// GRPCHandler 把消息的 Value 当作已经序列化好的 protobuf 请求，直接调用 method，例如 /article.ArticleService/Save
+// 不需要生成的客户端代码，响应也不解析，只看有没有出错
+type GRPCHandler struct {
+	conn   grpc.ClientConnInterface
+	method string
+}
+
+func NewGRPCHandler(conn grpc.ClientConnInterface, method string) *GRPCHandler {
+	return &GRPCHandler{conn: conn, method: method}
+}
+
+func (h *GRPCHandler) Handle(ctx context.Context, msg mq.Message) error {
+	return invoke(ctx, h.conn, h.method, msg.Value)
+}
+
+// GRPCBatchHandler encode 负责把一批消息拼成批量接口的请求，例如一个 repeated 字段
+type GRPCBatchHandler struct {
+	conn   grpc.ClientConnInterface
+	method string
+	encode func(msgs []mq.Message) ([]byte, error)
+}
+
+func NewGRPCBatchHandler(conn grpc.ClientConnInterface, method string,
+	encode func(msgs []mq.Message) ([]byte, error)) *GRPCBatchHandler {
+	return &GRPCBatchHandler{conn: conn, method: method, encode: encode}
+}
+
+func (h *GRPCBatchHandler) HandleBatch(ctx context.Context, msgs []mq.Message) error {
+	req, err := h.encode(msgs)
+	if err != nil {
+		return fmt.Errorf("%w: 编码批量请求失败 %w", ErrNonRetryable, err)
+	}
+	return invoke(ctx, h.conn, h.method, req)
+}
+
+func invoke(ctx context.Context, conn grpc.ClientConnInterface, method string, req []byte) error {
+	err := conn.Invoke(ctx, method, &rawFrame{data: req}, &rawFrame{}, grpc.ForceCodec(rawCodec{}))
+	if err == nil {
+		return nil
+	}
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.PermissionDenied,
+		codes.FailedPrecondition, codes.OutOfRange, codes.Unimplemented, codes.Unauthenticated:
+		return fmt.Errorf("%w: %w", ErrNonRetryable, err)
+	case codes.ResourceExhausted:
+		return &OverloadError{Err: err}
+	}
+	return err
+}
+
+// rawFrame 请求和响应都是原始的字节
+type rawFrame struct {
+	data []byte
+}
+
+// rawCodec 不做任何编解码。名字是 proto，这样服务端还是按照 application/grpc+proto 来解析请求
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	f, ok := v.(*rawFrame)
+	if !ok {
+		return nil, fmt.Errorf("handler: 不支持的类型 %T", v)
+	}
+	return f.data, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	f, ok := v.(*rawFrame)
+	if !ok {
+		return fmt.Errorf("handler: 不支持的类型 %T", v)
+	}
+	f.data = append(f.data[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
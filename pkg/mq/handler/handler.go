@@ -0,0 +1,134 @@
+// Package handler 把消费者和业务逻辑解耦：消费者只负责拉取、提交，业务逻辑实现 Handler 或者 BatchHandler，
+// 现成的实现有 HTTP、gRPC 和直接写数据库三种。超时、重试、日志和监控这些通用的逻辑做成 Middleware，
+// 同一个 Middleware 既可以用在 Handler 上，也可以用在 BatchHandler 上
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"interview-cases/pkg/mq"
+	"time"
+)
+
+// ErrNonRetryable 重试也不会成功的错误，例如消息格式不对、参数错误
+var ErrNonRetryable = errors.New("handler: 不可重试的错误")
+
+// Handler 处理一条消息，返回 nil 就说明处理完了，可以提交
+type Handler interface {
+	Handle(ctx context.Context, msg mq.Message) error
+}
+
+type HandlerFunc func(ctx context.Context, msg mq.Message) error
+
+func (f HandlerFunc) Handle(ctx context.Context, msg mq.Message) error {
+	return f(ctx, msg)
+}
+
+// BatchHandler 一次处理一批消息，要么都成功，要么都失败
+// 能够知道每条消息结果的实现，在部分消息失败的时候返回 *BatchError
+type BatchHandler interface {
+	HandleBatch(ctx context.Context, msgs []mq.Message) error
+}
+
+// BatchError 一批里面只有 Failed 里面的消息失败了，其他的消息都已经处理完了
+// Failed 的 key 是消息在这一批里面的下标，参数错误之类的失败要包装 ErrNonRetryable
+type BatchError struct {
+	Failed map[int]error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("handler: 一批消息里面有 %d 条处理失败", len(e.Failed))
+}
+
+// OverloadError 下游过载了，例如 HTTP 的 429 和 gRPC 的 ResourceExhausted
+// 这不是消息本身的问题，不应该进死信队列，调用方应该降低并发，至少等 RetryAfter 之后再重试
+type OverloadError struct {
+	// RetryAfter 下游建议等多久，没有建议的时候是 0
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *OverloadError) Error() string {
+	return fmt.Sprintf("handler: 下游过载，建议等待 %s 之后重试 %v", e.RetryAfter, e.Err)
+}
+
+func (e *OverloadError) Unwrap() error {
+	return e.Err
+}
+
+// IsOverload err 里面有 *OverloadError 的时候返回下游建议等多久
+func IsOverload(err error) (time.Duration, bool) {
+	var oe *OverloadError
+	if errors.As(err, &oe) {
+		return oe.RetryAfter, true
+	}
+	return 0, false
+}
+
+type BatchHandlerFunc func(ctx context.Context, msgs []mq.Message) error
+
+func (f BatchHandlerFunc) HandleBatch(ctx context.Context, msgs []mq.Message) error {
+	return f(ctx, msgs)
+}
+
+// Middleware 和 gRPC 的拦截器一样，在 next 前后加上自己的逻辑
+// 单条消息的时候 msgs 里面只有这一条
+type Middleware func(ctx context.Context, msgs []mq.Message, next func(ctx context.Context) error) error
+
+// Wrap 第一个 Middleware 在最外层
+func Wrap(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		mw, next := mws[i], h
+		h = HandlerFunc(func(ctx context.Context, msg mq.Message) error {
+			return mw(ctx, []mq.Message{msg}, func(ctx context.Context) error {
+				return next.Handle(ctx, msg)
+			})
+		})
+	}
+	return h
+}
+
+// WrapBatch 第一个 Middleware 在最外层
+func WrapBatch(h BatchHandler, mws ...Middleware) BatchHandler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		mw, next := mws[i], h
+		h = BatchHandlerFunc(func(ctx context.Context, msgs []mq.Message) error {
+			return mw(ctx, msgs, func(ctx context.Context) error {
+				return next.HandleBatch(ctx, msgs)
+			})
+		})
+	}
+	return h
+}
+
+// RetryOptions 重试策略
+type RetryOptions struct {
+	// MaxAttempts 最多执行多少次，包括第一次
+	MaxAttempts int
+	// Backoff 第一次重试之前等多久，之后每次乘以 Multiplier，最多等 MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Multiplier float64
+}
+
+func DefaultRetryOptions() RetryOptions {
+	return RetryOptions{
+		MaxAttempts: 3,
+		Backoff:     100 * time.Millisecond,
+		MaxBackoff:  2 * time.Second,
+		Multiplier:  2,
+	}
+}
+
+// Delay 第 attempt 次失败之后等多久，attempt 从 1 开始
+func (o RetryOptions) Delay(attempt int) time.Duration {
+	d := float64(o.Backoff)
+	for i := 1; i < attempt; i++ {
+		d *= o.Multiplier
+		if d >= float64(o.MaxBackoff) {
+			return o.MaxBackoff
+		}
+	}
+	return time.Duration(d)
+}
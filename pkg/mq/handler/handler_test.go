@@ -0,0 +1,314 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"interview-cases/pkg/mq"
+	"interview-cases/test"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWrap(t *testing.T) {
+	var trace []string
+	record := func(name string) Middleware {
+		return func(ctx context.Context, msgs []mq.Message, next func(ctx context.Context) error) error {
+			trace = append(trace, fmt.Sprintf("%s:%d", name, len(msgs)))
+			return next(ctx)
+		}
+	}
+	h := Wrap(HandlerFunc(func(ctx context.Context, msg mq.Message) error {
+		trace = append(trace, "handle:"+string(msg.Value))
+		return nil
+	}), record("a"), record("b"))
+	require.NoError(t, h.Handle(context.Background(), mq.Message{Value: []byte("1")}))
+	assert.Equal(t, []string{"a:1", "b:1", "handle:1"}, trace)
+
+	trace = nil
+	bh := WrapBatch(BatchHandlerFunc(func(ctx context.Context, msgs []mq.Message) error {
+		trace = append(trace, fmt.Sprintf("batch:%d", len(msgs)))
+		return nil
+	}), record("a"), record("b"))
+	require.NoError(t, bh.HandleBatch(context.Background(), make([]mq.Message, 3)))
+	assert.Equal(t, []string{"a:3", "b:3", "batch:3"}, trace)
+}
+
+func TestRetryOptions_Delay(t *testing.T) {
+	opts := RetryOptions{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 3}
+	assert.Equal(t, 100*time.Millisecond, opts.Delay(1))
+	assert.Equal(t, 300*time.Millisecond, opts.Delay(2))
+	assert.Equal(t, 900*time.Millisecond, opts.Delay(3))
+	assert.Equal(t, time.Second, opts.Delay(4))
+}
+
+func TestRetry(t *testing.T) {
+	opts := RetryOptions{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond, Multiplier: 2}
+	testCases := []struct {
+		name      string
+		failures  int
+		err       error
+		wantCalls int
+		wantErr   bool
+		// wantDelay 过载的时候至少等 Retry-After
+		wantDelay time.Duration
+	}{
+		{name: "失败两次之后成功", failures: 2, err: errors.New("数据库超时"), wantCalls: 3},
+		{name: "一直失败", failures: 100, err: errors.New("数据库超时"), wantCalls: 3, wantErr: true},
+		{name: "不可重试", failures: 100, err: ErrNonRetryable, wantCalls: 1, wantErr: true},
+		{name: "下游过载", failures: 1, err: &OverloadError{RetryAfter: 50 * time.Millisecond}, wantCalls: 2, wantDelay: 50 * time.Millisecond},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Now()
+			defer func() {
+				assert.GreaterOrEqual(t, time.Since(start), tc.wantDelay)
+			}()
+			calls := 0
+			h := Wrap(HandlerFunc(func(ctx context.Context, msg mq.Message) error {
+				calls++
+				if calls <= tc.failures {
+					return tc.err
+				}
+				return nil
+			}), Retry(opts))
+			err := h.Handle(context.Background(), mq.Message{})
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, tc.wantCalls, calls)
+		})
+	}
+}
+
+// TestAIMD 过载的时候并发减半并且暂停，冷却期里面的过载不会再减，成功之后慢慢恢复
+func TestAIMD(t *testing.T) {
+	l := NewAIMD(AIMDOptions{Min: 1, Max: 4, Decrease: 0.5, Cooldown: 20 * time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 4; i++ {
+		require.NoError(t, l.Acquire(ctx))
+	}
+	assert.True(t, l.Saturated())
+	// 并发用完了，拿不到
+	short, cancelShort := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancelShort()
+	assert.ErrorIs(t, l.Acquire(short), context.DeadlineExceeded)
+
+	// 同一轮里面的四个请求都过载了，只减一次
+	overload := &OverloadError{RetryAfter: 50 * time.Millisecond}
+	for i := 0; i < 4; i++ {
+		l.Release(overload)
+	}
+	assert.Equal(t, 2, l.Limit())
+	assert.True(t, l.Saturated())
+	start := time.Now()
+	require.NoError(t, l.Acquire(ctx))
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+	l.Release(nil)
+	assert.False(t, l.Saturated())
+
+	// 成功了 limit 次之后并发加一，最多加到 Max
+	for i := 0; i < 10; i++ {
+		require.NoError(t, l.Acquire(ctx))
+		l.Release(nil)
+	}
+	assert.Equal(t, 4, l.Limit())
+
+	// 业务错误不影响并发，超时算过载
+	require.NoError(t, l.Acquire(ctx))
+	l.Release(errors.New("参数错误"))
+	assert.Equal(t, 4, l.Limit())
+	require.NoError(t, l.Acquire(ctx))
+	l.Release(context.DeadlineExceeded)
+	assert.Equal(t, 2, l.Limit())
+}
+
+// TestTimeout 放在 Retry 里面，每次调用单独计时
+func TestTimeout(t *testing.T) {
+	opts := RetryOptions{MaxAttempts: 2, Backoff: time.Millisecond, MaxBackoff: time.Millisecond, Multiplier: 2}
+	metrics := NewMetrics()
+	calls := 0
+	h := Wrap(HandlerFunc(func(ctx context.Context, msg mq.Message) error {
+		calls++
+		<-ctx.Done()
+		return ctx.Err()
+	}), metrics.Middleware(), Logging(nil), Retry(opts), Timeout(10*time.Millisecond))
+	err := h.Handle(context.Background(), mq.Message{Topic: "t"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 2, calls)
+
+	stats := metrics.Stats()
+	assert.Equal(t, int64(1), stats.Calls)
+	assert.Equal(t, int64(1), stats.Failures)
+	assert.Equal(t, int64(1), stats.Messages)
+	assert.GreaterOrEqual(t, stats.MaxLatency, 20*time.Millisecond)
+}
+
+func TestHTTPHandler(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/batch" && strings.Contains(string(body), "bad"):
+			// 第二条参数错误，第三条数据库超时
+			w.WriteHeader(http.StatusMultiStatus)
+			_ = json.NewEncoder(w).Encode(BatchResult{Results: []ItemResult{
+				{Code: http.StatusOK}, {Code: http.StatusBadRequest, Msg: "参数错误"},
+				{Code: http.StatusInternalServerError, Msg: "系统错误"},
+			}})
+		case strings.Contains(string(body), "bad"):
+			w.WriteHeader(http.StatusBadRequest)
+		case strings.Contains(string(body), "busy"):
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	h := NewHTTPHandler(server.Client(), server.URL+"/handle")
+	ctx := context.Background()
+	assert.NoError(t, h.Handle(ctx, mq.Message{Value: []byte(`{"id":1}`)}))
+	err := h.Handle(ctx, mq.Message{Value: []byte(`"bad"`)})
+	assert.ErrorIs(t, err, ErrNonRetryable)
+	err = h.Handle(ctx, mq.Message{Value: []byte(`"busy"`)})
+	assert.NotErrorIs(t, err, ErrNonRetryable)
+	retryAfter, ok := IsOverload(err)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Second, retryAfter)
+
+	bh := NewHTTPBatchHandler(server.Client(), server.URL+"/batch")
+	msgs := []mq.Message{{Value: []byte(`{"id":1}`)}, {Value: []byte(`{"id":2}`)}}
+	require.NoError(t, bh.HandleBatch(ctx, msgs))
+	var vals []string
+	require.NoError(t, json.Unmarshal([]byte(bodies[len(bodies)-1]), &vals))
+	assert.Equal(t, []string{`{"id":1}`, `{"id":2}`}, vals)
+
+	// 部分成功
+	msgs = append(msgs, mq.Message{Value: []byte(`"bad"`)})
+	err = bh.HandleBatch(ctx, msgs)
+	var be *BatchError
+	require.ErrorAs(t, err, &be)
+	require.Len(t, be.Failed, 2)
+	assert.ErrorIs(t, be.Failed[1], ErrNonRetryable)
+	assert.NotErrorIs(t, be.Failed[2], ErrNonRetryable)
+	// Retry 不会重试部分成功的批次
+	calls := len(bodies)
+	err = WrapBatch(bh, Retry(DefaultRetryOptions())).HandleBatch(ctx, msgs)
+	assert.ErrorAs(t, err, &be)
+	assert.Equal(t, calls+1, len(bodies))
+}
+
+func TestGRPCHandler(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	// 没有注册任何服务，所有的调用都到这里，请求原样记录下来
+	server := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}),
+		grpc.UnknownServiceHandler(func(srv any, stream grpc.ServerStream) error {
+			method, _ := grpc.MethodFromServerStream(stream)
+			var req rawFrame
+			if err := stream.RecvMsg(&req); err != nil {
+				return err
+			}
+			mu.Lock()
+			requests = append(requests, method+" "+string(req.data))
+			mu.Unlock()
+			switch string(req.data) {
+			case "bad":
+				return status.Error(codes.InvalidArgument, "参数错误")
+			case "busy":
+				return status.Error(codes.ResourceExhausted, "限流")
+			}
+			return stream.SendMsg(&rawFrame{data: []byte("ok")})
+		}))
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer server.Stop()
+	conn, err := grpc.Dial("bufnet", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h := NewGRPCHandler(conn, "/user.UserService/Save")
+	assert.NoError(t, h.Handle(ctx, mq.Message{Value: []byte("u1")}))
+	assert.ErrorIs(t, h.Handle(ctx, mq.Message{Value: []byte("bad")}), ErrNonRetryable)
+	err = h.Handle(ctx, mq.Message{Value: []byte("busy")})
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNonRetryable)
+
+	bh := NewGRPCBatchHandler(conn, "/user.UserService/BatchSave", func(msgs []mq.Message) ([]byte, error) {
+		vals := make([]string, 0, len(msgs))
+		for _, msg := range msgs {
+			vals = append(vals, string(msg.Value))
+		}
+		return []byte(strings.Join(vals, ",")), nil
+	})
+	assert.NoError(t, bh.HandleBatch(ctx, []mq.Message{{Value: []byte("u2")}, {Value: []byte("u3")}}))
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{
+		"/user.UserService/Save u1",
+		"/user.UserService/Save bad",
+		"/user.UserService/Save busy",
+		"/user.UserService/BatchSave u2,u3",
+	}, requests)
+}
+
+type handlerUser struct {
+	ID   int64 `gorm:"primaryKey"`
+	Name string
+}
+
+func TestGormHandler(t *testing.T) {
+	db := test.InitMemoryDB(t)
+	require.NoError(t, db.AutoMigrate(&handlerUser{}))
+
+	ctx := context.Background()
+	h := NewGormHandler[handlerUser](db)
+	require.NoError(t, h.Handle(ctx, mq.Message{Value: []byte(`{"ID":1,"Name":"Tom"}`)}))
+	require.NoError(t, h.HandleBatch(ctx, []mq.Message{
+		{Value: []byte(`{"ID":2,"Name":"Jerry"}`)},
+		{Value: []byte(`{"ID":3,"Name":"Spike"}`)},
+	}))
+	// 有一条解析不了，其他的照常插入
+	err := h.HandleBatch(ctx, []mq.Message{{Value: []byte(`{`)}, {Value: []byte(`{"ID":4,"Name":"Tyke"}`)}})
+	var be *BatchError
+	require.ErrorAs(t, err, &be)
+	require.Len(t, be.Failed, 1)
+	assert.ErrorIs(t, be.Failed[0], ErrNonRetryable)
+	// 主键冲突是数据库的错误，交给 Retry 或者调用方决定
+	err = h.Handle(ctx, mq.Message{Value: []byte(`{"ID":1,"Name":"Tom"}`)})
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNonRetryable)
+
+	var users []handlerUser
+	require.NoError(t, db.Order("id").Find(&users).Error)
+	assert.Equal(t, []handlerUser{{ID: 1, Name: "Tom"}, {ID: 2, Name: "Jerry"},
+		{ID: 3, Name: "Spike"}, {ID: 4, Name: "Tyke"}}, users)
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"interview-cases/pkg/mq"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HTTPHandler 把消息的 Value 当作 JSON 请求体 POST 到 url
+type HTTPHandler struct {
+	client *http.Client
+	url    string
+}
+
+func NewHTTPHandler(client *http.Client, url string) *HTTPHandler {
+	return &HTTPHandler{client: client, url: url}
+}
+
+func (h *HTTPHandler) Handle(ctx context.Context, msg mq.Message) error {
+	_, _, err := post(ctx, h.client, h.url, msg.Value)
+	return err
+}
+
+// HTTPBatchHandler 把一批消息的 Value 放到一个 JSON 字符串数组里面，POST 到批量接口
+// 批量接口部分成功的时候返回 207 和 BatchResult，这个时候返回 *BatchError
+type HTTPBatchHandler struct {
+	client *http.Client
+	url    string
+}
+
+func NewHTTPBatchHandler(client *http.Client, url string) *HTTPBatchHandler {
+	return &HTTPBatchHandler{client: client, url: url}
+}
+
+func (h *HTTPBatchHandler) HandleBatch(ctx context.Context, msgs []mq.Message) error {
+	vals := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		vals = append(vals, string(msg.Value))
+	}
+	data, err := json.Marshal(vals)
+	if err != nil {
+		return err
+	}
+	status, body, err := post(ctx, h.client, h.url, data)
+	if err != nil || status != http.StatusMultiStatus {
+		return err
+	}
+	var res BatchResult
+	err = json.Unmarshal(body, &res)
+	if err != nil {
+		return fmt.Errorf("解析批量接口的响应失败 %w", err)
+	}
+	if len(res.Results) != len(msgs) {
+		return fmt.Errorf("批量接口返回了 %d 个结果，但是发送了 %d 条消息", len(res.Results), len(msgs))
+	}
+	failed := make(map[int]error)
+	for i, item := range res.Results {
+		if err := checkStatus(item.Code, []byte(item.Msg)); err != nil {
+			failed[i] = err
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return &BatchError{Failed: failed}
+}
+
+// BatchResult 批量接口部分成功的时候的响应，Results 和请求里面的消息一一对应
+type BatchResult struct {
+	Results []ItemResult `json:"results"`
+}
+
+// ItemResult Code 的含义和 HTTP 状态码一样
+type ItemResult struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg,omitempty"`
+}
+
+// post 返回状态码和响应，状态码不是 2xx 的时候返回 error
+func post(ctx context.Context, client *http.Client, url string, body []byte) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	err = checkStatus(resp.StatusCode, respBody)
+	var oe *OverloadError
+	if errors.As(err, &oe) {
+		oe.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	if err != nil {
+		return resp.StatusCode, respBody, err
+	}
+	slog.Debug("处理完毕", slog.String("resp", string(respBody)))
+	return resp.StatusCode, respBody, nil
+}
+
+// checkStatus 参数错误重试也没用，其他的错误（例如限流、数据库超时）可以重试
+func checkStatus(code int, body []byte) error {
+	switch {
+	case code == http.StatusTooManyRequests:
+		return &OverloadError{Err: fmt.Errorf("状态码 %d, 响应 %s", code, body)}
+	case code >= 500:
+		return fmt.Errorf("状态码 %d, 响应 %s", code, body)
+	case code >= 400:
+		return fmt.Errorf("%w: 状态码 %d, 响应 %s", ErrNonRetryable, code, body)
+	}
+	return nil
+}
+
+// parseRetryAfter Retry-After 可以是秒数，也可以是 HTTP 日期，解析不了就返回 0
+func parseRetryAfter(val string) time.Duration {
+	if val == "" {
+		return 0
+	}
+	if sec, err := strconv.Atoi(val); err == nil {
+		return max(time.Duration(sec)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(val); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
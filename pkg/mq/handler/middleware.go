@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"interview-cases/pkg/mq"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Timeout 每次调用最多执行多久，和 Retry 一起用的时候放在 Retry 里面，就是每次重试单独计时
+func Timeout(d time.Duration) Middleware {
+	return func(ctx context.Context, msgs []mq.Message, next func(ctx context.Context) error) error {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		return next(ctx)
+	}
+}
+
+// Retry 失败了按照 opts 重试，ErrNonRetryable 不会重试
+// *BatchError 也不会重试，因为其他的消息已经成功了，交给消费者处理失败的那几条
+// 下游过载的时候至少等 Retry-After 再重试
+func Retry(opts RetryOptions) Middleware {
+	return func(ctx context.Context, msgs []mq.Message, next func(ctx context.Context) error) error {
+		var be *BatchError
+		for attempt := 1; ; attempt++ {
+			err := next(ctx)
+			if err == nil {
+				return nil
+			}
+			if errors.Is(err, ErrNonRetryable) || errors.As(err, &be) || attempt >= opts.MaxAttempts {
+				return err
+			}
+			delay := opts.Delay(attempt)
+			if retryAfter, ok := IsOverload(err); ok {
+				delay = max(delay, retryAfter)
+			}
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return fmt.Errorf("等待重试的时候退出 %w", errors.Join(err, ctx.Err()))
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+// Logging 成功的时候打 Debug 日志，失败的时候打 Error 日志，下游过载是预期之内的，只打 Warn 日志，
+// logger 为 nil 的时候用 slog.Default()
+func Logging(logger *slog.Logger) Middleware {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(ctx context.Context, msgs []mq.Message, next func(ctx context.Context) error) error {
+		start := time.Now()
+		err := next(ctx)
+		attrs := []any{slog.Int("size", len(msgs)), slog.Duration("duration", time.Since(start))}
+		if len(msgs) > 0 {
+			attrs = append(attrs, slog.String("topic", msgs[0].Topic),
+				slog.Int("partition", int(msgs[0].Partition)), slog.Int64("offset", msgs[0].Offset))
+		}
+		if _, ok := IsOverload(err); ok {
+			logger.WarnContext(ctx, "下游过载", append(attrs, slog.Any("err", err))...)
+			return err
+		}
+		if err != nil {
+			logger.ErrorContext(ctx, "处理消息失败", append(attrs, slog.Any("err", err))...)
+			return err
+		}
+		logger.DebugContext(ctx, "处理消息成功", attrs...)
+		return nil
+	}
+}
+
+// Metrics 统计调用次数、失败次数、消息数量和耗时
+type Metrics struct {
+	mu    sync.Mutex
+	stats Stats
+}
+
+type Stats struct {
+	Calls    int64
+	Failures int64
+	Messages int64
+	// TotalLatency 除以 Calls 就是平均耗时
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+func (m *Metrics) Middleware() Middleware {
+	return func(ctx context.Context, msgs []mq.Message, next func(ctx context.Context) error) error {
+		start := time.Now()
+		err := next(ctx)
+		latency := time.Since(start)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.stats.Calls++
+		m.stats.Messages += int64(len(msgs))
+		m.stats.TotalLatency += latency
+		m.stats.MaxLatency = max(m.stats.MaxLatency, latency)
+		if err != nil {
+			m.stats.Failures++
+		}
+		return err
+	}
+}
+
+func (m *Metrics) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
@@ -0,0 +1,164 @@
+package kafkago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	kafkago "github.com/segmentio/kafka-go"
+	"interview-cases/pkg/mq"
+	"time"
+)
+
+func (b *Broker) Watermarks(ctx context.Context, tp mq.TopicPartition) (int64, int64, error) {
+	conn, err := b.dialLeader(ctx, tp)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+	low, err := conn.ReadFirstOffset()
+	if err != nil {
+		return 0, 0, fmt.Errorf("查询 %s/%d 的偏移量失败 %w", tp.Topic, tp.Partition, err)
+	}
+	high, err := conn.ReadLastOffset()
+	if err != nil {
+		return 0, 0, fmt.Errorf("查询 %s/%d 的偏移量失败 %w", tp.Topic, tp.Partition, err)
+	}
+	return low, high, nil
+}
+
+func (b *Broker) OffsetForTime(ctx context.Context, tp mq.TopicPartition, ts time.Time) (int64, error) {
+	conn, err := b.dialLeader(ctx, tp)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	off, err := conn.ReadOffset(ts)
+	if err != nil {
+		return 0, fmt.Errorf("查询 %s/%d 的偏移量失败 %w", tp.Topic, tp.Partition, err)
+	}
+	// 没有时间戳不早于 ts 的消息的时候 Kafka 返回 -1
+	if off >= 0 {
+		return off, nil
+	}
+	off, err = conn.ReadLastOffset()
+	if err != nil {
+		return 0, fmt.Errorf("查询 %s/%d 的偏移量失败 %w", tp.Topic, tp.Partition, err)
+	}
+	return off, nil
+}
+
+// dialLeader 连接分区的 leader，每次查询单独发一个 ListOffsets 请求
+// 不用 Client.ListOffsets 一次查多个：同一个分区在一个请求里面出现两次 broker 会返回 INVALID_REQUEST，
+// 而且 kafka-go 是按照返回的时间戳区分 earliest 和 latest 的，v1 之后两个都是 -1，会混在一起
+func (b *Broker) dialLeader(ctx context.Context, tp mq.TopicPartition) (*kafkago.Conn, error) {
+	var err error
+	for _, addr := range b.brokers {
+		var conn *kafkago.Conn
+		conn, err = kafkago.DialLeader(ctx, "tcp", addr, tp.Topic, int(tp.Partition))
+		if errors.Is(err, kafkago.UnknownTopicOrPartition) {
+			return nil, fmt.Errorf("%w %s: %w", mq.ErrUnknownTopic, tp.Topic, err)
+		}
+		if err != nil {
+			continue
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			err = conn.SetDeadline(deadline)
+			if err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+		}
+		return conn, nil
+	}
+	return nil, fmt.Errorf("连接 %s/%d 的 leader 失败 %w", tp.Topic, tp.Partition, err)
+}
+
+func (b *Broker) Committed(ctx context.Context, groupID string, tps ...mq.TopicPartition) (map[mq.TopicPartition]int64, error) {
+	topics := make(map[string][]int)
+	for _, tp := range tps {
+		topics[tp.Topic] = append(topics[tp.Topic], int(tp.Partition))
+	}
+	resp, err := b.client.OffsetFetch(ctx, &kafkago.OffsetFetchRequest{GroupID: groupID, Topics: topics})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	res := make(map[mq.TopicPartition]int64, len(tps))
+	for topic, partitions := range resp.Topics {
+		for _, p := range partitions {
+			if p.Error != nil {
+				return nil, fmt.Errorf("查询 %s/%d 提交的偏移量失败 %w", topic, p.Partition, p.Error)
+			}
+			// 没有提交过是 -1
+			if p.CommittedOffset >= 0 {
+				res[mq.TopicPartition{Topic: topic, Partition: int32(p.Partition)}] = p.CommittedOffset
+			}
+		}
+	}
+	return res, nil
+}
+
+// CommitOffsets 不加入消费者组直接提交，Kafka 只允许在消费者组为空的时候这么做
+func (b *Broker) CommitOffsets(ctx context.Context, groupID string, offsets map[mq.TopicPartition]int64) error {
+	groups, err := b.client.DescribeGroups(ctx, &kafkago.DescribeGroupsRequest{GroupIDs: []string{groupID}})
+	if err != nil {
+		return err
+	}
+	for _, g := range groups.Groups {
+		if len(g.Members) > 0 {
+			return fmt.Errorf("%w: %s 里面有 %d 个消费者", mq.ErrGroupActive, groupID, len(g.Members))
+		}
+	}
+	topics := make(map[string][]kafkago.OffsetCommit)
+	for tp, off := range offsets {
+		topics[tp.Topic] = append(topics[tp.Topic], kafkago.OffsetCommit{Partition: int(tp.Partition), Offset: off})
+	}
+	resp, err := b.client.OffsetCommit(ctx, &kafkago.OffsetCommitRequest{
+		GroupID:      groupID,
+		GenerationID: -1,
+		Topics:       topics,
+	})
+	if err != nil {
+		return err
+	}
+	for topic, partitions := range resp.Topics {
+		for _, p := range partitions {
+			if p.Error != nil {
+				return fmt.Errorf("提交 %s/%d 的偏移量失败 %w", topic, p.Partition, p.Error)
+			}
+		}
+	}
+	return nil
+}
+
+func (b *Broker) Reader(tp mq.TopicPartition, offset int64) (mq.Reader, error) {
+	r := kafkago.NewReader(kafkago.ReaderConfig{
+		Brokers:   b.brokers,
+		Topic:     tp.Topic,
+		Partition: int(tp.Partition),
+	})
+	err := r.SetOffset(offset)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	return &reader{reader: r}, nil
+}
+
+type reader struct {
+	reader *kafkago.Reader
+}
+
+func (r *reader) Fetch(ctx context.Context) (mq.Message, error) {
+	msg, err := r.reader.FetchMessage(ctx)
+	if err != nil {
+		return mq.Message{}, err
+	}
+	return fromKafkaMessage(msg), nil
+}
+
+func (r *reader) Close() error {
+	return r.reader.Close()
+}
@@ -0,0 +1,147 @@
+package kafkago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	kafkago "github.com/segmentio/kafka-go"
+	"interview-cases/pkg/mq"
+	"time"
+)
+
+// Broker 基于 segmentio/kafka-go 的实现
+type Broker struct {
+	brokers []string
+	client  *kafkago.Client
+}
+
+func NewBroker(brokers ...string) *Broker {
+	return &Broker{
+		brokers: brokers,
+		client:  &kafkago.Client{Addr: kafkago.TCP(brokers...)},
+	}
+}
+
+func (b *Broker) Producer() (mq.Producer, error) {
+	return &producer{
+		writer: &kafkago.Writer{
+			Addr:                   kafkago.TCP(b.brokers...),
+			Balancer:               &partitionBalancer{},
+			AllowAutoTopicCreation: true,
+			// 默认是凑够一批或者等一秒钟才发送，而我们是同步发送，没有必要等那么久
+			BatchTimeout: 10 * time.Millisecond,
+		},
+	}, nil
+}
+
+func (b *Broker) Consumer(groupID string, topics ...string) (mq.Consumer, error) {
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("mq: 消费者至少要订阅一个 topic")
+	}
+	return newConsumer(b.brokers, groupID, topics)
+}
+
+func (b *Broker) CreateTopic(ctx context.Context, topic string, partitions int) error {
+	resp, err := b.client.CreateTopics(ctx, &kafkago.CreateTopicsRequest{
+		Topics: []kafkago.TopicConfig{
+			{
+				Topic:             topic,
+				NumPartitions:     partitions,
+				ReplicationFactor: 1,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("创建 topic %s 失败 %w", topic, err)
+	}
+	err = resp.Errors[topic]
+	if err != nil && !errors.Is(err, kafkago.TopicAlreadyExists) {
+		return fmt.Errorf("创建 topic %s 失败 %w", topic, err)
+	}
+	return nil
+}
+
+func (b *Broker) Partitions(ctx context.Context, topic string) ([]int32, error) {
+	resp, err := b.client.Metadata(ctx, &kafkago.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range resp.Topics {
+		if t.Name != topic {
+			continue
+		}
+		if t.Error != nil {
+			return nil, fmt.Errorf("%w %s: %w", mq.ErrUnknownTopic, topic, t.Error)
+		}
+		res := make([]int32, 0, len(t.Partitions))
+		for _, p := range t.Partitions {
+			res = append(res, int32(p.ID))
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("%w %s", mq.ErrUnknownTopic, topic)
+}
+
+func (b *Broker) Close() error {
+	return nil
+}
+
+// partitionBalancer 指定了分区就用指定的分区，否则和 kafkago.Hash 一样按照 Key 哈希
+type partitionBalancer struct {
+	hash kafkago.Hash
+}
+
+func (p *partitionBalancer) Balance(msg kafkago.Message, partitions ...int) int {
+	if msg.Partition >= 0 {
+		return msg.Partition
+	}
+	return p.hash.Balance(msg, partitions...)
+}
+
+type producer struct {
+	writer *kafkago.Writer
+}
+
+func (p *producer) Produce(ctx context.Context, msgs ...mq.Message) error {
+	kmsgs := make([]kafkago.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		kmsgs = append(kmsgs, toKafkaMessage(msg))
+	}
+	return p.writer.WriteMessages(ctx, kmsgs...)
+}
+
+func (p *producer) Close() error {
+	return p.writer.Close()
+}
+
+func toKafkaMessage(msg mq.Message) kafkago.Message {
+	headers := make([]kafkago.Header, 0, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers = append(headers, kafkago.Header{Key: h.Key, Value: h.Value})
+	}
+	return kafkago.Message{
+		Topic: msg.Topic,
+		// kafka-go 的 Writer 本身会忽略 Partition，由 partitionBalancer 来处理
+		Partition: int(msg.Partition),
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   headers,
+		Time:      msg.Timestamp,
+	}
+}
+
+func fromKafkaMessage(msg kafkago.Message) mq.Message {
+	headers := make([]mq.Header, 0, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers = append(headers, mq.Header{Key: h.Key, Value: h.Value})
+	}
+	return mq.Message{
+		Topic:     msg.Topic,
+		Partition: int32(msg.Partition),
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   headers,
+		Timestamp: msg.Time,
+	}
+}
@@ -0,0 +1,338 @@
+package kafkago
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	kafkago "github.com/segmentio/kafka-go"
+	"interview-cases/pkg/mq"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// consumer kafka-go 的 Reader 在消费者组模式下不支持暂停分区，
+// 所以用 ConsumerGroup 加入消费者组，每个分配到的分区单独一个 Reader 和一个 goroutine
+// goroutine 每次只拉取一条消息放在 partition.msg 里面，Fetch 取走之后才拉取下一条，
+// 所以暂停的分区不会再拉取消息，内存里面最多只有 Reader 自己的缓冲区
+type consumer struct {
+	brokers []string
+	group   *kafkago.ConsumerGroup
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	// 下面的字段都由 mu 保护
+	mu  sync.Mutex
+	gen *kafkago.Generation
+	// genCtx 当前这一代的 context，这一代结束的时候会取消，分区的 goroutine 都从它派生
+	genCtx context.Context
+	// readers 当前这一代所有分区的 goroutine，这一代结束的时候要等它们都退出
+	readers *sync.WaitGroup
+	// generation 每次重新分配分区加一
+	generation int64
+	assigned   []mq.TopicPartition
+	// offsets 分区从哪里开始读，Seek 会修改它
+	offsets    map[mq.TopicPartition]int64
+	partitions map[mq.TopicPartition]*partition
+	paused     map[mq.TopicPartition]struct{}
+	// 下一次从哪个分区开始找消息，避免某个分区一直占着
+	next int
+	// changed 有新的消息或者分区恢复了就关闭，然后换一个新的
+	changed chan struct{}
+	closed  bool
+}
+
+// partition 一个分区的 Reader，重新分配分区之后就换成新的了，旧的拉取到的消息会被丢掉
+type partition struct {
+	tp     mq.TopicPartition
+	cancel context.CancelFunc
+	// msg 已经拉取、还没有被 Fetch 取走的消息
+	msg *kafkago.Message
+	// taken Fetch 取走 msg 之后通知 goroutine 拉取下一条
+	taken chan struct{}
+}
+
+func newConsumer(brokers []string, groupID string, topics []string) (*consumer, error) {
+	group, err := kafkago.NewConsumerGroup(kafkago.ConsumerGroupConfig{
+		ID:          groupID,
+		Brokers:     brokers,
+		Topics:      topics,
+		StartOffset: kafkago.FirstOffset,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &consumer{
+		brokers:    brokers,
+		group:      group,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		partitions: make(map[mq.TopicPartition]*partition),
+		paused:     make(map[mq.TopicPartition]struct{}),
+		changed:    make(chan struct{}),
+	}
+	go c.run(ctx)
+	return c, nil
+}
+
+// run 上一代结束的时候分区的 goroutine 都已经退出了，Next 返回新的一代之后给新分配的分区启动 goroutine
+func (c *consumer) run(ctx context.Context) {
+	defer close(c.done)
+	for {
+		gen, err := c.group.Next(ctx)
+		if ctx.Err() != nil || errors.Is(err, kafkago.ErrGroupClosed) {
+			return
+		}
+		if err != nil {
+			slog.Error("加入消费者组失败", slog.Any("err", err))
+			continue
+		}
+		c.assign(gen)
+	}
+}
+
+// assign kafka-go 的一代里面只要有一个 Start 的函数返回，这一代就结束了，
+// 所以每一代只 Start 一个函数，由它启动各个分区的 goroutine，然后等这一代结束
+func (c *consumer) assign(gen *kafkago.Generation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen = gen
+	c.generation++
+	c.genCtx = nil
+	c.readers = &sync.WaitGroup{}
+	c.assigned = c.assigned[:0]
+	c.partitions = make(map[mq.TopicPartition]*partition)
+	c.offsets = make(map[mq.TopicPartition]int64)
+	for topic, assignments := range gen.Assignments {
+		for _, a := range assignments {
+			tp := mq.TopicPartition{Topic: topic, Partition: int32(a.ID)}
+			c.assigned = append(c.assigned, tp)
+			// 没有提交过偏移量的分区是 kafkago.FirstOffset
+			c.offsets[tp] = a.Offset
+		}
+	}
+	slices.SortFunc(c.assigned, func(a, b mq.TopicPartition) int {
+		if a.Topic != b.Topic {
+			return strings.Compare(a.Topic, b.Topic)
+		}
+		return int(a.Partition - b.Partition)
+	})
+	c.next = 0
+	readers := c.readers
+	gen.Start(func(ctx context.Context) {
+		c.mu.Lock()
+		if c.gen == gen {
+			c.genCtx = ctx
+			for _, tp := range c.assigned {
+				c.startLocked(tp, c.offsets[tp])
+			}
+		}
+		c.mu.Unlock()
+		<-ctx.Done()
+		readers.Wait()
+	})
+}
+
+// startLocked 给分区启动一个从 offset 开始读的 goroutine，原来的 goroutine 拉取到的消息会被丢掉
+func (c *consumer) startLocked(tp mq.TopicPartition, offset int64) {
+	if old, ok := c.partitions[tp]; ok {
+		old.cancel()
+	}
+	ctx, cancel := context.WithCancel(c.genCtx)
+	p := &partition{tp: tp, cancel: cancel, taken: make(chan struct{}, 1)}
+	c.partitions[tp] = p
+	c.readers.Add(1)
+	go func() {
+		defer c.readers.Done()
+		defer cancel()
+		c.read(ctx, p, offset)
+	}()
+}
+
+func (c *consumer) read(ctx context.Context, p *partition, offset int64) {
+	reader := kafkago.NewReader(kafkago.ReaderConfig{
+		Brokers:   c.brokers,
+		Topic:     p.tp.Topic,
+		Partition: int(p.tp.Partition),
+	})
+	defer reader.Close()
+	err := reader.SetOffset(offset)
+	if err != nil {
+		slog.Error("设置分区的偏移量失败", slog.String("topic", p.tp.Topic),
+			slog.Int("partition", int(p.tp.Partition)), slog.Int64("offset", offset), slog.Any("err", err))
+		return
+	}
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Error("拉取消息失败", slog.String("topic", p.tp.Topic),
+				slog.Int("partition", int(p.tp.Partition)), slog.Any("err", err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		c.mu.Lock()
+		if c.partitions[p.tp] != p {
+			c.mu.Unlock()
+			return
+		}
+		p.msg = &msg
+		c.notifyLocked()
+		c.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.taken:
+		}
+	}
+}
+
+func (c *consumer) Fetch(ctx context.Context) (mq.Message, error) {
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return mq.Message{}, mq.ErrClosed
+		}
+		msg, ok := c.pollLocked()
+		changed := c.changed
+		c.mu.Unlock()
+		if ok {
+			return fromKafkaMessage(msg), nil
+		}
+		select {
+		case <-ctx.Done():
+			return mq.Message{}, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (c *consumer) pollLocked() (kafkago.Message, bool) {
+	n := len(c.assigned)
+	for i := 0; i < n; i++ {
+		idx := (c.next + i) % n
+		tp := c.assigned[idx]
+		if _, ok := c.paused[tp]; ok {
+			continue
+		}
+		p := c.partitions[tp]
+		if p == nil || p.msg == nil {
+			continue
+		}
+		msg := *p.msg
+		p.msg = nil
+		p.taken <- struct{}{}
+		c.next = (idx + 1) % n
+		return msg, true
+	}
+	return kafkago.Message{}, false
+}
+
+func (c *consumer) notifyLocked() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// Commit 用当前这一代提交，重新分配分区之后，已经不属于自己的分区会提交失败
+func (c *consumer) Commit(ctx context.Context, msgs ...mq.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	gen, closed := c.gen, c.closed
+	c.mu.Unlock()
+	if closed {
+		return mq.ErrClosed
+	}
+	if gen == nil {
+		return fmt.Errorf("mq: 还没有加入消费者组")
+	}
+	// 和 Kafka 一样，提交的是下一条要消费的消息的偏移量
+	offsets := make(map[string]map[int]int64)
+	for _, msg := range msgs {
+		partitions, ok := offsets[msg.Topic]
+		if !ok {
+			partitions = make(map[int]int64)
+			offsets[msg.Topic] = partitions
+		}
+		if off, ok := partitions[int(msg.Partition)]; !ok || msg.Offset+1 > off {
+			partitions[int(msg.Partition)] = msg.Offset + 1
+		}
+	}
+	return gen.CommitOffsets(offsets)
+}
+
+func (c *consumer) Pause(partitions ...mq.TopicPartition) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tp := range partitions {
+		c.paused[tp] = struct{}{}
+	}
+	return nil
+}
+
+func (c *consumer) Resume(partitions ...mq.TopicPartition) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tp := range partitions {
+		delete(c.paused, tp)
+	}
+	c.notifyLocked()
+	return nil
+}
+
+// Seek 重新启动分区的 goroutine，从 offset 开始读
+// 这一代的 goroutine 还没有启动的话，只修改开始读的位置
+func (c *consumer) Seek(tp mq.TopicPartition, offset int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return mq.ErrClosed
+	}
+	if _, ok := c.offsets[tp]; !ok {
+		return fmt.Errorf("mq: 分区 %s/%d 没有分配给这个消费者", tp.Topic, tp.Partition)
+	}
+	if offset < 0 {
+		return fmt.Errorf("mq: 分区 %s/%d 没有偏移量 %d", tp.Topic, tp.Partition, offset)
+	}
+	c.offsets[tp] = offset
+	if c.genCtx != nil {
+		c.startLocked(tp, offset)
+	}
+	return nil
+}
+
+func (c *consumer) Assignment() ([]mq.TopicPartition, int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, 0, mq.ErrClosed
+	}
+	return slices.Clone(c.assigned), c.generation, nil
+}
+
+func (c *consumer) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.notifyLocked()
+	c.mu.Unlock()
+	c.cancel()
+	// 关闭 ConsumerGroup 会结束当前这一代，等所有分区的 goroutine 退出
+	err := c.group.Close()
+	<-c.done
+	return err
+}
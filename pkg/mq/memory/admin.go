@@ -0,0 +1,135 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"interview-cases/pkg/mq"
+	"sort"
+	"time"
+)
+
+// Watermarks 进程内的消息不会过期，所以 low 总是 0
+func (b *Broker) Watermarks(ctx context.Context, tp mq.TopicPartition) (int64, int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	log, err := b.partitionLocked(tp)
+	if err != nil {
+		return 0, 0, err
+	}
+	return 0, int64(len(log.msgs)), nil
+}
+
+func (b *Broker) OffsetForTime(ctx context.Context, tp mq.TopicPartition, ts time.Time) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	log, err := b.partitionLocked(tp)
+	if err != nil {
+		return 0, err
+	}
+	// 和 Kafka 一样假设同一个分区上的时间戳是递增的
+	idx := sort.Search(len(log.msgs), func(i int) bool {
+		return !log.msgs[i].Timestamp.Before(ts)
+	})
+	return int64(idx), nil
+}
+
+func (b *Broker) Committed(ctx context.Context, groupID string, tps ...mq.TopicPartition) (map[mq.TopicPartition]int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	res := make(map[mq.TopicPartition]int64, len(tps))
+	g, ok := b.groups[groupID]
+	if !ok {
+		return res, nil
+	}
+	for _, tp := range tps {
+		if off, ok := g.committed[tp]; ok {
+			res[tp] = off
+		}
+	}
+	return res, nil
+}
+
+func (b *Broker) CommitOffsets(ctx context.Context, groupID string, offsets map[mq.TopicPartition]int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return mq.ErrClosed
+	}
+	g, ok := b.groups[groupID]
+	if !ok {
+		g = &group{committed: make(map[mq.TopicPartition]int64)}
+		b.groups[groupID] = g
+	}
+	if len(g.members) > 0 {
+		return fmt.Errorf("%w: %s 里面有 %d 个消费者", mq.ErrGroupActive, groupID, len(g.members))
+	}
+	for tp, off := range offsets {
+		if _, err := b.partitionLocked(tp); err != nil {
+			return err
+		}
+		g.committed[tp] = off
+	}
+	return nil
+}
+
+func (b *Broker) Reader(tp mq.TopicPartition, offset int64) (mq.Reader, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, mq.ErrClosed
+	}
+	if _, err := b.partitionLocked(tp); err != nil {
+		return nil, err
+	}
+	return &reader{b: b, tp: tp, pos: offset}, nil
+}
+
+func (b *Broker) partitionLocked(tp mq.TopicPartition) (*partitionLog, error) {
+	logs, ok := b.topics[tp.Topic]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", mq.ErrUnknownTopic, tp.Topic)
+	}
+	if tp.Partition < 0 || int(tp.Partition) >= len(logs) {
+		return nil, fmt.Errorf("mq: topic %s 没有分区 %d", tp.Topic, tp.Partition)
+	}
+	return logs[tp.Partition], nil
+}
+
+// reader 的字段都由 Broker.mu 保护
+type reader struct {
+	b      *Broker
+	tp     mq.TopicPartition
+	pos    int64
+	closed bool
+}
+
+func (r *reader) Fetch(ctx context.Context) (mq.Message, error) {
+	for {
+		r.b.mu.Lock()
+		if r.closed || r.b.closed {
+			r.b.mu.Unlock()
+			return mq.Message{}, mq.ErrClosed
+		}
+		log := r.b.topics[r.tp.Topic][r.tp.Partition]
+		if r.pos < int64(len(log.msgs)) {
+			msg := log.msgs[r.pos]
+			r.pos++
+			r.b.mu.Unlock()
+			return msg, nil
+		}
+		changed := r.b.changed
+		r.b.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return mq.Message{}, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (r *reader) Close() error {
+	r.b.mu.Lock()
+	defer r.b.mu.Unlock()
+	r.closed = true
+	return nil
+}
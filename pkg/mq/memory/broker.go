@@ -0,0 +1,240 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"interview-cases/pkg/mq"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Broker 进程内的消息队列，模拟了 Kafka 的分区、消费者组和偏移量
+// 适合在没有 Kafka 的环境下运行案例和测试，不会持久化任何数据
+type Broker struct {
+	mu     sync.Mutex
+	topics map[string][]*partitionLog
+	groups map[string]*group
+	// changed 在有新消息、分区分配变化或者恢复分区的时候被关闭并替换
+	// 阻塞在 Fetch 上的消费者靠它来唤醒
+	changed chan struct{}
+	// 没有 Key 的消息轮询分区
+	roundRobin int
+	closed     bool
+}
+
+type partitionLog struct {
+	msgs []mq.Message
+}
+
+type group struct {
+	// 按照加入的顺序排列，分配分区的时候也按照这个顺序
+	members   []*consumer
+	committed map[mq.TopicPartition]int64
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		topics:  make(map[string][]*partitionLog),
+		groups:  make(map[string]*group),
+		changed: make(chan struct{}),
+	}
+}
+
+func (b *Broker) Producer() (mq.Producer, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, mq.ErrClosed
+	}
+	return &producer{b: b}, nil
+}
+
+func (b *Broker) Consumer(groupID string, topics ...string) (mq.Consumer, error) {
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("mq: 消费者至少要订阅一个 topic")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, mq.ErrClosed
+	}
+	g, ok := b.groups[groupID]
+	if !ok {
+		g = &group{committed: make(map[mq.TopicPartition]int64)}
+		b.groups[groupID] = g
+	}
+	c := &consumer{
+		b:      b,
+		g:      g,
+		topics: topics,
+		paused: make(map[mq.TopicPartition]struct{}),
+	}
+	g.members = append(g.members, c)
+	b.rebalanceLocked(g)
+	return c, nil
+}
+
+func (b *Broker) CreateTopic(ctx context.Context, topic string, partitions int) error {
+	if partitions <= 0 {
+		return fmt.Errorf("mq: 分区数量必须大于 0，实际 %d", partitions)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return mq.ErrClosed
+	}
+	if _, ok := b.topics[topic]; ok {
+		return nil
+	}
+	b.createTopicLocked(topic, partitions)
+	return nil
+}
+
+func (b *Broker) Partitions(ctx context.Context, topic string) ([]int32, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	logs, ok := b.topics[topic]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", mq.ErrUnknownTopic, topic)
+	}
+	res := make([]int32, 0, len(logs))
+	for i := range logs {
+		res = append(res, int32(i))
+	}
+	return res, nil
+}
+
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	b.notifyLocked()
+	return nil
+}
+
+func (b *Broker) createTopicLocked(topic string, partitions int) {
+	logs := make([]*partitionLog, 0, partitions)
+	for i := 0; i < partitions; i++ {
+		logs = append(logs, &partitionLog{})
+	}
+	b.topics[topic] = logs
+	// 订阅了这个 topic 的消费者组要重新分配分区，其他消费者组不受影响
+	for _, g := range b.groups {
+		for _, c := range g.members {
+			if c.subscribed(topic) {
+				b.rebalanceLocked(g)
+				break
+			}
+		}
+	}
+}
+
+func (b *Broker) produce(msgs []mq.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return mq.ErrClosed
+	}
+	now := time.Now()
+	for _, msg := range msgs {
+		logs, ok := b.topics[msg.Topic]
+		if !ok {
+			// 和 docker-compose 里面的 Kafka 一样，允许自动创建 topic
+			b.createTopicLocked(msg.Topic, 1)
+			logs = b.topics[msg.Topic]
+		}
+		partition := msg.Partition
+		switch {
+		case partition == mq.PartitionAny && len(msg.Key) > 0:
+			partition = mq.HashPartition(msg.Key, len(logs))
+		case partition == mq.PartitionAny:
+			partition = int32(b.roundRobin % len(logs))
+			b.roundRobin++
+		case partition < 0 || int(partition) >= len(logs):
+			return fmt.Errorf("mq: topic %s 没有分区 %d", msg.Topic, partition)
+		}
+		log := logs[partition]
+		msg.Partition = partition
+		msg.Offset = int64(len(log.msgs))
+		if msg.Timestamp.IsZero() {
+			msg.Timestamp = now
+		}
+		log.msgs = append(log.msgs, msg)
+	}
+	b.notifyLocked()
+	return nil
+}
+
+// rebalanceLocked 重新给消费者组里面的消费者分配分区
+// 和 Kafka 的 eager 模式一样，所有消费者都会回到已提交的偏移量上，
+// 没有提交的消息会被重新消费
+func (b *Broker) rebalanceLocked(g *group) {
+	for _, c := range g.members {
+		c.assigned = c.assigned[:0]
+	}
+	topicSet := make(map[string]struct{})
+	for _, c := range g.members {
+		for _, topic := range c.topics {
+			topicSet[topic] = struct{}{}
+		}
+	}
+	topics := make([]string, 0, len(topicSet))
+	for topic := range topicSet {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		logs, ok := b.topics[topic]
+		if !ok {
+			continue
+		}
+		candidates := make([]*consumer, 0, len(g.members))
+		for _, c := range g.members {
+			if c.subscribed(topic) {
+				candidates = append(candidates, c)
+			}
+		}
+		for i := range logs {
+			c := candidates[i%len(candidates)]
+			c.assigned = append(c.assigned, mq.TopicPartition{Topic: topic, Partition: int32(i)})
+		}
+	}
+	for _, c := range g.members {
+		c.positions = make(map[mq.TopicPartition]int64, len(c.assigned))
+		paused := make(map[mq.TopicPartition]struct{})
+		for _, tp := range c.assigned {
+			c.positions[tp] = g.committed[tp]
+			if _, ok := c.paused[tp]; ok {
+				paused[tp] = struct{}{}
+			}
+		}
+		c.paused = paused
+		c.next = 0
+		c.generation++
+	}
+	b.notifyLocked()
+}
+
+func (b *Broker) notifyLocked() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+type producer struct {
+	b *Broker
+}
+
+func (p *producer) Produce(ctx context.Context, msgs ...mq.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return p.b.produce(msgs)
+}
+
+func (p *producer) Close() error {
+	return nil
+}
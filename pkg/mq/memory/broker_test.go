@@ -0,0 +1,183 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/pkg/mq"
+	"testing"
+	"time"
+)
+
+func TestBroker_ProduceFetchCommit(t *testing.T) {
+	b := NewBroker()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, b.CreateTopic(ctx, "test_topic", 3))
+	p, err := b.Producer()
+	require.NoError(t, err)
+	// 同一个 Key 的消息落在同一个分区，并且保持顺序
+	for i := 0; i < 5; i++ {
+		err = p.Produce(ctx, mq.Message{
+			Topic:     "test_topic",
+			Partition: mq.PartitionAny,
+			Key:       []byte("user_1"),
+			Value:     []byte(fmt.Sprintf("%d", i)),
+		})
+		require.NoError(t, err)
+	}
+	c, err := b.Consumer("test_group", "test_topic")
+	require.NoError(t, err)
+	wantPartition := mq.HashPartition([]byte("user_1"), 3)
+	var last mq.Message
+	for i := 0; i < 5; i++ {
+		msg, err := c.Fetch(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, wantPartition, msg.Partition)
+		assert.Equal(t, int64(i), msg.Offset)
+		assert.Equal(t, fmt.Sprintf("%d", i), string(msg.Value))
+		last = msg
+		if i == 2 {
+			require.NoError(t, c.Commit(ctx, msg))
+		}
+	}
+	// 没有消息了就阻塞到超时
+	fetchCtx, fetchCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	_, err = c.Fetch(fetchCtx)
+	fetchCancel()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// 没有提交的消息在消费者重新加入之后会被重新消费
+	require.NoError(t, c.Close())
+	c, err = b.Consumer("test_group", "test_topic")
+	require.NoError(t, err)
+	msg, err := c.Fetch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), msg.Offset)
+	require.NoError(t, c.Commit(ctx, last))
+	require.NoError(t, c.Close())
+
+	// 新的消费者组从头开始
+	c, err = b.Consumer("other_group", "test_topic")
+	require.NoError(t, err)
+	msg, err = c.Fetch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), msg.Offset)
+}
+
+func TestBroker_GroupAssignment(t *testing.T) {
+	b := NewBroker()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, b.CreateTopic(ctx, "test_topic", 2))
+	c1, err := b.Consumer("test_group", "test_topic")
+	require.NoError(t, err)
+	c2, err := b.Consumer("test_group", "test_topic")
+	require.NoError(t, err)
+	p, err := b.Producer()
+	require.NoError(t, err)
+	err = p.Produce(ctx,
+		mq.Message{Topic: "test_topic", Partition: 0, Value: []byte("p0")},
+		mq.Message{Topic: "test_topic", Partition: 1, Value: []byte("p1")})
+	require.NoError(t, err)
+
+	// 两个分区分别分给了两个消费者
+	msg1, err := c1.Fetch(ctx)
+	require.NoError(t, err)
+	msg2, err := c2.Fetch(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"p0", "p1"}, []string{string(msg1.Value), string(msg2.Value)})
+	assert.NotEqual(t, msg1.Partition, msg2.Partition)
+
+	// 指定了不存在的分区
+	err = p.Produce(ctx, mq.Message{Topic: "test_topic", Partition: 5})
+	assert.Error(t, err)
+}
+
+func TestBroker_PauseResume(t *testing.T) {
+	b := NewBroker()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, b.CreateTopic(ctx, "test_topic", 2))
+	c, err := b.Consumer("test_group", "test_topic")
+	require.NoError(t, err)
+	p, err := b.Producer()
+	require.NoError(t, err)
+	tp0 := mq.TopicPartition{Topic: "test_topic", Partition: 0}
+	require.NoError(t, c.Pause(tp0))
+	err = p.Produce(ctx,
+		mq.Message{Topic: "test_topic", Partition: 0, Value: []byte("p0")},
+		mq.Message{Topic: "test_topic", Partition: 1, Value: []byte("p1")})
+	require.NoError(t, err)
+
+	msg, err := c.Fetch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "p1", string(msg.Value))
+
+	// 暂停的分区上的消息要等到恢复之后才能拿到
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = c.Resume(tp0)
+	}()
+	start := time.Now()
+	msg, err = c.Fetch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "p0", string(msg.Value))
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+}
+
+func TestBroker_Seek(t *testing.T) {
+	b := NewBroker()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, b.CreateTopic(ctx, "test_topic", 2))
+	p, err := b.Producer()
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, p.Produce(ctx, mq.Message{Topic: "test_topic", Partition: 1, Value: []byte(fmt.Sprintf("%d", i))}))
+	}
+	c1, err := b.Consumer("test_group", "test_topic")
+	require.NoError(t, err)
+	seeker := c1.(mq.Seeker)
+	tps, gen, err := seeker.Assignment()
+	require.NoError(t, err)
+	assert.Len(t, tps, 2)
+
+	tp := mq.TopicPartition{Topic: "test_topic", Partition: 1}
+	require.NoError(t, seeker.Seek(tp, 2))
+	msg, err := c1.Fetch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), msg.Offset)
+	// 往回移动，重新消费
+	require.NoError(t, seeker.Seek(tp, 0))
+	msg, err = c1.Fetch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), msg.Offset)
+	assert.Error(t, seeker.Seek(tp, 4))
+
+	// 重新分配之后 generation 变了，分区 1 分给了 c2，c1 不能再移动它
+	c2, err := b.Consumer("test_group", "test_topic")
+	require.NoError(t, err)
+	tps, newGen, err := seeker.Assignment()
+	require.NoError(t, err)
+	assert.NotEqual(t, gen, newGen)
+	assert.Equal(t, []mq.TopicPartition{{Topic: "test_topic", Partition: 0}}, tps)
+	assert.Error(t, seeker.Seek(tp, 0))
+	require.NoError(t, c2.(mq.Seeker).Seek(tp, 1))
+	msg, err = c2.Fetch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), msg.Offset)
+}
+
+func TestBroker_Close(t *testing.T) {
+	b := NewBroker()
+	c, err := b.Consumer("test_group", "test_topic")
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = b.Close()
+	}()
+	_, err = c.Fetch(context.Background())
+	assert.ErrorIs(t, err, mq.ErrClosed)
+}
@@ -0,0 +1,160 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"interview-cases/pkg/mq"
+	"slices"
+)
+
+// consumer 的字段都由 Broker.mu 保护
+type consumer struct {
+	b      *Broker
+	g      *group
+	topics []string
+
+	assigned  []mq.TopicPartition
+	positions map[mq.TopicPartition]int64
+	paused    map[mq.TopicPartition]struct{}
+	// 下一次从哪个分区开始找消息，避免某个分区一直占着
+	next int
+	// generation 每次重新分配分区加一
+	generation int64
+	closed     bool
+}
+
+func (c *consumer) Fetch(ctx context.Context) (mq.Message, error) {
+	for {
+		c.b.mu.Lock()
+		if c.closed || c.b.closed {
+			c.b.mu.Unlock()
+			return mq.Message{}, mq.ErrClosed
+		}
+		msg, ok := c.pollLocked()
+		changed := c.b.changed
+		c.b.mu.Unlock()
+		if ok {
+			return msg, nil
+		}
+		select {
+		case <-ctx.Done():
+			return mq.Message{}, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (c *consumer) pollLocked() (mq.Message, bool) {
+	n := len(c.assigned)
+	for i := 0; i < n; i++ {
+		idx := (c.next + i) % n
+		tp := c.assigned[idx]
+		if _, ok := c.paused[tp]; ok {
+			continue
+		}
+		log := c.b.topics[tp.Topic][tp.Partition]
+		pos := c.positions[tp]
+		if pos >= int64(len(log.msgs)) {
+			continue
+		}
+		c.positions[tp] = pos + 1
+		c.next = (idx + 1) % n
+		return log.msgs[pos], true
+	}
+	return mq.Message{}, false
+}
+
+func (c *consumer) Commit(ctx context.Context, msgs ...mq.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.b.mu.Lock()
+	defer c.b.mu.Unlock()
+	if c.closed {
+		return mq.ErrClosed
+	}
+	// 和 Kafka 一样，提交的是下一条要消费的消息的偏移量
+	offsets := make(map[mq.TopicPartition]int64, len(msgs))
+	for _, msg := range msgs {
+		tp := msg.TopicPartition()
+		if off, ok := offsets[tp]; !ok || msg.Offset+1 > off {
+			offsets[tp] = msg.Offset + 1
+		}
+	}
+	for tp, off := range offsets {
+		c.g.committed[tp] = off
+	}
+	return nil
+}
+
+func (c *consumer) Pause(partitions ...mq.TopicPartition) error {
+	c.b.mu.Lock()
+	defer c.b.mu.Unlock()
+	for _, tp := range partitions {
+		c.paused[tp] = struct{}{}
+	}
+	return nil
+}
+
+func (c *consumer) Resume(partitions ...mq.TopicPartition) error {
+	c.b.mu.Lock()
+	defer c.b.mu.Unlock()
+	for _, tp := range partitions {
+		delete(c.paused, tp)
+	}
+	c.b.notifyLocked()
+	return nil
+}
+
+func (c *consumer) Seek(tp mq.TopicPartition, offset int64) error {
+	c.b.mu.Lock()
+	defer c.b.mu.Unlock()
+	if c.closed {
+		return mq.ErrClosed
+	}
+	if _, ok := c.positions[tp]; !ok {
+		return fmt.Errorf("mq: 分区 %s/%d 没有分配给这个消费者", tp.Topic, tp.Partition)
+	}
+	if offset < 0 || offset > int64(len(c.b.topics[tp.Topic][tp.Partition].msgs)) {
+		return fmt.Errorf("mq: 分区 %s/%d 没有偏移量 %d", tp.Topic, tp.Partition, offset)
+	}
+	c.positions[tp] = offset
+	c.b.notifyLocked()
+	return nil
+}
+
+func (c *consumer) Assignment() ([]mq.TopicPartition, int64, error) {
+	c.b.mu.Lock()
+	defer c.b.mu.Unlock()
+	if c.closed {
+		return nil, 0, mq.ErrClosed
+	}
+	return slices.Clone(c.assigned), c.generation, nil
+}
+
+func (c *consumer) Close() error {
+	c.b.mu.Lock()
+	defer c.b.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	members := c.g.members[:0]
+	for _, m := range c.g.members {
+		if m != c {
+			members = append(members, m)
+		}
+	}
+	c.g.members = members
+	c.b.rebalanceLocked(c.g)
+	return nil
+}
+
+func (c *consumer) subscribed(topic string) bool {
+	for _, t := range c.topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,273 @@
+// Package offset 重置消费者组的偏移量，或者在不影响消费者组的情况下重放一段消息。
+// 下游的 bug 把数据写坏了之后，修复完 bug 可以把消费者组退回到出问题之前，
+// 也可以只把出问题的那一段消息重新交给某个 Handler 处理
+package offset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"interview-cases/pkg/mq"
+	"interview-cases/pkg/mq/handler"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// ErrNotSupported Broker 没有实现 mq.Admin
+var ErrNotSupported = errors.New("offset: 这个 Broker 不支持查询和修改偏移量")
+
+// AdminOf 检查 broker 是不是实现了 mq.Admin
+func AdminOf(broker mq.Broker) (mq.Admin, error) {
+	admin, ok := broker.(mq.Admin)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return admin, nil
+}
+
+type Kind int
+
+const (
+	Earliest Kind = iota
+	Latest
+	Absolute
+	Timestamp
+)
+
+// Target 把偏移量移动到哪里
+type Target struct {
+	Kind Kind
+	// Offset Kind 是 Absolute 的时候用
+	Offset int64
+	// Time Kind 是 Timestamp 的时候用，对应第一条时间戳不早于它的消息
+	Time time.Time
+}
+
+// ParseTarget 支持 earliest、latest、偏移量和 RFC3339 格式的时间，例如 2024-05-01T10:00:00+08:00
+func ParseTarget(s string) (Target, error) {
+	switch s {
+	case "earliest":
+		return Target{Kind: Earliest}, nil
+	case "latest":
+		return Target{Kind: Latest}, nil
+	}
+	if off, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if off < 0 {
+			return Target{}, fmt.Errorf("偏移量不能是负数 %d", off)
+		}
+		return Target{Kind: Absolute, Offset: off}, nil
+	}
+	ts, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return Target{}, fmt.Errorf("无法解析 %q，只支持 earliest、latest、偏移量或者 RFC3339 格式的时间", s)
+	}
+	return Target{Kind: Timestamp, Time: ts}, nil
+}
+
+func (t Target) String() string {
+	switch t.Kind {
+	case Earliest:
+		return "earliest"
+	case Latest:
+		return "latest"
+	case Absolute:
+		return strconv.FormatInt(t.Offset, 10)
+	default:
+		return t.Time.Format(time.RFC3339)
+	}
+}
+
+// resolve 目标在分区上对应的偏移量，超出 [low, high] 的偏移量会被限制在这个范围里面
+func (t Target) resolve(ctx context.Context, admin mq.Admin, tp mq.TopicPartition, low, high int64) (int64, error) {
+	switch t.Kind {
+	case Earliest:
+		return low, nil
+	case Latest:
+		return high, nil
+	case Absolute:
+		return min(max(t.Offset, low), high), nil
+	default:
+		off, err := admin.OffsetForTime(ctx, tp, t.Time)
+		if err != nil {
+			return 0, fmt.Errorf("查询 %s/%d 在 %s 的偏移量失败 %w", tp.Topic, tp.Partition, t, err)
+		}
+		return min(max(off, low), high), nil
+	}
+}
+
+// TopicPartitions partitions 为空的时候返回 topic 的所有分区，否则检查这些分区是不是都存在
+func TopicPartitions(ctx context.Context, broker mq.Broker, topic string, partitions []int32) ([]mq.TopicPartition, error) {
+	all, err := broker.Partitions(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	if len(partitions) == 0 {
+		partitions = all
+	}
+	res := make([]mq.TopicPartition, 0, len(partitions))
+	for _, p := range partitions {
+		if !slices.Contains(all, p) {
+			return nil, fmt.Errorf("topic %s 没有分区 %d", topic, p)
+		}
+		res = append(res, mq.TopicPartition{Topic: topic, Partition: p})
+	}
+	slices.SortFunc(res, func(a, b mq.TopicPartition) int {
+		return int(a.Partition - b.Partition)
+	})
+	return res, nil
+}
+
+// Change 一个分区上偏移量的变化
+type Change struct {
+	mq.TopicPartition
+	Low  int64
+	High int64
+	// Current 当前提交的偏移量，-1 代表没有提交过，消费者会从最早的消息开始消费
+	Current int64
+	Target  int64
+}
+
+// LagBefore 重置之前还有多少条消息没有消费
+func (c Change) LagBefore() int64 {
+	if c.Current < 0 {
+		return c.High - c.Low
+	}
+	return c.High - max(c.Current, c.Low)
+}
+
+// LagAfter 重置之后还有多少条消息没有消费
+func (c Change) LagAfter() int64 {
+	return c.High - c.Target
+}
+
+// Plan 计算重置之后每个分区的偏移量，不会修改任何东西，dry run 的时候直接输出它的结果
+func Plan(ctx context.Context, admin mq.Admin, groupID string, tps []mq.TopicPartition, target Target) ([]Change, error) {
+	committed, err := admin.Committed(ctx, groupID, tps...)
+	if err != nil {
+		return nil, fmt.Errorf("查询 %s 提交的偏移量失败 %w", groupID, err)
+	}
+	res := make([]Change, 0, len(tps))
+	for _, tp := range tps {
+		low, high, err := admin.Watermarks(ctx, tp)
+		if err != nil {
+			return nil, fmt.Errorf("查询 %s/%d 的偏移量范围失败 %w", tp.Topic, tp.Partition, err)
+		}
+		off, err := target.resolve(ctx, admin, tp, low, high)
+		if err != nil {
+			return nil, err
+		}
+		current, ok := committed[tp]
+		if !ok {
+			current = -1
+		}
+		res = append(res, Change{TopicPartition: tp, Low: low, High: high, Current: current, Target: off})
+	}
+	return res, nil
+}
+
+// Apply 把 Plan 的结果提交到消费者组，消费者组里面还有消费者的时候会返回 mq.ErrGroupActive，
+// 因为正在运行的消费者会用自己的位置把重置的结果覆盖掉
+func Apply(ctx context.Context, admin mq.Admin, groupID string, changes []Change) error {
+	offsets := make(map[mq.TopicPartition]int64, len(changes))
+	for _, c := range changes {
+		offsets[c.TopicPartition] = c.Target
+	}
+	err := admin.CommitOffsets(ctx, groupID, offsets)
+	if err != nil {
+		return fmt.Errorf("重置 %s 的偏移量失败 %w", groupID, err)
+	}
+	return nil
+}
+
+// Range 一个分区上 [Start, End) 之间的消息
+type Range struct {
+	mq.TopicPartition
+	Start int64
+	End   int64
+}
+
+func (r Range) Len() int64 {
+	return max(r.End-r.Start, 0)
+}
+
+// Ranges 计算每个分区上 from 到 to 之间的范围，to 在计算的时候就确定下来了，
+// 所以之后写入的消息不会被重放
+func Ranges(ctx context.Context, admin mq.Admin, tps []mq.TopicPartition, from, to Target) ([]Range, error) {
+	res := make([]Range, 0, len(tps))
+	for _, tp := range tps {
+		low, high, err := admin.Watermarks(ctx, tp)
+		if err != nil {
+			return nil, fmt.Errorf("查询 %s/%d 的偏移量范围失败 %w", tp.Topic, tp.Partition, err)
+		}
+		start, err := from.resolve(ctx, admin, tp, low, high)
+		if err != nil {
+			return nil, err
+		}
+		end, err := to.resolve(ctx, admin, tp, low, high)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, Range{TopicPartition: tp, Start: start, End: end})
+	}
+	return res, nil
+}
+
+// Replay 按照分区依次把范围里面的消息交给 h 处理，不加入消费者组，也不提交偏移量，
+// 所以正在运行的消费者不受影响。h 返回错误就停下来，错误里面有出错的那条消息的偏移量，
+// 修复之后可以从那里重新开始。返回已经处理了多少条消息
+func Replay(ctx context.Context, admin mq.Admin, ranges []Range, h handler.Handler) (int64, error) {
+	var cnt int64
+	for _, r := range ranges {
+		n, err := replayRange(ctx, admin, r, h)
+		cnt += n
+		if err != nil {
+			return cnt, err
+		}
+	}
+	return cnt, nil
+}
+
+func replayRange(ctx context.Context, admin mq.Admin, r Range, h handler.Handler) (int64, error) {
+	if r.Len() == 0 {
+		return 0, nil
+	}
+	reader, err := admin.Reader(r.TopicPartition, r.Start)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	var cnt int64
+	for {
+		msg, err := reader.Fetch(ctx)
+		if err != nil {
+			return cnt, fmt.Errorf("读取 %s/%d 的消息失败 %w", r.Topic, r.Partition, err)
+		}
+		// 压缩过的 topic 上偏移量可能不连续，所以不能按照条数判断
+		if msg.Offset >= r.End {
+			return cnt, nil
+		}
+		err = h.Handle(ctx, msg)
+		if err != nil {
+			return cnt, &ReplayError{Message: msg, Err: err}
+		}
+		cnt++
+		if msg.Offset+1 >= r.End {
+			return cnt, nil
+		}
+	}
+}
+
+// ReplayError 重放到 Message 的时候 Handler 返回了 Err
+type ReplayError struct {
+	Message mq.Message
+	Err     error
+}
+
+func (e *ReplayError) Error() string {
+	return fmt.Sprintf("重放 %s/%d 偏移量 %d 的消息失败 %v", e.Message.Topic, e.Message.Partition, e.Message.Offset, e.Err)
+}
+
+func (e *ReplayError) Unwrap() error {
+	return e.Err
+}
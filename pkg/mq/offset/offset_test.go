@@ -0,0 +1,245 @@
+package offset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/pkg/mq"
+	"interview-cases/pkg/mq/handler"
+	"interview-cases/pkg/mq/kafkago"
+	"interview-cases/pkg/mq/memory"
+	"interview-cases/test"
+	"testing"
+	"time"
+)
+
+// base 第 i 条消息的时间戳是 base 之后 i 分钟
+var base = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+// initBroker 两个分区，每个分区 10 条消息，test_group 在分区 0 上提交到了 8，分区 1 上没有提交过
+func initBroker(t *testing.T, ctx context.Context) (*memory.Broker, []mq.TopicPartition) {
+	b := memory.NewBroker()
+	return b, initTopic(t, ctx, b, "test_topic", "test_group", base)
+}
+
+// initTopic 在 b 上准备 initBroker 描述的数据，第 i 条消息的时间戳是 start 之后 i 分钟
+func initTopic(t *testing.T, ctx context.Context, b mq.Broker, topic, group string, start time.Time) []mq.TopicPartition {
+	require.NoError(t, b.CreateTopic(ctx, topic, 2))
+	p, err := b.Producer()
+	require.NoError(t, err)
+	defer p.Close()
+	for partition := int32(0); partition < 2; partition++ {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, p.Produce(ctx, mq.Message{
+				Topic:     topic,
+				Partition: partition,
+				Value:     []byte(fmt.Sprintf("%d_%d", partition, i)),
+				Timestamp: start.Add(time.Duration(i) * time.Minute),
+			}))
+		}
+	}
+	admin, err := AdminOf(b)
+	require.NoError(t, err)
+	require.NoError(t, admin.CommitOffsets(ctx, group, map[mq.TopicPartition]int64{{Topic: topic, Partition: 0}: 8}))
+	tps, err := TopicPartitions(ctx, b, topic, nil)
+	require.NoError(t, err)
+	return tps
+}
+
+func TestParseTarget(t *testing.T) {
+	testCases := []struct {
+		input   string
+		want    Target
+		wantErr bool
+	}{
+		{input: "earliest", want: Target{Kind: Earliest}},
+		{input: "latest", want: Target{Kind: Latest}},
+		{input: "42", want: Target{Kind: Absolute, Offset: 42}},
+		{input: "2024-05-01T18:00:00+08:00", want: Target{Kind: Timestamp, Time: base}},
+		{input: "-1", wantErr: true},
+		{input: "yesterday", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := ParseTarget(tc.input)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want.Kind, got.Kind)
+			assert.Equal(t, tc.want.Offset, got.Offset)
+			assert.True(t, tc.want.Time.Equal(got.Time))
+			assert.Equal(t, tc.input, got.String())
+		})
+	}
+}
+
+func TestPlan(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	b, tps := initBroker(t, ctx)
+	testCases := []struct {
+		name   string
+		target Target
+		// 每个分区的目标和重置之后的积压，重置之前的积压分别是 2 和 10
+		want      []int64
+		wantAfter []int64
+	}{
+		{name: "earliest", target: Target{Kind: Earliest}, want: []int64{0, 0}, wantAfter: []int64{10, 10}},
+		{name: "latest", target: Target{Kind: Latest}, want: []int64{10, 10}, wantAfter: []int64{0, 0}},
+		{name: "偏移量", target: Target{Kind: Absolute, Offset: 3}, want: []int64{3, 3}, wantAfter: []int64{7, 7}},
+		{name: "偏移量超出范围", target: Target{Kind: Absolute, Offset: 100}, want: []int64{10, 10}, wantAfter: []int64{0, 0}},
+		{name: "时间", target: Target{Kind: Timestamp, Time: base.Add(150 * time.Second)}, want: []int64{3, 3}, wantAfter: []int64{7, 7}},
+		{name: "时间晚于所有消息", target: Target{Kind: Timestamp, Time: base.Add(time.Hour)}, want: []int64{10, 10}, wantAfter: []int64{0, 0}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			changes, err := Plan(ctx, b, "test_group", tps, tc.target)
+			require.NoError(t, err)
+			require.Len(t, changes, 2)
+			assert.Equal(t, int64(8), changes[0].Current)
+			assert.Equal(t, int64(-1), changes[1].Current)
+			assert.Equal(t, int64(2), changes[0].LagBefore())
+			assert.Equal(t, int64(10), changes[1].LagBefore())
+			for i, c := range changes {
+				assert.Equal(t, tc.want[i], c.Target)
+				assert.Equal(t, tc.wantAfter[i], c.LagAfter())
+			}
+		})
+	}
+	// dry run 不会修改任何东西
+	committed, err := b.Committed(ctx, "test_group", tps...)
+	require.NoError(t, err)
+	assert.Equal(t, map[mq.TopicPartition]int64{tps[0]: 8}, committed)
+}
+
+func TestApply(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	b, tps := initBroker(t, ctx)
+	// 只重置分区 0
+	tps, err := TopicPartitions(ctx, b, "test_topic", []int32{0})
+	require.NoError(t, err)
+	changes, err := Plan(ctx, b, "test_group", tps, Target{Kind: Timestamp, Time: base.Add(5 * time.Minute)})
+	require.NoError(t, err)
+
+	// 还有消费者在运行的时候不能重置
+	c, err := b.Consumer("test_group", "test_topic")
+	require.NoError(t, err)
+	assert.ErrorIs(t, Apply(ctx, b, "test_group", changes), mq.ErrGroupActive)
+	require.NoError(t, c.Close())
+
+	require.NoError(t, Apply(ctx, b, "test_group", changes))
+	c, err = b.Consumer("test_group", "test_topic")
+	require.NoError(t, err)
+	defer c.Close()
+	seen := map[int32]int64{}
+	for i := 0; i < 2; i++ {
+		msg, err := c.Fetch(ctx)
+		require.NoError(t, err)
+		seen[msg.Partition] = msg.Offset
+	}
+	assert.Equal(t, map[int32]int64{0: 5, 1: 0}, seen)
+
+	_, err = TopicPartitions(ctx, b, "test_topic", []int32{2})
+	assert.Error(t, err)
+}
+
+func TestReplay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	b, tps := initBroker(t, ctx)
+	ranges, err := Ranges(ctx, b, tps, Target{Kind: Timestamp, Time: base.Add(2 * time.Minute)},
+		Target{Kind: Timestamp, Time: base.Add(5 * time.Minute)})
+	require.NoError(t, err)
+	assert.Equal(t, []Range{{TopicPartition: tps[0], Start: 2, End: 5}, {TopicPartition: tps[1], Start: 2, End: 5}}, ranges)
+
+	// 重放的时候又来了新消息，不会被重放
+	p, err := b.Producer()
+	require.NoError(t, err)
+	require.NoError(t, p.Produce(ctx, mq.Message{Topic: "test_topic", Partition: 0, Value: []byte("new")}))
+	var vals []string
+	h := handler.HandlerFunc(func(ctx context.Context, msg mq.Message) error {
+		vals = append(vals, string(msg.Value))
+		return nil
+	})
+	cnt, err := Replay(ctx, b, ranges, h)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), cnt)
+	assert.Equal(t, []string{"0_2", "0_3", "0_4", "1_2", "1_3", "1_4"}, vals)
+
+	// 出错了就停下来，告诉调用方从哪里重新开始
+	vals = nil
+	h = func(ctx context.Context, msg mq.Message) error {
+		if string(msg.Value) == "0_4" {
+			return errors.New("数据库超时")
+		}
+		vals = append(vals, string(msg.Value))
+		return nil
+	}
+	cnt, err = Replay(ctx, b, ranges, h)
+	var re *ReplayError
+	require.ErrorAs(t, err, &re)
+	assert.Equal(t, int64(4), re.Message.Offset)
+	assert.Equal(t, int64(2), cnt)
+	assert.Equal(t, []string{"0_2", "0_3"}, vals)
+
+	// 重放不会修改消费者组提交的偏移量
+	committed, err := b.Committed(ctx, "test_group", tps...)
+	require.NoError(t, err)
+	assert.Equal(t, map[mq.TopicPartition]int64{tps[0]: 8}, committed)
+}
+
+// TestKafka 在真实的 Kafka 上查询、重置和重放，连不上 Kafka 的时候跳过
+func TestKafka(t *testing.T) {
+	brokers := test.RequireKafka(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	b := kafkago.NewBroker(brokers...)
+	defer b.Close()
+	topic := fmt.Sprintf("test_offset_%d", time.Now().UnixNano())
+	group := topic + "_group"
+	// 时间戳不能太早，不然会被 Kafka 按照保留时间删掉
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	tps := initTopic(t, ctx, b, topic, group, start)
+
+	changes, err := Plan(ctx, b, group, tps, Target{Kind: Timestamp, Time: start.Add(150 * time.Second)})
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	assert.Equal(t, int64(8), changes[0].Current)
+	assert.Equal(t, int64(-1), changes[1].Current)
+	assert.Equal(t, int64(3), changes[0].Target)
+	assert.Equal(t, int64(3), changes[1].Target)
+	// 没有时间戳不早于目标的消息，Kafka 返回的是 -1，要换成 high
+	changes, err = Plan(ctx, b, group, tps, Target{Kind: Timestamp, Time: start.Add(time.Hour)})
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), changes[0].Target)
+	assert.Equal(t, int64(10), changes[1].Target)
+	changes, err = Plan(ctx, b, group, tps, Target{Kind: Earliest})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), changes[0].Target)
+	assert.Equal(t, int64(10), changes[0].LagAfter())
+
+	ranges, err := Ranges(ctx, b, tps, Target{Kind: Timestamp, Time: start.Add(2 * time.Minute)},
+		Target{Kind: Timestamp, Time: start.Add(5 * time.Minute)})
+	require.NoError(t, err)
+	assert.Equal(t, []Range{{TopicPartition: tps[0], Start: 2, End: 5}, {TopicPartition: tps[1], Start: 2, End: 5}}, ranges)
+	var vals []string
+	cnt, err := Replay(ctx, b, ranges, handler.HandlerFunc(func(ctx context.Context, msg mq.Message) error {
+		vals = append(vals, string(msg.Value))
+		return nil
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), cnt)
+	assert.Equal(t, []string{"0_2", "0_3", "0_4", "1_2", "1_3", "1_4"}, vals)
+
+	changes, err = Plan(ctx, b, group, tps[:1], Target{Kind: Absolute, Offset: 5})
+	require.NoError(t, err)
+	require.NoError(t, Apply(ctx, b, group, changes))
+	committed, err := b.Committed(ctx, group, tps...)
+	require.NoError(t, err)
+	assert.Equal(t, map[mq.TopicPartition]int64{tps[0]: 5}, committed)
+}
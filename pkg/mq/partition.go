@@ -0,0 +1,16 @@
+package mq
+
+import "hash/fnv"
+
+// HashPartition 按照 Key 选择分区，算法和 kafka-go 的 Hash 保持一致（FNV-1a）
+// 这样同一个 Key 不管用哪个实现都会落到同一个分区上
+// 注意要先转成 int32 再取模，最后取绝对值，kafka-go 是为了和 Sarama 保持一致
+func HashPartition(key []byte, partitions int) int32 {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	partition := int32(h.Sum32()) % int32(partitions)
+	if partition < 0 {
+		partition = -partition
+	}
+	return partition
+}
@@ -0,0 +1,27 @@
+package mq
+
+import (
+	"fmt"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// TestHashPartition 同一个 Key 在进程内的实现和真的 Kafka 上要落到同一个分区
+func TestHashPartition(t *testing.T) {
+	// 没有 Key 的时候 kafka-go 用的是轮询，不在这里比较
+	keys := [][]byte{[]byte(""), []byte("a"), []byte("user_1"), []byte("中文")}
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("%d", i)))
+	}
+	for _, n := range []int{1, 2, 3, 4, 7, 12} {
+		partitions := make([]int, n)
+		for i := range partitions {
+			partitions[i] = i
+		}
+		for _, key := range keys {
+			want := (&kafka.Hash{}).Balance(kafka.Message{Key: key}, partitions...)
+			assert.Equal(t, int32(want), HashPartition(key, n), "key %q, %d 个分区", key, n)
+		}
+	}
+}
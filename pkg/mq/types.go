@@ -0,0 +1,118 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// PartitionAny 发送消息的时候不指定分区，由生产者根据 Key 来选择分区
+const PartitionAny int32 = -1
+
+var (
+	ErrClosed       = errors.New("mq: 已经关闭")
+	ErrUnknownTopic = errors.New("mq: 未知 topic")
+	// ErrGroupActive 消费者组里面还有消费者的时候不能直接修改偏移量
+	ErrGroupActive = errors.New("mq: 消费者组里面还有活跃的消费者")
+)
+
+// Message 是和具体 Kafka 客户端无关的消息
+type Message struct {
+	Topic string
+	// Partition 发送的时候如果是 PartitionAny，就会按照 Key 哈希到某个分区上
+	Partition int32
+	// Offset 只有读出来的消息才有意义
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   []Header
+	Timestamp time.Time
+}
+
+// Header 消息头，可以用来携带一些元数据，例如重试次数、失败原因
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// TopicPartition 代表一个 topic 上的某个分区
+type TopicPartition struct {
+	Topic     string
+	Partition int32
+}
+
+// Producer 生产者
+type Producer interface {
+	// Produce 同步发送消息，返回 nil 就说明消息已经写入了
+	Produce(ctx context.Context, msgs ...Message) error
+	Close() error
+}
+
+// Consumer 消费者，总是在消费者组里面，并且不会自动提交
+type Consumer interface {
+	// Fetch 读取下一条消息，但是不会提交
+	Fetch(ctx context.Context) (Message, error)
+	// Commit 提交消息，每个分区只有偏移量最大的那一条会生效
+	Commit(ctx context.Context, msgs ...Message) error
+	// Pause 暂停分区，在 Resume 之前 Fetch 都不会返回这些分区上的消息
+	Pause(partitions ...TopicPartition) error
+	Resume(partitions ...TopicPartition) error
+	Close() error
+}
+
+// Seeker 可以移动消费位置的 Consumer，不是每种实现都支持
+// 例如把偏移量和业务数据保存在同一个数据库事务里面的时候，每次分区分配变化之后都要从数据库里面恢复消费位置
+type Seeker interface {
+	// Seek 下一次 Fetch 从 offset 开始返回这个分区上的消息，只能移动分配给自己的分区
+	Seek(tp TopicPartition, offset int64) error
+	// Assignment 当前分配给自己的分区，generation 每次重新分配之后都会变，
+	// 调用方发现它变了就要重新 Seek
+	Assignment() (partitions []TopicPartition, generation int64, err error)
+}
+
+// Admin 查询和修改消费者组的偏移量，重置偏移量和重放消息的时候用，Broker 的实现可以选择支持
+type Admin interface {
+	// Watermarks low 是分区上最早的一条消息的偏移量，high 是下一条写入的消息的偏移量
+	Watermarks(ctx context.Context, tp TopicPartition) (low, high int64, err error)
+	// OffsetForTime 第一条时间戳不早于 ts 的消息的偏移量，没有这样的消息就返回 high
+	OffsetForTime(ctx context.Context, tp TopicPartition, ts time.Time) (int64, error)
+	// Committed 消费者组提交的偏移量，也就是下一条要消费的消息，没有提交过的分区不在结果里面
+	Committed(ctx context.Context, groupID string, tps ...TopicPartition) (map[TopicPartition]int64, error)
+	// CommitOffsets 直接修改消费者组的偏移量，消费者组里面还有消费者的时候返回 ErrGroupActive
+	CommitOffsets(ctx context.Context, groupID string, offsets map[TopicPartition]int64) error
+	// Reader 不加入任何消费者组，从 offset 开始读一个分区
+	Reader(tp TopicPartition, offset int64) (Reader, error)
+}
+
+// Reader 只读一个分区，不属于任何消费者组，也不会提交偏移量
+type Reader interface {
+	Fetch(ctx context.Context) (Message, error)
+	Close() error
+}
+
+// Broker 是各种实现的统一入口
+type Broker interface {
+	Producer() (Producer, error)
+	// Consumer 以 groupID 加入消费者组，新的消费者组从最早的消息开始消费
+	Consumer(groupID string, topics ...string) (Consumer, error)
+	// CreateTopic 创建 topic，如果已经存在了就什么也不做
+	CreateTopic(ctx context.Context, topic string, partitions int) error
+	// Partitions 返回 topic 的所有分区
+	Partitions(ctx context.Context, topic string) ([]int32, error)
+	Close() error
+}
+
+// HeaderValue 返回第一个名字为 key 的头部
+func (m Message) HeaderValue(key string) ([]byte, bool) {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return h.Value, true
+		}
+	}
+	return nil, false
+}
+
+// TopicPartition 返回消息所在的分区
+func (m Message) TopicPartition() TopicPartition {
+	return TopicPartition{Topic: m.Topic, Partition: m.Partition}
+}
@@ -0,0 +1,220 @@
+package osc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+	"reflect"
+	"time"
+)
+
+// register 在 db 上注册捕获修改的回调，Run 开始复制之前注册，结束之后用 unregister 移除，
+// DryRun 和还没有 Run 的 Migration 不会影响 db 上的其他语句：
+//   - 写入之前加读锁，切换的时候 cutOver 加写锁，就可以阻塞写入，显式事务一直持有读锁到事务结束
+//   - UPDATE 和 DELETE 执行之前查出来会被影响的主键，执行之后就查不到了
+//   - 事务提交之后再标记为被修改过，不然重新复制的时候读到的可能还是旧数据，显式事务要等 txPool 结束
+func (m *Migration) register() error {
+	cb := m.db.Callback()
+	return errors.Join(
+		cb.Create().Before("gorm:begin_transaction").Register(m.key("lock_create"), m.lock),
+		cb.Create().After("gorm:commit_or_rollback_transaction").Register(m.key("mark_create"), m.mark(true)),
+		cb.Update().Before("gorm:begin_transaction").Register(m.key("lock_update"), m.lock),
+		cb.Update().Before("gorm:update").Register(m.key("collect_update"), m.collect),
+		cb.Update().After("gorm:commit_or_rollback_transaction").Register(m.key("mark_update"), m.mark(false)),
+		cb.Delete().Before("gorm:begin_transaction").Register(m.key("lock_delete"), m.lock),
+		cb.Delete().Before("gorm:delete").Register(m.key("collect_delete"), m.collect),
+		cb.Delete().After("gorm:commit_or_rollback_transaction").Register(m.key("mark_delete"), m.mark(false)),
+	)
+}
+
+// unregister 移除 register 注册的回调，正在执行的语句已经拿到了回调列表，还是会执行完 mark 释放读锁
+func (m *Migration) unregister() error {
+	cb := m.db.Callback()
+	return errors.Join(
+		cb.Create().Remove(m.key("lock_create")),
+		cb.Create().Remove(m.key("mark_create")),
+		cb.Update().Remove(m.key("lock_update")),
+		cb.Update().Remove(m.key("collect_update")),
+		cb.Update().Remove(m.key("mark_update")),
+		cb.Delete().Remove(m.key("lock_delete")),
+		cb.Delete().Remove(m.key("collect_delete")),
+		cb.Delete().Remove(m.key("mark_delete")),
+	)
+}
+
+func (m *Migration) key(name string) string {
+	return "osc:" + m.table + ":" + name
+}
+
+func (m *Migration) lock(db *gorm.DB) {
+	if !m.capturing.Load() || db.Statement.Table != m.table || db.DryRun {
+		return
+	}
+	// 显式事务从第一个语句开始持有读锁，一直到事务结束，所以切换的时候不会有还没提交的修改
+	if tx, ok := db.Statement.ConnPool.(*txPool); ok {
+		m.lockTx(db, tx)
+		return
+	}
+	// 不是通过 New 包装过的 db 开启的事务，不知道什么时候提交，只能每个语句单独标记
+	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok {
+		return
+	}
+	m.gate.RLock()
+	db.InstanceSet(m.key("locked"), true)
+}
+
+// lockTx 事务里面第一次修改原表的时候加读锁，事务结束之后标记修改过的行再释放
+func (m *Migration) lockTx(db *gorm.DB, tx *txPool) {
+	m.mu.Lock()
+	_, ok := m.txs[tx]
+	m.mu.Unlock()
+	if ok {
+		return
+	}
+	// 事务可能已经占着连接了，在这里等 cutOver 的写锁，cutOver 又等这个连接，就死锁了，
+	// 所以切换的时候直接让这个语句失败，事务回滚之后重试就可以
+	if !m.gate.TryRLock() {
+		_ = db.AddError(fmt.Errorf("%w: %s", ErrCuttingOver, m.table))
+		return
+	}
+	m.mu.Lock()
+	m.txs[tx] = nil
+	m.mu.Unlock()
+	tx.onEnd(func() {
+		m.mu.Lock()
+		keys := m.txs[tx]
+		delete(m.txs, tx)
+		m.mu.Unlock()
+		m.MarkDirty(keys...)
+		m.gate.RUnlock()
+	})
+}
+
+func (m *Migration) collect(db *gorm.DB) {
+	if !m.capturing.Load() || db.Statement.Table != m.table || db.DryRun || db.Error != nil {
+		return
+	}
+	keys, err := m.affected(db)
+	if err != nil {
+		_ = db.AddError(err)
+		return
+	}
+	db.InstanceSet(m.key("keys"), keys)
+}
+
+func (m *Migration) mark(create bool) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Statement.Table != m.table {
+			return
+		}
+		if _, ok := db.InstanceGet(m.key("locked")); ok {
+			defer m.gate.RUnlock()
+		}
+		if db.Error != nil || db.DryRun {
+			return
+		}
+		var keys []any
+		if v, ok := db.InstanceGet(m.key("keys")); ok {
+			keys = v.([]any)
+		}
+		// 插入之后才知道自增主键
+		if create {
+			keys = append(keys, primaryValues(db, db.Statement.ReflectValue)...)
+		}
+		// 显式事务里面的修改等事务结束之后再标记
+		if tx, ok := db.Statement.ConnPool.(*txPool); ok && m.deferMark(tx, keys) {
+			return
+		}
+		m.MarkDirty(keys...)
+	}
+}
+
+func (m *Migration) deferMark(tx *txPool, keys []any) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pending, ok := m.txs[tx]
+	if ok {
+		m.txs[tx] = append(pending, keys...)
+	}
+	return ok
+}
+
+// affected UPDATE 或者 DELETE 会影响的主键，可能比实际影响的多，多了只是多复制几行
+func (m *Migration) affected(db *gorm.DB) ([]any, error) {
+	keys := primaryValues(db, db.Statement.ReflectValue)
+	if db.Statement.Model != nil {
+		keys = append(keys, primaryValues(db, reflect.ValueOf(db.Statement.Model))...)
+	}
+	where, ok := db.Statement.Clauses["WHERE"].Expression.(clause.Where)
+	if !ok || len(where.Exprs) == 0 {
+		return keys, nil
+	}
+	// NewDB 会沿用当前的连接，所以在事务里面也能查到
+	tx := db.Session(&gorm.Session{NewDB: true}).Table(m.table)
+	if sch := db.Statement.Schema; sch != nil {
+		// 条件里面可能用到了 clause.PrimaryColumn，需要 Model 才知道主键是哪一列
+		tx = tx.Model(reflect.New(sch.ModelType).Interface())
+	}
+	vals := reflect.New(reflect.SliceOf(m.pk.FieldType))
+	err := tx.Clauses(where).Pluck(m.pk.DBName, vals.Interface()).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < vals.Elem().Len(); i++ {
+		keys = append(keys, vals.Elem().Index(i).Interface())
+	}
+	return keys, nil
+}
+
+// primaryValues 从结构体或者切片里面取出不为零值的主键
+func primaryValues(db *gorm.DB, rv reflect.Value) []any {
+	sch := db.Statement.Schema
+	if sch == nil || sch.PrioritizedPrimaryField == nil {
+		return nil
+	}
+	field := sch.PrioritizedPrimaryField
+	rv = reflect.Indirect(rv)
+	var elems []reflect.Value
+	switch rv.Kind() {
+	case reflect.Struct:
+		elems = append(elems, rv)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			elems = append(elems, reflect.Indirect(rv.Index(i)))
+		}
+	}
+	var res []any
+	for _, elem := range elems {
+		if elem.Kind() != reflect.Struct || elem.Type() != sch.ModelType {
+			continue
+		}
+		val, zero := field.ValueOf(db.Statement.Context, elem)
+		if !zero {
+			res = append(res, val)
+		}
+	}
+	return res
+}
+
+// recorder 记录 DryRun 生成的语句
+type recorder struct {
+	sqls []string
+}
+
+func (r *recorder) LogMode(logger.LogLevel) logger.Interface {
+	return r
+}
+
+func (r *recorder) Info(context.Context, string, ...any) {}
+
+func (r *recorder) Warn(context.Context, string, ...any) {}
+
+func (r *recorder) Error(context.Context, string, ...any) {}
+
+func (r *recorder) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	r.sqls = append(r.sqls, sql)
+}
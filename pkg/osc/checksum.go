@@ -0,0 +1,53 @@
+package osc
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// checksum 按照主键顺序读取 (lower, upper] 范围内的行，计算行数和所有列的哈希
+// 没有用 MySQL 的 CRC32、BIT_XOR 之类的函数，是为了在 SQLite 上也能用
+func (m *Migration) checksum(ctx context.Context, table string, lower, upper any) (string, error) {
+	cols := make([]string, 0, len(m.columns))
+	for _, c := range m.columns {
+		cols = append(cols, m.quote(c))
+	}
+	cond, vars := m.rangeCond(lower, upper)
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s",
+		strings.Join(cols, ","), m.quote(table), cond, m.quote(m.pk.DBName))
+	rows, err := m.db.WithContext(ctx).Raw(query, vars...).Rows()
+	if err != nil {
+		return "", fmt.Errorf("osc: 计算 %s 的 checksum 失败 %w", table, err)
+	}
+	defer rows.Close()
+	h := sha256.New()
+	vals := make([]sql.NullString, len(cols))
+	dest := make([]any, len(cols))
+	for i := range vals {
+		dest[i] = &vals[i]
+	}
+	var count int64
+	for rows.Next() {
+		err = rows.Scan(dest...)
+		if err != nil {
+			return "", err
+		}
+		count++
+		for _, v := range vals {
+			// 带上长度，这样 NULL 和空字符串、("a", "bc") 和 ("ab", "c") 都不一样
+			if v.Valid {
+				fmt.Fprintf(h, "%d:%s", len(v.String), v.String)
+			} else {
+				h.Write([]byte("-"))
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%s", count, hex.EncodeToString(h.Sum(nil))), nil
+}
@@ -0,0 +1,599 @@
+// Package osc 在线表结构变更（online schema change），思路和 gh-ost、pt-online-schema-change 一样：
+//
+//  1. 按照新的结构创建一张影子表
+//  2. 按照主键分批把原表的数据复制到影子表，每一批之间休息一下，避免影响线上业务
+//  3. 复制的过程中通过 gorm 的回调记录原表上被修改过的主键，再从原表把这些行重新复制一遍
+//  4. 复制完之后短暂地阻塞写入，把剩下的修改同步过去，校验两张表的 checksum，最后用 RENAME 交换两张表
+//
+// 因为变更是通过 gorm 的回调捕获的，所以只有通过同一个 *gorm.DB 的 Create、Update、Delete 写入的数据才会被同步，
+// 用 Exec 执行的原生 SQL 需要自己调用 MarkDirty。显式事务里面的修改要等事务结束之后才会标记，
+// 切换的时候会等这些事务结束
+package osc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrChecksum 加锁重新复制之后原表和影子表的数据还是不一样，这时候不会交换两张表
+	ErrChecksum = errors.New("osc: 原表和影子表的数据不一致")
+	// ErrLeftover 上一次迁移留下来的旧表还在，需要先确认没用了再删掉
+	ErrLeftover = errors.New("osc: 上一次迁移留下的表还在")
+	// ErrCuttingOver 正在切换的时候显式事务第一次修改原表会返回这个错误，回滚之后重试就可以
+	ErrCuttingOver = errors.New("osc: 正在切换表")
+)
+
+type Stage string
+
+const (
+	StageInit    Stage = "init"
+	StageCopy    Stage = "copy"
+	StageVerify  Stage = "verify"
+	StageCutOver Stage = "cut_over"
+	StageDone    Stage = "done"
+)
+
+type Options struct {
+	// BatchSize 每一批复制多少行
+	BatchSize int
+	// Interval 两批之间休息多久，用来限流
+	Interval time.Duration
+	// DryRun 只统计要复制多少数据，输出要执行的语句，不会修改数据库
+	DryRun bool
+	// DropOld 切换之后删掉原来的表，默认保留下来，方便回滚
+	DropOld bool
+}
+
+func DefaultOptions() Options {
+	return Options{
+		BatchSize: 1000,
+		Interval:  10 * time.Millisecond,
+	}
+}
+
+// Progress 迁移的进度，Total 是开始复制的时候原表的行数
+type Progress struct {
+	Stage  Stage
+	Paused bool
+	Total  int64
+	Copied int64
+	// Dirty 被修改过、还没有重新复制的行数
+	Dirty int
+	// Applied 因为被修改过而重新复制的行数
+	Applied int64
+}
+
+// Plan DryRun 的时候输出的内容
+type Plan struct {
+	Table   string
+	Shadow  string
+	Old     string
+	Columns []string
+	Rows    int64
+	Batches int64
+	// Statements 要执行的语句，复制的语句只列出了第一批
+	Statements []string
+}
+
+// Migration 把 table 迁移成 model 的结构，迁移完之后表名不变
+// 同一张表同一时间只能有一个 Migration
+type Migration struct {
+	db     *gorm.DB
+	model  any
+	opts   Options
+	table  string
+	shadow string
+	old    string
+	pk     *schema.Field
+
+	// columns 原表和新结构都有的列，只复制这些列
+	columns []string
+
+	// gate 切换的时候加写锁，阻塞通过 gorm 写原表的语句
+	gate      sync.RWMutex
+	capturing atomic.Bool
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	dirty map[string]any
+	// txs 修改过原表、还没有结束的显式事务，以及事务里面修改过的主键
+	txs      map[*txPool][]any
+	progress Progress
+}
+
+// New model 是迁移之后的结构，例如 &UserAfter{}，表名用的是 table 而不是 model 的表名
+// Run 的时候会在 db 上注册回调，所以 db 应该是业务代码用的同一个 *gorm.DB
+// New 会包装 db 的连接池，这样才知道显式事务什么时候结束，所以要在并发使用 db 之前调用
+func New(db *gorm.DB, table string, model any, opts Options) (*Migration, error) {
+	if opts.BatchSize <= 0 {
+		return nil, fmt.Errorf("osc: BatchSize 必须大于 0")
+	}
+	stmt := &gorm.Statement{DB: db}
+	err := stmt.Parse(model)
+	if err != nil {
+		return nil, err
+	}
+	pk := stmt.Schema.PrioritizedPrimaryField
+	if pk == nil {
+		return nil, fmt.Errorf("osc: %T 必须有且只有一个主键", model)
+	}
+	m := &Migration{
+		db:       db,
+		model:    model,
+		opts:     opts,
+		table:    table,
+		shadow:   "_" + table + "_new",
+		old:      "_" + table + "_old",
+		pk:       pk,
+		dirty:    make(map[string]any),
+		txs:      make(map[*txPool][]any),
+		progress: Progress{Stage: StageInit},
+	}
+	m.cond = sync.NewCond(&m.mu)
+	wrapConnPool(db)
+	return m, nil
+}
+
+// Pause 当前这一批复制完之后暂停，切换开始之后就不能暂停了
+func (m *Migration) Pause() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.progress.Paused = true
+}
+
+func (m *Migration) Resume() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.progress.Paused = false
+	m.cond.Broadcast()
+}
+
+func (m *Migration) Progress() Progress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := m.progress
+	res.Dirty = len(m.dirty)
+	return res
+}
+
+// MarkDirty 告诉 Migration 这些行被修改过了，绕过 gorm 回调修改原表的时候要调用
+func (m *Migration) MarkDirty(keys ...any) {
+	if !m.capturing.Load() {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range keys {
+		m.dirty[fmt.Sprint(k)] = k
+	}
+}
+
+// Run 执行迁移，ctx 被取消的时候会停下来，影子表会保留下来，但是下一次 Run 会重新创建影子表
+func (m *Migration) Run(ctx context.Context) error {
+	plan, err := m.Plan(ctx)
+	if err != nil {
+		return err
+	}
+	if m.opts.DryRun {
+		for _, s := range plan.Statements {
+			slog.Info("DryRun", slog.String("table", m.table), slog.String("sql", s))
+		}
+		return nil
+	}
+
+	db := m.db.WithContext(ctx)
+	if db.Migrator().HasTable(m.shadow) {
+		slog.Warn("删除上一次迁移留下的影子表", slog.String("table", m.shadow))
+		err = db.Migrator().DropTable(m.shadow)
+		if err != nil {
+			return err
+		}
+	}
+	err = db.Table(m.shadow).Migrator().CreateTable(m.model)
+	if err != nil {
+		return fmt.Errorf("osc: 创建影子表失败 %w", err)
+	}
+
+	// 注册失败的时候可能已经注册了一部分，所以也要移除
+	err = m.register()
+	defer func() {
+		if err := m.unregister(); err != nil {
+			slog.Error("移除回调失败", slog.String("table", m.table), slog.Any("err", err))
+		}
+	}()
+	if err != nil {
+		return fmt.Errorf("osc: 注册回调失败 %w", err)
+	}
+	// 先开始记录修改，再开始复制，这样复制过程中的修改都不会漏掉
+	m.capturing.Store(true)
+	defer m.capturing.Store(false)
+	m.setProgress(func(p *Progress) {
+		p.Stage, p.Total = StageCopy, plan.Rows
+	})
+	bounds, err := m.copy(ctx)
+	if err != nil {
+		return err
+	}
+	return m.cutOver(ctx, bounds)
+}
+
+// Plan 统计要复制的数据，不会修改数据库
+func (m *Migration) Plan(ctx context.Context) (Plan, error) {
+	db := m.db.WithContext(ctx)
+	if db.Migrator().HasTable(m.old) {
+		return Plan{}, fmt.Errorf("%w: %s", ErrLeftover, m.old)
+	}
+	err := m.initColumns(db)
+	if err != nil {
+		return Plan{}, err
+	}
+	plan := Plan{Table: m.table, Shadow: m.shadow, Old: m.old, Columns: m.columns}
+	err = db.Table(m.table).Count(&plan.Rows).Error
+	if err != nil {
+		return Plan{}, err
+	}
+	bs := int64(m.opts.BatchSize)
+	plan.Batches = (plan.Rows + bs - 1) / bs
+
+	// 借助 DryRun 拿到建表语句
+	rec := &recorder{}
+	err = db.Session(&gorm.Session{DryRun: true, Logger: rec}).Table(m.shadow).Migrator().CreateTable(m.model)
+	if err != nil {
+		return Plan{}, err
+	}
+	plan.Statements = append(plan.Statements, rec.sqls...)
+	upper, ok, err := m.nextBound(ctx, nil, nil)
+	if err != nil {
+		return Plan{}, err
+	}
+	if ok {
+		cond, vars := m.rangeCond(nil, upper)
+		del, ins := m.copySQL(cond)
+		plan.Statements = append(plan.Statements,
+			m.db.Dialector.Explain(del, vars...), m.db.Dialector.Explain(ins, vars...))
+	}
+	plan.Statements = append(plan.Statements, m.swapSQL()...)
+	if m.opts.DropOld {
+		plan.Statements = append(plan.Statements, "DROP TABLE "+m.quote(m.old))
+	}
+	return plan, nil
+}
+
+func (m *Migration) initColumns(db *gorm.DB) error {
+	types, err := db.Migrator().ColumnTypes(m.table)
+	if err != nil {
+		return fmt.Errorf("osc: 读取 %s 的列失败 %w", m.table, err)
+	}
+	existing := make(map[string]struct{}, len(types))
+	for _, t := range types {
+		existing[t.Name()] = struct{}{}
+	}
+	stmt := &gorm.Statement{DB: db}
+	err = stmt.Parse(m.model)
+	if err != nil {
+		return err
+	}
+	m.columns = m.columns[:0]
+	for _, name := range stmt.Schema.DBNames {
+		if _, ok := existing[name]; ok {
+			m.columns = append(m.columns, name)
+		}
+	}
+	return nil
+}
+
+// copy 按照主键分批复制，返回每一批的上界，校验的时候也是按照这些范围分批计算 checksum
+// 只复制到开始的时候最大的主键，后面新插入的行已经被回调记录下来了，不然一直有插入的话就永远复制不完
+func (m *Migration) copy(ctx context.Context) ([]any, error) {
+	last, ok, err := m.lastKey(ctx, nil, nil)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var (
+		bounds []any
+		lower  any
+	)
+	for {
+		err = m.wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		upper, ok, err := m.nextBound(ctx, lower, last)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return bounds, nil
+		}
+		n, err := m.copyRange(ctx, lower, upper)
+		if err != nil {
+			return nil, err
+		}
+		err = m.applyDirty(ctx)
+		if err != nil {
+			return nil, err
+		}
+		m.setProgress(func(p *Progress) {
+			p.Copied += n
+		})
+		bounds = append(bounds, upper)
+		lower = upper
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(m.opts.Interval):
+		}
+	}
+}
+
+// wait 暂停的时候阻塞，直到 Resume 或者 ctx 被取消
+func (m *Migration) wait(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.cond.Broadcast()
+	})
+	defer stop()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for m.progress.Paused && ctx.Err() == nil {
+		m.cond.Wait()
+	}
+	return ctx.Err()
+}
+
+// nextBound 找到 (lower, last] 里面第 BatchSize 行的主键，不够一批就返回最大的主键，
+// last 为 nil 说明没有上界
+func (m *Migration) nextBound(ctx context.Context, lower, last any) (any, bool, error) {
+	vals := reflect.New(reflect.SliceOf(m.pk.FieldType))
+	pk := clause.Column{Name: m.pk.DBName}
+	err := m.keyRange(ctx, lower, last).Order(clause.OrderByColumn{Column: pk}).
+		Offset(m.opts.BatchSize-1).Limit(1).Pluck(m.pk.DBName, vals.Interface()).Error
+	if err != nil {
+		return nil, false, err
+	}
+	if vals.Elem().Len() == 0 {
+		return m.lastKey(ctx, lower, last)
+	}
+	return vals.Elem().Index(0).Interface(), true, nil
+}
+
+// lastKey (lower, last] 里面最大的主键
+func (m *Migration) lastKey(ctx context.Context, lower, last any) (any, bool, error) {
+	vals := reflect.New(reflect.SliceOf(m.pk.FieldType))
+	pk := clause.Column{Name: m.pk.DBName}
+	err := m.keyRange(ctx, lower, last).Order(clause.OrderByColumn{Column: pk, Desc: true}).
+		Limit(1).Pluck(m.pk.DBName, vals.Interface()).Error
+	if err != nil || vals.Elem().Len() == 0 {
+		return nil, false, err
+	}
+	return vals.Elem().Index(0).Interface(), true, nil
+}
+
+func (m *Migration) keyRange(ctx context.Context, lower, last any) *gorm.DB {
+	cond, vars := m.rangeCond(lower, last)
+	return m.db.WithContext(ctx).Table(m.table).Where(cond, vars...)
+}
+
+// copyRange 复制 (lower, upper] 范围内的行，先删后插，所以重复执行也没关系
+func (m *Migration) copyRange(ctx context.Context, lower, upper any) (int64, error) {
+	cond, vars := m.rangeCond(lower, upper)
+	del, ins := m.copySQL(cond)
+	var n int64
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(del, vars...).Error
+		if err != nil {
+			return err
+		}
+		res := tx.Exec(ins, vars...)
+		n = res.RowsAffected
+		return res.Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("osc: 复制 (%v, %v] 失败 %w", lower, upper, err)
+	}
+	return n, nil
+}
+
+// applyDirty 从原表重新复制被修改过的行，原表里面已经没有的行在影子表里面也会被删掉
+func (m *Migration) applyDirty(ctx context.Context) error {
+	m.mu.Lock()
+	keys := make([]any, 0, len(m.dirty))
+	for _, k := range m.dirty {
+		keys = append(keys, k)
+	}
+	m.dirty = make(map[string]any)
+	m.mu.Unlock()
+
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), m.opts.BatchSize)]
+		keys = keys[len(batch):]
+		del, ins := m.copySQL(m.quote(m.pk.DBName) + " IN ?")
+		err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			err := tx.Exec(del, batch).Error
+			if err != nil {
+				return err
+			}
+			return tx.Exec(ins, batch).Error
+		})
+		if err != nil {
+			// 没有复制成功的放回去，下一次再试
+			m.MarkDirty(append(batch, keys...)...)
+			return fmt.Errorf("osc: 同步修改失败 %w", err)
+		}
+		m.setProgress(func(p *Progress) {
+			p.Applied += int64(len(batch))
+		})
+	}
+	return nil
+}
+
+// cutOver 先在不阻塞写入的情况下校验一遍，再加锁同步剩下的修改，
+// 重新复制不一致的范围之后再校验一遍，然后交换两张表
+func (m *Migration) cutOver(ctx context.Context, bounds []any) error {
+	m.setProgress(func(p *Progress) {
+		p.Stage = StageVerify
+	})
+	err := m.applyDirty(ctx)
+	if err != nil {
+		return err
+	}
+	ranges := make([][2]any, 0, len(bounds)+1)
+	var lower any
+	for _, b := range bounds {
+		ranges = append(ranges, [2]any{lower, b})
+		lower = b
+	}
+	// 最后一个范围是开区间，复制期间新插入的行都在这里
+	ranges = append(ranges, [2]any{lower, nil})
+	// 这时候还在写入，不一致的可能只是刚刚被修改过，所以加锁之后再校验一遍
+	mismatched, err := m.verify(ctx, ranges)
+	if err != nil {
+		return err
+	}
+
+	m.setProgress(func(p *Progress) {
+		p.Stage = StageCutOver
+	})
+	m.gate.Lock()
+	defer m.gate.Unlock()
+	start := time.Now()
+	err = m.applyDirty(ctx)
+	if err != nil {
+		return err
+	}
+	mismatched, err = m.verify(ctx, mismatched)
+	if err != nil {
+		return err
+	}
+	// 还不一致的话可能是绕过 gorm 修改了原表又没有调用 MarkDirty，已经阻塞了写入，直接重新复制
+	for _, r := range mismatched {
+		_, err = m.copyRange(ctx, r[0], r[1])
+		if err != nil {
+			return err
+		}
+	}
+	mismatched, err = m.verify(ctx, mismatched)
+	if err != nil {
+		return err
+	}
+	if len(mismatched) > 0 {
+		return fmt.Errorf("%w: %s 主键范围 %v", ErrChecksum, m.table, mismatched)
+	}
+	err = m.swap(ctx)
+	if err != nil {
+		return err
+	}
+	m.capturing.Store(false)
+	slog.Info("表结构变更完成", slog.String("table", m.table),
+		slog.Duration("blocked", time.Since(start)))
+	if m.opts.DropOld {
+		err = m.db.WithContext(ctx).Migrator().DropTable(m.old)
+		if err != nil {
+			return err
+		}
+	}
+	m.setProgress(func(p *Progress) {
+		p.Stage = StageDone
+	})
+	return nil
+}
+
+func (m *Migration) verify(ctx context.Context, ranges [][2]any) ([][2]any, error) {
+	var mismatched [][2]any
+	for _, r := range ranges {
+		src, err := m.checksum(ctx, m.table, r[0], r[1])
+		if err != nil {
+			return nil, err
+		}
+		dst, err := m.checksum(ctx, m.shadow, r[0], r[1])
+		if err != nil {
+			return nil, err
+		}
+		if src != dst {
+			mismatched = append(mismatched, r)
+		}
+	}
+	return mismatched, nil
+}
+
+func (m *Migration) swap(ctx context.Context) error {
+	sqls := m.swapSQL()
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, s := range sqls {
+			err := tx.Exec(s).Error
+			if err != nil {
+				return fmt.Errorf("osc: 交换表失败 %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// swapSQL MySQL 的 RENAME TABLE 可以一次交换两张表，中间没有表不存在的时刻；
+// 别的数据库在一个事务里面改两次名字
+func (m *Migration) swapSQL() []string {
+	table, shadow, old := m.quote(m.table), m.quote(m.shadow), m.quote(m.old)
+	if m.db.Dialector.Name() == "mysql" {
+		return []string{fmt.Sprintf("RENAME TABLE %s TO %s, %s TO %s", table, old, shadow, table)}
+	}
+	return []string{
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", table, old),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", shadow, table),
+	}
+}
+
+// copySQL 返回删除影子表里面的行和从原表复制的语句，cond 是 WHERE 后面的条件
+func (m *Migration) copySQL(cond string) (string, string) {
+	cols := make([]string, 0, len(m.columns))
+	for _, c := range m.columns {
+		cols = append(cols, m.quote(c))
+	}
+	colStr := strings.Join(cols, ",")
+	del := fmt.Sprintf("DELETE FROM %s WHERE %s", m.quote(m.shadow), cond)
+	ins := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s WHERE %s",
+		m.quote(m.shadow), colStr, colStr, m.quote(m.table), cond)
+	return del, ins
+}
+
+// rangeCond (lower, upper]，lower 或者 upper 为 nil 说明那一边没有限制
+func (m *Migration) rangeCond(lower, upper any) (string, []any) {
+	pk := m.quote(m.pk.DBName)
+	var (
+		conds []string
+		vars  []any
+	)
+	if lower != nil {
+		conds = append(conds, pk+" > ?")
+		vars = append(vars, lower)
+	}
+	if upper != nil {
+		conds = append(conds, pk+" <= ?")
+		vars = append(vars, upper)
+	}
+	if len(conds) == 0 {
+		return "1 = 1", nil
+	}
+	return strings.Join(conds, " AND "), vars
+}
+
+func (m *Migration) quote(name string) string {
+	return m.db.Statement.Quote(name)
+}
+
+func (m *Migration) setProgress(fn func(p *Progress)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn(&m.progress)
+}
@@ -0,0 +1,225 @@
+package osc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"interview-cases/test"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// oscUser 迁移前的结构
+type oscUser struct {
+	ID   uint   `gorm:"primaryKey"`
+	Name string `gorm:"unique;type:varchar(255)"`
+	Age  int
+}
+
+func (oscUser) TableName() string {
+	return "osc_users"
+}
+
+// oscUserV2 迁移之后多了一个联合索引和一列
+type oscUserV2 struct {
+	ID    uint   `gorm:"primaryKey"`
+	Name  string `gorm:"index:idx_osc_name_age;type:varchar(255)"`
+	Age   int    `gorm:"index:idx_osc_name_age"`
+	Email string `gorm:"type:varchar(255)"`
+}
+
+func initDB(t *testing.T, rows int) *gorm.DB {
+	db := test.InitMemoryDB(t)
+	require.NoError(t, db.AutoMigrate(&oscUser{}))
+	users := make([]oscUser, 0, rows)
+	for i := 1; i <= rows; i++ {
+		users = append(users, oscUser{Name: fmt.Sprintf("user_%d", i), Age: i % 50})
+	}
+	require.NoError(t, db.CreateInBatches(&users, 100).Error)
+	return db
+}
+
+func TestDryRun(t *testing.T) {
+	db := initDB(t, 120)
+	opts := DefaultOptions()
+	opts.BatchSize, opts.DryRun = 50, true
+	m, err := New(db, "osc_users", &oscUserV2{}, opts)
+	require.NoError(t, err)
+	assert.False(t, registered(db))
+	plan, err := m.Plan(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(120), plan.Rows)
+	assert.Equal(t, int64(3), plan.Batches)
+	// email 是新加的列，原表里面没有
+	assert.Equal(t, []string{"id", "name", "age"}, plan.Columns)
+	sqls := strings.Join(plan.Statements, "\n")
+	assert.Contains(t, sqls, "CREATE TABLE `_osc_users_new`")
+	assert.Contains(t, sqls, "`id` <= 50")
+	assert.Contains(t, sqls, "ALTER TABLE `_osc_users_new` RENAME TO `osc_users`")
+
+	require.NoError(t, m.Run(context.Background()))
+	assert.False(t, registered(db))
+	assert.False(t, db.Migrator().HasTable("_osc_users_new"))
+	assert.False(t, db.Migrator().HasIndex(&oscUser{}, "idx_osc_name_age"))
+}
+
+// TestRun 复制的过程中一直有增删改，切换之后新表和旧表的数据应该完全一样
+func TestRun(t *testing.T) {
+	db := initDB(t, 500)
+	opts := DefaultOptions()
+	opts.BatchSize, opts.Interval = 50, 5*time.Millisecond
+	m, err := New(db, "osc_users", &oscUserV2{}, opts)
+	require.NoError(t, err)
+	assert.False(t, registered(db))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	writes := 0
+	var capturing bool
+	// cutting 开始切换之后就不再写入，每个语句之前都要检查，
+	// 不然切换的时候被阻塞的语句会在交换之后写到新表里面
+	cutting := func() bool {
+		stage := m.Progress().Stage
+		return stage == StageCutOver || stage == StageDone
+	}
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			if m.Progress().Stage == StageCopy {
+				capturing = capturing || registered(db)
+			}
+			u := oscUser{Name: fmt.Sprintf("new_user_%d", i), Age: i}
+			ops := []func() error{
+				func() error { return db.Create(&u).Error },
+				func() error { return db.Model(&u).Update("age", i+1000).Error },
+				func() error { return db.Model(&oscUser{}).Where("id = ?", i%500+1).Update("age", i).Error },
+			}
+			if i%3 == 0 {
+				ops = append(ops, func() error { return db.Delete(&oscUser{}, i%500+1).Error })
+			}
+			if i%5 == 0 {
+				ops = append(ops, func() error {
+					err := db.Transaction(func(tx *gorm.DB) error {
+						err := tx.Create(&oscUser{Name: fmt.Sprintf("tx_user_%d", i)}).Error
+						if err != nil {
+							return err
+						}
+						return tx.Model(&oscUser{}).Where("id = ?", (i+7)%500+1).Update("age", -i).Error
+					})
+					// 刚好开始切换的话事务会失败
+					if errors.Is(err, ErrCuttingOver) {
+						return nil
+					}
+					return err
+				})
+			}
+			for _, op := range ops {
+				if cutting() {
+					return
+				}
+				assert.NoError(t, op())
+			}
+			writes++
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	require.NoError(t, m.Run(context.Background()))
+	wg.Wait()
+	assert.Greater(t, writes, 0)
+	// 复制的时候注册了回调，结束之后移除
+	assert.True(t, capturing)
+	assert.False(t, registered(db))
+
+	p := m.Progress()
+	assert.Equal(t, StageDone, p.Stage)
+	assert.GreaterOrEqual(t, p.Total, int64(500))
+	assert.Greater(t, p.Applied, int64(0))
+	assert.True(t, db.Migrator().HasIndex(&oscUser{}, "idx_osc_name_age"))
+	assert.True(t, db.Migrator().HasColumn(&oscUser{}, "email"))
+
+	var want, got []oscUser
+	require.NoError(t, db.Table("_osc_users_old").Order("id").Find(&want).Error)
+	require.NoError(t, db.Order("id").Find(&got).Error)
+	assert.Equal(t, want, got)
+
+	// 旧表还在，需要人工确认之后删掉才能再次迁移
+	_, err = m.Plan(context.Background())
+	assert.ErrorIs(t, err, ErrLeftover)
+}
+
+// registered osc_users 上有没有捕获修改的回调
+func registered(db *gorm.DB) bool {
+	return db.Callback().Create().Get("osc:osc_users:lock_create") != nil
+}
+
+// TestChecksum 绕过 gorm 回调修改原表，又没有调用 MarkDirty，
+// 加锁之后校验不通过的范围会重新复制，切换之后的数据还是一样的
+func TestChecksum(t *testing.T) {
+	db := initDB(t, 200)
+	opts := DefaultOptions()
+	opts.BatchSize = 20
+	m, err := New(db, "osc_users", &oscUserV2{}, opts)
+	require.NoError(t, err)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- m.Run(context.Background())
+	}()
+	require.Eventually(t, func() bool {
+		return m.Progress().Copied >= 40
+	}, time.Second, time.Millisecond)
+	m.Pause()
+	// 暂停之后最多再复制完当前这一批
+	time.Sleep(20 * time.Millisecond)
+	p := m.Progress()
+	assert.True(t, p.Paused)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, p.Copied, m.Progress().Copied)
+
+	require.NoError(t, db.Exec("UPDATE osc_users SET age = -1 WHERE id = 1").Error)
+	m.Resume()
+	require.NoError(t, <-errCh)
+	assert.True(t, db.Migrator().HasIndex(&oscUser{}, "idx_osc_name_age"))
+	var u oscUser
+	require.NoError(t, db.First(&u, 1).Error)
+	assert.Equal(t, -1, u.Age)
+}
+
+// TestTransaction 显式事务里面的修改等事务结束之后才标记，事务结束之前切换拿不到写锁
+func TestTransaction(t *testing.T) {
+	db := initDB(t, 10)
+	m, err := New(db, "osc_users", &oscUserV2{}, DefaultOptions())
+	require.NoError(t, err)
+	require.NoError(t, m.register())
+	defer func() {
+		require.NoError(t, m.unregister())
+	}()
+	m.capturing.Store(true)
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		require.NoError(t, tx.Model(&oscUser{}).Where("id = ?", 1).Update("age", 100).Error)
+		require.NoError(t, tx.Create(&oscUser{Name: "tx_user"}).Error)
+		// 还没有提交，这时候重新复制读到的还是旧数据
+		assert.Equal(t, 0, m.Progress().Dirty)
+		assert.False(t, m.gate.TryLock())
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, m.Progress().Dirty)
+	require.True(t, m.gate.TryLock())
+
+	// 切换的时候显式事务不能再开始修改原表，回滚之后重试
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return tx.Model(&oscUser{}).Where("id = ?", 2).Update("age", 100).Error
+	})
+	assert.ErrorIs(t, err, ErrCuttingOver)
+	m.gate.Unlock()
+	var u oscUser
+	require.NoError(t, db.First(&u, 2).Error)
+	assert.Equal(t, 2, u.Age)
+}
@@ -0,0 +1,97 @@
+package osc
+
+import (
+	"context"
+	"database/sql"
+	"gorm.io/gorm"
+	"sync"
+)
+
+// connPool 包装 db 的连接池，开启的事务都是 txPool，这样才知道事务什么时候结束
+// gorm 的回调只能看到单个语句，显式事务里面的语句执行完的时候事务还没有提交
+type connPool struct {
+	gorm.ConnPool
+}
+
+// wrapConnPool 只包装一次，同一个 db 上的多个 Migration 共用
+// 修改的是 db 的配置，所以要在并发使用 db 之前调用
+func wrapConnPool(db *gorm.DB) {
+	if _, ok := db.Config.ConnPool.(*connPool); ok {
+		return
+	}
+	pool := &connPool{ConnPool: db.Config.ConnPool}
+	if db.Statement.ConnPool == db.Config.ConnPool {
+		db.Statement.ConnPool = pool
+	}
+	db.Config.ConnPool = pool
+}
+
+func (p *connPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	var (
+		tx  gorm.ConnPool
+		err error
+	)
+	switch beginner := p.ConnPool.(type) {
+	case gorm.TxBeginner:
+		tx, err = beginner.BeginTx(ctx, opts)
+	case gorm.ConnPoolBeginner:
+		tx, err = beginner.BeginTx(ctx, opts)
+	default:
+		return nil, gorm.ErrInvalidTransaction
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &txPool{ConnPool: tx, parent: p}, nil
+}
+
+func (p *connPool) GetDBConn() (*sql.DB, error) {
+	if sqlDB, ok := p.ConnPool.(*sql.DB); ok {
+		return sqlDB, nil
+	}
+	if connector, ok := p.ConnPool.(gorm.GetDBConnector); ok {
+		return connector.GetDBConn()
+	}
+	return nil, gorm.ErrInvalidDB
+}
+
+// txPool 事务提交或者回滚之后执行 onEnd 注册的函数
+type txPool struct {
+	gorm.ConnPool
+	parent *connPool
+
+	mu    sync.Mutex
+	hooks []func()
+}
+
+func (tx *txPool) Commit() error {
+	defer tx.end()
+	return tx.ConnPool.(gorm.TxCommitter).Commit()
+}
+
+func (tx *txPool) Rollback() error {
+	defer tx.end()
+	return tx.ConnPool.(gorm.TxCommitter).Rollback()
+}
+
+func (tx *txPool) GetDBConn() (*sql.DB, error) {
+	return tx.parent.GetDBConn()
+}
+
+// onEnd 不管提交成功还是失败都会执行，提交失败的时候数据也可能已经写进去了
+func (tx *txPool) onEnd(fn func()) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.hooks = append(tx.hooks, fn)
+}
+
+// end 提交失败之后 gorm 还会再回滚一次，hooks 只执行一次
+func (tx *txPool) end() {
+	tx.mu.Lock()
+	hooks := tx.hooks
+	tx.hooks = nil
+	tx.mu.Unlock()
+	for _, fn := range hooks {
+		fn()
+	}
+}
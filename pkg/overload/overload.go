@@ -0,0 +1,120 @@
+// Package overload 让服务端在撑不住的时候明确告诉调用方：返回 429 和 Retry-After，
+// 而不是等数据库超时之后返回 500，调用方（例如消息队列的消费者）收到之后降低并发、暂停一会儿。
+// 判断依据是数据库的耗时和正在处理的请求数量
+package overload
+
+import (
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Options struct {
+	// MaxInflight 正在处理的请求达到这个数量之后，新的请求直接拒绝
+	MaxInflight int64
+	// MaxLatency 数据库耗时的移动平均值超过它之后，只放一个请求进去探测数据库有没有恢复
+	MaxLatency time.Duration
+	// RetryAfter 拒绝的时候让调用方至少等多久，数据库越慢等得越久，最多等 MaxRetryAfter
+	RetryAfter    time.Duration
+	MaxRetryAfter time.Duration
+}
+
+func DefaultOptions() Options {
+	return Options{
+		MaxInflight:   64,
+		MaxLatency:    200 * time.Millisecond,
+		RetryAfter:    time.Second,
+		MaxRetryAfter: 10 * time.Second,
+	}
+}
+
+// ewmaAlpha 越大越看重最近的耗时
+const ewmaAlpha = 0.2
+
+// Shedder 过载的时候拒绝请求
+type Shedder struct {
+	opts     Options
+	inflight atomic.Int64
+	rejected atomic.Int64
+
+	mu      sync.Mutex
+	latency time.Duration
+}
+
+func New(opts Options) *Shedder {
+	return &Shedder{opts: opts}
+}
+
+// Observe 记录一次数据库操作的耗时，业务代码在每次访问数据库之后调用
+func (s *Shedder) Observe(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.latency == 0 {
+		s.latency = d
+		return
+	}
+	s.latency = time.Duration(ewmaAlpha*float64(d) + (1-ewmaAlpha)*float64(s.latency))
+}
+
+// Allow 不过载的时候返回 true，这个时候调用方要在请求结束之后调用 Done；
+// 过载的时候返回 false 和建议调用方等多久
+func (s *Shedder) Allow() (time.Duration, bool) {
+	latency := s.Latency()
+	n := s.inflight.Add(1)
+	// 数据库变慢之后只留一个请求，否则没有新的耗时，永远发现不了数据库已经恢复了
+	if n > s.opts.MaxInflight || (latency > s.opts.MaxLatency && n > 1) {
+		s.inflight.Add(-1)
+		s.rejected.Add(1)
+		return s.retryAfter(latency), false
+	}
+	return 0, true
+}
+
+func (s *Shedder) Done() {
+	s.inflight.Add(-1)
+}
+
+// retryAfter 数据库耗时是阈值的几倍，就等几倍的 RetryAfter
+func (s *Shedder) retryAfter(latency time.Duration) time.Duration {
+	d := s.opts.RetryAfter
+	if latency > s.opts.MaxLatency && s.opts.MaxLatency > 0 {
+		d = time.Duration(float64(d) * float64(latency) / float64(s.opts.MaxLatency))
+	}
+	return min(d, s.opts.MaxRetryAfter)
+}
+
+func (s *Shedder) Latency() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latency
+}
+
+type Stats struct {
+	Inflight int64         `json:"inflight"`
+	Rejected int64         `json:"rejected"`
+	Latency  time.Duration `json:"latency"`
+}
+
+func (s *Shedder) Stats() Stats {
+	return Stats{Inflight: s.inflight.Load(), Rejected: s.rejected.Load(), Latency: s.Latency()}
+}
+
+// Middleware 过载的时候返回 429，Retry-After 只能是整数秒，所以向上取整
+func (s *Shedder) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		d, ok := s.Allow()
+		if !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+			c.AbortWithStatus(http.StatusTooManyRequests)
+			slog.Debug("过载，拒绝请求", slog.String("path", c.FullPath()), slog.Duration("retryAfter", d))
+			return
+		}
+		defer s.Done()
+		c.Next()
+	}
+}
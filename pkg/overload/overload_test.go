@@ -0,0 +1,80 @@
+package overload
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestShedder_Allow(t *testing.T) {
+	s := New(Options{MaxInflight: 2, MaxLatency: 100 * time.Millisecond, RetryAfter: time.Second, MaxRetryAfter: 3 * time.Second})
+	// 正在处理的请求太多
+	_, ok := s.Allow()
+	assert.True(t, ok)
+	_, ok = s.Allow()
+	assert.True(t, ok)
+	d, ok := s.Allow()
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, d)
+	s.Done()
+	s.Done()
+
+	// 数据库变慢之后只放一个请求进去
+	s.Observe(200 * time.Millisecond)
+	_, ok = s.Allow()
+	assert.True(t, ok)
+	d, ok = s.Allow()
+	assert.False(t, ok)
+	assert.Equal(t, 2*time.Second, d)
+	// 越慢等得越久，但是有上限
+	s.Observe(5 * time.Second)
+	d, ok = s.Allow()
+	assert.False(t, ok)
+	assert.Equal(t, 3*time.Second, d)
+	s.Done()
+
+	// 数据库恢复之后
+	for i := 0; i < 30; i++ {
+		s.Observe(time.Millisecond)
+	}
+	_, ok = s.Allow()
+	assert.True(t, ok)
+	_, ok = s.Allow()
+	assert.True(t, ok)
+	s.Done()
+	s.Done()
+	assert.Equal(t, Stats{Inflight: 0, Rejected: 3, Latency: s.Latency()}, s.Stats())
+}
+
+func TestShedder_Middleware(t *testing.T) {
+	s := New(Options{MaxInflight: 1, MaxLatency: time.Second, RetryAfter: 1500 * time.Millisecond, MaxRetryAfter: 10 * time.Second})
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	block := make(chan struct{})
+	r.GET("/", s.Middleware(), func(c *gin.Context) {
+		<-block
+		c.String(http.StatusOK, "OK")
+	})
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		done <- w.Code
+	}()
+	assert.Eventually(t, func() bool {
+		return s.Stats().Inflight == 1
+	}, time.Second, time.Millisecond)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	// 向上取整
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	close(block)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Zero(t, s.Stats().Inflight)
+}
@@ -0,0 +1,140 @@
+package slowlog
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// IN (?, ?, ?) 不管有多少个参数都是同一种查询
+	inList = regexp.MustCompile(`in ?\(\?(?:, ?\?)*\)`)
+	// 批量插入 VALUES (?,?),(?,?) 不管有多少行都是同一种查询
+	valuesList = regexp.MustCompile(`values ?\([?, ]*\)(?:, ?\([?, ]*\))*`)
+)
+
+// Fingerprint 把 SQL 归一化成指纹，只是参数不一样的语句有同一个指纹：
+// 字符串和数字都替换成 ?，IN 列表和多行 VALUES 折叠成 (?+)，
+// 去掉注释，连续的空白变成一个空格，关键字和标识符都转成小写，反引号里面的内容保持原样
+func Fingerprint(sql string) string {
+	var sb strings.Builder
+	sb.Grow(len(sql))
+	space := false
+	// write 写入一段内容，之前有空白的话先补一个空格
+	write := func(s string) {
+		if space && sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		space = false
+		sb.WriteString(s)
+	}
+	n := len(sql)
+	for i := 0; i < n; {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			i++
+		case c == '\'' || c == '"':
+			i = skipQuoted(sql, i)
+			write("?")
+		case c == '`':
+			end := strings.IndexByte(sql[i+1:], '`')
+			if end < 0 {
+				write(sql[i:])
+				i = n
+				continue
+			}
+			write(sql[i : i+end+2])
+			i += end + 2
+		case c == '#' || (c == '-' && i+1 < n && sql[i+1] == '-'):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = n - i
+			}
+			i += end
+			space = true
+		case c == '/' && i+1 < n && sql[i+1] == '*':
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = n
+			} else {
+				i += end + 4
+			}
+			space = true
+		case isDigit(c):
+			i = skipNumber(sql, i)
+			write("?")
+		default:
+			// 标识符整个读出来，所以 orders_v1 里面的 1 不会被当成数字
+			j := i
+			for j < n && isIdent(sql[j]) {
+				j++
+			}
+			if j == i {
+				j++
+			}
+			write(strings.ToLower(sql[i:j]))
+			i = j
+		}
+	}
+	res := strings.TrimRight(sb.String(), "; ")
+	res = inList.ReplaceAllString(res, "in (?+)")
+	return valuesList.ReplaceAllString(res, "values (?+)")
+}
+
+// skipQuoted 返回引号结束之后的位置，支持反斜杠转义和两个引号连写的转义
+func skipQuoted(sql string, i int) int {
+	quote := sql[i]
+	i++
+	for i < len(sql) {
+		switch sql[i] {
+		case '\\':
+			i += 2
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i += 2
+				continue
+			}
+			return i + 1
+		default:
+			i++
+		}
+	}
+	return len(sql)
+}
+
+// skipNumber 支持整数、小数、科学计数法和 0x 开头的十六进制
+func skipNumber(sql string, i int) int {
+	if strings.HasPrefix(sql[i:], "0x") || strings.HasPrefix(sql[i:], "0X") {
+		i += 2
+		for i < len(sql) && isHex(sql[i]) {
+			i++
+		}
+		return i
+	}
+	for i < len(sql) {
+		c := sql[i]
+		switch {
+		case isDigit(c) || c == '.':
+			i++
+		case (c == 'e' || c == 'E') && i+1 < len(sql) &&
+			(isDigit(sql[i+1]) || sql[i+1] == '-' || sql[i+1] == '+'):
+			i += 2
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,208 @@
+package slowlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"interview-cases/pkg/explain"
+	"interview-cases/pkg/stats"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Stat 一个指纹的统计结果，耗时的单位都是毫秒
+type Stat struct {
+	Fingerprint string `json:"fingerprint"`
+	// Example 第一次出现的语句，参数已经填进去了，可以直接复制到控制台里面执行
+	Example string  `json:"example"`
+	Count   int64   `json:"count"`
+	Errors  int64   `json:"errors"`
+	Slow    int64   `json:"slow"`
+	TotalMs float64 `json:"total_ms"`
+	MeanMs  float64 `json:"mean_ms"`
+	P99Ms   float64 `json:"p99_ms"`
+	MaxMs   float64 `json:"max_ms"`
+	// AffectedRows 查询返回的行数加上写操作影响的行数，也就是 gorm 的 RowsAffected，不是扫描的行数
+	AffectedRows int64 `json:"affected_rows"`
+	// EstimatedRows EXPLAIN 估计的扫描行数，只有变慢过的指纹才有
+	EstimatedRows int64         `json:"estimated_rows"`
+	Plan          *explain.Plan `json:"plan,omitempty"`
+	PlanError     string        `json:"plan_error,omitempty"`
+	// SamplesMs 耗时样本，升序，合并多份报告的时候用来重新计算 P99
+	SamplesMs []float64 `json:"samples_ms"`
+}
+
+// fill 根据原始数据计算平均值、P99 和估计的扫描行数
+func (s *Stat) fill() {
+	if s.Count > 0 {
+		s.MeanMs = stats.Round(s.TotalMs / float64(s.Count))
+	}
+	if len(s.SamplesMs) > 0 {
+		s.P99Ms = stats.Percentile(s.SamplesMs, 99)
+	}
+	if s.Plan != nil {
+		s.EstimatedRows = s.Plan.TotalRows()
+	}
+}
+
+type Report struct {
+	Name        string    `json:"name"`
+	CreatedAt   time.Time `json:"created_at"`
+	ThresholdMs float64   `json:"threshold_ms"`
+	Stats       []Stat    `json:"stats"`
+}
+
+type SortBy string
+
+const (
+	SortByTotal SortBy = "total"
+	SortByP99   SortBy = "p99"
+	SortByCount SortBy = "count"
+	SortBySlow  SortBy = "slow"
+)
+
+// Sort 从高到低排序，不认识的 by 按照总耗时排序
+func (r Report) Sort(by SortBy) {
+	key := func(s Stat) float64 {
+		switch by {
+		case SortByP99:
+			return s.P99Ms
+		case SortByCount:
+			return float64(s.Count)
+		case SortBySlow:
+			return float64(s.Slow)
+		default:
+			return s.TotalMs
+		}
+	}
+	sort.SliceStable(r.Stats, func(i, j int) bool {
+		ki, kj := key(r.Stats[i]), key(r.Stats[j])
+		if ki != kj {
+			return ki > kj
+		}
+		return r.Stats[i].Fingerprint < r.Stats[j].Fingerprint
+	})
+}
+
+// Top 只保留前 n 个指纹，n 小于等于 0 的时候保留全部
+func (r Report) Top(n int) Report {
+	if n > 0 && n < len(r.Stats) {
+		r.Stats = r.Stats[:n]
+	}
+	return r
+}
+
+// Merge 把多份报告里面同一个指纹的统计结果合在一起，例如多个测试包各自生成的报告
+func Merge(name string, reports ...Report) Report {
+	res := Report{Name: name, CreatedAt: time.Now()}
+	idx := make(map[string]int)
+	for _, r := range reports {
+		res.ThresholdMs = max(res.ThresholdMs, r.ThresholdMs)
+		for _, s := range r.Stats {
+			i, ok := idx[s.Fingerprint]
+			if !ok {
+				idx[s.Fingerprint] = len(res.Stats)
+				s.SamplesMs = append([]float64(nil), s.SamplesMs...)
+				res.Stats = append(res.Stats, s)
+				continue
+			}
+			m := &res.Stats[i]
+			m.Count += s.Count
+			m.Errors += s.Errors
+			m.Slow += s.Slow
+			m.TotalMs = stats.Round(m.TotalMs + s.TotalMs)
+			m.MaxMs = max(m.MaxMs, s.MaxMs)
+			m.AffectedRows += s.AffectedRows
+			m.SamplesMs = append(m.SamplesMs, s.SamplesMs...)
+			if m.Plan == nil && s.Plan != nil {
+				m.Plan, m.PlanError = s.Plan, ""
+			}
+		}
+	}
+	for i := range res.Stats {
+		sort.Float64s(res.Stats[i].SamplesMs)
+		res.Stats[i].fill()
+	}
+	res.Sort(SortByTotal)
+	return res
+}
+
+func (r Report) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// Markdown 汇总表格，后面附上慢查询的执行计划
+func (r Report) Markdown() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "## %s\n\n", r.Name)
+	fmt.Fprintf(&sb, "- 时间: %s\n", r.CreatedAt.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&sb, "- 慢查询阈值: %.3f ms\n", r.ThresholdMs)
+	sb.WriteString("- 估计扫描行数: EXPLAIN 的估计值，只有变慢过的指纹才有，不是实际扫描的行数\n")
+	sb.WriteString("\n| 指纹 | 次数 | 慢 | 错误 | 总耗时 (ms) | 平均 (ms) | P99 (ms) | 最大 (ms) | 返回或影响行数 | 估计扫描行数 |\n")
+	sb.WriteString("| --- | ---: | ---: | ---: | ---: | ---: | ---: | ---: | ---: | ---: |\n")
+	for _, s := range r.Stats {
+		// 指纹里面可能有反引号，所以用两个反引号包起来
+		fmt.Fprintf(&sb, "| `` %s `` | %d | %d | %d | %.3f | %.3f | %.3f | %.3f | %d | %s |\n",
+			strings.ReplaceAll(s.Fingerprint, "|", `\|`), s.Count, s.Slow, s.Errors,
+			s.TotalMs, s.MeanMs, s.P99Ms, s.MaxMs, s.AffectedRows, estimated(s))
+	}
+	for _, s := range r.Stats {
+		if s.Plan == nil && s.PlanError == "" {
+			continue
+		}
+		fmt.Fprintf(&sb, "\n### %s\n\n", s.Fingerprint)
+		if s.PlanError != "" {
+			fmt.Fprintf(&sb, "EXPLAIN 失败: %s\n", s.PlanError)
+			continue
+		}
+		fmt.Fprintf(&sb, "```\n%s```\n", s.Plan)
+	}
+	return sb.String()
+}
+
+func estimated(s Stat) string {
+	if s.Plan == nil {
+		return "-"
+	}
+	return fmt.Sprint(s.EstimatedRows)
+}
+
+const filePrefix = "slowlog_"
+
+// WriteFile 在 dir 下面生成 slowlog_<名字>.json，返回文件路径，同名的报告会被覆盖
+func (r Report) WriteFile(dir string) (string, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return "", err
+	}
+	data, err := r.JSON()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, filePrefix+r.Name+".json")
+	return path, os.WriteFile(path, data, 0o644)
+}
+
+// ReadDir 读取 dir 下面所有 WriteFile 生成的报告
+func ReadDir(dir string) ([]Report, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, filePrefix+"*.json"))
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Report, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var r Report
+		err = json.Unmarshal(data, &r)
+		if err != nil {
+			return nil, fmt.Errorf("解析 %s 失败 %w", path, err)
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
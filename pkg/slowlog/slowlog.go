@@ -0,0 +1,261 @@
+// Package slowlog 是一个 gorm 插件，按照指纹聚合 SQL 的执行次数、耗时和返回或影响的行数，
+// 超过阈值的语句会自动执行一次 EXPLAIN，方便找出哪些查询随着数据量增长变慢了。
+// 插件不统计实际扫描的行数，报告里面的扫描行数是 EXPLAIN 的估计值，只有变慢过的指纹才有
+package slowlog
+
+import (
+	"encoding/json"
+	"errors"
+	"gorm.io/gorm"
+	"interview-cases/pkg/explain"
+	"interview-cases/pkg/stats"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Options struct {
+	// Threshold 慢查询阈值
+	Threshold time.Duration
+	// Explain 慢查询自动执行 EXPLAIN，只在 MySQL 上生效，每个指纹只执行一次
+	Explain bool
+	// MaxSamples 每个指纹最多保留多少个耗时样本来计算 P99，超过之后用蓄水池抽样
+	MaxSamples int
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Threshold:  100 * time.Millisecond,
+		Explain:    true,
+		MaxSamples: 1000,
+	}
+}
+
+const startKey = "slowlog:start"
+
+// Plugin 可以同时注册到多个 *gorm.DB 上，统计结果是合在一起的
+type Plugin struct {
+	opts Options
+
+	mu    sync.Mutex
+	stats map[string]*stat
+	rand  *rand.Rand
+}
+
+func New(opts Options) *Plugin {
+	if opts.MaxSamples <= 0 {
+		opts.MaxSamples = DefaultOptions().MaxSamples
+	}
+	return &Plugin{
+		opts:  opts,
+		stats: make(map[string]*stat),
+		rand:  rand.New(rand.NewPCG(1, 2)),
+	}
+}
+
+func (p *Plugin) Name() string {
+	return "interview-cases:slowlog"
+}
+
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("*").Register("slowlog:before_create", p.before),
+		cb.Create().After("*").Register("slowlog:after_create", p.after(true)),
+		cb.Query().Before("*").Register("slowlog:before_query", p.before),
+		cb.Query().After("*").Register("slowlog:after_query", p.after(true)),
+		cb.Update().Before("*").Register("slowlog:before_update", p.before),
+		cb.Update().After("*").Register("slowlog:after_update", p.after(true)),
+		cb.Delete().Before("*").Register("slowlog:before_delete", p.before),
+		cb.Delete().After("*").Register("slowlog:after_delete", p.after(true)),
+		cb.Raw().Before("*").Register("slowlog:before_raw", p.before),
+		cb.Raw().After("*").Register("slowlog:after_raw", p.after(true)),
+		// Row 返回的时候调用方还没有读完结果，同一个连接上不能再执行 EXPLAIN
+		cb.Row().Before("*").Register("slowlog:before_row", p.before),
+		cb.Row().After("*").Register("slowlog:after_row", p.after(false)),
+	)
+}
+
+func (p *Plugin) before(db *gorm.DB) {
+	db.InstanceSet(startKey, time.Now())
+}
+
+func (p *Plugin) after(explainable bool) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		val, ok := db.InstanceGet(startKey)
+		if !ok || db.DryRun {
+			return
+		}
+		elapsed := time.Since(val.(time.Time))
+		stmt := db.Statement
+		sqlStr := stmt.SQL.String()
+		// 自己执行的 EXPLAIN 不统计
+		if sqlStr == "" || isExplain(sqlStr) {
+			return
+		}
+		fp := Fingerprint(sqlStr)
+		needExplain := p.record(fp, elapsed, stmt.RowsAffected, db.Error, func() string {
+			return db.Dialector.Explain(sqlStr, stmt.Vars...)
+		})
+		if !needExplain || !explainable || !p.opts.Explain || !canExplain(fp) ||
+			db.Error != nil || db.Dialector.Name() != "mysql" {
+			return
+		}
+		// NewDB 会沿用当前的连接，所以在事务里面也能执行
+		plan, err := explain.ExplainSQL(db.Session(&gorm.Session{NewDB: true}), sqlStr, stmt.Vars...)
+		p.setPlan(fp, plan, err)
+	}
+}
+
+func isExplain(sql string) bool {
+	sql = strings.TrimSpace(sql)
+	return len(sql) >= 7 && strings.EqualFold(sql[:7], "EXPLAIN")
+}
+
+// canExplain MySQL 只能 EXPLAIN 这几种语句，DDL、LOAD DATA 之类的都不行
+func canExplain(fp string) bool {
+	verb, _, _ := strings.Cut(strings.TrimLeft(fp, "( "), " ")
+	switch verb {
+	case "select", "insert", "update", "delete", "replace", "with":
+		return true
+	default:
+		return false
+	}
+}
+
+// stat 一个指纹的统计数据
+type stat struct {
+	fingerprint string
+	example     string
+	count       int64
+	errors      int64
+	slow        int64
+	total       time.Duration
+	max         time.Duration
+	affected    int64
+	samples     []time.Duration
+	explained   bool
+	plan        *explain.Plan
+	planErr     string
+}
+
+// record 返回 true 说明这个指纹第一次超过阈值，需要执行 EXPLAIN
+// example 只有第一次出现的时候才会调用
+func (p *Plugin) record(fp string, elapsed time.Duration, affected int64, err error, example func() string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.stats[fp]
+	if !ok {
+		s = &stat{fingerprint: fp, example: example()}
+		p.stats[fp] = s
+	}
+	s.count++
+	s.total += elapsed
+	s.max = max(s.max, elapsed)
+	// Row 和 Raw 拿不到行数，RowsAffected 是 -1
+	if affected > 0 {
+		s.affected += affected
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		s.errors++
+	}
+	if len(s.samples) < p.opts.MaxSamples {
+		s.samples = append(s.samples, elapsed)
+	} else if i := p.rand.Int64N(s.count); i < int64(len(s.samples)) {
+		s.samples[i] = elapsed
+	}
+	if elapsed < p.opts.Threshold {
+		return false
+	}
+	s.slow++
+	if s.explained {
+		return false
+	}
+	s.explained = true
+	return true
+}
+
+func (p *Plugin) setPlan(fp string, plan explain.Plan, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats[fp]
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.planErr = err.Error()
+		return
+	}
+	s.plan = &plan
+}
+
+// Report 当前的统计结果，按照总耗时从高到低排序
+func (p *Plugin) Report(name string) Report {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := make([]Stat, 0, len(p.stats))
+	for _, s := range p.stats {
+		res = append(res, s.toStat())
+	}
+	r := Report{
+		Name:        name,
+		CreatedAt:   time.Now(),
+		ThresholdMs: stats.Ms(p.opts.Threshold),
+		Stats:       res,
+	}
+	r.Sort(SortByTotal)
+	return r
+}
+
+// Reset 清空统计结果
+func (p *Plugin) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats = make(map[string]*stat)
+}
+
+// ServeHTTP 以 JSON 的格式输出当前的统计结果，可以挂在任意路由上
+// 查询参数 sort 取值 total、p99、count、slow，top 限制返回的指纹数量
+func (p *Plugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := p.Report(r.URL.Query().Get("name"))
+	if by := r.URL.Query().Get("sort"); by != "" {
+		report.Sort(SortBy(by))
+	}
+	if top := r.URL.Query().Get("top"); top != "" {
+		n, err := strconv.Atoi(top)
+		if err != nil {
+			http.Error(w, "top 必须是整数", http.StatusBadRequest)
+			return
+		}
+		report = report.Top(n)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+func (s *stat) toStat() Stat {
+	samples := make([]float64, 0, len(s.samples))
+	for _, d := range s.samples {
+		samples = append(samples, stats.Ms(d))
+	}
+	sort.Float64s(samples)
+	res := Stat{
+		Fingerprint:  s.fingerprint,
+		Example:      s.example,
+		Count:        s.count,
+		Errors:       s.errors,
+		Slow:         s.slow,
+		TotalMs:      stats.Ms(s.total),
+		MaxMs:        stats.Ms(s.max),
+		AffectedRows: s.affected,
+		Plan:         s.plan,
+		PlanError:    s.planErr,
+		SamplesMs:    samples,
+	}
+	res.fill()
+	return res
+}
@@ -0,0 +1,159 @@
+package slowlog
+
+import (
+	"encoding/json"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"interview-cases/pkg/explain"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+	testCases := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{
+			name: "数字和字符串",
+			sql:  "SELECT * FROM `orders` WHERE buyer = 11 AND sn = 'sn_\\'1''2' LIMIT 10 OFFSET 10000",
+			want: "select * from `orders` where buyer = ? and sn = ? limit ? offset ?",
+		},
+		{
+			name: "标识符里面的数字",
+			sql:  "SELECT * FROM orders_v1 WHERE buyer=1",
+			want: "select * from orders_v1 where buyer=?",
+		},
+		{
+			name: "IN 列表",
+			sql:  "SELECT * FROM users WHERE id IN (?,?,?) AND age in (1, 2)",
+			want: "select * from users where id in (?+) and age in (?+)",
+		},
+		{
+			name: "批量插入",
+			sql:  "INSERT INTO `orders` (`sn`,`buyer`) VALUES (?,?),(?,?),(?,?)",
+			want: "insert into `orders` (`sn`,`buyer`) values (?+)",
+		},
+		{
+			name: "空白和注释",
+			sql:  "SELECT id -- 注释\n  FROM\torders /* 注释 */ WHERE ctime > 1.5e3 AND x = 0xFF;",
+			want: "select id from orders where ctime > ? and x = ?",
+		},
+		{
+			name: "反引号保持原样",
+			sql:  "select `ID` from `Orders`",
+			want: "select `ID` from `Orders`",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, Fingerprint(tc.sql))
+		})
+	}
+}
+
+type slowOrder struct {
+	ID    int64
+	Buyer int64
+}
+
+func TestPlugin(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	// 阈值是 0，所有语句都是慢查询，但是 SQLite 不会执行 EXPLAIN
+	p := New(Options{Threshold: 0, Explain: true, MaxSamples: 3})
+	require.NoError(t, db.Use(p))
+	require.NoError(t, db.AutoMigrate(&slowOrder{}))
+	p.Reset()
+
+	for i := 0; i < 5; i++ {
+		err = db.Create(&slowOrder{Buyer: int64(i)}).Error
+		require.NoError(t, err)
+		var res []slowOrder
+		err = db.Where("buyer = ?", i).Find(&res).Error
+		require.NoError(t, err)
+		require.Len(t, res, 1)
+	}
+	// DryRun 不统计
+	db.Session(&gorm.Session{DryRun: true}).Find(&[]slowOrder{})
+	err = db.Exec("SELECT * FROM not_exist").Error
+	require.Error(t, err)
+
+	r := p.Report("test")
+	require.Len(t, r.Stats, 3)
+	stats := map[string]Stat{}
+	for _, s := range r.Stats {
+		stats[s.Fingerprint] = s
+	}
+	q := stats["select * from `slow_orders` where buyer = ?"]
+	assert.Equal(t, int64(5), q.Count)
+	assert.Equal(t, int64(5), q.Slow)
+	assert.Equal(t, int64(5), q.AffectedRows)
+	assert.Equal(t, "SELECT * FROM `slow_orders` WHERE buyer = 0", q.Example)
+	// 样本最多保留 3 个
+	assert.Len(t, q.SamplesMs, 3)
+	assert.Nil(t, q.Plan)
+	assert.Equal(t, int64(1), stats["select * from not_exist"].Errors)
+	assert.Equal(t, int64(5), stats["insert into `slow_orders` (`buyer`) values (?+) returning `id`"].Count)
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?sort=count&top=1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var got Report
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	require.Len(t, got.Stats, 1)
+	assert.Equal(t, int64(5), got.Stats[0].Count)
+}
+
+func TestMerge(t *testing.T) {
+	plan := &explain.Plan{Rows: []explain.Row{{Table: "orders", Rows: 100}, {Table: "users", Rows: 20}}}
+	a := Report{ThresholdMs: 100, Stats: []Stat{
+		{Fingerprint: "a", Count: 2, TotalMs: 3, MaxMs: 2, SamplesMs: []float64{1, 2}},
+		{Fingerprint: "b", Count: 1, TotalMs: 1, MaxMs: 1, SamplesMs: []float64{1}},
+	}}
+	b := Report{ThresholdMs: 200, Stats: []Stat{
+		{Fingerprint: "a", Count: 1, Slow: 1, TotalMs: 300, MaxMs: 300, SamplesMs: []float64{300}, Plan: plan},
+	}}
+	r := Merge("merged", a, b)
+	assert.Equal(t, float64(200), r.ThresholdMs)
+	require.Len(t, r.Stats, 2)
+	s := r.Stats[0]
+	assert.Equal(t, "a", s.Fingerprint)
+	assert.Equal(t, int64(3), s.Count)
+	assert.Equal(t, int64(1), s.Slow)
+	assert.Equal(t, float64(303), s.TotalMs)
+	assert.Equal(t, float64(101), s.MeanMs)
+	assert.Equal(t, float64(300), s.P99Ms)
+	assert.Equal(t, []float64{1, 2, 300}, s.SamplesMs)
+	assert.Equal(t, int64(120), s.EstimatedRows)
+	// 合并不能修改原来的报告
+	assert.Equal(t, []float64{1, 2}, a.Stats[0].SamplesMs)
+
+	r.Sort(SortByCount)
+	assert.Equal(t, "a", r.Stats[0].Fingerprint)
+	assert.Len(t, r.Top(1).Stats, 1)
+	assert.Len(t, r.Top(0).Stats, 2)
+
+	dir := t.TempDir()
+	path, err := r.WriteFile(dir)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "slowlog_merged.json"), path)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.json"), []byte("{}"), 0o644))
+	reports, err := ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.Equal(t, r.Stats, reports[0].Stats)
+	assert.Contains(t, r.Markdown(), "| `` a `` | 3 | 1 | 0 |")
+	assert.Contains(t, r.Markdown(), "EXPLAIN 的估计值")
+	assert.WithinDuration(t, time.Now(), reports[0].CreatedAt, time.Minute)
+}
@@ -0,0 +1,26 @@
+// Package stats 压测报告和慢查询报告共用的统计函数，耗时都换算成毫秒
+package stats
+
+import (
+	"math"
+	"time"
+)
+
+// Percentile 用的是 nearest-rank 算法，sorted 必须是升序的
+func Percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Ms 把 d 换算成毫秒，保留三位小数
+func Ms(d time.Duration) float64 {
+	return Round(float64(d) / float64(time.Millisecond))
+}
+
+// Round 保留三位小数，也就是精确到微秒
+func Round(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
@@ -0,0 +1,21 @@
+package stats
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	assert.Equal(t, 5.0, Percentile(sorted, 50))
+	assert.Equal(t, 9.0, Percentile(sorted, 90))
+	assert.Equal(t, 10.0, Percentile(sorted, 99))
+	assert.Equal(t, 1.0, Percentile(sorted, 0))
+}
+
+func TestMs(t *testing.T) {
+	assert.Equal(t, 1.5, Ms(1500*time.Microsecond))
+	assert.Equal(t, 0.001, Ms(1234*time.Nanosecond))
+	assert.Equal(t, 2.346, Round(2.3456))
+}
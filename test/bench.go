@@ -0,0 +1,64 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"interview-cases/pkg/bench"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// RunBench 运行性能对比，把 Markdown 报告打印出来，
+// 并且在 bench.report_dir 下面生成 JSON 和 Markdown 两份报告，方便在不同机器、不同提交之间比较
+// labels 是额外写进报告的信息，例如数据库类型、数据量
+func RunBench(t testing.TB, name string, opts bench.Options,
+	labels map[string]string, variants ...bench.Variant) bench.Report {
+	t.Helper()
+	report, err := bench.Compare(context.Background(), name, opts, variants...)
+	require.NoError(t, err)
+	report.Labels = labels
+	t.Log("\n" + report.Markdown())
+	dir, err := benchReportDir()
+	require.NoError(t, err)
+	jsonPath, mdPath, err := report.WriteFiles(dir)
+	require.NoError(t, err)
+	t.Log("报告:", jsonPath, mdPath)
+	return report
+}
+
+func benchReportDir() (string, error) {
+	return resolveDir(InitConfig().Bench.ReportDir)
+}
+
+// resolveDir 相对路径是相对于仓库根目录的
+func resolveDir(dir string) (string, error) {
+	if filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	root, err := ModuleRoot()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, dir), nil
+}
+
+// ModuleRoot 从当前目录开始往上找 go.mod 所在的目录，也就是仓库的根目录
+func ModuleRoot() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		_, err = os.Stat(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("没有找到 go.mod")
+		}
+		dir = parent
+	}
+}
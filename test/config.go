@@ -0,0 +1,285 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// EnvConfigFile 指定配置文件的路径，不指定就从当前目录开始往上找 config/config.yaml
+	EnvConfigFile = "INTERVIEW_CASES_CONFIG"
+	// EnvProfile 指定使用哪个 profile，优先级比配置文件里面的 profile 高
+	EnvProfile = "INTERVIEW_CASES_PROFILE"
+
+	EnvMySQLDSN = "INTERVIEW_CASES_MYSQL_DSN"
+	// EnvDBMode 取值 auto、mysql、embedded，参考 DBMode
+	EnvDBMode         = "INTERVIEW_CASES_DB_MODE"
+	EnvRedisAddr      = "INTERVIEW_CASES_REDIS_ADDR"
+	EnvRedisBloomAddr = "INTERVIEW_CASES_REDIS_BLOOM_ADDR"
+	// EnvKafkaBrokers 多个地址用逗号分隔
+	EnvKafkaBrokers = "INTERVIEW_CASES_KAFKA_BROKERS"
+	// EnvBenchDir 性能对比报告的输出目录
+	EnvBenchDir = "INTERVIEW_CASES_BENCH_DIR"
+	// EnvFixtureSize 取值 small、medium、large
+	EnvFixtureSize   = "INTERVIEW_CASES_FIXTURE_SIZE"
+	EnvFixtureSeed   = "INTERVIEW_CASES_FIXTURE_SEED"
+	EnvFixtureMethod = "INTERVIEW_CASES_FIXTURE_METHOD"
+	// EnvSlowLogDir 慢查询报告的输出目录，为空就不输出
+	EnvSlowLogDir = "INTERVIEW_CASES_SLOWLOG_DIR"
+	// EnvSlowLogThreshold 慢查询阈值，例如 100ms
+	EnvSlowLogThreshold = "INTERVIEW_CASES_SLOWLOG_THRESHOLD"
+)
+
+const defaultConfigFile = "config/config.yaml"
+
+// Config 案例依赖的基础设施地址
+// 优先级从低到高依次是：默认值、配置文件、配置文件里面的 profile、环境变量
+type Config struct {
+	MySQL      MySQLConfig   `yaml:"mysql"`
+	Redis      RedisConfig   `yaml:"redis"`
+	RedisBloom RedisConfig   `yaml:"redis_bloom"`
+	Kafka      KafkaConfig   `yaml:"kafka"`
+	Bench      BenchConfig   `yaml:"bench"`
+	Fixture    FixtureConfig `yaml:"fixture"`
+	SlowLog    SlowLogConfig `yaml:"slow_log"`
+}
+
+type MySQLConfig struct {
+	DSN  string `yaml:"dsn"`
+	Mode DBMode `yaml:"mode"`
+}
+
+// DBMode 决定 InitDB 返回什么数据库
+type DBMode string
+
+const (
+	// DBModeAuto 连得上 MySQL 就用 MySQL，否则用嵌入式数据库
+	DBModeAuto DBMode = "auto"
+	// DBModeMySQL 只用 MySQL，连不上直接 panic
+	DBModeMySQL DBMode = "mysql"
+	// DBModeEmbedded 只用嵌入式数据库，不需要启动任何容器
+	DBModeEmbedded DBMode = "embedded"
+)
+
+type RedisConfig struct {
+	Addr string `yaml:"addr"`
+}
+
+type KafkaConfig struct {
+	Brokers []string `yaml:"brokers"`
+}
+
+type BenchConfig struct {
+	// ReportDir 相对路径是相对于仓库根目录的
+	ReportDir string `yaml:"report_dir"`
+}
+
+// FixtureConfig 测试数据的生成方式，参考 pkg/fixture
+type FixtureConfig struct {
+	// Size 数据量，small、medium 或者 large
+	Size string `yaml:"size"`
+	// Seed 随机数种子，种子一样生成的数据就一样
+	Seed uint64 `yaml:"seed"`
+	// Method 写入方式，auto 在 MySQL 上优先用 LOAD DATA，batch 只用多行 INSERT
+	Method string `yaml:"method"`
+}
+
+// SlowLogConfig 慢查询统计，参考 pkg/slowlog
+type SlowLogConfig struct {
+	// Threshold 超过这个时间的语句会打印日志，并且自动执行 EXPLAIN
+	Threshold time.Duration `yaml:"threshold"`
+	// Dir 测试结束之后把统计结果写到这个目录，为空就不写，相对路径是相对于仓库根目录的
+	Dir string `yaml:"dir"`
+}
+
+// configFile 配置文件的结构，顶层是公共配置，profiles 里面是各自的覆盖
+type configFile struct {
+	Config   `yaml:",inline"`
+	Profile  string            `yaml:"profile"`
+	Profiles map[string]Config `yaml:"profiles"`
+}
+
+var (
+	config     Config
+	configOnce sync.Once
+)
+
+// DefaultConfig 对应 docker-compose.yml 里面的端口
+func DefaultConfig() Config {
+	return Config{
+		MySQL: MySQLConfig{
+			DSN:  "root:root@tcp(127.0.0.1:13306)/interview_cases?charset=utf8mb4&parseTime=True&loc=Local",
+			Mode: DBModeAuto,
+		},
+		Redis:      RedisConfig{Addr: "localhost:6379"},
+		RedisBloom: RedisConfig{Addr: "localhost:6380"},
+		Kafka:      KafkaConfig{Brokers: []string{"127.0.0.1:9092"}},
+		Bench:      BenchConfig{ReportDir: "bench_reports"},
+		Fixture: FixtureConfig{
+			Size:   "medium",
+			Seed:   20241101,
+			Method: "auto",
+		},
+		SlowLog: SlowLogConfig{Threshold: 100 * time.Millisecond},
+	}
+}
+
+// InitConfig 加载配置，整个进程只会加载一次
+func InitConfig() Config {
+	configOnce.Do(func() {
+		path := os.Getenv(EnvConfigFile)
+		if path == "" {
+			path = findConfigFile()
+		}
+		cfg, err := LoadConfig(path, os.Getenv(EnvProfile))
+		if err != nil {
+			panic(fmt.Sprintf("加载配置失败: %v", err))
+		}
+		config = cfg
+	})
+	return config
+}
+
+// LoadConfig 从 path 加载配置，path 为空就只使用默认值和环境变量
+// profile 为空就使用配置文件里面指定的 profile
+func LoadConfig(path string, profile string) (Config, error) {
+	cfg := DefaultConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		var file configFile
+		err = yaml.Unmarshal(data, &file)
+		if err != nil {
+			return Config{}, fmt.Errorf("解析配置文件 %s 失败 %w", path, err)
+		}
+		cfg = cfg.merge(file.Config)
+		if profile == "" {
+			profile = file.Profile
+		}
+		if profile != "" {
+			p, ok := file.Profiles[profile]
+			if !ok {
+				return Config{}, fmt.Errorf("配置文件 %s 里面没有 profile %s", path, profile)
+			}
+			cfg = cfg.merge(p)
+		}
+	} else if profile != "" {
+		return Config{}, fmt.Errorf("没有找到配置文件，无法使用 profile %s", profile)
+	}
+	envCfg, err := configFromEnv()
+	if err != nil {
+		return Config{}, err
+	}
+	cfg = cfg.merge(envCfg)
+	switch cfg.MySQL.Mode {
+	case DBModeAuto, DBModeMySQL, DBModeEmbedded:
+	default:
+		return Config{}, fmt.Errorf("未知的数据库模式 %s", cfg.MySQL.Mode)
+	}
+	return cfg, nil
+}
+
+// merge 用 other 里面不为空的字段覆盖 c
+func (c Config) merge(other Config) Config {
+	if other.MySQL.DSN != "" {
+		c.MySQL.DSN = other.MySQL.DSN
+	}
+	if other.MySQL.Mode != "" {
+		c.MySQL.Mode = other.MySQL.Mode
+	}
+	if other.Redis.Addr != "" {
+		c.Redis.Addr = other.Redis.Addr
+	}
+	if other.RedisBloom.Addr != "" {
+		c.RedisBloom.Addr = other.RedisBloom.Addr
+	}
+	if len(other.Kafka.Brokers) > 0 {
+		c.Kafka.Brokers = other.Kafka.Brokers
+	}
+	if other.Bench.ReportDir != "" {
+		c.Bench.ReportDir = other.Bench.ReportDir
+	}
+	if other.Fixture.Size != "" {
+		c.Fixture.Size = other.Fixture.Size
+	}
+	if other.Fixture.Seed != 0 {
+		c.Fixture.Seed = other.Fixture.Seed
+	}
+	if other.Fixture.Method != "" {
+		c.Fixture.Method = other.Fixture.Method
+	}
+	if other.SlowLog.Threshold != 0 {
+		c.SlowLog.Threshold = other.SlowLog.Threshold
+	}
+	if other.SlowLog.Dir != "" {
+		c.SlowLog.Dir = other.SlowLog.Dir
+	}
+	return c
+}
+
+func configFromEnv() (Config, error) {
+	var cfg Config
+	cfg.MySQL.DSN = os.Getenv(EnvMySQLDSN)
+	cfg.MySQL.Mode = DBMode(os.Getenv(EnvDBMode))
+	cfg.Redis.Addr = os.Getenv(EnvRedisAddr)
+	cfg.RedisBloom.Addr = os.Getenv(EnvRedisBloomAddr)
+	cfg.Bench.ReportDir = os.Getenv(EnvBenchDir)
+	cfg.Fixture.Size = os.Getenv(EnvFixtureSize)
+	cfg.Fixture.Method = os.Getenv(EnvFixtureMethod)
+	cfg.SlowLog.Dir = os.Getenv(EnvSlowLogDir)
+	if brokers := os.Getenv(EnvKafkaBrokers); brokers != "" {
+		for _, broker := range strings.Split(brokers, ",") {
+			if broker = strings.TrimSpace(broker); broker != "" {
+				cfg.Kafka.Brokers = append(cfg.Kafka.Brokers, broker)
+			}
+		}
+	}
+	if seed := os.Getenv(EnvFixtureSeed); seed != "" {
+		var err error
+		cfg.Fixture.Seed, err = strconv.ParseUint(seed, 10, 64)
+		if err != nil {
+			return Config{}, fmt.Errorf("环境变量 %s 不是合法的种子 %w", EnvFixtureSeed, err)
+		}
+	}
+	if threshold := os.Getenv(EnvSlowLogThreshold); threshold != "" {
+		var err error
+		cfg.SlowLog.Threshold, err = time.ParseDuration(threshold)
+		if err != nil {
+			return Config{}, fmt.Errorf("环境变量 %s 不是合法的时间 %w", EnvSlowLogThreshold, err)
+		}
+	}
+	return cfg, nil
+}
+
+// findConfigFile 测试运行的时候工作目录是包所在的目录，所以要往上找
+func findConfigFile() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, defaultConfigFile)
+		_, err = os.Stat(path)
+		if err == nil {
+			return path
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
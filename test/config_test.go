@@ -0,0 +1,166 @@
+package test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(`
+redis:
+  addr: "redis.local:6379"
+kafka:
+  brokers: ["kafka.local:9092"]
+profile: dev
+profiles:
+  dev:
+    mysql:
+      dsn: "dev_dsn"
+  ci:
+    mysql:
+      dsn: "ci_dsn"
+    redis:
+      addr: "ci.redis:6379"
+    slow_log:
+      threshold: 500ms
+`), 0o644)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		path    string
+		profile string
+		env     map[string]string
+		wantCfg func() Config
+		wantErr bool
+	}{
+		{
+			name: "没有配置文件就用默认值",
+			wantCfg: func() Config {
+				return DefaultConfig()
+			},
+		},
+		{
+			name: "使用配置文件里面指定的 profile",
+			path: path,
+			wantCfg: func() Config {
+				cfg := DefaultConfig()
+				cfg.MySQL.DSN = "dev_dsn"
+				cfg.Redis.Addr = "redis.local:6379"
+				cfg.Kafka.Brokers = []string{"kafka.local:9092"}
+				return cfg
+			},
+		},
+		{
+			name:    "指定 profile",
+			path:    path,
+			profile: "ci",
+			wantCfg: func() Config {
+				cfg := DefaultConfig()
+				cfg.MySQL.DSN = "ci_dsn"
+				cfg.Redis.Addr = "ci.redis:6379"
+				cfg.Kafka.Brokers = []string{"kafka.local:9092"}
+				cfg.SlowLog.Threshold = 500 * time.Millisecond
+				return cfg
+			},
+		},
+		{
+			name:    "环境变量优先级最高",
+			path:    path,
+			profile: "ci",
+			env: map[string]string{
+				EnvRedisAddr:    "env.redis:6379",
+				EnvKafkaBrokers: "k1:9092, k2:9092",
+				EnvDBMode:       "embedded",
+				EnvBenchDir:     "/tmp/bench",
+				EnvFixtureSize:  "small",
+				EnvFixtureSeed:  "7",
+				EnvSlowLogDir:   "/tmp/slowlog",
+			},
+			wantCfg: func() Config {
+				cfg := DefaultConfig()
+				cfg.MySQL.DSN = "ci_dsn"
+				cfg.MySQL.Mode = DBModeEmbedded
+				cfg.Bench.ReportDir = "/tmp/bench"
+				cfg.Fixture.Size = "small"
+				cfg.Fixture.Seed = 7
+				cfg.SlowLog.Threshold = 500 * time.Millisecond
+				cfg.SlowLog.Dir = "/tmp/slowlog"
+				cfg.Redis.Addr = "env.redis:6379"
+				cfg.Kafka.Brokers = []string{"k1:9092", "k2:9092"}
+				return cfg
+			},
+		},
+		{
+			name:    "仓库里面的配置文件",
+			path:    "../config/config.yaml",
+			profile: "compose",
+			wantCfg: func() Config {
+				cfg := DefaultConfig()
+				cfg.MySQL.DSN = "root:root@tcp(mysql8:3306)/interview_cases?charset=utf8mb4&parseTime=True&loc=Local"
+				cfg.Redis.Addr = "redis:6379"
+				cfg.RedisBloom.Addr = "redis-bloom:6379"
+				cfg.Kafka.Brokers = []string{"kafka:9092"}
+				return cfg
+			},
+		},
+		{
+			name: "未知的数据库模式",
+			env: map[string]string{
+				EnvDBMode: "oracle",
+			},
+			wantErr: true,
+		},
+		{
+			name: "种子不是数字",
+			env: map[string]string{
+				EnvFixtureSeed: "abc",
+			},
+			wantErr: true,
+		},
+		{
+			name: "慢查询阈值",
+			env: map[string]string{
+				EnvSlowLogThreshold: "50ms",
+			},
+			wantCfg: func() Config {
+				cfg := DefaultConfig()
+				cfg.SlowLog.Threshold = 50 * time.Millisecond
+				return cfg
+			},
+		},
+		{
+			name: "慢查询阈值不是时间",
+			env: map[string]string{
+				EnvSlowLogThreshold: "50",
+			},
+			wantErr: true,
+		},
+		{
+			name:    "未知 profile",
+			path:    path,
+			profile: "unknown",
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, key := range []string{EnvMySQLDSN, EnvDBMode, EnvRedisAddr, EnvRedisBloomAddr, EnvKafkaBrokers, EnvBenchDir,
+				EnvFixtureSize, EnvFixtureSeed, EnvFixtureMethod, EnvSlowLogDir, EnvSlowLogThreshold} {
+				t.Setenv(key, tc.env[key])
+			}
+			cfg, err := LoadConfig(tc.path, tc.profile)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantCfg(), cfg)
+		})
+	}
+}
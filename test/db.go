@@ -1,32 +1,115 @@
 package test
 
 import (
+	"fmt"
+	"github.com/glebarez/sqlite"
+	mysqldriver "github.com/go-sql-driver/mysql"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 	"log"
+	"log/slog"
 	"os"
-	"time"
+	"sync"
+	"testing"
 )
 
+// embeddedDSN 内存数据库，整个测试进程共享同一份数据
+const embeddedDSN = "file:interview_cases?mode=memory&cache=shared&_pragma=busy_timeout(5000)"
+
+var (
+	embeddedDB     *gorm.DB
+	embeddedDBOnce sync.Once
+)
+
+// InitDB 根据配置里面的 mysql.mode 返回 MySQL 或者嵌入式数据库
+// 两者支持的特性不一样，依赖 InnoDB 行为的案例应该用 RequireDB 或者 DialectOf 判断一下
 func InitDB() *gorm.DB {
-	newLogger := logger.New(
+	cfg := InitConfig().MySQL
+	switch cfg.Mode {
+	case DBModeEmbedded:
+		return initEmbeddedDB()
+	case DBModeAuto:
+		if err := pingMySQL(cfg.DSN); err != nil {
+			slog.Warn("连不上 MySQL，使用嵌入式数据库", slog.Any("err", err))
+			return initEmbeddedDB()
+		}
+	}
+	// 数据库配置在 config/config.yaml 里面，也可以用环境变量覆盖
+	db, err := gorm.Open(mysql.Open(cfg.DSN), &gorm.Config{
+		Logger: newLogger(),
+	})
+	if err != nil {
+		panic("连接数据库失败")
+	}
+	useSlowLog(db)
+	return db
+}
+
+func newLogger() logger.Interface {
+	return logger.New(
 		// 自定义日志输出（这里设置为标准输出）
 		log.New(os.Stdout, "\r\n", log.LstdFlags),
 		logger.Config{
-			SlowThreshold:             time.Second, // 慢查询阈值
-			LogLevel:                  logger.Info, // 设置日志级别为 Info，打印 SQL
-			Colorful:                  true,        // 使用彩色日志
-			IgnoreRecordNotFoundError: true,        // 忽略记录未找到的错误
+			SlowThreshold:             InitConfig().SlowLog.Threshold, // 慢查询阈值
+			LogLevel:                  logger.Info,                    // 设置日志级别为 Info，打印 SQL
+			Colorful:                  true,                           // 使用彩色日志
+			IgnoreRecordNotFoundError: true,                           // 忽略记录未找到的错误
 		},
 	)
-	// 记得换成你的数据库配置
-	dsn := "root:root@tcp(127.0.0.1:13306)/interview_cases?charset=utf8mb4&parseTime=True&loc=Local"
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
-		Logger: newLogger,
+}
+
+// initEmbeddedDB 嵌入式数据库用的是 SQLite，只有一个连接，
+// 这样并发写入的时候不会出现 database is locked，代价是所有请求都是串行的
+func initEmbeddedDB() *gorm.DB {
+	embeddedDBOnce.Do(func() {
+		db, err := gorm.Open(sqlite.Open(embeddedDSN), &gorm.Config{
+			Logger: newLogger(),
+		})
+		if err != nil {
+			panic("连接数据库失败")
+		}
+		sqlDB, err := db.DB()
+		if err != nil {
+			panic("连接数据库失败")
+		}
+		// 内存数据库在最后一个连接关闭的时候就没了，所以连接不能过期
+		sqlDB.SetMaxOpenConns(1)
+		sqlDB.SetMaxIdleConns(1)
+		sqlDB.SetConnMaxLifetime(0)
+		sqlDB.SetConnMaxIdleTime(0)
+		useSlowLog(db)
+		embeddedDB = db
+	})
+	return embeddedDB
+}
+
+// InitMemoryDB 每个测试一个单独的内存数据库，测试结束的时候关闭，适合不依赖配置的包自己的测试
+// 和嵌入式数据库一样只有一个连接，但是不打印 SQL，也不注册慢查询插件
+func InitMemoryDB(t testing.TB) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
 	})
 	if err != nil {
-		panic("连接数据库失败")
+		t.Fatalf("打开内存数据库失败 %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("打开内存数据库失败 %v", err)
 	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		_ = sqlDB.Close()
+	})
 	return db
 }
+
+// pingMySQL 只检查 DSN 里面的地址能不能连上，不校验账号密码
+func pingMySQL(dsn string) error {
+	cfg, err := mysqldriver.ParseDSN(dsn)
+	if err != nil {
+		return err
+	}
+	return dial(cfg.Net, cfg.Addr)
+}
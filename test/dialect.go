@@ -0,0 +1,105 @@
+package test
+
+import (
+	"gorm.io/gorm"
+	"interview-cases/pkg/fixture"
+	"testing"
+)
+
+// Capability 案例可能依赖的数据库特性
+type Capability string
+
+const (
+	// CapRowLock SELECT ... FOR UPDATE 会锁住命中的行
+	CapRowLock Capability = "supports FOR UPDATE row locks"
+	// CapGapLock SELECT ... FOR UPDATE 没有命中的时候会加间隙锁（next-key lock），
+	// 死锁和插入被阻塞的案例都依赖这个行为
+	CapGapLock Capability = "supports FOR UPDATE gap locks"
+	// CapExplain 支持 MySQL 格式的 EXPLAIN，也就是有 type、key、rows、Extra 这些列
+	CapExplain Capability = "supports MySQL style EXPLAIN"
+	// CapExplainJSON 支持 EXPLAIN FORMAT=JSON
+	CapExplainJSON Capability = "supports EXPLAIN FORMAT=JSON"
+	// CapExplainAnalyze 支持 EXPLAIN ANALYZE，MySQL 8.0.18 之后才有
+	CapExplainAnalyze Capability = "supports EXPLAIN ANALYZE"
+	// CapCrossDatabase 能用 db.table 的写法访问别的库
+	CapCrossDatabase Capability = "supports cross database queries"
+)
+
+// Dialect 描述了当前数据库是什么，以及支持哪些特性
+type Dialect struct {
+	// Name 和 gorm 的 Dialector.Name() 一致，比如 mysql、sqlite
+	Name         string
+	capabilities map[Capability]struct{}
+}
+
+var (
+	mysqlDialect = newDialect("mysql",
+		CapRowLock, CapGapLock, CapExplain, CapExplainJSON, CapExplainAnalyze, CapCrossDatabase)
+	// SQLite 是整个库加锁的，也没有 MySQL 风格的 EXPLAIN
+	sqliteDialect = newDialect("sqlite")
+)
+
+func newDialect(name string, caps ...Capability) Dialect {
+	m := make(map[Capability]struct{}, len(caps))
+	for _, c := range caps {
+		m[c] = struct{}{}
+	}
+	return Dialect{Name: name, capabilities: m}
+}
+
+// DialectOf 返回 db 的方言，不认识的数据库认为什么特性都不支持
+func DialectOf(db *gorm.DB) Dialect {
+	switch name := db.Dialector.Name(); name {
+	case mysqlDialect.Name:
+		return mysqlDialect
+	case sqliteDialect.Name:
+		return sqliteDialect
+	default:
+		return newDialect(name)
+	}
+}
+
+func (d Dialect) Supports(c Capability) bool {
+	_, ok := d.capabilities[c]
+	return ok
+}
+
+// Missing 返回 caps 里面不支持的特性
+func (d Dialect) Missing(caps ...Capability) []Capability {
+	var res []Capability
+	for _, c := range caps {
+		if !d.Supports(c) {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// RequireDB 和 InitDB 一样，但是如果数据库缺少 caps 里面的特性，就跳过当前测试
+// 在 suite 的 SetupSuite 里面调用会跳过整个 suite
+func RequireDB(t testing.TB, caps ...Capability) *gorm.DB {
+	t.Helper()
+	db := InitDB()
+	d := DialectOf(db)
+	if missing := d.Missing(caps...); len(missing) > 0 {
+		t.Skipf("当前数据库 %s 不支持 %v", d.Name, missing)
+	}
+	return db
+}
+
+// Supports 数据库缺少 caps 里面的特性时返回 false，并且在测试日志里面说明原因
+// 适合只跳过测试里面的一部分断言，整个测试都要跳过的话用 RequireDB
+func Supports(t testing.TB, db *gorm.DB, caps ...Capability) bool {
+	t.Helper()
+	d := DialectOf(db)
+	if missing := d.Missing(caps...); len(missing) > 0 {
+		t.Logf("当前数据库 %s 不支持 %v，跳过相关的检查", d.Name, missing)
+		return false
+	}
+	return true
+}
+
+// Truncate 清空表并且重置自增主键，参考 fixture.Truncate
+func Truncate(db *gorm.DB, tables ...string) error {
+	return fixture.Truncate(db, tables...)
+}
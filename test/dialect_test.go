@@ -0,0 +1,33 @@
+package test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestEmbeddedDB(t *testing.T) {
+	type Item struct {
+		ID   int64 `gorm:"primaryKey;autoIncrement"`
+		Name string
+	}
+	db := initEmbeddedDB()
+	// 每次拿到的都是同一个数据库
+	assert.Same(t, db, initEmbeddedDB())
+
+	d := DialectOf(db)
+	assert.Equal(t, "sqlite", d.Name)
+	assert.False(t, d.Supports(CapGapLock))
+	assert.Equal(t, []Capability{CapGapLock, CapExplainJSON}, d.Missing(CapGapLock, CapExplainJSON))
+
+	require.NoError(t, db.AutoMigrate(&Item{}))
+	require.NoError(t, db.Create(&[]Item{{Name: "a"}, {Name: "b"}}).Error)
+	require.NoError(t, Truncate(db, "items"))
+	var cnt int64
+	require.NoError(t, db.Model(&Item{}).Count(&cnt).Error)
+	assert.Equal(t, int64(0), cnt)
+	// 自增主键也要重置
+	item := Item{Name: "c"}
+	require.NoError(t, db.Create(&item).Error)
+	assert.Equal(t, int64(1), item.ID)
+}
@@ -0,0 +1,15 @@
+package test
+
+import (
+	"interview-cases/pkg/fixture"
+)
+
+// FixtureOptions 按照配置里面的 fixture 部分生成测试数据
+func FixtureOptions() fixture.Options {
+	cfg := InitConfig().Fixture
+	opts, err := fixture.NewOptions(cfg.Size, cfg.Seed, cfg.Method)
+	if err != nil {
+		panic(err)
+	}
+	return opts
+}
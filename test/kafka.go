@@ -4,14 +4,50 @@ import (
 	"context"
 	"fmt"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
-
+	"interview-cases/pkg/mq"
+	mqkafkago "interview-cases/pkg/mq/kafkago"
+	"interview-cases/pkg/mq/memory"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
 	"time"
 )
 
+var (
+	memoryBroker     *memory.Broker
+	memoryBrokerOnce sync.Once
+)
+
+// InitMQ 如果 Kafka 能连上就用 Kafka，否则退化成进程内的消息队列
+// 进程内的消息队列在整个测试进程里面共享，这样生产者和消费者才能看到同一份数据
+func InitMQ() mq.Broker {
+	brokers := InitConfig().Kafka.Brokers
+	err := PingKafka()
+	if err == nil {
+		return mqkafkago.NewBroker(brokers...)
+	}
+	slog.Warn("连不上 Kafka，使用进程内的消息队列", slog.Any("brokers", brokers), slog.Any("err", err))
+	memoryBrokerOnce.Do(func() {
+		memoryBroker = memory.NewBroker()
+	})
+	return memoryBroker
+}
+
+// RequireKafka 连不上 Kafka 的时候跳过测试，用来测试只有真实的 Kafka 才有的行为
+func RequireKafka(t testing.TB) []string {
+	t.Helper()
+	err := PingKafka()
+	if err != nil {
+		t.Skipf("连不上 Kafka: %v", err)
+	}
+	return InitConfig().Kafka.Brokers
+}
+
 func InitTopic(topics ...kafka.TopicSpecification) {
 	// 创建 AdminClient
 	adminClient, err := kafka.NewAdminClient(&kafka.ConfigMap{
-		"bootstrap.servers": "127.0.0.1:9092",
+		"bootstrap.servers": strings.Join(InitConfig().Kafka.Brokers, ","),
 	})
 	if err != nil {
 		panic(fmt.Sprintf("创建kafka连接失败: %v", err))
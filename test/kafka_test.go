@@ -0,0 +1,113 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/pkg/mq"
+	mqkafkago "interview-cases/pkg/mq/kafkago"
+	"testing"
+	"time"
+)
+
+// TestKafkagoAdmin 用真实的 Kafka 验证 kafkago 的偏移量查询，进程内的消息队列测不出 ListOffsets 的问题
+func TestKafkagoAdmin(t *testing.T) {
+	brokers := RequireKafka(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	broker := mqkafkago.NewBroker(brokers...)
+	defer broker.Close()
+
+	topic := fmt.Sprintf("test_admin_%d", time.Now().UnixNano())
+	require.NoError(t, broker.CreateTopic(ctx, topic, 1))
+	producer, err := broker.Producer()
+	require.NoError(t, err)
+	defer producer.Close()
+	base := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	msgs := make([]mq.Message, 0, 5)
+	for i := 0; i < 5; i++ {
+		msgs = append(msgs, mq.Message{
+			Topic:     topic,
+			Value:     []byte(fmt.Sprint(i)),
+			Timestamp: base.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	require.NoError(t, producer.Produce(ctx, msgs...))
+
+	tp := mq.TopicPartition{Topic: topic, Partition: 0}
+	low, high, err := broker.Watermarks(ctx, tp)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), low)
+	assert.Equal(t, int64(5), high)
+
+	testCases := []struct {
+		name string
+		ts   time.Time
+		want int64
+	}{
+		{name: "早于所有消息", ts: base.Add(-time.Minute), want: 0},
+		{name: "刚好是某条消息的时间", ts: base.Add(2 * time.Minute), want: 2},
+		{name: "两条消息之间", ts: base.Add(2*time.Minute + time.Second), want: 3},
+		// 没有这样的消息就返回 high，不能是 -1
+		{name: "晚于所有消息", ts: base.Add(time.Hour), want: 5},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			off, err := broker.OffsetForTime(ctx, tp, tc.ts)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, off)
+		})
+	}
+}
+
+// TestKafkagoConsumerPause 暂停的分区不能再返回消息，恢复之后按照原来的顺序继续返回
+func TestKafkagoConsumerPause(t *testing.T) {
+	brokers := RequireKafka(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	broker := mqkafkago.NewBroker(brokers...)
+	defer broker.Close()
+
+	topic := fmt.Sprintf("test_pause_%d", time.Now().UnixNano())
+	require.NoError(t, broker.CreateTopic(ctx, topic, 2))
+	producer, err := broker.Producer()
+	require.NoError(t, err)
+	defer producer.Close()
+	var msgs []mq.Message
+	for p := int32(0); p < 2; p++ {
+		for i := 0; i < 5; i++ {
+			msgs = append(msgs, mq.Message{Topic: topic, Partition: p, Value: []byte(fmt.Sprint(i))})
+		}
+	}
+	require.NoError(t, producer.Produce(ctx, msgs...))
+
+	consumer, err := broker.Consumer(topic+"_group", topic)
+	require.NoError(t, err)
+	defer consumer.Close()
+	p0 := mq.TopicPartition{Topic: topic, Partition: 0}
+	require.NoError(t, consumer.Pause(p0))
+	for i := 0; i < 5; i++ {
+		msg, err := consumer.Fetch(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), msg.Partition)
+		assert.Equal(t, int64(i), msg.Offset)
+	}
+	// p0 暂停了，p1 也读完了，应该等到超时
+	fetchCtx, fetchCancel := context.WithTimeout(ctx, 2*time.Second)
+	_, err = consumer.Fetch(fetchCtx)
+	fetchCancel()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.NoError(t, consumer.Resume(p0))
+	for i := 0; i < 5; i++ {
+		msg, err := consumer.Fetch(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int32(0), msg.Partition)
+		assert.Equal(t, int64(i), msg.Offset)
+		require.NoError(t, consumer.Commit(ctx, msg))
+	}
+	committed, err := broker.Committed(ctx, topic+"_group", p0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), committed[p0])
+}
@@ -0,0 +1,42 @@
+package test
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// 下面这些方法只检查配置里面的地址能不能连上，
+// 不能连上的时候 InitXXX 会退化成进程内的实现，InnoDB 相关的特性除外
+
+func PingMySQL() error {
+	return pingMySQL(InitConfig().MySQL.DSN)
+}
+
+func PingRedis() error {
+	return dial("tcp", InitConfig().Redis.Addr)
+}
+
+func PingRedisBloom() error {
+	return dial("tcp", InitConfig().RedisBloom.Addr)
+}
+
+func PingKafka() error {
+	brokers := InitConfig().Kafka.Brokers
+	if len(brokers) == 0 {
+		return errors.New("没有配置 Kafka 地址")
+	}
+	return dial("tcp", brokers[0])
+}
+
+func dial(network, addr string) error {
+	conn, err := net.DialTimeout(network, addr, time.Second)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func reachable(addr string) bool {
+	return dial("tcp", addr) == nil
+}
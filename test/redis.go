@@ -1,15 +1,99 @@
 package test
 
-import "github.com/redis/go-redis/v9"
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"sync"
+	"time"
+)
 
+var (
+	embeddedRedis          *miniredis.Miniredis
+	embeddedRedisStop      func()
+	embeddedRedisOnce      sync.Once
+	embeddedRedisBloom     *miniredis.Miniredis
+	embeddedRedisBloomStop func()
+	embeddedRedisBloomOnce sync.Once
+)
+
+// InitRedis 如果连不上 Redis，就启动一个进程内的 Redis
 func InitRedis() redis.Cmdable {
+	addr := InitConfig().Redis.Addr
+	if !reachable(addr) {
+		slog.Warn("连不上 Redis，使用进程内的 Redis", slog.String("addr", addr))
+		embeddedRedisOnce.Do(func() {
+			embeddedRedis, embeddedRedisStop = startEmbeddedRedis()
+		})
+		addr = embeddedRedis.Addr()
+	}
 	return redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
+		Addr: addr,
 	})
 }
 
+// InitRedisBloom 如果连不上 RedisBloom，就启动一个进程内的 Redis，
+// 它用哈希结构模拟了 BF.ADD 之类的命令
 func InitRedisBloom() redis.Cmdable {
+	addr := InitConfig().RedisBloom.Addr
+	if !reachable(addr) {
+		slog.Warn("连不上 RedisBloom，使用进程内的 Redis", slog.String("addr", addr))
+		embeddedRedisBloomOnce.Do(func() {
+			embeddedRedisBloom, embeddedRedisBloomStop = startEmbeddedRedis()
+		})
+		addr = embeddedRedisBloom.Addr()
+	}
 	return redis.NewClient(&redis.Options{
-		Addr: "localhost:6380",
+		Addr: addr,
 	})
 }
+
+// startEmbeddedRedis 返回的 stop 停止推进过期时间并关闭 Redis，可以重复调用
+func startEmbeddedRedis() (*miniredis.Miniredis, func()) {
+	m := miniredis.NewMiniRedis()
+	err := m.Start()
+	if err != nil {
+		panic(err)
+	}
+	err = registerBloomCommands(m)
+	if err != nil {
+		panic(err)
+	}
+	// miniredis 的过期时间不会自己流逝，要手动推进
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		last := time.Now()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				m.FastForward(now.Sub(last))
+				last = now
+			}
+		}
+	}()
+	var once sync.Once
+	return m, func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+			m.Close()
+		})
+	}
+}
+
+// stopEmbeddedRedis 关闭 InitRedis 和 InitRedisBloom 启动的进程内 Redis，
+// 之后不能再调用 InitRedis 和 InitRedisBloom
+func stopEmbeddedRedis() {
+	for _, stop := range []func(){embeddedRedisStop, embeddedRedisBloomStop} {
+		if stop != nil {
+			stop()
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package test
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"strings"
+	"sync"
+)
+
+// bloomCommands 用哈希结构模拟 RedisBloom 的命令
+// 每个元素都是哈希里面的一个字段，所以不会有误判，相当于一个精确的布隆过滤器。
+// 因为 DEL、EXISTS、TYPE 这些命令都作用在同一个键上，所以清理数据的方式和真的 RedisBloom 一样。
+// 注意这些命令不能在 MULTI 或者 Lua 脚本里面使用，并且只作用在 0 号库上
+type bloomCommands struct {
+	m *miniredis.Miniredis
+	// 先查后写需要是原子的
+	mu sync.Mutex
+}
+
+func registerBloomCommands(m *miniredis.Miniredis) error {
+	b := &bloomCommands{m: m}
+	cmds := map[string]server.Cmd{
+		"BF.RESERVE": b.reserve,
+		"BF.ADD":     b.add,
+		"BF.MADD":    b.madd,
+		"BF.EXISTS":  b.exists,
+		"BF.MEXISTS": b.mexists,
+	}
+	for name, cmd := range cmds {
+		err := m.Server().Register(name, cmd)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reserve BF.RESERVE key error_rate capacity [EXPANSION expansion] [NONSCALING]
+// 我们的过滤器没有误判，所以参数都会被忽略
+func (b *bloomCommands) reserve(c *server.Peer, cmd string, args []string) {
+	if len(args) < 3 {
+		c.WriteError(errWrongNumber(cmd))
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.m.Exists(args[0]) {
+		c.WriteError("ERR item exists")
+		return
+	}
+	// 哈希不能是空的，所以放一个占位字段
+	b.m.HSet(args[0], bloomPlaceholder, "")
+	c.WriteOK()
+}
+
+func (b *bloomCommands) add(c *server.Peer, cmd string, args []string) {
+	if len(args) != 2 {
+		c.WriteError(errWrongNumber(cmd))
+		return
+	}
+	res, ok := b.addItems(c, args[0], args[1:])
+	if !ok {
+		return
+	}
+	c.WriteInt(res[0])
+}
+
+func (b *bloomCommands) madd(c *server.Peer, cmd string, args []string) {
+	if len(args) < 2 {
+		c.WriteError(errWrongNumber(cmd))
+		return
+	}
+	res, ok := b.addItems(c, args[0], args[1:])
+	if !ok {
+		return
+	}
+	c.WriteLen(len(res))
+	for _, r := range res {
+		c.WriteInt(r)
+	}
+}
+
+func (b *bloomCommands) exists(c *server.Peer, cmd string, args []string) {
+	if len(args) != 2 {
+		c.WriteError(errWrongNumber(cmd))
+		return
+	}
+	res, ok := b.existItems(c, args[0], args[1:])
+	if !ok {
+		return
+	}
+	c.WriteInt(res[0])
+}
+
+func (b *bloomCommands) mexists(c *server.Peer, cmd string, args []string) {
+	if len(args) < 2 {
+		c.WriteError(errWrongNumber(cmd))
+		return
+	}
+	res, ok := b.existItems(c, args[0], args[1:])
+	if !ok {
+		return
+	}
+	c.WriteLen(len(res))
+	for _, r := range res {
+		c.WriteInt(r)
+	}
+}
+
+// addItems 返回每个元素是否是新加入的，1 代表新加入
+func (b *bloomCommands) addItems(c *server.Peer, key string, items []string) ([]int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.checkType(c, key) {
+		return nil, false
+	}
+	res := make([]int, 0, len(items))
+	for _, item := range items {
+		field := bloomField(item)
+		if b.hasField(key, field) {
+			res = append(res, 0)
+			continue
+		}
+		b.m.HSet(key, field, "1")
+		res = append(res, 1)
+	}
+	return res, true
+}
+
+func (b *bloomCommands) existItems(c *server.Peer, key string, items []string) ([]int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.checkType(c, key) {
+		return nil, false
+	}
+	res := make([]int, 0, len(items))
+	for _, item := range items {
+		if b.hasField(key, bloomField(item)) {
+			res = append(res, 1)
+		} else {
+			res = append(res, 0)
+		}
+	}
+	return res, true
+}
+
+func (b *bloomCommands) checkType(c *server.Peer, key string) bool {
+	if b.m.Exists(key) && b.m.Type(key) != "hash" {
+		c.WriteError("WRONGTYPE Operation against a key holding the wrong kind of value")
+		return false
+	}
+	return true
+}
+
+func (b *bloomCommands) hasField(key, field string) bool {
+	if !b.m.Exists(key) {
+		return false
+	}
+	return b.m.HGet(key, field) != ""
+}
+
+// bloomPlaceholder 和 bloomField 的前缀不同，不会冲突
+const bloomPlaceholder = "\x00reserved"
+
+func bloomField(item string) string {
+	return "i:" + item
+}
+
+func errWrongNumber(cmd string) string {
+	return "ERR wrong number of arguments for '" + strings.ToLower(cmd) + "' command"
+}
@@ -0,0 +1,88 @@
+package test
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"interview-cases/case11_20/case18"
+	"interview-cases/case21_30/case26/repository/cache"
+	"testing"
+	"time"
+)
+
+func TestEmbeddedRedis(t *testing.T) {
+	m, stop := startEmbeddedRedis()
+	defer stop()
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("ZADD GT XX", func(t *testing.T) {
+		key := "rank"
+		require.NoError(t, rdb.ZAdd(ctx, key, redis.Z{Score: 10, Member: "a"}).Err())
+		err := rdb.ZAddArgs(ctx, key, redis.ZAddArgs{
+			XX: true,
+			GT: true,
+			Members: []redis.Z{
+				{Score: 5, Member: "a"},
+				{Score: 20, Member: "b"},
+			},
+		}).Err()
+		require.NoError(t, err)
+		res, err := rdb.ZRevRangeWithScores(ctx, key, 0, -1).Result()
+		require.NoError(t, err)
+		// 分数变小不会更新，不存在的元素也不会加进去
+		assert.Equal(t, []redis.Z{{Score: 10, Member: "a"}}, res)
+	})
+
+	t.Run("MULTI 和哈希", func(t *testing.T) {
+		pipe := rdb.TxPipeline()
+		pipe.HMSet(ctx, "hash", map[string]any{"k1": "v1", "k2": "v2"})
+		pipe.Set(ctx, "str", "1", time.Minute)
+		_, err := pipe.Exec(ctx)
+		require.NoError(t, err)
+		vals, err := rdb.HMGet(ctx, "hash", "k2").Result()
+		require.NoError(t, err)
+		assert.Equal(t, []any{"v2"}, vals)
+	})
+
+	t.Run("case18 的滑动窗口脚本", func(t *testing.T) {
+		limiter := case18.NewLimiter(rdb, time.Minute, 2, "limiter")
+		for _, want := range []bool{true, true, false} {
+			ok, err := limiter.Allow(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, want, ok)
+		}
+	})
+
+	t.Run("过期时间会自己流逝", func(t *testing.T) {
+		require.NoError(t, rdb.Set(ctx, "expire", "1", 200*time.Millisecond).Err())
+		time.Sleep(500 * time.Millisecond)
+		_, err := rdb.Get(ctx, "expire").Result()
+		assert.Equal(t, redis.Nil, err)
+	})
+
+	t.Run("case26 的布隆过滤器", func(t *testing.T) {
+		coupon, err := cache.NewRedisCoupon(ctx, rdb, "coupon", 2, 10)
+		require.NoError(t, err)
+		exist, err := coupon.CheckUidExist(ctx, 1)
+		require.NoError(t, err)
+		// BF.ADD 返回的是是否新加入
+		assert.True(t, exist)
+		exist, err = coupon.CheckUidExist(ctx, 1)
+		require.NoError(t, err)
+		assert.False(t, exist)
+
+		res, err := rdb.BFMExists(ctx, "bloom_filter:1", 1, 3).Result()
+		require.NoError(t, err)
+		assert.Equal(t, []bool{true, false}, res)
+		require.NoError(t, rdb.Del(ctx, "bloom_filter:1").Err())
+		ok, err := rdb.BFExists(ctx, "bloom_filter:1", 1).Result()
+		require.NoError(t, err)
+		assert.False(t, ok)
+		// 类型不对
+		err = rdb.BFAdd(ctx, "str", 1).Err()
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,77 @@
+package test
+
+import (
+	"gorm.io/gorm"
+	"interview-cases/pkg/slowlog"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var (
+	slowLog     *slowlog.Plugin
+	slowLogOnce sync.Once
+)
+
+// SlowLog InitDB 返回的数据库都注册了这个插件，整个测试进程共享同一份统计结果
+func SlowLog() *slowlog.Plugin {
+	slowLogOnce.Do(func() {
+		opts := slowlog.DefaultOptions()
+		opts.Threshold = InitConfig().SlowLog.Threshold
+		slowLog = slowlog.New(opts)
+	})
+	return slowLog
+}
+
+func useSlowLog(db *gorm.DB) {
+	err := db.Use(SlowLog())
+	if err != nil {
+		panic("注册慢查询插件失败")
+	}
+}
+
+// RunMain 在 TestMain 里面调用，测试结束之后关闭进程内的 Redis，把慢查询统计写到 slow_log.dir，没有配置就不写
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(test.RunMain(m))
+//	}
+func RunMain(m *testing.M) int {
+	code := m.Run()
+	stopEmbeddedRedis()
+	path, err := DumpSlowLog()
+	if err != nil {
+		slog.Error("写入慢查询报告失败", slog.Any("err", err))
+		return max(code, 1)
+	}
+	if path != "" {
+		slog.Info("慢查询报告", slog.String("path", path))
+	}
+	return code
+}
+
+// DumpSlowLog 把当前的统计结果写到 slow_log.dir 下面，文件名是测试二进制的名字，例如 slowlog_case1_10.json
+// 没有配置目录或者没有执行过 SQL 就返回空字符串
+func DumpSlowLog() (string, error) {
+	dir, err := SlowLogDir()
+	if dir == "" || err != nil {
+		return "", err
+	}
+	name := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe"), ".test")
+	report := SlowLog().Report(name)
+	if len(report.Stats) == 0 {
+		return "", nil
+	}
+	return report.WriteFile(dir)
+}
+
+// SlowLogDir 慢查询报告的目录，已经转换成了绝对路径，没有配置就返回空字符串
+func SlowLogDir() (string, error) {
+	dir := InitConfig().SlowLog.Dir
+	if dir == "" {
+		return "", nil
+	}
+	return resolveDir(dir)
+}